@@ -32,7 +32,7 @@ cryptography to protect your sensitive files. It provides authenticated encrypti
 using AES-256-GCM with PBKDF2 key derivation for maximum security.
 
 ` + cli.ColorGreen + "🔐 SECURITY FEATURES:" + cli.ColorReset + `
-  • AES-256-GCM authenticated encryption
+  • AES-256-GCM or XChaCha20-Poly1305 authenticated encryption
   • PBKDF2 key derivation with 100,000 iterations  
   • 32-byte random salt for each file
   • Secure memory handling and cleanup
@@ -117,8 +117,19 @@ func init() {
 	// Add subcommands
 	rootCmd.AddCommand(commands.EncryptCmd)
 	rootCmd.AddCommand(commands.DecryptCmd)
+	rootCmd.AddCommand(commands.EncryptTextCmd)
+	rootCmd.AddCommand(commands.DecryptTextCmd)
 	rootCmd.AddCommand(commands.InfoCmd)
 	rootCmd.AddCommand(commands.VerifyCmd)
+	rootCmd.AddCommand(commands.KdfCmd)
+	rootCmd.AddCommand(commands.UpgradeCmd)
+	rootCmd.AddCommand(commands.RepairCmd)
+	rootCmd.AddCommand(commands.CatCmd)
+	rootCmd.AddCommand(commands.PasswdCmd)
+	rootCmd.AddCommand(commands.SharesCmd)
+	rootCmd.AddCommand(commands.KeygenCmd)
+	rootCmd.AddCommand(commands.SignCmd)
+	rootCmd.AddCommand(commands.VerifySigCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(helpCmd)
 
@@ -3,13 +3,16 @@ module github.com/vuongdat67/NT140.Q11.ANTT-Group15
 go 1.25.0
 
 require (
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/reedsolomon v1.10.0
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/crypto v0.28.0
+	golang.org/x/sys v0.36.0
 	golang.org/x/term v0.35.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.36.0 // indirect
 )
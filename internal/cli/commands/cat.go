@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// CatCmd represents the cat command
+var CatCmd = &cobra.Command{
+	Use:   "cat <file>",
+	Short: "📄 Print decrypted content to stdout",
+	Long: `Decrypt an encrypted file, or a byte range of it, straight to stdout.
+
+No decrypted file is written to disk. With --offset and --length only the
+segments covering the range are read and decrypted, so extracting a few
+lines from a multi-gigabyte file is fast. Every segment is authenticated
+before any of its bytes are printed. Compressed and v1 files are decrypted
+from the start, so a range near their end takes as long as the whole file.
+
+The password prompt is printed to stderr so stdout can be piped.`,
+	Example: `  # Print a whole file
+  filevault cat notes.txt.enc
+
+  # Print 4 KiB starting at byte 1,000,000
+  filevault cat server.log.enc --offset 1000000 --length 4096
+
+  # Print the last 1000 bytes
+  filevault cat server.log.enc --offset -1000
+
+  # Page through a large file
+  filevault cat server.log.enc | less`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCat,
+}
+
+var (
+	catOffset     int64
+	catLength     int64
+	catKeyfiles   []string
+	catIdentities []string
+	catSSHKeys    []string
+	catCertKeys   []string
+	catShares     []string
+)
+
+func init() {
+	CatCmd.Flags().Int64Var(&catOffset, "offset", 0, "first byte to print (negative counts from the end)")
+	CatCmd.Flags().Int64Var(&catLength, "length", -1, "number of bytes to print (-1 for the rest of the file)")
+	CatCmd.Flags().StringArrayVar(&catKeyfiles, "keyfile", nil, keyfileFlagUsage)
+	CatCmd.Flags().StringArrayVarP(&catIdentities, "identity", "i", nil, identityFlagUsage)
+	CatCmd.Flags().StringArrayVar(&catSSHKeys, "ssh-identity", nil, sshIdentityFlagUsage)
+	CatCmd.Flags().StringArrayVar(&catCertKeys, "key", nil, keyFlagUsage)
+	CatCmd.Flags().StringArrayVar(&catShares, "share", nil, shareFlagUsage)
+}
+
+func runCat(cmd *cobra.Command, args []string) error {
+	inputFile, cleanup, err := core.Dearmored(args[0])
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := security.ValidateEncryptedFile(inputFile); err != nil {
+		return err
+	}
+
+	creds, err := unlockShareCredentials([]string{inputFile}, catKeyfiles, slices.Concat(catIdentities, catSSHKeys, catCertKeys), catShares, "Enter password: ", security.ReadPasswordStderr)
+	if err != nil {
+		return err
+	}
+
+	reader, err := core.OpenReader(inputFile, creds)
+	if errors.Is(err, core.ErrRandomAccessUnsupported) {
+		// v1 and compressed files are decrypted from the start instead
+		return catStream(inputFile, creds)
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	offset, length, err := catRange(reader.Size())
+	if err != nil {
+		return err
+	}
+
+	return catCopy(io.NewSectionReader(reader, offset, length))
+}
+
+// catStream prints the requested range of a file that has to be read
+// sequentially, discarding the plaintext before the offset
+func catStream(inputFile string, creds core.Credentials) error {
+	stream, header, err := core.OpenStream(inputFile, creds)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	offset, length := catOffset, catLength
+	if header.HasFlag(fileops.FlagStreamed) {
+		// Compressed from a pipe: the size is only known at the end
+		if offset < 0 {
+			return fmt.Errorf("a negative offset needs the file size, which was not recorded for this file")
+		}
+		if length < 0 {
+			length = math.MaxInt64
+		}
+	} else if offset, length, err = catRange(int64(header.OriginalSize)); err != nil {
+		return err
+	}
+
+	if n, err := io.CopyN(io.Discard, stream, offset); err == io.EOF {
+		return fmt.Errorf("offset %d is beyond the end of the file (%d bytes)", catOffset, n)
+	} else if err != nil {
+		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+	return catCopy(io.LimitReader(stream, length))
+}
+
+// catRange resolves --offset and --length against a plaintext of size bytes
+func catRange(size int64) (offset, length int64, err error) {
+	offset = catOffset
+	if offset < 0 {
+		offset += size
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	if offset > size {
+		return 0, 0, fmt.Errorf("offset %d is beyond the end of the file (%d bytes)", catOffset, size)
+	}
+
+	length = catLength
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	return offset, length, nil
+}
+
+// catCopy writes plaintext to stdout
+func catCopy(src io.Reader) error {
+	writer := bufio.NewWriter(os.Stdout)
+	if _, err := io.Copy(writer, src); err != nil {
+		writer.Flush()
+		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+	return writer.Flush()
+}
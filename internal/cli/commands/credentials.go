@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/config"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// keyfileFlagUsage is the help text shared by every --keyfile flag
+const keyfileFlagUsage = "keyfile to combine with the password (repeatable; any file works)"
+
+// passwordFileFlagUsage is the help text shared by every --password-file flag
+const passwordFileFlagUsage = "read the password from the first line of this file instead of the terminal"
+
+// passwordEnv names the environment variable that scripts can set to give
+// the password when no terminal is at hand
+const passwordEnv = "FILEVAULT_PASSWORD"
+
+// Help text shared by the recipient and identity flags
+const (
+	recipientFlagUsage    = "public key (fvpk1..., fvpq1...) or file of public keys to encrypt to (repeatable)"
+	identityFlagUsage     = "identity file from 'filevault keygen' or SSH private key (repeatable)"
+	sshRecipientFlagUsage = "SSH public key, .pub or authorized_keys file to encrypt to (repeatable)"
+	sshIdentityFlagUsage  = "SSH private key to decrypt with, e.g. ~/.ssh/id_ed25519 (repeatable)"
+	certFlagUsage         = "X.509 certificate (PEM) to encrypt to, checked against the CA bundle (repeatable)"
+	keyFlagUsage          = "private key (PEM) of a certificate to decrypt with (repeatable)"
+)
+
+// parseRecipients reads the recipients given with -r or --ssh-recipient:
+// each value is a public key, or a file listing public keys one per line
+// with # comments, such as an SSH .pub or authorized_keys file
+func parseRecipients(values []string) ([]crypto.Recipient, error) {
+	var recipients []crypto.Recipient
+	for _, value := range values {
+		lower := strings.ToLower(value)
+		if strings.HasPrefix(lower, crypto.X25519RecipientPrefix) || strings.HasPrefix(lower, crypto.HybridRecipientPrefix) || strings.HasPrefix(value, "ssh-") {
+			recipient, err := crypto.ParseRecipient(value)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		file, err := os.Open(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: not a public key or readable file", value)
+		}
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			recipient, err := crypto.ParseRecipient(text)
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: %w", value, line, err)
+			}
+			recipients = append(recipients, recipient)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients from %s: %w", value, err)
+		}
+	}
+	return recipients, nil
+}
+
+// parseCertRecipients reads the certificates given with --cert and checks
+// each against the CA bundle and its validity period
+func parseCertRecipients(paths []string, cfg *config.Config) ([]crypto.Recipient, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	roots, err := cfg.CertPool()
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []crypto.Recipient
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		recipient, err := crypto.ParseCertRecipient(data, roots, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// loadIdentities reads the identity files given with -i, --ssh-identity or
+// --key. PEM private keys (SSH or certificate keys) are recognized by their
+// armor; readPassword asks for the passphrase of encrypted ones.
+func loadIdentities(paths []string, readPassword func(string) (string, error)) ([]crypto.Identity, error) {
+	var identities []crypto.Identity
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+
+		if strings.Contains(string(data), "PRIVATE KEY-----") {
+			passphrase := func() ([]byte, error) {
+				secret, err := readPassword(fmt.Sprintf("Enter passphrase for %s: ", path))
+				return []byte(secret), err
+			}
+			parsed, err := crypto.ParsePrivateKeyIdentities(data, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			identities = append(identities, parsed...)
+			continue
+		}
+
+		parsed, err := crypto.ParseIdentities(strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	return identities, nil
+}
+
+// checkKeyfiles fails early on keyfiles that cannot be read or are empty,
+// before any password is asked for
+func checkKeyfiles(keyfiles []string) error {
+	for _, keyfile := range keyfiles {
+		if _, err := crypto.HashKeyfile(keyfile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// passwordReader returns how passwords are read: from passwordFile or
+// $FILEVAULT_PASSWORD if given, or else from the terminal. When stdin
+// carries data the terminal is opened directly, and when stdout does the
+// prompts go to stderr, so that they never mix with a pipeline's data.
+func passwordReader(passwordFile string, stdinData, stdoutData bool) func(string) (string, error) {
+	switch {
+	case passwordFile != "":
+		return func(string) (string, error) { return security.ReadPasswordFile(passwordFile) }
+	case os.Getenv(passwordEnv) != "":
+		return func(string) (string, error) { return os.Getenv(passwordEnv), nil }
+	case stdinData:
+		return security.ReadPasswordTTY
+	case stdoutData:
+		return security.ReadPasswordStderr
+	}
+	return security.PromptPassword
+}
+
+// newCredentials collects the factors that protect new files: the given
+// keyfiles and, unless noPassword is set, a password entered twice
+func newCredentials(keyfiles []string, noPassword bool, prompt string, readPassword func(string) (string, error)) (core.Credentials, error) {
+	creds := core.Credentials{Keyfiles: keyfiles}
+	if noPassword && !creds.UsesKeyfiles() {
+		return creds, fmt.Errorf("--no-password requires at least one --keyfile")
+	}
+	if err := checkKeyfiles(keyfiles); err != nil {
+		return creds, err
+	}
+	if noPassword {
+		return creds, nil
+	}
+
+	password, err := readPassword(prompt)
+	if err != nil {
+		return creds, fmt.Errorf("failed to get password: %w", err)
+	}
+
+	confirmPassword, err := readPassword("Confirm password: ")
+	if err != nil {
+		return creds, fmt.Errorf("failed to get password confirmation: %w", err)
+	}
+
+	if password != confirmPassword {
+		return creds, fmt.Errorf("passwords do not match")
+	}
+
+	creds.Password = password
+	return creds, nil
+}
+
+// unlockCredentials asks for exactly the factors that files need: a
+// password prompt only if some file cannot be opened by the given keyfiles
+// or identities alone, and an error before anything is decrypted if a file
+// needs a keyfile or identity that was not given. Files with key slots may
+// be opened by any slot whose factors match. Files that cannot be read are
+// assumed to need a password; their real error is reported when they are
+// processed.
+func unlockCredentials(files, keyfiles, identityFiles []string, prompt string, readPassword func(string) (string, error)) (core.Credentials, error) {
+	creds := core.Credentials{Keyfiles: keyfiles}
+	if err := checkKeyfiles(keyfiles); err != nil {
+		return creds, err
+	}
+	identities, err := loadIdentities(identityFiles, readPassword)
+	if err != nil {
+		return creds, err
+	}
+	creds.Identities = identities
+
+	needPassword := false
+	for _, file := range files {
+		sets, err := core.UnlockFactors(file)
+		if err != nil {
+			needPassword = true
+			continue
+		}
+		needed, err := passwordNeeded(file, sets, creds)
+		if err != nil {
+			return creds, err
+		}
+		needPassword = needPassword || needed
+	}
+	if !needPassword {
+		return creds, nil
+	}
+
+	password, err := readPassword(prompt)
+	if err != nil {
+		return creds, fmt.Errorf("failed to get password: %w", err)
+	}
+	creds.Password = password
+	return creds, nil
+}
+
+// passwordNeeded reports whether a file that the factor sets unlock needs
+// a password on top of the keyfiles and identities in creds, and fails if
+// none of the sets can be met
+func passwordNeeded(file string, sets []fileops.Factors, creds core.Credentials) (bool, error) {
+	usable, passwordless, recipientOnly := 0, false, len(sets) > 0
+	for _, factors := range sets {
+		if factors.RequiresIdentity() {
+			if creds.UsesIdentities() {
+				usable++
+				passwordless = true
+			}
+			continue
+		}
+		recipientOnly = false
+		if factors.RequiresKeyfile() != creds.UsesKeyfiles() {
+			continue
+		}
+		usable++
+		passwordless = passwordless || !factors.RequiresPassword()
+	}
+
+	if usable == 0 && recipientOnly {
+		return false, fmt.Errorf("%s: %w (use --identity or --key)", file, core.ErrIdentityRequired)
+	}
+	if usable == 0 && creds.UsesKeyfiles() {
+		return false, fmt.Errorf("%s: %w", file, core.ErrKeyfileNotUsed)
+	}
+	if usable == 0 {
+		return false, fmt.Errorf("%s: %w (use --keyfile)", file, core.ErrKeyfileRequired)
+	}
+	return !passwordless, nil
+}
@@ -2,14 +2,17 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
 )
 
@@ -21,10 +24,17 @@ var DecryptCmd = &cobra.Command{
 
 The decryption process:
   1. Validates the FileVault format and magic number
-  2. Prompts for the encryption password
+  2. Prompts for the factors recorded in the header: the password,
+     keyfiles (--keyfile), or both; files encrypted to a public key
+     open with its identity (--identity) instead
   3. Derives the decryption key using stored salt
   4. Verifies authentication tag for integrity
-  5. Decrypts and restores the original file
+  5. Decrypts and restores the original file; with --preserve, also its
+     mode, timestamps, owner and extended attributes as recorded when it
+     was encrypted. Directories from 'encrypt --recursive' are extracted
+     with their modes, times and symlinks (and owners with --preserve);
+     absolute paths, '..' and symlinks pointing outside the output
+     directory are refused
 
 SECURITY VERIFICATION:
   • Validates FileVault format signature
@@ -34,8 +44,11 @@ SECURITY VERIFICATION:
 
 PERFORMANCE:
   • Progress tracking for large files
-  • Optimized streaming decryption
-  • Batch processing for multiple files`,
+  • Segments decrypted in parallel on all CPUs (--threads to limit)
+  • Batch processing for multiple files
+  • Shell pipelines: '-' reads stdin and '-o -' writes stdout, decrypted
+    as it streams; the password then comes from --password-file,
+    $FILEVAULT_PASSWORD or the terminal`,
 	Example: `  # Basic decryption
   filevault decrypt document.pdf.enc
 
@@ -48,6 +61,33 @@ PERFORMANCE:
   # Decrypt to directory
   filevault decrypt file1.enc file2.enc -o decrypted/
 
+  # Decrypt a file protected with a keyfile
+  filevault decrypt secret.txt.enc --keyfile ~/keys/photo.jpg
+
+  # Decrypt a file encrypted to your public key
+  filevault decrypt secret.txt.enc -i ~/.filevault/identity.key
+
+  # Decrypt with your SSH key (asks for its passphrase if it has one)
+  filevault decrypt secret.txt.enc --ssh-identity ~/.ssh/id_ed25519
+
+  # Decrypt with the private key of the certificate it was encrypted to
+  filevault decrypt secret.txt.enc --key alice.key
+
+  # Decrypt a 2-of-3 threshold file: asks for a second holder's password
+  # unless a share card from 'filevault shares export' is given
+  filevault decrypt vault.tar.enc
+  filevault decrypt vault.tar.enc --share share-2.txt
+
+  # Extract an encrypted directory into restore/ (restore/project/...)
+  filevault decrypt project.enc -o restore/
+
+  # Restore permissions, timestamps and extended attributes too
+  filevault decrypt deploy.sh.enc --preserve
+
+  # Restore a database straight from the encrypted dump
+  filevault decrypt - < db.enc | psql mydb
+  filevault decrypt db.enc -o - | psql mydb
+
   # Force overwrite existing files
   filevault decrypt backup.enc -o original.txt --force
 
@@ -58,38 +98,70 @@ PERFORMANCE:
 }
 
 var (
-	decryptOutput string
-	decryptForce  bool
+	decryptOutput     string
+	decryptForce      bool
+	decryptThreads    int
+	decryptPreserve   bool
+	decryptKeyfiles   []string
+	decryptIdentities []string
+	decryptSSHKeys    []string
+	decryptCertKeys   []string
+	decryptShares     []string
+	decryptPassFile   string
 )
 
 func init() {
 	DecryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "", "output file or directory")
 	DecryptCmd.Flags().BoolVarP(&decryptForce, "force", "f", false, "overwrite existing files")
+	DecryptCmd.Flags().IntVar(&decryptThreads, "threads", 0, "segment decryption workers (0 = one per CPU)")
+	DecryptCmd.Flags().BoolVarP(&decryptPreserve, "preserve", "p", false, "restore the original mode, timestamps, owner (as root) and extended attributes")
+	DecryptCmd.Flags().StringArrayVar(&decryptKeyfiles, "keyfile", nil, keyfileFlagUsage)
+	DecryptCmd.Flags().StringArrayVarP(&decryptIdentities, "identity", "i", nil, identityFlagUsage)
+	DecryptCmd.Flags().StringArrayVar(&decryptSSHKeys, "ssh-identity", nil, sshIdentityFlagUsage)
+	DecryptCmd.Flags().StringArrayVar(&decryptCertKeys, "key", nil, keyFlagUsage)
+	DecryptCmd.Flags().StringArrayVar(&decryptShares, "share", nil, shareFlagUsage)
+	DecryptCmd.Flags().StringVar(&decryptPassFile, "password-file", "", passwordFileFlagUsage)
 }
 
 func runDecrypt(cmd *cobra.Command, args []string) error {
 	verbose, _ := cmd.Root().PersistentFlags().GetBool("verbose")
 	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
 
+	// Pipelines: stdin in, or stdout out
+	if slices.Contains(args, "-") || decryptOutput == "-" {
+		if len(args) > 1 {
+			return fmt.Errorf("'-' decrypts a single stream; it cannot be combined with other files")
+		}
+		return decryptPipe(args[0], verbose)
+	}
+
+	// Armored files are decrypted from a decoded copy
+	sources, cleanup, err := dearmorInputs(args)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	// Enhanced batch processing
 	if len(args) > 1 {
-		return processBatchDecrypt(args, verbose, quiet)
+		return processBatchDecrypt(args, sources, verbose, quiet)
 	}
 
 	// Single file processing
-	return decryptSingleFile(args[0], verbose, quiet)
+	return decryptSingleFile(args[0], sources[0], verbose, quiet)
 }
 
-// processBatchDecrypt handles multiple file decryption
-func processBatchDecrypt(files []string, verbose, quiet bool) error {
+// processBatchDecrypt handles multiple file decryption; sources are the
+// binary forms of files
+func processBatchDecrypt(files, sources []string, verbose, quiet bool) error {
 	if !quiet {
 		cli.PrintInfo(fmt.Sprintf("Starting batch decryption of %d files", len(files)))
 	}
 
-	// Get password once for all files
-	password, err := security.PromptPassword("Enter password for batch decryption: ")
+	// Get credentials once for all files
+	creds, err := unlockShareCredentials(sources, decryptKeyfiles, slices.Concat(decryptIdentities, decryptSSHKeys, decryptCertKeys), decryptShares, "Enter password for batch decryption: ", passwordReader(decryptPassFile, false, false))
 	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+		return err
 	}
 
 	successCount := 0
@@ -100,7 +172,7 @@ func processBatchDecrypt(files []string, verbose, quiet bool) error {
 			cli.PrintProgress(fmt.Sprintf("Processing file %d/%d: %s", i+1, len(files), inputFile))
 		}
 
-		if err := decryptSingleFileWithPassword(inputFile, password, verbose, quiet); err != nil {
+		if err := decryptSingleFileWithCredentials(inputFile, sources[i], creds, verbose, quiet); err != nil {
 			if !quiet {
 				cli.PrintError(fmt.Sprintf("Failed to decrypt %s: %v", inputFile, err))
 			}
@@ -121,14 +193,14 @@ func processBatchDecrypt(files []string, verbose, quiet bool) error {
 	return nil
 }
 
-func decryptSingleFile(inputFile string, verbose, quiet bool) error {
+func decryptSingleFile(inputFile, source string, verbose, quiet bool) error {
 	// Validate input file
 	if err := security.ValidateInputFile(inputFile); err != nil {
 		return err
 	}
 
 	// Check if it's actually an encrypted file
-	isEncrypted, err := security.IsEncryptedFile(inputFile)
+	isEncrypted, err := security.IsEncryptedFile(source)
 	if err != nil {
 		return fmt.Errorf("failed to check file format: %w", err)
 	}
@@ -139,24 +211,37 @@ func decryptSingleFile(inputFile string, verbose, quiet bool) error {
 		}
 	}
 
+	// Encrypted directories are extracted rather than written to one file
+	if isArchive(source) {
+		creds, err := unlockShareCredentials([]string{source}, decryptKeyfiles, slices.Concat(decryptIdentities, decryptSSHKeys, decryptCertKeys), decryptShares, "Enter password for decryption: ", passwordReader(decryptPassFile, false, false))
+		if err != nil {
+			return err
+		}
+		return decryptArchive(inputFile, source, creds, verbose, quiet)
+	}
+
 	// Get file info for progress tracking
-	fileInfo, err := os.Stat(inputFile)
+	fileInfo, err := os.Stat(source)
 	if err != nil {
 		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	// Determine output file
 	outputFile := decryptOutput
+	name := inputFile
+	if source != inputFile {
+		name = strings.TrimSuffix(inputFile, armorSuffix)
+	}
 	if outputFile == "" {
 		// Auto-determine output filename
-		baseName := filepath.Base(inputFile)
+		baseName := filepath.Base(name)
 		if strings.HasSuffix(baseName, ".enc") {
-			outputFile = strings.TrimSuffix(inputFile, ".enc")
+			outputFile = strings.TrimSuffix(name, ".enc")
 		} else {
-			outputFile = inputFile + ".decrypted"
+			outputFile = name + ".decrypted"
 		}
 	} else if info, err := os.Stat(outputFile); err == nil && info.IsDir() {
-		baseName := filepath.Base(inputFile)
+		baseName := filepath.Base(name)
 		if strings.HasSuffix(baseName, ".enc") {
 			baseName = strings.TrimSuffix(baseName, ".enc")
 		}
@@ -173,9 +258,9 @@ func decryptSingleFile(inputFile string, verbose, quiet bool) error {
 		cli.PrintInfo("Getting password for decryption...")
 	}
 
-	password, err := security.PromptPassword("Enter password for decryption: ")
+	creds, err := unlockShareCredentials([]string{source}, decryptKeyfiles, slices.Concat(decryptIdentities, decryptSSHKeys, decryptCertKeys), decryptShares, "Enter password for decryption: ", passwordReader(decryptPassFile, false, false))
 	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
+		return err
 	}
 
 	// Show progress
@@ -192,16 +277,16 @@ func decryptSingleFile(inputFile string, verbose, quiet bool) error {
 
 	// Perform decryption
 	startTime := time.Now()
+	opts := core.DecryptOptions{Threads: decryptThreads, Preserve: decryptPreserve}
 	if progress != nil {
 		// Use progress callback
-		err = core.DecryptFileWithProgress(inputFile, outputFile, password, func(current, total int64, operation string) {
+		opts.Progress = func(current, total int64, operation string) {
 			// Convert percentage-based progress to file-size based
 			actualProgress := (current * fileInfo.Size()) / total
 			progress.Update(actualProgress)
-		})
-	} else {
-		err = core.DecryptFile(inputFile, outputFile, password)
+		}
 	}
+	err = core.DecryptFileWithOptions(source, outputFile, creds, opts)
 
 	if err != nil {
 		if progress != nil {
@@ -230,40 +315,47 @@ func decryptSingleFile(inputFile string, verbose, quiet bool) error {
 	return nil
 }
 
-// decryptSingleFileWithPassword decrypts a file with pre-provided password
-func decryptSingleFileWithPassword(inputFile, password string, verbose, quiet bool) error {
+// decryptSingleFileWithCredentials decrypts a file with pre-provided credentials
+func decryptSingleFileWithCredentials(inputFile, source string, creds core.Credentials, verbose, quiet bool) error {
 	// Validate input file
 	if err := security.ValidateInputFile(inputFile); err != nil {
 		return err
 	}
 
 	// Check if it's actually an encrypted file
-	isEncrypted, err := security.IsEncryptedFile(inputFile)
+	isEncrypted, err := security.IsEncryptedFile(source)
 	if err != nil {
 		return fmt.Errorf("failed to check file format: %w", err)
 	}
 	if !isEncrypted {
 		return fmt.Errorf("file doesn't appear to be a FileVault encrypted file")
 	}
+	if isArchive(source) {
+		return decryptArchive(inputFile, source, creds, verbose, quiet)
+	}
 
 	// Get file info for progress tracking
-	fileInfo, err := os.Stat(inputFile)
+	fileInfo, err := os.Stat(source)
 	if err != nil {
 		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	// Determine output file
 	outputFile := decryptOutput
+	name := inputFile
+	if source != inputFile {
+		name = strings.TrimSuffix(inputFile, armorSuffix)
+	}
 	if outputFile == "" {
 		// Auto-determine output filename
-		baseName := filepath.Base(inputFile)
+		baseName := filepath.Base(name)
 		if strings.HasSuffix(baseName, ".enc") {
-			outputFile = strings.TrimSuffix(inputFile, ".enc")
+			outputFile = strings.TrimSuffix(name, ".enc")
 		} else {
-			outputFile = inputFile + ".decrypted"
+			outputFile = name + ".decrypted"
 		}
 	} else if info, err := os.Stat(outputFile); err == nil && info.IsDir() {
-		baseName := filepath.Base(inputFile)
+		baseName := filepath.Base(name)
 		if strings.HasSuffix(baseName, ".enc") {
 			baseName = strings.TrimSuffix(baseName, ".enc")
 		}
@@ -288,16 +380,16 @@ func decryptSingleFileWithPassword(inputFile, password string, verbose, quiet bo
 
 	// Perform decryption
 	startTime := time.Now()
+	opts := core.DecryptOptions{Threads: decryptThreads, Preserve: decryptPreserve}
 	if progress != nil {
 		// Use progress callback
-		err = core.DecryptFileWithProgress(inputFile, outputFile, password, func(current, total int64, operation string) {
+		opts.Progress = func(current, total int64, operation string) {
 			// Convert percentage-based progress to file-size based
 			actualProgress := (current * fileInfo.Size()) / total
 			progress.Update(actualProgress)
-		})
-	} else {
-		err = core.DecryptFile(inputFile, outputFile, password)
+		}
 	}
+	err = core.DecryptFileWithOptions(source, outputFile, creds, opts)
 
 	if err != nil {
 		if progress != nil {
@@ -322,3 +414,137 @@ func decryptSingleFileWithPassword(inputFile, password string, verbose, quiet bo
 
 	return nil
 }
+
+// decryptPipe decrypts stdin ("-") or a file to stdout ("-o -") for shell
+// pipelines. Each segment is authenticated before it is written, but a
+// damaged file may have written some plaintext before the error. Messages
+// go to stderr and the password comes from --password-file,
+// $FILEVAULT_PASSWORD or the terminal. Encrypted directories are written
+// to stdout as tar, or extracted from stdin into the -o directory.
+func decryptPipe(inputFile string, verbose bool) error {
+	fromStdin := inputFile == "-"
+	outputFile := decryptOutput
+	if outputFile == "" && fromStdin {
+		outputFile = "-"
+	}
+	toStdout := outputFile == "-"
+
+	if decryptPreserve {
+		return fmt.Errorf("--preserve restores attributes from a file to a file; it cannot be used with '-'")
+	}
+	identityFiles := slices.Concat(decryptIdentities, decryptSSHKeys, decryptCertKeys)
+	readPassword := passwordReader(decryptPassFile, fromStdin, toStdout)
+	opts := core.DecryptOptions{Threads: decryptThreads}
+
+	// A file is decrypted with random access, which also handles recovery
+	// records; stdin is read once, header and key slots first
+	var decrypt func(io.Writer) error
+	if fromStdin {
+		stream, err := core.ReadEncryptedStream(os.Stdin)
+		if err != nil {
+			return err
+		}
+		archive := !toStdout && stream.Header().HasFlag(fileops.FlagArchive)
+		if !toStdout && !archive {
+			if err := security.ValidateOutputFile(outputFile, decryptForce); err != nil {
+				return err
+			}
+		}
+		creds, err := unlockStreamCredentials(stream, decryptKeyfiles, identityFiles, decryptShares, "Enter password for decryption: ", readPassword)
+		if err != nil {
+			return err
+		}
+		if archive {
+			opts.Overwrite = decryptForce
+			if err := stream.ExtractTo(outputFile, creds, opts); err != nil {
+				return fmt.Errorf("decryption failed: %w", err)
+			}
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Extracted stdin into %s\n", outputFile)
+			}
+			return nil
+		}
+		decrypt = func(w io.Writer) error { return stream.DecryptTo(w, creds, opts) }
+	} else {
+		if err := security.ValidateInputFile(inputFile); err != nil {
+			return err
+		}
+		source, cleanup, err := core.Dearmored(inputFile)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		creds, err := unlockShareCredentials([]string{source}, decryptKeyfiles, identityFiles, decryptShares, "Enter password for decryption: ", readPassword)
+		if err != nil {
+			return err
+		}
+		decrypt = func(w io.Writer) error {
+			stream, _, err := core.OpenStream(source, creds)
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			_, err = io.Copy(w, stream)
+			return err
+		}
+	}
+
+	startTime := time.Now()
+	var err error
+	if toStdout {
+		err = decrypt(os.Stdout)
+	} else {
+		var file *os.File
+		if file, err = os.Create(outputFile); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		err = decrypt(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Decrypted %s -> %s in %s\n", pipeName(inputFile, "stdin"), pipeName(outputFile, "stdout"), cli.FormatDuration(time.Since(startTime).Seconds()))
+	}
+	return nil
+}
+
+// isArchive reports whether the encrypted file at path holds a directory
+func isArchive(path string) bool {
+	header, err := core.ReadHeader(path)
+	return err == nil && header.HasFlag(fileops.FlagArchive)
+}
+
+// decryptArchive extracts an encrypted directory into the -o directory, or
+// next to the encrypted file. Existing files are only replaced with --force.
+func decryptArchive(inputFile, source string, creds core.Credentials, verbose, quiet bool) error {
+	destDir := decryptOutput
+	if destDir == "" {
+		destDir = filepath.Dir(inputFile)
+	}
+
+	if verbose && !quiet {
+		cli.PrintInfo(fmt.Sprintf("Extracting %s into %s", inputFile, destDir))
+	}
+
+	startTime := time.Now()
+	opts := core.DecryptOptions{Threads: decryptThreads, Preserve: decryptPreserve, Overwrite: decryptForce}
+	if err := core.DecryptArchive(source, destDir, creds, opts); err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Extracted: %s -> %s", inputFile, destDir))
+		if verbose {
+			cli.PrintInfo(fmt.Sprintf("Extraction completed in %s", cli.FormatDuration(time.Since(startTime).Seconds())))
+		}
+	}
+	return nil
+}
@@ -1,15 +1,22 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/config"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+	"golang.org/x/term"
 )
 
 // EncryptCmd represents the encrypt command
@@ -20,21 +27,45 @@ var EncryptCmd = &cobra.Command{
 
 The encryption process:
   1. Prompts for a password (with strength checking)
-  2. Derives encryption key using PBKDF2 (100,000 iterations)
+  2. Derives encryption key using PBKDF2 (100,000 iterations by default)
+     or Argon2id (--kdf argon2id)
   3. Generates random salt and IV for each file
-  4. Encrypts with AES-256-GCM (provides authentication)
+  4. Encrypts with AES-256-GCM or XChaCha20-Poly1305 (provides authentication)
   5. Creates .enc file with custom FileVault format
 
 SECURITY FEATURES:
   • Each file gets unique salt and IV
   • Password strength validation
+  • Optional keyfiles, alone or combined with the password
+  • Public-key recipients (-r) who decrypt with their identity, no password
+  • Existing SSH ed25519/RSA keys as recipients (--ssh-recipient)
+  • X.509 certificates from a trusted CA as recipients (--cert)
+  • Embedded minisign signature naming the author (--sign)
+  • Threshold keys (--threshold 2-of-3): any K of N password holders and
+    recipients together decrypt, fewer cannot (see 'filevault shares')
+  • ASCII armor (--armor): Base64 between BEGIN/END lines with a checksum,
+    for email and chat; decrypt, info and verify detect it automatically
+  • Recovery record (--redundancy 10) so 'filevault repair' can undo bit
+    rot and damaged sectors without the password
+  • Size hiding: --pad pads to a PADMÉ size (at most 12% larger) and
+    keeps the real size in the encrypted metadata
+  • Mode, timestamps and extended attributes recorded in the encrypted
+    metadata for 'decrypt --preserve' (--owner records the UID/GID too)
+  • Whole directories (--recursive): the tree, with modes, symlinks and
+    empty directories, is streamed as one tar archive into a single .enc
+    that 'decrypt' extracts and 'info --unlock' lists; the directory
+    itself is kept
   • Memory is securely cleaned after use
   • File integrity protection with authentication tags
 
 PERFORMANCE:
   • Progress bars for files > 1MB
   • Optimized streaming for large files
-  • Multi-file batch processing support`,
+  • Segments encrypted in parallel on all CPUs (--threads to limit)
+  • Multi-file batch processing support
+  • Shell pipelines: '-' reads stdin and '-o -' writes stdout, encrypted
+    as it streams; the password then comes from --password-file,
+    $FILEVAULT_PASSWORD or the terminal`,
 	Example: `  # Basic encryption
   filevault encrypt document.pdf
 
@@ -53,6 +84,62 @@ PERFORMANCE:
   # Custom PBKDF2 iterations for extra security
   filevault encrypt secret.txt --iterations 200000
 
+  # Memory-hard Argon2id key derivation (256 MiB, 4 passes)
+  filevault encrypt secret.txt --kdf argon2id --argon2-memory 256 --argon2-time 4
+
+  # Use XChaCha20-Poly1305, or let FileVault pick based on the CPU
+  filevault encrypt secret.txt --cipher xchacha20-poly1305
+  filevault encrypt secret.txt --cipher auto
+
+  # Compress before encrypting (skipped automatically for compressed inputs)
+  filevault encrypt database.sql --compress zstd
+  filevault encrypt logs.txt --compress gzip --compress-level 9
+
+  # Require a keyfile in addition to the password
+  filevault encrypt secret.txt --keyfile ~/keys/photo.jpg
+
+  # Use keyfiles only, without a password
+  filevault encrypt secret.txt --keyfile usb/key1 --keyfile usb/key2 --no-password
+
+  # Encrypt for two people by public key (see 'filevault keygen')
+  filevault encrypt secret.txt -r fvpk1... -r team-keys.txt
+
+  # Encrypt to SSH keys you already have
+  filevault encrypt secret.txt --ssh-recipient ~/.ssh/id_ed25519.pub
+  filevault encrypt secret.txt --ssh-recipient ~/.ssh/authorized_keys
+
+  # Encrypt to a certificate issued by the CA in ca_bundle (or --ca-bundle)
+  filevault encrypt secret.txt --cert alice.pem --ca-bundle corp-ca.pem
+
+  # Hide the exact size of the document
+  filevault encrypt contract.pdf --pad
+
+  # Split the key so that any 2 of 3 people must be present to decrypt:
+  # asks for two passwords, the third share goes to the recipient
+  filevault encrypt vault.tar --threshold 2-of-3 -r fvpk1...
+
+  # Add 10% Reed-Solomon parity to survive bit rot (see 'filevault repair')
+  filevault encrypt archive.tar --redundancy 10
+
+  # Encrypt a whole directory into project.enc (-r names a recipient, so
+  # --recursive has no short form)
+  filevault encrypt --recursive project/
+
+  # Produce an ASCII-armored file (secret.txt.enc.asc) to paste into email
+  filevault encrypt secret.txt --armor -r fvpk1...
+
+  # Keep the original filename readable without the password
+  filevault encrypt report.pdf --plain-name
+
+  # Sign the encrypted file with your minisign key (see 'filevault sign')
+  filevault encrypt report.pdf --sign ~/.filevault/minisign.key
+
+  # Encrypt a database dump in a pipeline; the password is asked on the terminal
+  pg_dump mydb | filevault encrypt - > db.enc
+
+  # Unattended, with the password in a file only you can read
+  tar c project/ | filevault encrypt - --password-file ~/.vault-pass | ssh backup 'cat > project.tar.enc'
+
   # Force overwrite existing files
   filevault encrypt data.xlsx -o backup.enc --force`,
 	Args: cobra.MinimumNArgs(1),
@@ -64,48 +151,271 @@ var (
 	encryptForce      bool
 	encryptKeep       bool
 	encryptIterations int
+	encryptKDF        string
+	encryptCipher     string
+	encryptArgon2Mem  uint32
+	encryptArgon2Time uint32
+	encryptArgon2Par  uint8
+	encryptPlainName  bool
+	encryptOwner      bool
+	encryptPad        bool
+	encryptCompress   string
+	encryptCompLevel  uint8
+	encryptThreads    int
+	encryptKeyfiles   []string
+	encryptNoPassword bool
+	encryptRecipients []string
+	encryptSSHKeys    []string
+	encryptCerts      []string
+	encryptCABundle   string
+	encryptSign       string
+	encryptRedundancy int
+	encryptThreshold  string
+	encryptArmor      bool
+	encryptPassFile   string
+	encryptRecursive  bool
 )
 
 func init() {
 	EncryptCmd.Flags().StringVarP(&encryptOutput, "output", "o", "", "output file or directory")
 	EncryptCmd.Flags().BoolVarP(&encryptForce, "force", "f", false, "overwrite existing files")
 	EncryptCmd.Flags().BoolVarP(&encryptKeep, "keep", "k", false, "keep original file after encryption")
-	EncryptCmd.Flags().IntVar(&encryptIterations, "iterations", 100000, "PBKDF2 iterations")
+	EncryptCmd.Flags().IntVar(&encryptIterations, "iterations", crypto.DefaultIterations, "PBKDF2 iterations (see 'filevault kdf calibrate')")
+	EncryptCmd.Flags().StringVar(&encryptCipher, "cipher", "aes-256-gcm", "cipher: aes-256-gcm, xchacha20-poly1305 or auto")
+	EncryptCmd.Flags().StringVar(&encryptKDF, "kdf", "pbkdf2", "key derivation function: pbkdf2 or argon2id")
+	EncryptCmd.Flags().Uint32Var(&encryptArgon2Mem, "argon2-memory", crypto.DefaultArgon2Memory/1024, "Argon2id memory in MiB")
+	EncryptCmd.Flags().Uint32Var(&encryptArgon2Time, "argon2-time", crypto.DefaultArgon2Time, "Argon2id passes")
+	EncryptCmd.Flags().Uint8Var(&encryptArgon2Par, "argon2-parallelism", crypto.DefaultArgon2Parallelism, "Argon2id lanes")
+	EncryptCmd.Flags().BoolVar(&encryptPlainName, "plain-name", false, "store the original filename unencrypted in the header (records no file attributes)")
+	EncryptCmd.Flags().BoolVar(&encryptPad, "pad", false, "pad to a PADMÉ size so the ciphertext does not reveal the exact size")
+	EncryptCmd.Flags().BoolVar(&encryptOwner, "owner", false, "record the owner UID/GID for 'decrypt --preserve'")
+	EncryptCmd.Flags().StringVar(&encryptCompress, "compress", "none", "compress before encrypting: none, gzip or zstd")
+	EncryptCmd.Flags().Uint8Var(&encryptCompLevel, "compress-level", 0, "compression level (gzip 1-9, zstd 1-22; 0 for the default)")
+	EncryptCmd.Flags().IntVar(&encryptThreads, "threads", 0, "segment encryption workers (0 = one per CPU)")
+	EncryptCmd.Flags().StringArrayVar(&encryptKeyfiles, "keyfile", nil, keyfileFlagUsage)
+	EncryptCmd.Flags().BoolVar(&encryptNoPassword, "no-password", false, "encrypt with keyfiles only, without a password")
+	EncryptCmd.Flags().StringArrayVarP(&encryptRecipients, "recipient", "r", nil, recipientFlagUsage)
+	EncryptCmd.Flags().StringArrayVar(&encryptSSHKeys, "ssh-recipient", nil, sshRecipientFlagUsage)
+	EncryptCmd.Flags().StringArrayVar(&encryptCerts, "cert", nil, certFlagUsage)
+	EncryptCmd.Flags().StringVar(&encryptCABundle, "ca-bundle", "", "CA certificates (PEM) that --cert must chain to (overrides ca_bundle)")
+	EncryptCmd.Flags().StringVar(&encryptSign, "sign", "", "embed a signature made with this minisign secret key")
+	EncryptCmd.Flags().IntVar(&encryptRedundancy, "redundancy", 0, "append a recovery record with this much Reed-Solomon parity, in percent (1-100)")
+	EncryptCmd.Flags().StringVar(&encryptThreshold, "threshold", "", "split the key so any K of N holders decrypt, e.g. 2-of-3 (recipients hold shares too)")
+	EncryptCmd.Flags().BoolVar(&encryptArmor, "armor", false, "write ASCII armor (Base64 with BEGIN/END lines) instead of binary")
+	EncryptCmd.Flags().StringVar(&encryptPassFile, "password-file", "", passwordFileFlagUsage)
+	EncryptCmd.Flags().BoolVar(&encryptRecursive, "recursive", false, "encrypt directories as one archive each, with their whole tree")
+}
+
+// encryptOptions builds the core encryption options. Flags given on the
+// command line win over the config file, which wins over built-in defaults.
+func encryptOptions(cmd *cobra.Command) (core.EncryptOptions, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return core.EncryptOptions{}, err
+	}
+
+	if cmd.Flags().Changed("cipher") {
+		cfg.DefaultAlgorithm = encryptCipher
+	}
+	if cmd.Flags().Changed("kdf") {
+		cfg.DefaultKDF = encryptKDF
+	}
+	if cmd.Flags().Changed("iterations") {
+		cfg.DefaultIterations = encryptIterations
+	}
+	if cmd.Flags().Changed("argon2-memory") {
+		cfg.Argon2Memory = encryptArgon2Mem
+	}
+	if cmd.Flags().Changed("argon2-time") {
+		cfg.Argon2Time = encryptArgon2Time
+	}
+	if cmd.Flags().Changed("argon2-parallelism") {
+		cfg.Argon2Parallelism = encryptArgon2Par
+	}
+	if cmd.Flags().Changed("compress") {
+		cfg.Compression = encryptCompress
+	}
+	if cmd.Flags().Changed("compress-level") {
+		cfg.CompressionLevel = encryptCompLevel
+	}
+	if encryptPad && !cmd.Flags().Changed("compress") {
+		// Compressed sizes depend on the content; padding hides the size
+		cfg.Compression = "none"
+	}
+	if cmd.Flags().Changed("ca-bundle") {
+		cfg.CABundle = encryptCABundle
+	}
+	if cmd.Flags().Changed("redundancy") {
+		cfg.Redundancy = encryptRedundancy
+	}
+	if encryptArmor {
+		// Recovery records repair binary files; armor has its own checksum
+		if cmd.Flags().Changed("redundancy") && encryptRedundancy > 0 {
+			return core.EncryptOptions{}, fmt.Errorf("--armor cannot be combined with --redundancy")
+		}
+		cfg.Redundancy = 0
+	}
+	if cfg.Redundancy < 0 || cfg.Redundancy > 100 {
+		return core.EncryptOptions{}, fmt.Errorf("invalid redundancy %d%%: must be between 1 and 100", cfg.Redundancy)
+	}
+
+	algorithm, err := cfg.Algorithm()
+	if err != nil {
+		return core.EncryptOptions{}, err
+	}
+
+	params, err := cfg.KDFParams()
+	if err != nil {
+		return core.EncryptOptions{}, err
+	}
+	if err := params.Validate(); err != nil {
+		return core.EncryptOptions{}, err
+	}
+
+	compressionParams, err := cfg.CompressionParams()
+	if err != nil {
+		return core.EncryptOptions{}, err
+	}
+
+	recipients, err := parseRecipients(slices.Concat(encryptRecipients, encryptSSHKeys))
+	if err != nil {
+		return core.EncryptOptions{}, err
+	}
+	certRecipients, err := parseCertRecipients(encryptCerts, cfg)
+	if err != nil {
+		return core.EncryptOptions{}, err
+	}
+	recipients = append(recipients, certRecipients...)
+
+	threshold := 0
+	if encryptThreshold != "" {
+		var shares int
+		if threshold, shares, err = parseThreshold(encryptThreshold); err != nil {
+			return core.EncryptOptions{}, err
+		}
+		if len(recipients) > shares {
+			return core.EncryptOptions{}, fmt.Errorf("%d recipients need at least %d shares, not %d", len(recipients), len(recipients), shares)
+		}
+		if len(encryptKeyfiles) > 0 || encryptNoPassword {
+			return core.EncryptOptions{}, fmt.Errorf("--threshold shares go to passwords and recipients; it cannot be combined with keyfiles")
+		}
+	}
+
+	var signer *crypto.MinisignPrivateKey
+	if encryptSign != "" {
+		if signer, err = loadSigningKey(encryptSign); err != nil {
+			return core.EncryptOptions{}, err
+		}
+	}
+
+	return core.EncryptOptions{
+		KDF:               params,
+		Algorithm:         algorithm,
+		PlaintextMetadata: encryptPlainName,
+		RecordOwner:       encryptOwner,
+		Pad:               encryptPad,
+		Compression:       compressionParams,
+		Threads:           encryptThreads,
+		Recipients:        recipients,
+		Threshold:         threshold,
+		Signer:            signer,
+		Recovery:          cfg.Redundancy,
+		Armor:             encryptArmor,
+	}, nil
+}
+
+// encryptCredentials asks for the password and keyfiles that protect new
+// files. Files encrypted to recipients need no password unless keyfiles
+// were given too. With --threshold, every share not held by a recipient
+// gets a password of its own, asked again if another holder already has
+// it; the holders after the first are added to opts.ShareHolders.
+func encryptCredentials(opts *core.EncryptOptions, prompt string, readPassword func(string) (string, error)) (core.Credentials, error) {
+	if opts.Threshold > 0 {
+		_, shares, err := parseThreshold(encryptThreshold)
+		if err != nil {
+			return core.Credentials{}, err
+		}
+		if shares-len(opts.Recipients) > 1 && (encryptPassFile != "" || os.Getenv(passwordEnv) != "") {
+			return core.Credentials{}, fmt.Errorf("--threshold needs a password for each share holder; enter them on the terminal")
+		}
+		var holders []core.Credentials
+		for len(holders) < shares-len(opts.Recipients) {
+			creds, err := newCredentials(nil, false, fmt.Sprintf("Enter password for share holder %d of %d: ", len(holders)+1, shares-len(opts.Recipients)), readPassword)
+			if err != nil {
+				return core.Credentials{}, err
+			}
+			if err := core.CheckShareHolder(holders, creds); errors.Is(err, core.ErrDuplicateHolder) {
+				cli.PrintWarning("Another share holder already has this password; each holder needs their own")
+				continue
+			} else if err != nil {
+				return core.Credentials{}, err
+			}
+			holders = append(holders, creds)
+		}
+		if len(holders) == 0 {
+			return core.Credentials{}, nil
+		}
+		opts.ShareHolders = holders[1:]
+		return holders[0], nil
+	}
+	if len(opts.Recipients) > 0 && len(encryptKeyfiles) == 0 && !encryptNoPassword {
+		return core.Credentials{}, nil
+	}
+	return newCredentials(encryptKeyfiles, encryptNoPassword, prompt, readPassword)
+}
+
+// encryptedSuffix is appended to the names of encrypted files
+func encryptedSuffix(opts core.EncryptOptions) string {
+	if opts.Armor {
+		return ".enc" + armorSuffix
+	}
+	return ".enc"
 }
 
 func runEncrypt(cmd *cobra.Command, args []string) error {
 	verbose, _ := cmd.Root().PersistentFlags().GetBool("verbose")
 	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
 
+	// Reject bad key derivation settings before asking for a password
+	opts, err := encryptOptions(cmd)
+	if err != nil {
+		return fmt.Errorf("invalid encryption settings: %w", err)
+	}
+
+	if encryptRecursive && encryptPad {
+		return fmt.Errorf("--pad needs the size of the input up front; it cannot be used with --recursive")
+	}
+
+	// Pipelines: stdin in, or stdout out
+	if slices.Contains(args, "-") || encryptOutput == "-" {
+		if len(args) > 1 {
+			return fmt.Errorf("'-' encrypts a single stream; it cannot be combined with other files")
+		}
+		return encryptPipe(cmd, args[0], opts, verbose)
+	}
+
 	// Enhanced batch processing
 	if len(args) > 1 {
-		return processBatchEncrypt(args, verbose, quiet)
+		return processBatchEncrypt(args, opts, verbose, quiet)
 	}
 
-	// Single file processing
-	return encryptSingleFile(args[0], verbose, quiet)
+	// Single directory or file processing
+	if isDirectory(args[0]) {
+		return encryptDirectory(args[0], opts, verbose, quiet)
+	}
+	return encryptSingleFile(args[0], opts, verbose, quiet)
 }
 
 // processBatchEncrypt handles multiple file encryption
-func processBatchEncrypt(files []string, verbose, quiet bool) error {
+func processBatchEncrypt(files []string, opts core.EncryptOptions, verbose, quiet bool) error {
 	if !quiet {
 		cli.PrintInfo(fmt.Sprintf("Starting batch encryption of %d files", len(files)))
 	}
 
-	// Get password once for all files
-	password, err := security.PromptPassword("Enter password for batch encryption: ")
+	// Get credentials once for all files
+	creds, err := encryptCredentials(&opts, "Enter password for batch encryption: ", passwordReader(encryptPassFile, false, false))
 	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
-	}
-
-	// Confirm password
-	confirmPassword, err := security.PromptPassword("Confirm password: ")
-	if err != nil {
-		return fmt.Errorf("failed to get password confirmation: %w", err)
-	}
-
-	if password != confirmPassword {
-		return fmt.Errorf("passwords do not match")
+		return err
 	}
 
 	successCount := 0
@@ -116,7 +426,7 @@ func processBatchEncrypt(files []string, verbose, quiet bool) error {
 			cli.PrintProgress(fmt.Sprintf("Processing file %d/%d: %s", i+1, len(files), inputFile))
 		}
 
-		if err := encryptSingleFileWithPassword(inputFile, password, verbose, quiet); err != nil {
+		if err := encryptSingleFileWithCredentials(inputFile, creds, opts, verbose, quiet); err != nil {
 			if !quiet {
 				cli.PrintError(fmt.Sprintf("Failed to encrypt %s: %v", inputFile, err))
 			}
@@ -137,7 +447,7 @@ func processBatchEncrypt(files []string, verbose, quiet bool) error {
 	return nil
 }
 
-func encryptSingleFile(inputFile string, verbose, quiet bool) error {
+func encryptSingleFile(inputFile string, opts core.EncryptOptions, verbose, quiet bool) error {
 	// Validate input file
 	if err := security.ValidateInputFile(inputFile); err != nil {
 		return err
@@ -152,9 +462,9 @@ func encryptSingleFile(inputFile string, verbose, quiet bool) error {
 	// Determine output file
 	outputFile := encryptOutput
 	if outputFile == "" {
-		outputFile = inputFile + ".enc"
+		outputFile = inputFile + encryptedSuffix(opts)
 	} else if info, err := os.Stat(outputFile); err == nil && info.IsDir() {
-		outputFile = filepath.Join(outputFile, filepath.Base(inputFile)+".enc")
+		outputFile = filepath.Join(outputFile, filepath.Base(inputFile)+encryptedSuffix(opts))
 	}
 
 	// Validate output file
@@ -167,39 +477,21 @@ func encryptSingleFile(inputFile string, verbose, quiet bool) error {
 		cli.PrintInfo("Getting password for encryption...")
 	}
 
-	password, err := security.PromptPassword("Enter password for encryption: ")
-	if err != nil {
-		return fmt.Errorf("failed to get password: %w", err)
-	}
-
-	// Confirm password
-	confirmPassword, err := security.PromptPassword("Confirm password: ")
+	creds, err := encryptCredentials(&opts, "Enter password for encryption: ", passwordReader(encryptPassFile, false, false))
 	if err != nil {
-		return fmt.Errorf("failed to get password confirmation: %w", err)
-	}
-
-	if password != confirmPassword {
-		return fmt.Errorf("passwords do not match")
+		return err
 	}
 
-	// Check password strength
-	strength := security.CheckPasswordStrength(password)
-	if strength == security.Weak && !encryptForce {
-		if !quiet {
-			cli.PrintWarning(fmt.Sprintf("Password strength is %s", strength))
-			if !cli.ConfirmAction("Continue with weak password?") {
-				return fmt.Errorf("encryption cancelled due to weak password")
-			}
-		}
-	} else if verbose {
-		cli.PrintInfo(fmt.Sprintf("Password strength: %s", strength))
+	if err := checkPasswordStrength(creds, opts, verbose, quiet); err != nil {
+		return err
 	}
 
 	// Show progress
 	if verbose && !quiet {
 		cli.PrintInfo(fmt.Sprintf("Encrypting %s -> %s", inputFile, outputFile))
 		cli.PrintInfo(fmt.Sprintf("File size: %s", cli.FormatBytes(uint64(fileInfo.Size()))))
-		cli.PrintInfo(fmt.Sprintf("Using PBKDF2 with %d iterations", encryptIterations))
+		cli.PrintInfo(fmt.Sprintf("Cipher: %s", crypto.AlgorithmName(opts.Algorithm)))
+		cli.PrintInfo(fmt.Sprintf("Key derivation: %s", opts.KDF))
 	}
 
 	// Create progress bar for larger files
@@ -212,12 +504,11 @@ func encryptSingleFile(inputFile string, verbose, quiet bool) error {
 	startTime := time.Now()
 	if progress != nil {
 		// Use progress callback
-		err = core.EncryptFileWithProgress(inputFile, outputFile, password, func(current, total int64, operation string) {
+		opts.Progress = func(current, total int64, operation string) {
 			progress.Update(current)
-		})
-	} else {
-		err = core.EncryptFile(inputFile, outputFile, password)
+		}
 	}
+	err = core.EncryptFileWithOptions(inputFile, outputFile, creds, opts)
 
 	if err != nil {
 		if progress != nil {
@@ -254,8 +545,46 @@ func encryptSingleFile(inputFile string, verbose, quiet bool) error {
 	return nil
 }
 
-// encryptSingleFileWithPassword encrypts a file with pre-provided password
-func encryptSingleFileWithPassword(inputFile, password string, verbose, quiet bool) error {
+// checkPasswordStrength warns about weak passwords and asks whether to go
+// on with them: that of creds and, with --threshold, those of the other
+// share holders. Keyfile-only credentials have no password to check.
+func checkPasswordStrength(creds core.Credentials, opts core.EncryptOptions, verbose, quiet bool) error {
+	passwords := []string{creds.Password}
+	for _, holder := range opts.ShareHolders {
+		passwords = append(passwords, holder.Password)
+	}
+	label := func(i int) string {
+		if len(passwords) == 1 {
+			return "Password"
+		}
+		return fmt.Sprintf("Password of share holder %d", i+1)
+	}
+
+	if weak := security.WeakPasswords(passwords); len(weak) > 0 && !encryptForce {
+		if !quiet {
+			for _, i := range weak {
+				cli.PrintWarning(fmt.Sprintf("%s strength is %s", label(i), security.Weak))
+			}
+			if !cli.ConfirmAction("Continue with weak password?") {
+				return fmt.Errorf("encryption cancelled due to weak password")
+			}
+		}
+	} else if verbose {
+		for i, password := range passwords {
+			if password != "" {
+				cli.PrintInfo(fmt.Sprintf("%s strength: %s", label(i), security.CheckPasswordStrength(password)))
+			}
+		}
+	}
+	return nil
+}
+
+// encryptSingleFileWithCredentials encrypts a file with pre-provided credentials
+func encryptSingleFileWithCredentials(inputFile string, creds core.Credentials, opts core.EncryptOptions, verbose, quiet bool) error {
+	if isDirectory(inputFile) {
+		return encryptDirectoryWithCredentials(inputFile, creds, opts, verbose, quiet)
+	}
+
 	// Validate input file
 	if err := security.ValidateInputFile(inputFile); err != nil {
 		return err
@@ -270,9 +599,9 @@ func encryptSingleFileWithPassword(inputFile, password string, verbose, quiet bo
 	// Determine output file
 	outputFile := encryptOutput
 	if outputFile == "" {
-		outputFile = inputFile + ".enc"
+		outputFile = inputFile + encryptedSuffix(opts)
 	} else if info, err := os.Stat(outputFile); err == nil && info.IsDir() {
-		outputFile = filepath.Join(outputFile, filepath.Base(inputFile)+".enc")
+		outputFile = filepath.Join(outputFile, filepath.Base(inputFile)+encryptedSuffix(opts))
 	}
 
 	// Validate output file
@@ -295,12 +624,11 @@ func encryptSingleFileWithPassword(inputFile, password string, verbose, quiet bo
 	startTime := time.Now()
 	if progress != nil {
 		// Use progress callback
-		err = core.EncryptFileWithProgress(inputFile, outputFile, password, func(current, total int64, operation string) {
+		opts.Progress = func(current, total int64, operation string) {
 			progress.Update(current)
-		})
-	} else {
-		err = core.EncryptFile(inputFile, outputFile, password)
+		}
 	}
+	err = core.EncryptFileWithOptions(inputFile, outputFile, creds, opts)
 
 	if err != nil {
 		if progress != nil {
@@ -336,3 +664,213 @@ func encryptSingleFileWithPassword(inputFile, password string, verbose, quiet bo
 
 	return nil
 }
+
+// encryptPipe encrypts stdin ("-"), or a file or directory to stdout
+// ("-o -"), for shell pipelines. Nothing is buffered as a whole, the input is never removed,
+// and stdout carries only the encrypted data: messages go to stderr and
+// the password comes from --password-file, $FILEVAULT_PASSWORD or the
+// terminal.
+func encryptPipe(cmd *cobra.Command, inputFile string, opts core.EncryptOptions, verbose bool) error {
+	fromStdin := inputFile == "-"
+	outputFile := encryptOutput
+	if outputFile == "" && fromStdin {
+		outputFile = "-"
+	}
+	toStdout := outputFile == "-"
+
+	if fromStdin && opts.Pad {
+		return fmt.Errorf("--pad needs the size of the input up front; it cannot be used with stdin")
+	}
+	if toStdout && !opts.Armor && term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("refusing to write encrypted data to a terminal (redirect stdout or use --armor)")
+	}
+	if toStdout && !cmd.Flags().Changed("redundancy") && !isRegularFile(os.Stdout) {
+		// A recovery record from the config needs a file to seek back in
+		opts.Recovery = 0
+	}
+	directory := !fromStdin && isDirectory(inputFile)
+	if directory && !encryptRecursive {
+		return fmt.Errorf("%s is a directory (use --recursive to encrypt it as one archive)", inputFile)
+	}
+	if directory {
+		if err := security.ValidateInputDirectory(inputFile); err != nil {
+			return err
+		}
+	} else if !fromStdin {
+		if err := security.ValidateInputFile(inputFile); err != nil {
+			return err
+		}
+	}
+	if !toStdout {
+		if info, err := os.Stat(outputFile); err == nil && info.IsDir() {
+			return fmt.Errorf("stdin has no name to put in %s; give -o a file name", outputFile)
+		}
+		if err := security.ValidateOutputFile(outputFile, encryptForce); err != nil {
+			return err
+		}
+	}
+
+	creds, err := encryptCredentials(&opts, "Enter password for encryption: ", passwordReader(encryptPassFile, fromStdin, toStdout))
+	if err != nil {
+		return err
+	}
+
+	// Nobody can be asked to confirm a weak password here, nor that of
+	// any other share holder
+	passwords := []string{creds.Password}
+	for _, holder := range opts.ShareHolders {
+		passwords = append(passwords, holder.Password)
+	}
+	if len(security.WeakPasswords(passwords)) > 0 && !encryptForce {
+		return fmt.Errorf("password strength is %s (use --force to encrypt anyway)", security.Weak)
+	}
+
+	var output io.Writer = os.Stdout
+	var file *os.File
+	if !toStdout {
+		if file, err = os.Create(outputFile); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		output = file
+	}
+
+	startTime := time.Now()
+	switch {
+	case fromStdin:
+		err = core.EncryptStream(output, os.Stdin, creds, opts)
+	case directory:
+		err = core.EncryptDirectoryTo(output, inputFile, creds, opts)
+	default:
+		err = core.EncryptFileTo(output, inputFile, creds, opts)
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Encrypted %s -> %s in %s\n", pipeName(inputFile, "stdin"), pipeName(outputFile, "stdout"), cli.FormatDuration(time.Since(startTime).Seconds()))
+	}
+	return nil
+}
+
+// pipeName returns stream, e.g. "stdin", for the path "-" in messages
+func pipeName(path, stream string) string {
+	if path == "-" {
+		return stream
+	}
+	return path
+}
+
+// isRegularFile reports whether file, such as a redirected stdout, is a
+// regular file rather than a pipe or terminal
+func isRegularFile(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// isDirectory reports whether path names a directory
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// directoryOutput returns where the archive of dirPath goes: next to it,
+// or into the directory given with -o, named after it
+func directoryOutput(dirPath string, opts core.EncryptOptions) (string, error) {
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve directory: %w", err)
+	}
+	outputFile := encryptOutput
+	if outputFile == "" {
+		// Next to the directory, named as given unless that is "." or ".."
+		outputFile = filepath.Clean(dirPath)
+		if base := filepath.Base(outputFile); base == "." || base == ".." {
+			outputFile = absPath
+		}
+		outputFile += encryptedSuffix(opts)
+	} else if isDirectory(outputFile) {
+		outputFile = filepath.Join(outputFile, filepath.Base(absPath)+encryptedSuffix(opts))
+	}
+
+	// The archive would otherwise grow while it is read
+	absOutput, err := filepath.Abs(outputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output file: %w", err)
+	}
+	if rel, err := filepath.Rel(absPath, absOutput); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output file %s is inside the directory being encrypted", outputFile)
+	}
+	return outputFile, nil
+}
+
+// encryptDirectory encrypts a directory given with --recursive into one
+// archive, asking for the credentials once the paths are checked
+func encryptDirectory(dirPath string, opts core.EncryptOptions, verbose, quiet bool) error {
+	if !encryptRecursive {
+		return fmt.Errorf("%s is a directory (use --recursive to encrypt it as one archive)", dirPath)
+	}
+	if err := security.ValidateInputDirectory(dirPath); err != nil {
+		return err
+	}
+	outputFile, err := directoryOutput(dirPath, opts)
+	if err != nil {
+		return err
+	}
+	if err := security.ValidateOutputFile(outputFile, encryptForce); err != nil {
+		return err
+	}
+
+	creds, err := encryptCredentials(&opts, "Enter password for encryption: ", passwordReader(encryptPassFile, false, false))
+	if err != nil {
+		return err
+	}
+	if err := checkPasswordStrength(creds, opts, verbose, quiet); err != nil {
+		return err
+	}
+	return encryptDirectoryWithCredentials(dirPath, creds, opts, verbose, quiet)
+}
+
+// encryptDirectoryWithCredentials encrypts a directory tree into one
+// archive with pre-provided credentials. The directory itself is kept.
+func encryptDirectoryWithCredentials(dirPath string, creds core.Credentials, opts core.EncryptOptions, verbose, quiet bool) error {
+	if !encryptRecursive {
+		return fmt.Errorf("%s is a directory (use --recursive to encrypt it as one archive)", dirPath)
+	}
+	if err := security.ValidateInputDirectory(dirPath); err != nil {
+		return err
+	}
+	outputFile, err := directoryOutput(dirPath, opts)
+	if err != nil {
+		return err
+	}
+	if err := security.ValidateOutputFile(outputFile, encryptForce); err != nil {
+		return err
+	}
+
+	if verbose && !quiet {
+		cli.PrintInfo(fmt.Sprintf("Encrypting directory %s -> %s", dirPath, outputFile))
+	}
+
+	startTime := time.Now()
+	if err := core.EncryptDirectory(dirPath, outputFile, creds, opts); err != nil {
+		os.Remove(outputFile)
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Encrypted: %s -> %s", dirPath, outputFile))
+		if verbose {
+			cli.PrintInfo(fmt.Sprintf("Encryption completed in %s", cli.FormatDuration(time.Since(startTime).Seconds())))
+		}
+	}
+	return nil
+}
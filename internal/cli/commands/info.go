@@ -2,13 +2,18 @@ package commands
 
 import (
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
 )
 
 // InfoCmd represents the info command
@@ -19,16 +24,23 @@ var InfoCmd = &cobra.Command{
 
 Shows detailed metadata including:
   • File format version and magic number
-  • Encryption algorithm (AES-256-GCM)
+  • Encryption algorithm (AES-256-GCM or XChaCha20-Poly1305)
   • Original filename and file size
+  • Recorded mode, timestamps, owner and extended attributes (--unlock)
+  • The members of an encrypted directory (--unlock)
+  • Compression algorithm and the real compression ratio
   • Salt and IV information (for security analysis)
   • PBKDF2 iteration count
+  • Factors needed to unlock the file (password, keyfile, identity)
   • Authentication tag status
   • File creation and modification times
   • File integrity status
 
 This command does NOT require the password and will not decrypt the file.
 It only reads and displays the metadata stored in the FileVault header.
+Files in format v3 keep the original filename in an encrypted metadata
+block; use --unlock to enter the password and show it, along with the
+attributes 'decrypt --preserve' restores.
 
 SECURITY ANALYSIS:
   • Verifies FileVault format integrity
@@ -46,6 +58,9 @@ BATCH PROCESSING:
   # Analyze encryption parameters
   filevault info secret-data.enc
 
+  # Show the encrypted original filename (asks for the password)
+  filevault info --unlock secret-data.enc
+
   # Check multiple encrypted files
   filevault info backup1.enc backup2.enc backup3.enc
 
@@ -62,11 +77,23 @@ BATCH PROCESSING:
 }
 
 var (
-	infoShowHex bool
+	infoShowHex    bool
+	infoUnlock     bool
+	infoKeyfiles   []string
+	infoIdentities []string
+	infoSSHKeys    []string
+	infoCertKeys   []string
+	infoShares     []string
 )
 
 func init() {
 	InfoCmd.Flags().BoolVar(&infoShowHex, "hex", false, "show cryptographic parameters in hexadecimal")
+	InfoCmd.Flags().BoolVar(&infoUnlock, "unlock", false, "ask for the password and show encrypted metadata")
+	InfoCmd.Flags().StringArrayVar(&infoKeyfiles, "keyfile", nil, keyfileFlagUsage+", used with --unlock")
+	InfoCmd.Flags().StringArrayVarP(&infoIdentities, "identity", "i", nil, identityFlagUsage+", used with --unlock")
+	InfoCmd.Flags().StringArrayVar(&infoSSHKeys, "ssh-identity", nil, sshIdentityFlagUsage+", used with --unlock")
+	InfoCmd.Flags().StringArrayVar(&infoCertKeys, "key", nil, keyFlagUsage+", used with --unlock")
+	InfoCmd.Flags().StringArrayVar(&infoShares, "share", nil, shareFlagUsage+", used with --unlock")
 }
 
 func runInfo(cmd *cobra.Command, args []string) error {
@@ -100,21 +127,56 @@ func analyzeFile(inputFile string, verbose, quiet bool) error {
 		return fmt.Errorf("failed to analyze file: %w", err)
 	}
 
+	// Armored files are inspected through a decoded copy
+	source, cleanup, err := core.Dearmored(inputFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	// Read detailed header information
-	file, err := os.Open(inputFile)
+	file, err := os.Open(source)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
 	var header fileops.FileHeader
-	if result.FormatValid {
+	if result.HeaderValid {
 		_, err = header.ReadFrom(file)
 		if err != nil {
 			return fmt.Errorf("failed to read file header: %w", err)
 		}
 	}
 
+	// Decrypt the metadata block on request, and the archive of an
+	// encrypted directory to list it; nothing is written
+	var attrs *fileops.FileAttributes
+	var members []core.ArchiveEntry
+	if infoUnlock && result.IsValid && (result.MetadataEncrypted || result.Archive) {
+		creds, err := unlockShareCredentials([]string{source}, infoKeyfiles, slices.Concat(infoIdentities, infoSSHKeys, infoCertKeys), infoShares, "Enter password to unlock metadata: ", security.PromptPassword)
+		if err != nil {
+			return err
+		}
+
+		// An archive is decrypted once, for its metadata and members alike
+		var meta *fileops.Metadata
+		if result.Archive {
+			meta, members, err = core.ReadArchive(source, creds)
+		} else {
+			meta, err = core.ReadMetadata(source, creds)
+		}
+		if err != nil {
+			return err
+		}
+		result.OriginalFilename = meta.FileName
+		result.MetadataEncrypted = false
+		if result.Padded {
+			result.OriginalSize = meta.Size
+		}
+		attrs = meta.Attributes
+	}
+
 	// Display comprehensive information
 	if !quiet {
 		fmt.Printf("%s═══════════════════════════════════════════════════════════════%s\n", cli.ColorBlue, cli.ColorReset)
@@ -135,26 +197,58 @@ func analyzeFile(inputFile string, verbose, quiet bool) error {
 		if result.IsValid {
 			fmt.Printf("  Status: %s✅ Valid FileVault File%s\n", cli.ColorGreen, cli.ColorReset)
 			fmt.Printf("  Format: FileVault v%d\n", result.FormatVersion)
+			if result.Armored {
+				fmt.Printf("  ASCII Armor: yes (sizes below are of the decoded file)\n")
+			}
+			if result.FormatVersion < fileops.FormatVersion {
+				fmt.Printf("  %sLegacy format: run 'filevault upgrade %s' to convert it to v%d%s\n",
+					cli.ColorYellow, inputFile, fileops.FormatVersion, cli.ColorReset)
+			}
 			fmt.Printf("  Algorithm: %s\n", result.Algorithm)
-			fmt.Printf("  Key Derivation: PBKDF2-SHA256 (100,000 iterations)\n")
+			fmt.Printf("  Key Derivation: %s\n", result.KDF)
+			fmt.Printf("  Unlocked By: %s\n", result.Unlock)
+			if result.KeySlots > 0 {
+				fmt.Printf("  Key Slots: %d (see 'filevault passwd list')\n", result.KeySlots)
+			}
+			if result.SignerKeyID != "" {
+				fmt.Printf("  Signed By: key %s (check with 'filevault verify --pubkey')\n", result.SignerKeyID)
+			}
+			if result.Recovery != nil {
+				fmt.Printf("  Recovery Record: %s\n", recoveryDescription(result.Recovery))
+			}
+			if result.Archive {
+				fmt.Printf("  Contents: directory (tar archive, listed with --unlock)\n")
+			}
 		} else {
 			fmt.Printf("  Status: %s❌ Invalid or Corrupted%s\n", cli.ColorRed, cli.ColorReset)
 			fmt.Printf("  Error: %s\n", result.ErrorMessage)
+			if result.Recovery != nil {
+				printDamage(result.Recovery)
+			}
 		}
 		fmt.Printf("\n")
 
 		// Original file information
-		if result.IsValid && result.OriginalFilename != "" {
+		if result.IsValid && (result.OriginalFilename != "" || result.MetadataEncrypted) {
 			fmt.Printf("%sOriginal File Information:%s\n", cli.ColorCyan, cli.ColorReset)
-			fmt.Printf("  Original Filename: %s\n", result.OriginalFilename)
-			fmt.Printf("  Original Size: %s\n", cli.FormatBytes(result.OriginalSize))
-
-			// Calculate compression ratio
-			compressionRatio := float64(result.OriginalSize) / float64(result.FileSize) * 100
-			fmt.Printf("  Compression Ratio: %.1f%%\n", compressionRatio)
+			fmt.Printf("  Original Filename: %s\n", originalName(result))
+			fmt.Printf("  Original Size: %s\n", originalSize(result))
+			fmt.Printf("  Compression: %s\n", result.Compression)
+			if !result.Padded && result.StoredSize != result.OriginalSize && result.OriginalSize > 0 {
+				fmt.Printf("  Compressed Size: %s (%.1f%% of original)\n",
+					cli.FormatBytes(result.StoredSize), compressionRatio(result.StoredSize, result.OriginalSize))
+			}
+			fmt.Printf("  Encryption Overhead: %s\n", cli.FormatBytes(uint64(result.FileSize)-result.StoredSize))
+			if attrs != nil {
+				printFileAttributes(attrs)
+			}
 			fmt.Printf("\n")
 		}
 
+		if members != nil {
+			printArchiveMembers(members)
+		}
+
 		// Security parameters (if verbose or hex requested)
 		if (verbose || infoShowHex) && result.IsValid {
 			fmt.Printf("%sCryptographic Parameters:%s\n", cli.ColorPurple, cli.ColorReset)
@@ -191,6 +285,20 @@ func analyzeFile(inputFile string, verbose, quiet bool) error {
 	return nil
 }
 
+// printFileAttributes shows the attributes recorded for the original file
+func printFileAttributes(attrs *fileops.FileAttributes) {
+	fmt.Printf("  Mode: %s\n", attrs.FileMode())
+	fmt.Printf("  Modified: %s\n", attrs.Modified().Format(time.RFC3339))
+	fmt.Printf("  Accessed: %s\n", attrs.Accessed().Format(time.RFC3339))
+	if attrs.UID != nil && attrs.GID != nil {
+		fmt.Printf("  Owner: uid %d, gid %d\n", *attrs.UID, *attrs.GID)
+	}
+	if len(attrs.Xattrs) > 0 {
+		names := slices.Sorted(maps.Keys(attrs.Xattrs))
+		fmt.Printf("  Extended Attributes: %s\n", strings.Join(names, ", "))
+	}
+}
+
 func runBatchInfo(files []string, verbose, quiet bool) error {
 	if !quiet {
 		cli.PrintInfo(fmt.Sprintf("Analyzing %d files", len(files)))
@@ -200,6 +308,7 @@ func runBatchInfo(files []string, verbose, quiet bool) error {
 	validCount := 0
 	invalidCount := 0
 	var totalOriginalSize uint64
+	var totalStoredSize uint64
 	var totalEncryptedSize int64
 
 	for i, file := range files {
@@ -219,6 +328,7 @@ func runBatchInfo(files []string, verbose, quiet bool) error {
 		if result.IsValid {
 			validCount++
 			totalOriginalSize += result.OriginalSize
+			totalStoredSize += result.StoredSize
 			totalEncryptedSize += result.FileSize
 
 			if verbose && !quiet {
@@ -226,7 +336,8 @@ func runBatchInfo(files []string, verbose, quiet bool) error {
 				fmt.Printf("  Status: ✅ Valid\n")
 				fmt.Printf("  Format: FileVault v%d\n", result.FormatVersion)
 				fmt.Printf("  Algorithm: %s\n", result.Algorithm)
-				fmt.Printf("  Original: %s (%s)\n", result.OriginalFilename, cli.FormatBytes(result.OriginalSize))
+				fmt.Printf("  Compression: %s\n", result.Compression)
+				fmt.Printf("  Original: %s (%s)\n", originalName(result), originalSize(result))
 				fmt.Printf("  Encrypted: %s\n", cli.FormatBytes(uint64(result.FileSize)))
 			}
 		} else {
@@ -250,8 +361,7 @@ func runBatchInfo(files []string, verbose, quiet bool) error {
 			fmt.Printf("Total encrypted size: %s\n", cli.FormatBytes(uint64(totalEncryptedSize)))
 
 			if totalOriginalSize > 0 {
-				avgCompression := float64(totalOriginalSize) / float64(totalEncryptedSize) * 100
-				fmt.Printf("Average compression: %.1f%%\n", avgCompression)
+				fmt.Printf("Overall compression: %.1f%% of original\n", compressionRatio(totalStoredSize, totalOriginalSize))
 			}
 		}
 	}
@@ -259,6 +369,58 @@ func runBatchInfo(files []string, verbose, quiet bool) error {
 	return nil
 }
 
+// compressionRatio returns stored as a percentage of original
+func compressionRatio(stored, original uint64) float64 {
+	return float64(stored) / float64(original) * 100
+}
+
+// printArchiveMembers lists the members of an encrypted directory like
+// 'tar tv'
+func printArchiveMembers(members []core.ArchiveEntry) {
+	var total int64
+	for _, member := range members {
+		total += member.Size
+	}
+	fmt.Printf("%sArchive Members (%d, %s):%s\n", cli.ColorCyan, len(members), cli.FormatBytes(uint64(total)), cli.ColorReset)
+	for _, member := range members {
+		mode := member.Mode.String()
+		if member.Mode&fs.ModeSymlink != 0 {
+			mode = "l" + mode[1:] // as ls and tar show it
+		}
+		line := fmt.Sprintf("  %s %10d  %s  %s", mode, member.Size, member.ModTime.Format("2006-01-02 15:04"), member.Name)
+		if member.LinkTarget != "" {
+			line += " -> " + member.LinkTarget
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\n")
+}
+
+// originalName returns the stored filename, or a placeholder when it is
+// hidden in the encrypted metadata block
+func originalName(result *core.VerificationResult) string {
+	if result.MetadataEncrypted {
+		return "🔒 encrypted (use --unlock)"
+	}
+	return result.OriginalFilename
+}
+
+// originalSize describes the original size; padded files only reveal the
+// padded size until they are unlocked, and compressed files encrypted from
+// a pipe never recorded it
+func originalSize(result *core.VerificationResult) string {
+	switch {
+	case result.Padded && result.MetadataEncrypted:
+		return fmt.Sprintf("padded to %s, real size encrypted", cli.FormatBytes(result.StoredSize))
+	case result.Padded:
+		return fmt.Sprintf("%s (padded to %s)", cli.FormatBytes(result.OriginalSize), cli.FormatBytes(result.StoredSize))
+	case result.Streamed && result.OriginalSize == 0:
+		return "unknown (compressed from a pipe)"
+	default:
+		return cli.FormatBytes(result.OriginalSize)
+	}
+}
+
 func getStatusColor(status bool) string {
 	if status {
 		return cli.ColorGreen
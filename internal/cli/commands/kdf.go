@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// KdfCmd groups key derivation helper commands
+var KdfCmd = &cobra.Command{
+	Use:   "kdf",
+	Short: "🔑 Key derivation tools",
+	Long: `Tools for choosing password key derivation parameters.
+
+The key derivation function and its cost are stored in every encrypted file,
+so files encrypted with different settings can always be decrypted.`,
+}
+
+// kdfCalibrateCmd measures this machine and recommends KDF parameters
+var kdfCalibrateCmd = &cobra.Command{
+	Use:   "calibrate",
+	Short: "⏱️  Pick KDF parameters for a target unlock time",
+	Long: `Measure key derivation speed on this machine and recommend parameters
+that make unlocking a file take about the target time.
+
+Slower key derivation makes password guessing more expensive for an attacker,
+but every legitimate decryption pays the same cost. Run this on the slowest
+machine that needs to open the files.`,
+	Example: `  # Recommend PBKDF2 iterations for a 1 second unlock
+  filevault kdf calibrate
+
+  # Aim for a 500ms unlock
+  filevault kdf calibrate --target 500ms
+
+  # Argon2id with 256 MiB of memory
+  filevault kdf calibrate --kdf argon2id --argon2-memory 256`,
+	Args: cobra.NoArgs,
+	RunE: runKdfCalibrate,
+}
+
+var (
+	kdfCalibrateTarget time.Duration
+	kdfCalibrateName   string
+	kdfCalibrateMemory uint32
+)
+
+func init() {
+	kdfCalibrateCmd.Flags().DurationVar(&kdfCalibrateTarget, "target", time.Second, "target unlock time")
+	kdfCalibrateCmd.Flags().StringVar(&kdfCalibrateName, "kdf", "pbkdf2", "key derivation function to calibrate: pbkdf2 or argon2id")
+	kdfCalibrateCmd.Flags().Uint32Var(&kdfCalibrateMemory, "argon2-memory", crypto.DefaultArgon2Memory/1024, "Argon2id memory in MiB")
+	KdfCmd.AddCommand(kdfCalibrateCmd)
+}
+
+func runKdfCalibrate(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	algorithm, err := crypto.ParseKDFName(kdfCalibrateName)
+	if err != nil {
+		return err
+	}
+
+	if !quiet {
+		cli.PrintProgress(fmt.Sprintf("Calibrating for a %s unlock time...", kdfCalibrateTarget))
+	}
+
+	base := crypto.KDFParams{Algorithm: algorithm}
+	if algorithm == crypto.KDFArgon2id {
+		base = crypto.DefaultArgon2idParams()
+		base.Memory = kdfCalibrateMemory * 1024
+	}
+
+	params, measured, err := crypto.CalibrateKDF(base, kdfCalibrateTarget)
+	if err != nil {
+		return fmt.Errorf("calibration failed: %w", err)
+	}
+
+	if quiet {
+		fmt.Println(kdfFlags(params))
+		return nil
+	}
+
+	cli.PrintSuccess(fmt.Sprintf("Recommended: %s", params))
+	fmt.Printf("   Measured unlock time: %s\n", measured.Round(time.Millisecond))
+	fmt.Printf("   Use with: filevault encrypt %s <file>\n", kdfFlags(params))
+
+	return nil
+}
+
+// kdfFlags renders params as the encrypt flags that select them
+func kdfFlags(params crypto.KDFParams) string {
+	if params.Algorithm == crypto.KDFArgon2id {
+		return fmt.Sprintf("--kdf argon2id --argon2-memory %d --argon2-time %d --argon2-parallelism %d",
+			params.Memory/1024, params.Time, params.Parallelism)
+	}
+	return fmt.Sprintf("--iterations %d", params.Iterations)
+}
@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// KeygenCmd generates X25519 identities for public-key encryption
+var KeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "🪪  Generate an identity for public-key encryption",
+	Long: `Generate an identity: a private key to keep and a public key to share.
+
+The default type is X25519 (public keys start with fvpk1). For data that
+must stay confidential for decades, --type mlkem768-x25519 generates a
+post-quantum hybrid key (fvpq1) that combines ML-KEM-768 with X25519, so a
+file stays safe even if recorded now and attacked later with a quantum
+computer. Hybrid public keys are long (about 2000 characters); share them
+as files.
+
+Anyone with the public key (fvpk1...) can encrypt files for you with
+'filevault encrypt -r', without sharing a password. Only the identity file
+opens them, with 'filevault decrypt -i'. The file key is wrapped for each
+recipient in a key slot of its own, so one file can have several
+recipients as well as a password.
+
+The identity file is written with owner-only permissions. Keep it safe:
+files encrypted only to your public key cannot be recovered without it.
+
+--type minisign creates an Ed25519 signing key for 'filevault sign' and
+'filevault encrypt --sign' instead, in the minisign format. The secret key
+is protected by a password (unless --no-password is given) and written to
+~/.filevault/minisign.key by default, with the public key next to it as
+minisign.pub.`,
+	Example: `  # Create an identity and print its public key
+  filevault keygen -o ~/.filevault/identity.key
+
+  # Create a post-quantum hybrid identity
+  filevault keygen --type mlkem768-x25519 -o ~/.filevault/archive.key
+
+  # Print the public key of an existing identity
+  filevault keygen -y ~/.filevault/identity.key
+
+  # Create a signing key and its minisign.pub
+  filevault keygen --type minisign
+
+  # Encrypt for the holder of that identity
+  filevault encrypt report.pdf -r fvpk1...`,
+	Args: cobra.NoArgs,
+	RunE: runKeygen,
+}
+
+var (
+	keygenOutput string
+	keygenPublic string
+	keygenForce  bool
+	keygenType   string
+	keygenNoPass bool
+)
+
+func init() {
+	KeygenCmd.Flags().StringVarP(&keygenOutput, "output", "o", "", "identity file to write (default: print to stdout)")
+	KeygenCmd.Flags().StringVarP(&keygenPublic, "public", "y", "", "print the public keys of an existing identity file")
+	KeygenCmd.Flags().BoolVarP(&keygenForce, "force", "f", false, "overwrite an existing identity file")
+	KeygenCmd.Flags().StringVarP(&keygenType, "type", "t", "x25519", "key type: x25519, mlkem768-x25519 for post-quantum hybrid keys, or minisign for signing keys")
+	KeygenCmd.Flags().BoolVar(&keygenNoPass, "no-password", false, "store a minisign signing key without a password")
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	if keygenPublic != "" {
+		if isMinisignKeyFile(keygenPublic) {
+			key, err := loadSigningKey(keygenPublic)
+			if err != nil {
+				return err
+			}
+			defer crypto.SecureZero(key.Key)
+			fmt.Println(key.Public())
+			return nil
+		}
+		identities, err := loadIdentities([]string{keygenPublic}, security.PromptPassword)
+		if err != nil {
+			return err
+		}
+		for _, identity := range identities {
+			fmt.Println(identity.Recipient())
+		}
+		return nil
+	}
+
+	if keygenType == "minisign" {
+		return runKeygenMinisign(quiet)
+	}
+
+	identity, err := generateIdentity(keygenType)
+	if err != nil {
+		return err
+	}
+	publicKey := identity.Recipient().String()
+	contents := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), publicKey, identity)
+
+	if keygenOutput == "" {
+		fmt.Print(contents)
+		return nil
+	}
+
+	if err := security.ValidateOutputFile(keygenOutput, keygenForce); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keygenOutput, []byte(contents), 0600); err != nil {
+		return fmt.Errorf("failed to write identity file: %w", err)
+	}
+
+	if quiet {
+		fmt.Println(publicKey)
+		return nil
+	}
+	cli.PrintSuccess(fmt.Sprintf("Identity written to %s", keygenOutput))
+	fmt.Printf("Public key: %s\n", publicKey)
+	return nil
+}
+
+// runKeygenMinisign creates a minisign signing key and its .pub file
+func runKeygenMinisign(quiet bool) error {
+	secretFile := keygenOutput
+	if secretFile == "" {
+		var err error
+		if secretFile, err = defaultSigningKey(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(secretFile), 0700); err != nil {
+			return fmt.Errorf("failed to create config directory: %w", err)
+		}
+	}
+	publicFile := strings.TrimSuffix(secretFile, ".key") + ".pub"
+	for _, path := range []string{secretFile, publicFile} {
+		if err := security.ValidateOutputFile(path, keygenForce); err != nil {
+			return err
+		}
+	}
+
+	var password []byte
+	if !keygenNoPass {
+		secret, err := security.PromptPassword("Enter password for the signing key: ")
+		if err != nil {
+			return fmt.Errorf("failed to get password: %w", err)
+		}
+		confirm, err := security.PromptPassword("Confirm password: ")
+		if err != nil {
+			return fmt.Errorf("failed to get password confirmation: %w", err)
+		}
+		if secret != confirm {
+			return fmt.Errorf("passwords do not match")
+		}
+		if secret == "" {
+			return fmt.Errorf("empty password (use --no-password to store the key unprotected)")
+		}
+		password = []byte(secret)
+	}
+
+	key, err := crypto.GenerateMinisignKey()
+	if err != nil {
+		return err
+	}
+	defer crypto.SecureZero(key.Key)
+
+	secretKey, err := key.Marshal(password)
+	if err != nil {
+		return err
+	}
+	publicKey, err := key.Public().MarshalText()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(secretFile, secretKey, 0600); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	if err := os.WriteFile(publicFile, publicKey, 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	if quiet {
+		fmt.Println(key.Public())
+		return nil
+	}
+	cli.PrintSuccess(fmt.Sprintf("Signing key written to %s", secretFile))
+	fmt.Printf("Public key (%s): %s\n", publicFile, key.Public())
+	return nil
+}
+
+// isMinisignKeyFile reports whether path holds a minisign secret key rather
+// than an identity
+func isMinisignKeyFile(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && strings.HasPrefix(string(data), "untrusted comment:")
+}
+
+// keygenIdentity is an identity that can be written to an identity file
+type keygenIdentity interface {
+	crypto.Identity
+	String() string
+}
+
+// generateIdentity creates a new identity of the named type
+func generateIdentity(keyType string) (keygenIdentity, error) {
+	switch keyType {
+	case "x25519":
+		return crypto.GenerateX25519Identity()
+	case "mlkem768-x25519":
+		return crypto.GenerateHybridIdentity()
+	default:
+		return nil, fmt.Errorf("unknown key type: %s (use x25519, mlkem768-x25519 or minisign)", keyType)
+	}
+}
@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// PasswdCmd groups the key slot management commands
+var PasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "🗝️  Manage the passwords that unlock a file",
+	Long: `Manage the key slots of an encrypted file.
+
+Files in format v4 are encrypted under a random file key. Each key slot
+holds that key wrapped under one password, keyfile set, or both, so several
+people can share a file with their own passwords, and passwords can be
+added, changed or removed without re-encrypting the contents. Only the key
+slot area after the header is rewritten.
+
+Removing a slot stops its password from opening the file from now on, but
+anyone who knew it before may have kept a copy of the old file or its key.
+
+Older files have no key slots; run 'filevault upgrade' first.`,
+}
+
+var passwdListCmd = &cobra.Command{
+	Use:   "list <file>",
+	Short: "List the key slots of a file",
+	Long: `List the key slots of an encrypted file with the factors and key
+derivation settings of each. No password is needed.`,
+	Example: `  filevault passwd list report.pdf.enc`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runPasswdList,
+}
+
+var passwdAddCmd = &cobra.Command{
+	Use:   "add <file>",
+	Short: "Add a password to a file",
+	Long: `Add a key slot for a new password, keyfile set, or both. The file is
+first unlocked with an existing password (and --keyfile if it needs one).
+The new slot uses the file's key derivation settings.`,
+	Example: `  # Give a colleague their own password
+  filevault passwd add report.pdf.enc
+
+  # Add a keyfile-only slot as a recovery key
+  filevault passwd add report.pdf.enc --new-keyfile usb/recovery.key --no-password`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPasswdAdd,
+}
+
+var passwdChangeCmd = &cobra.Command{
+	Use:   "change <file>",
+	Short: "Change the password of a key slot",
+	Long: `Replace the key slot opened by the current password with one for a new
+password, keyfile set, or both. Other slots are left alone.`,
+	Example: `  filevault passwd change report.pdf.enc
+
+  # Move a slot from password only to password + keyfile
+  filevault passwd change report.pdf.enc --new-keyfile ~/keys/photo.jpg`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPasswdChange,
+}
+
+var passwdRemoveCmd = &cobra.Command{
+	Use:   "remove <file>",
+	Short: "Remove a password from a file",
+	Long: `Remove a key slot. Without --slot the slot opened by the entered
+password is removed; with --slot any other slot can be removed by someone
+who can unlock the file. The last slot cannot be removed.`,
+	Example: `  # Remove your own password
+  filevault passwd remove report.pdf.enc
+
+  # Revoke slot 2 (see 'filevault passwd list')
+  filevault passwd remove report.pdf.enc --slot 2`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPasswdRemove,
+}
+
+var (
+	passwdKeyfiles    []string
+	passwdIdentities  []string
+	passwdSSHKeys     []string
+	passwdCertKeys    []string
+	passwdNewKeyfiles []string
+	passwdNoPassword  bool
+	passwdSlot        int
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{passwdAddCmd, passwdChangeCmd, passwdRemoveCmd} {
+		cmd.Flags().StringArrayVar(&passwdKeyfiles, "keyfile", nil, "keyfile needed to unlock the file (repeatable)")
+		cmd.Flags().StringArrayVarP(&passwdIdentities, "identity", "i", nil, "identity file that unlocks the file (repeatable)")
+		cmd.Flags().StringArrayVar(&passwdSSHKeys, "ssh-identity", nil, "SSH private key that unlocks the file (repeatable)")
+		cmd.Flags().StringArrayVar(&passwdCertKeys, "key", nil, "certificate private key that unlocks the file (repeatable)")
+	}
+	for _, cmd := range []*cobra.Command{passwdAddCmd, passwdChangeCmd} {
+		cmd.Flags().StringArrayVar(&passwdNewKeyfiles, "new-keyfile", nil, "keyfile for the new slot (repeatable; any file works)")
+		cmd.Flags().BoolVar(&passwdNoPassword, "no-password", false, "protect the new slot with keyfiles only")
+	}
+	passwdRemoveCmd.Flags().IntVar(&passwdSlot, "slot", -1, "index of the slot to remove (default: the slot you unlock)")
+
+	PasswdCmd.AddCommand(passwdListCmd, passwdAddCmd, passwdChangeCmd, passwdRemoveCmd)
+}
+
+func runPasswdList(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	if err := security.ValidateEncryptedFile(args[0]); err != nil {
+		return err
+	}
+
+	slots, err := core.ListKeySlots(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, slot := range slots {
+		if quiet {
+			fmt.Printf("%d\t%s\n", slot.Index, slot.Factors)
+			continue
+		}
+		if slot.Threshold > 0 {
+			fmt.Printf("  Slot %d: %s, share %d, any %d decrypt (%s)\n", slot.Index, slot.Factors, slot.Share, slot.Threshold, slot.KDF)
+			continue
+		}
+		fmt.Printf("  Slot %d: %s (%s)\n", slot.Index, slot.Factors, slot.KDF)
+	}
+	return nil
+}
+
+func runPasswdAdd(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	creds, newCreds, err := passwdCredentials(args[0])
+	if err != nil {
+		return err
+	}
+
+	index, err := core.AddKeySlot(args[0], creds, newCreds)
+	if err != nil {
+		return fmt.Errorf("failed to add key slot: %w", err)
+	}
+
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Added key slot %d (%s) to %s", index, newCreds.Factors(), args[0]))
+	}
+	return nil
+}
+
+func runPasswdChange(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	creds, newCreds, err := passwdCredentials(args[0])
+	if err != nil {
+		return err
+	}
+
+	index, err := core.ChangeKeySlot(args[0], creds, newCreds)
+	if err != nil {
+		return fmt.Errorf("failed to change key slot: %w", err)
+	}
+
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Changed key slot %d of %s", index, args[0]))
+	}
+	return nil
+}
+
+func runPasswdRemove(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	if err := security.ValidateEncryptedFile(args[0]); err != nil {
+		return err
+	}
+
+	creds, err := unlockCredentials(args, passwdKeyfiles, slices.Concat(passwdIdentities, passwdSSHKeys, passwdCertKeys), "Enter a current password: ", security.PromptPassword)
+	if err != nil {
+		return err
+	}
+
+	index, err := core.RemoveKeySlot(args[0], creds, passwdSlot)
+	if err != nil {
+		return fmt.Errorf("failed to remove key slot: %w", err)
+	}
+
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Removed key slot %d from %s", index, args[0]))
+	}
+	return nil
+}
+
+// passwdCredentials asks for the credentials that unlock file and for the
+// credentials of the new slot
+func passwdCredentials(file string) (core.Credentials, core.Credentials, error) {
+	if err := security.ValidateEncryptedFile(file); err != nil {
+		return core.Credentials{}, core.Credentials{}, err
+	}
+
+	creds, err := unlockCredentials([]string{file}, passwdKeyfiles, slices.Concat(passwdIdentities, passwdSSHKeys, passwdCertKeys), "Enter a current password: ", security.PromptPassword)
+	if err != nil {
+		return core.Credentials{}, core.Credentials{}, err
+	}
+
+	newCreds, err := newCredentials(passwdNewKeyfiles, passwdNoPassword, "Enter new password: ", security.PromptPassword)
+	if err != nil {
+		return core.Credentials{}, core.Credentials{}, err
+	}
+	return creds, newCreds, nil
+}
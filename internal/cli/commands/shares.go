@@ -0,0 +1,352 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// shareFlagUsage is the help text shared by every --share flag
+const shareFlagUsage = "share card file or share code from 'filevault shares export' (repeatable)"
+
+// maxShareAttempts limits the wrong passwords entered while collecting shares
+const maxShareAttempts = 3
+
+// SharesCmd groups the commands for threshold files
+var SharesCmd = &cobra.Command{
+	Use:   "shares",
+	Short: "🧩 Work with the key shares of threshold files",
+	Long: `Files encrypted with --threshold K-of-N have their key split into N
+Shamir shares, one per password holder or recipient. Any K of them together
+decrypt the file; fewer learn nothing about the key.
+
+decrypt, cat and info ask for more holders' passwords until enough shares
+are unlocked. A holder who cannot be present can export their share to a
+printable card instead, which is passed with --share.`,
+}
+
+var sharesExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Print share cards for the shares you can unlock",
+	Long: `Unlock your shares of a threshold file and print each as a card: the
+file it belongs to, its number, and a checksummed code that can be typed
+back in groups. A card holds one share only, so it is no more sensitive than
+the password it was unlocked with, but it is not protected by one either:
+store it like the password.`,
+	Example: `  # Print your share to paper
+  filevault shares export vault.tar.enc
+
+  # Export the share of an identity to a file for a colleague
+  filevault shares export vault.tar.enc -i ~/.filevault/identity.key -o share-2.txt
+
+  # Decrypt with your password and a colleague's card
+  filevault decrypt vault.tar.enc --share share-2.txt`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSharesExport,
+}
+
+var (
+	sharesOutput     string
+	sharesForce      bool
+	sharesKeyfiles   []string
+	sharesIdentities []string
+	sharesSSHKeys    []string
+	sharesCertKeys   []string
+)
+
+func init() {
+	sharesExportCmd.Flags().StringVarP(&sharesOutput, "output", "o", "", "write the cards to this file instead of stdout")
+	sharesExportCmd.Flags().BoolVarP(&sharesForce, "force", "f", false, "overwrite an existing output file")
+	sharesExportCmd.Flags().StringArrayVar(&sharesKeyfiles, "keyfile", nil, keyfileFlagUsage)
+	sharesExportCmd.Flags().StringArrayVarP(&sharesIdentities, "identity", "i", nil, identityFlagUsage)
+	sharesExportCmd.Flags().StringArrayVar(&sharesSSHKeys, "ssh-identity", nil, sshIdentityFlagUsage)
+	sharesExportCmd.Flags().StringArrayVar(&sharesCertKeys, "key", nil, keyFlagUsage)
+
+	SharesCmd.AddCommand(sharesExportCmd)
+}
+
+// parseThreshold parses a --threshold value such as "2-of-3"
+func parseThreshold(value string) (int, int, error) {
+	k, n, ok := strings.Cut(strings.ToLower(value), "-of-")
+	threshold, err1 := strconv.Atoi(k)
+	shares, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid threshold %q: use K-of-N, e.g. 2-of-3", value)
+	}
+	if threshold < 2 || threshold > shares || shares > crypto.MaxShares {
+		return 0, 0, fmt.Errorf("invalid threshold %q: need 2 <= K <= N <= %d", value, crypto.MaxShares)
+	}
+	return threshold, shares, nil
+}
+
+// parseShareCards reads the shares given with --share: each value is a
+// share code, or a card file whose code starts at the line beginning with
+// the share prefix and runs to the next blank line
+func parseShareCards(values []string) ([]*crypto.Share, error) {
+	var shares []*crypto.Share
+	for _, value := range values {
+		text := value
+		if !strings.HasPrefix(strings.ReplaceAll(value, " ", ""), crypto.SharePrefix) {
+			data, err := os.ReadFile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid share %q: not a share code or readable card", value)
+			}
+			text = cardCode(string(data))
+		}
+		share, err := crypto.ParseShare(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid share %q: %w", value, err)
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// cardCode extracts the share code, without its grouping, from a card
+func cardCode(card string) string {
+	var code strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(card))
+	for scanner.Scan() {
+		line := strings.ReplaceAll(strings.TrimSpace(scanner.Text()), " ", "")
+		if code.Len() == 0 && !strings.HasPrefix(line, crypto.SharePrefix) {
+			continue
+		}
+		if line == "" {
+			break
+		}
+		code.WriteString(line)
+	}
+	return code.String()
+}
+
+// formatShareCard renders a share as a printable card
+func formatShareCard(share *crypto.Share, file string, total int) string {
+	code := share.String()
+	var groups []string
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:min(i+4, len(code))])
+	}
+
+	var card strings.Builder
+	fmt.Fprintf(&card, "FileVault share card\n")
+	fmt.Fprintf(&card, "  File:    %s\n", filepath.Base(file))
+	fmt.Fprintf(&card, "  File ID: %x\n", share.FileID)
+	fmt.Fprintf(&card, "  Share:   %d of %d (any %d decrypt)\n", share.Index, total, share.Threshold)
+	fmt.Fprintf(&card, "  Created: %s\n\n", time.Now().Format("2006-01-02"))
+	for i := 0; i < len(groups); i += 8 {
+		fmt.Fprintf(&card, "%s\n", strings.Join(groups[i:min(i+8, len(groups))], " "))
+	}
+	return card.String()
+}
+
+// unlockShareCredentials is unlockCredentials for files that may be split
+// into shares. Files that the cards alone open need no password. For the
+// others, once the first password or identity is in, the passwords of more
+// holders are asked for until enough shares are unlocked; they are carried
+// to decryption as Credentials.Shares.
+func unlockShareCredentials(files, keyfiles, identityFiles, cards []string, prompt string, readPassword func(string) (string, error)) (core.Credentials, error) {
+	shares, err := parseShareCards(cards)
+	if err != nil {
+		return core.Credentials{}, err
+	}
+
+	// Files that are not split, or whose header cannot be read, go through
+	// the usual checks
+	thresholds := make(map[string]*core.ThresholdInfo)
+	used := make([]bool, len(shares))
+	var locked []string
+	for _, file := range files {
+		info, err := core.ReadThreshold(file)
+		if err != nil {
+			locked = append(locked, file)
+			continue
+		}
+		thresholds[file] = info
+		if len(fileShares(info, shares, used)) < info.Threshold {
+			locked = append(locked, file)
+		}
+	}
+	for i, share := range shares {
+		if !used[i] {
+			return core.Credentials{}, fmt.Errorf("share %d (file ID %x) does not belong to any of the files", share.Index, share.FileID)
+		}
+	}
+
+	creds, err := unlockCredentials(locked, keyfiles, identityFiles, prompt, readPassword)
+	if err != nil {
+		return creds, err
+	}
+	creds.Shares = shares
+
+	for _, file := range locked {
+		info := thresholds[file]
+		if info == nil {
+			continue
+		}
+		unlock := func(c core.Credentials) ([]*crypto.Share, error) { return core.UnlockShares(file, c) }
+		if err := collectShares(file, info, &creds, unlock, readPassword); err != nil {
+			return creds, err
+		}
+	}
+	return creds, nil
+}
+
+// unlockStreamCredentials is unlockShareCredentials for an encrypted file
+// read from a pipe, whose header and key slots were read first
+func unlockStreamCredentials(stream *core.EncryptedStream, keyfiles, identityFiles, cards []string, prompt string, readPassword func(string) (string, error)) (core.Credentials, error) {
+	const name = "stdin"
+	shares, err := parseShareCards(cards)
+	if err != nil {
+		return core.Credentials{}, err
+	}
+	info, err := stream.ThresholdInfo()
+	if err != nil {
+		info = nil
+	}
+	for _, share := range shares {
+		if info == nil || len(fileShares(info, []*crypto.Share{share}, nil)) == 0 {
+			return core.Credentials{}, fmt.Errorf("share %d (file ID %x) does not belong to the file", share.Index, share.FileID)
+		}
+	}
+
+	creds := core.Credentials{Keyfiles: keyfiles, Shares: shares}
+	if info != nil && len(fileShares(info, shares, nil)) >= info.Threshold {
+		return creds, nil
+	}
+	if err := checkKeyfiles(keyfiles); err != nil {
+		return creds, err
+	}
+	if creds.Identities, err = loadIdentities(identityFiles, readPassword); err != nil {
+		return creds, err
+	}
+
+	needPassword, err := passwordNeeded(name, stream.Factors(), creds)
+	if err != nil {
+		return creds, err
+	}
+	if needPassword {
+		if creds.Password, err = readPassword(prompt); err != nil {
+			return creds, fmt.Errorf("failed to get password: %w", err)
+		}
+	}
+	if info == nil {
+		return creds, nil
+	}
+	return creds, collectShares(name, info, &creds, stream.UnlockShares, readPassword)
+}
+
+// collectShares asks for the passwords of more holders of a threshold
+// file until creds, with the shares that unlock opens, carry enough of
+// them
+func collectShares(file string, info *core.ThresholdInfo, creds *core.Credentials, unlock func(core.Credentials) ([]*crypto.Share, error), readPassword func(string) (string, error)) error {
+	opened, _ := unlock(*creds)
+	have := fileShares(info, append(opened, creds.Shares...), nil)
+	for attempts := 0; len(have) < info.Threshold; {
+		password, err := readPassword(fmt.Sprintf("Enter another holder's password for %s (%d of %d shares): ", filepath.Base(file), len(have), info.Threshold))
+		if err != nil {
+			return fmt.Errorf("failed to get password: %w", err)
+		}
+		if password == "" {
+			return fmt.Errorf("%s: %w: %d of %d", file, core.ErrMoreSharesNeeded, len(have), info.Threshold)
+		}
+
+		opened, err := unlock(core.Password(password))
+		if err == nil && len(fileShares(info, append(have, opened...), nil)) == len(have) {
+			// A password from a file or the environment is the same each time
+			err = fmt.Errorf("%w: %d of %d", core.ErrMoreSharesNeeded, len(have), info.Threshold)
+		}
+		if err != nil {
+			if attempts++; attempts == maxShareAttempts {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			fmt.Fprintln(os.Stderr, "That password opens no new share of the file, try again.")
+			continue
+		}
+		creds.Shares = append(creds.Shares, opened...)
+		have = fileShares(info, append(have, opened...), nil)
+	}
+	return nil
+}
+
+// fileShares returns the distinct shares that belong to the file described
+// by info, marking the ones it uses in used if that is not nil
+func fileShares(info *core.ThresholdInfo, shares []*crypto.Share, used []bool) []*crypto.Share {
+	var matching []*crypto.Share
+	for i, share := range shares {
+		if share.FileID != info.FileID || int(share.Threshold) != info.Threshold {
+			continue
+		}
+		if used != nil {
+			used[i] = true
+		}
+		if !slices.ContainsFunc(matching, func(s *crypto.Share) bool { return s.Index == share.Index }) {
+			matching = append(matching, share)
+		}
+	}
+	return matching
+}
+
+func runSharesExport(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+	inputFile := args[0]
+
+	if err := security.ValidateEncryptedFile(inputFile); err != nil {
+		return err
+	}
+	info, err := core.ReadThreshold(inputFile)
+	if errors.Is(err, core.ErrNotThreshold) {
+		return fmt.Errorf("%s: %w (encrypt with --threshold to split it)", inputFile, err)
+	}
+	if err != nil {
+		return err
+	}
+	if sharesOutput != "" {
+		if err := security.ValidateOutputFile(sharesOutput, sharesForce); err != nil {
+			return err
+		}
+	}
+
+	creds, err := unlockCredentials([]string{inputFile}, sharesKeyfiles, slices.Concat(sharesIdentities, sharesSSHKeys, sharesCertKeys), "Enter your password: ", security.ReadPasswordStderr)
+	if err != nil {
+		return err
+	}
+	shares, err := core.UnlockShares(inputFile, creds)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, share := range shares {
+			crypto.SecureZero(share.Value)
+		}
+	}()
+
+	cards := make([]string, len(shares))
+	for i, share := range shares {
+		cards[i] = formatShareCard(share, inputFile, info.Shares)
+	}
+	text := strings.Join(cards, "\n")
+
+	if sharesOutput == "" {
+		fmt.Print(text)
+		return nil
+	}
+	if err := os.WriteFile(sharesOutput, []byte(text), 0600); err != nil {
+		return fmt.Errorf("failed to write share cards: %w", err)
+	}
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Exported %d share card(s) of %s to %s", len(shares), inputFile, sharesOutput))
+	}
+	return nil
+}
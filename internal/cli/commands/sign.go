@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/config"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// signingKeyFile is the default signing key inside the config directory
+const signingKeyFile = "minisign.key"
+
+// SignCmd writes detached minisign signatures
+var SignCmd = &cobra.Command{
+	Use:   "sign [file...]",
+	Short: "✍️  Sign files with a minisign-compatible signature",
+	Long: `Write a detached Ed25519 signature for each file, next to it as
+<file>.minisig. Signatures use the minisign format, so anyone can check them
+with 'filevault verify-sig', minisign or signify-compatible tools, using
+your public key.
+
+Encryption already proves that a file was not modified, but only to those
+who hold the password. A signature proves who produced the file, to
+anyone. Any file can be signed, encrypted or not.
+
+Create a signing key with 'filevault keygen --type minisign'. By default
+the key is read from ~/.filevault/minisign.key.`,
+	Example: `  # Create a signing key, then sign a release
+  filevault keygen --type minisign
+  filevault sign release.tar.gz
+
+  # Sign with another key and a trusted comment
+  filevault sign backup.enc -s team.key -t "nightly backup"`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSign,
+}
+
+// VerifySigCmd checks detached minisign signatures
+var VerifySigCmd = &cobra.Command{
+	Use:   "verify-sig <file>",
+	Short: "✅ Check a minisign signature",
+	Long: `Check the detached signature of a file (<file>.minisig by default)
+against a public key. Signatures made by minisign are accepted too.
+
+The public key is a minisign .pub file or its base64 line (RW...). The
+trusted comment is printed once the signature is verified.`,
+	Example: `  filevault verify-sig release.tar.gz -p minisign.pub
+  filevault verify-sig release.tar.gz -p RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVerifySig,
+}
+
+var (
+	signSecretKey      string
+	signTrustedComment string
+	signSignatureFile  string
+	signForce          bool
+
+	verifySigPublicKeys []string
+	verifySigFile       string
+)
+
+func init() {
+	SignCmd.Flags().StringVarP(&signSecretKey, "secret-key", "s", "", "signing key (default ~/.filevault/minisign.key)")
+	SignCmd.Flags().StringVarP(&signTrustedComment, "trusted-comment", "t", "", "signed comment (default: timestamp and file name)")
+	SignCmd.Flags().StringVarP(&signSignatureFile, "signature", "x", "", "signature file (default <file>.minisig; one file only)")
+	SignCmd.Flags().BoolVarP(&signForce, "force", "f", false, "overwrite existing signature files")
+
+	VerifySigCmd.Flags().StringArrayVarP(&verifySigPublicKeys, "pubkey", "p", nil, signaturePubkeyFlagUsage)
+	VerifySigCmd.Flags().StringVarP(&verifySigFile, "signature", "x", "", "signature file (default <file>.minisig)")
+}
+
+// signaturePubkeyFlagUsage is the help text shared by the --pubkey flags
+const signaturePubkeyFlagUsage = "minisign public key file or base64 key to check signatures with (repeatable)"
+
+// defaultSigningKey returns the path of the default signing key
+func defaultSigningKey() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, signingKeyFile), nil
+}
+
+// loadSigningKey reads a minisign secret key, asking for its password if
+// it is encrypted. An empty path selects the default key.
+func loadSigningKey(path string) (*crypto.MinisignPrivateKey, error) {
+	if path == "" {
+		var err error
+		if path, err = defaultSigningKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("signing key %s not found (create one with 'filevault keygen --type minisign')", path)
+		}
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	password := func() ([]byte, error) {
+		secret, err := security.PromptPassword(fmt.Sprintf("Enter password for signing key %s: ", path))
+		return []byte(secret), err
+	}
+	key, err := crypto.ParseMinisignPrivateKey(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// loadSignaturePublicKeys reads the public keys given with --pubkey: each
+// value is a .pub file or a bare base64 key
+func loadSignaturePublicKeys(values []string) ([]*crypto.MinisignPublicKey, error) {
+	var keys []*crypto.MinisignPublicKey
+	for _, value := range values {
+		text := value
+		if data, err := os.ReadFile(value); err == nil {
+			text = string(data)
+		} else if !strings.HasPrefix(value, "RW") {
+			return nil, fmt.Errorf("invalid public key %q: not a minisign key or readable file", value)
+		}
+
+		key, err := crypto.ParseMinisignPublicKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", value, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func runSign(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+
+	if signSignatureFile != "" && len(args) > 1 {
+		return fmt.Errorf("--signature can only be used when signing one file")
+	}
+	for _, file := range args {
+		if err := security.ValidateInputFile(file); err != nil {
+			return err
+		}
+	}
+
+	key, err := loadSigningKey(signSecretKey)
+	if err != nil {
+		return err
+	}
+	defer crypto.SecureZero(key.Key)
+
+	for _, file := range args {
+		sigFile := signSignatureFile
+		if sigFile == "" {
+			sigFile = file + ".minisig"
+		}
+		if err := security.ValidateOutputFile(sigFile, signForce); err != nil {
+			return err
+		}
+
+		comment := signTrustedComment
+		if comment == "" {
+			comment = fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(file))
+		}
+
+		input, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file, err)
+		}
+		signature, err := key.Sign(input, comment)
+		input.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		text, err := signature.MarshalText()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(sigFile, text, 0644); err != nil {
+			return fmt.Errorf("failed to write signature: %w", err)
+		}
+		if !quiet {
+			cli.PrintSuccess(fmt.Sprintf("Signed: %s -> %s", file, sigFile))
+		}
+	}
+	return nil
+}
+
+func runVerifySig(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
+	file := args[0]
+
+	if len(verifySigPublicKeys) == 0 {
+		return fmt.Errorf("--pubkey is required")
+	}
+	keys, err := loadSignaturePublicKeys(verifySigPublicKeys)
+	if err != nil {
+		return err
+	}
+
+	sigFile := verifySigFile
+	if sigFile == "" {
+		sigFile = file + ".minisig"
+	}
+	data, err := os.ReadFile(sigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %w", err)
+	}
+	signature, err := crypto.ParseMinisignSignature(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sigFile, err)
+	}
+
+	key := crypto.FindMinisignKey(keys, signature.KeyID)
+	if key == nil {
+		return fmt.Errorf("%w: signed by key %s, which was not given", crypto.ErrSignatureInvalid, crypto.FormatKeyID(signature.KeyID))
+	}
+
+	input, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer input.Close()
+	if err := key.Verify(signature, input); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	if !quiet {
+		cli.PrintSuccess(fmt.Sprintf("Signature verified: %s (key %s)", file, crypto.FormatKeyID(key.ID)))
+		fmt.Printf("Trusted comment: %s\n", signature.TrustedComment)
+	}
+	return nil
+}
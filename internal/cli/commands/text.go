@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/config"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+	"golang.org/x/term"
+)
+
+// armorSuffix is appended to the names of armored files
+const armorSuffix = ".asc"
+
+// EncryptTextCmd encrypts a short text typed or pasted into the terminal
+var EncryptTextCmd = &cobra.Command{
+	Use:   "encrypt-text",
+	Short: "📝 Encrypt a short text to paste into email, tickets or chat",
+	Long: `Encrypt a short secret read from stdin and print it as ASCII armor:
+Base64 between BEGIN and END lines with a checksum, which survives email,
+ticket systems and chat. Texts up to 1 MiB are accepted.
+
+Type or paste the text after the password, then press Ctrl-D. Prompts go
+to stderr, so the armor can be redirected to a file. Decrypt it with
+'filevault decrypt-text', or save it to a file for 'filevault decrypt'.`,
+	Example: `  # Encrypt a password for a colleague who knows the passphrase
+  filevault encrypt-text
+
+  # Encrypt to a public key; no password is asked
+  echo "db password: hunter2" | filevault encrypt-text -r fvpk1...`,
+	Args: cobra.NoArgs,
+	RunE: runEncryptText,
+}
+
+// DecryptTextCmd decrypts armor pasted into the terminal
+var DecryptTextCmd = &cobra.Command{
+	Use:   "decrypt-text [file]",
+	Short: "📝 Decrypt an armored text pasted into the terminal",
+	Long: `Decrypt ASCII armor from 'filevault encrypt-text' or 'encrypt --armor'
+and print the text. The armor is read from the file, or from stdin up to
+the END line, so it can be pasted straight into the terminal; text around
+it, such as an email quote, is ignored.`,
+	Example: `  # Paste the armor, then enter the password
+  filevault decrypt-text
+
+  # Decrypt an armored message with your identity
+  filevault decrypt-text message.asc -i ~/.filevault/identity.key`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDecryptText,
+}
+
+var (
+	textRecipients []string
+	textIdentities []string
+)
+
+func init() {
+	EncryptTextCmd.Flags().StringArrayVarP(&textRecipients, "recipient", "r", nil, recipientFlagUsage)
+	DecryptTextCmd.Flags().StringArrayVarP(&textIdentities, "identity", "i", nil, identityFlagUsage)
+}
+
+// dearmorInputs returns the binary form of each encrypted file: the file
+// itself, or a temporary copy decoded from its ASCII armor. cleanup
+// removes the copies.
+func dearmorInputs(files []string) ([]string, func(), error) {
+	sources := make([]string, len(files))
+	var cleanups []func()
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}
+	for i, file := range files {
+		source, c, err := core.Dearmored(file)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		sources[i] = source
+		cleanups = append(cleanups, c)
+	}
+	return sources, cleanup, nil
+}
+
+func runEncryptText(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	algorithm, err := cfg.Algorithm()
+	if err != nil {
+		return err
+	}
+	params, err := cfg.KDFParams()
+	if err != nil {
+		return err
+	}
+	recipients, err := parseRecipients(textRecipients)
+	if err != nil {
+		return err
+	}
+	opts := core.EncryptOptions{KDF: params, Algorithm: algorithm, Recipients: recipients}
+
+	var creds core.Credentials
+	if len(recipients) == 0 {
+		password, err := security.ReadPasswordStderr("Enter password for the text: ")
+		if err != nil {
+			return fmt.Errorf("failed to get password: %w", err)
+		}
+		confirmPassword, err := security.ReadPasswordStderr("Confirm password: ")
+		if err != nil {
+			return fmt.Errorf("failed to get password confirmation: %w", err)
+		}
+		if password != confirmPassword {
+			return fmt.Errorf("passwords do not match")
+		}
+		creds.Password = password
+	}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Type or paste the text, then press Ctrl-D on an empty line:")
+	}
+	text, err := io.ReadAll(io.LimitReader(os.Stdin, core.MaxTextSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read text: %w", err)
+	}
+	defer security.SecureZeroMemory(text)
+
+	return core.EncryptText(os.Stdout, text, creds, opts)
+}
+
+func runDecryptText(cmd *cobra.Command, args []string) error {
+	input := io.Reader(os.Stdin)
+	if len(args) == 1 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer file.Close()
+		input = file
+	} else if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Paste the encrypted text:")
+	}
+
+	// The armor is read before the password is asked for, so that both can
+	// come from the terminal
+	data, err := core.ReadArmor(input)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted text: %w", err)
+	}
+
+	identities, err := loadIdentities(textIdentities, security.ReadPasswordStderr)
+	if err != nil {
+		return err
+	}
+	creds := core.Credentials{Identities: identities}
+	if len(identities) == 0 {
+		if creds.Password, err = security.ReadPasswordStderr("Enter password: "); err != nil {
+			return fmt.Errorf("failed to get password: %w", err)
+		}
+	}
+
+	text, err := core.DecryptText(bytes.NewReader(data), creds)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	defer security.SecureZeroMemory(text)
+
+	_, err = os.Stdout.Write(text)
+	return err
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/cli"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
 )
 
 // VerifyCmd represents the verify command
@@ -39,7 +40,17 @@ This is useful for:
 BATCH PROCESSING:
   • Supports multiple files in one command
   • Provides summary statistics for batch operations
-  • Detailed per-file results available with -v flag`,
+  • Detailed per-file results available with -v flag
+
+SIGNATURES:
+  Files encrypted with --sign carry a minisign signature over the header
+  and ciphertext. Give the signer's public key with --pubkey to check it;
+  without it, the key ID of the signer is only reported.
+
+RECOVERY RECORDS:
+  Files encrypted with --redundancy are checked block by block against
+  their recovery record. Damaged regions are listed, and 'filevault
+  repair' rebuilds them if there is enough parity left.`,
 	Example: `  # Verify single encrypted file
   filevault verify document.pdf.enc
 
@@ -56,33 +67,65 @@ BATCH PROCESSING:
   filevault verify -q suspicious.enc
 
   # Verify all files in directory
-  filevault verify encrypted-data/*`,
+  filevault verify encrypted-data/*
+
+  # Check that a file was signed by a known key
+  filevault verify report.pdf.enc --pubkey alice.pub`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: runVerify,
 }
 
 var (
-	verifyDeep bool
+	verifyDeep       bool
+	verifyPublicKeys []string
 )
 
 func init() {
 	VerifyCmd.Flags().BoolVar(&verifyDeep, "deep", false, "perform deep integrity verification (requires password)")
+	VerifyCmd.Flags().StringArrayVarP(&verifyPublicKeys, "pubkey", "p", nil, signaturePubkeyFlagUsage)
 }
 
 func runVerify(cmd *cobra.Command, args []string) error {
 	verbose, _ := cmd.Root().PersistentFlags().GetBool("verbose")
 	quiet, _ := cmd.Root().PersistentFlags().GetBool("quiet")
 
+	keys, err := loadSignaturePublicKeys(verifyPublicKeys)
+	if err != nil {
+		return err
+	}
+
 	// Handle batch verification
 	if len(args) > 1 {
-		return runBatchVerify(args, verbose, quiet)
+		return runBatchVerify(args, keys, verbose, quiet)
 	}
 
 	// Single file verification
-	return verifySingleFile(args[0], verbose, quiet)
+	return verifySingleFile(args[0], keys, verbose, quiet)
+}
+
+// checkEmbeddedSignature checks the signature of a valid file against keys,
+// if any were given, and marks the result invalid when it does not verify
+func checkEmbeddedSignature(result *core.VerificationResult, keys []*crypto.MinisignPublicKey) {
+	if !result.IsValid || len(keys) == 0 {
+		return
+	}
+	if _, err := core.VerifySignature(result.Filename, keys); err != nil {
+		result.IsValid = false
+		result.ErrorMessage = err.Error()
+		return
+	}
+	result.SignatureVerified = true
 }
 
-func verifySingleFile(inputFile string, verbose, quiet bool) error {
+// signerDescription describes who signed a file and whether it was checked
+func signerDescription(result *core.VerificationResult) string {
+	if result.SignatureVerified {
+		return fmt.Sprintf("key %s (verified)", result.SignerKeyID)
+	}
+	return fmt.Sprintf("key %s (not checked, use --pubkey)", result.SignerKeyID)
+}
+
+func verifySingleFile(inputFile string, keys []*crypto.MinisignPublicKey, verbose, quiet bool) error {
 	// Check if input file exists first
 	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
 		return fmt.Errorf("file not found: %s", inputFile)
@@ -93,24 +136,38 @@ func verifySingleFile(inputFile string, verbose, quiet bool) error {
 	if err != nil {
 		return fmt.Errorf("verification failed: %w", err)
 	}
+	checkEmbeddedSignature(result, keys)
 
 	// Display results
 	if result.IsValid {
 		if !quiet {
 			cli.PrintSuccess("File verification successful")
+			if result.SignerKeyID != "" {
+				fmt.Printf("   Signed by: %s\n", signerDescription(result))
+			}
 			if verbose {
 				fmt.Printf("   Format: FileVault v%d\n", result.FormatVersion)
+				if result.Armored {
+					fmt.Printf("   ASCII armor: checksum valid, checked after decoding\n")
+				}
 				fmt.Printf("   Algorithm: %s\n", result.Algorithm)
+				fmt.Printf("   Key derivation: %s\n", result.KDF)
 				fmt.Printf("   Original file: %s (%s)\n",
-					result.OriginalFilename,
-					cli.FormatBytes(result.OriginalSize))
+					originalName(result),
+					originalSize(result))
 				fmt.Printf("   Encrypted size: %s\n", cli.FormatBytes(uint64(result.FileSize)))
+				if result.Recovery != nil {
+					fmt.Printf("   Recovery record: %s\n", recoveryDescription(result.Recovery))
+				}
 				fmt.Printf("   Verification time: %s\n", cli.FormatDuration(result.VerificationTime.Seconds()))
 			}
 		}
 	} else {
 		if !quiet {
 			cli.PrintError(fmt.Sprintf("File verification failed: %s", result.ErrorMessage))
+			if result.Recovery != nil {
+				printDamage(result.Recovery)
+			}
 			if verbose {
 				fmt.Printf("   File accessible: %t\n", result.FileAccessible)
 				fmt.Printf("   Format valid: %t\n", result.FormatValid)
@@ -124,7 +181,7 @@ func verifySingleFile(inputFile string, verbose, quiet bool) error {
 	return nil
 }
 
-func runBatchVerify(files []string, verbose, quiet bool) error {
+func runBatchVerify(files []string, keys []*crypto.MinisignPublicKey, verbose, quiet bool) error {
 	if !quiet {
 		cli.PrintInfo(fmt.Sprintf("Starting batch verification of %d files", len(files)))
 	}
@@ -134,6 +191,9 @@ func runBatchVerify(files []string, verbose, quiet bool) error {
 	if err != nil {
 		return fmt.Errorf("batch verification failed: %w", err)
 	}
+	for _, result := range results {
+		checkEmbeddedSignature(result, keys)
+	}
 
 	// Calculate summary
 	summary := core.GetVerificationSummary(results)
@@ -153,10 +213,13 @@ func runBatchVerify(files []string, verbose, quiet bool) error {
 				fmt.Printf("    Error: %s\n", result.ErrorMessage)
 			} else if result.IsValid {
 				fmt.Printf("    Format: FileVault v%d, %s\n", result.FormatVersion, result.Algorithm)
-				if result.OriginalFilename != "" {
+				if result.SignerKeyID != "" {
+					fmt.Printf("    Signed by: %s\n", signerDescription(result))
+				}
+				if result.OriginalFilename != "" || result.MetadataEncrypted {
 					fmt.Printf("    Original: %s (%s)\n",
-						result.OriginalFilename,
-						cli.FormatBytes(result.OriginalSize))
+						originalName(result),
+						originalSize(result))
 				}
 			}
 		}
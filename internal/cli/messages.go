@@ -178,6 +178,18 @@ var UsageExamples = map[string][]string{
 		"filevault verify document.pdf.enc",
 		"filevault verify *.enc",
 	},
+	"kdf": {
+		"filevault kdf calibrate",
+		"filevault kdf calibrate --target 500ms",
+	},
+	"cat": {
+		"filevault cat notes.txt.enc",
+		"filevault cat server.log.enc --offset 1000000 --length 4096",
+	},
+	"upgrade": {
+		"filevault upgrade old-backup.enc",
+		"filevault upgrade archive/*.enc",
+	},
 }
 
 // PrintUsageExamples prints usage examples for a command
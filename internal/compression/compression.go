@@ -0,0 +1,193 @@
+// Package compression implements the optional compression stage applied to
+// the plaintext before it is encrypted.
+package compression
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithm identifiers as stored in the file header
+const (
+	None uint8 = 0
+	Gzip uint8 = 1
+	Zstd uint8 = 2
+)
+
+// Default and allowed compression levels
+const (
+	DefaultGzipLevel = 6
+	MinGzipLevel     = 1
+	MaxGzipLevel     = 9
+
+	DefaultZstdLevel = 3
+	MinZstdLevel     = 1
+	MaxZstdLevel     = 22
+)
+
+// Entropy sampling parameters used to skip inputs that will not shrink
+const (
+	// SampleSize is how much of the input is inspected before compressing
+	SampleSize = 64 * 1024
+	// EntropyThreshold in bits per byte; typical compressed or encrypted
+	// data is above 7.9, text and executables are well below
+	EntropyThreshold = 7.5
+)
+
+// ErrUnknownAlgorithm is returned for an unknown compression identifier
+var ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+
+// Params selects a compression algorithm and level
+type Params struct {
+	Algorithm uint8
+	// Level is algorithm specific; zero selects the algorithm's default
+	Level uint8
+}
+
+// Enabled reports whether p compresses at all
+func (p Params) Enabled() bool {
+	return p.Algorithm != None
+}
+
+// Validate checks that the algorithm is known and the level is in range
+func (p Params) Validate() error {
+	level := p.effectiveLevel()
+	switch p.Algorithm {
+	case None:
+		return nil
+	case Gzip:
+		if level < MinGzipLevel || level > MaxGzipLevel {
+			return fmt.Errorf("gzip level must be %d-%d, got %d", MinGzipLevel, MaxGzipLevel, level)
+		}
+	case Zstd:
+		if level < MinZstdLevel || level > MaxZstdLevel {
+			return fmt.Errorf("zstd level must be %d-%d, got %d", MinZstdLevel, MaxZstdLevel, level)
+		}
+	default:
+		return fmt.Errorf("%w: %d", ErrUnknownAlgorithm, p.Algorithm)
+	}
+	return nil
+}
+
+// String describes the parameters for display
+func (p Params) String() string {
+	switch p.Algorithm {
+	case None:
+		return "none"
+	case Gzip, Zstd:
+		return fmt.Sprintf("%s (level %d)", AlgorithmName(p.Algorithm), p.effectiveLevel())
+	default:
+		return AlgorithmName(p.Algorithm)
+	}
+}
+
+func (p Params) effectiveLevel() int {
+	if p.Level != 0 {
+		return int(p.Level)
+	}
+	switch p.Algorithm {
+	case Gzip:
+		return DefaultGzipLevel
+	case Zstd:
+		return DefaultZstdLevel
+	}
+	return 0
+}
+
+// AlgorithmName returns the display name of a compression algorithm
+func AlgorithmName(algorithm uint8) string {
+	switch algorithm {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Unknown (%d)", algorithm)
+	}
+}
+
+// ParseName maps a user-facing name to an algorithm identifier
+func ParseName(name string) (uint8, error) {
+	switch strings.ToLower(name) {
+	case "", "none", "off":
+		return None, nil
+	case "gzip", "gz":
+		return Gzip, nil
+	case "zstd", "zstandard":
+		return Zstd, nil
+	default:
+		return None, fmt.Errorf("%w: %q (use none, gzip or zstd)", ErrUnknownAlgorithm, name)
+	}
+}
+
+// NewWriter returns a writer that compresses into w. Close flushes the
+// compressed stream but does not close w.
+func NewWriter(w io.Writer, p Params) (io.WriteCloser, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch p.Algorithm {
+	case Gzip:
+		return gzip.NewWriterLevel(w, p.effectiveLevel())
+	case Zstd:
+		return zstd.NewWriter(w,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(p.effectiveLevel())),
+			zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnknownAlgorithm, p.Algorithm)
+	}
+}
+
+// NewReader returns a reader that decompresses r
+func NewReader(r io.Reader, algorithm uint8) (io.ReadCloser, error) {
+	switch algorithm {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnknownAlgorithm, algorithm)
+	}
+}
+
+// Entropy returns the Shannon entropy of sample in bits per byte
+func Entropy(sample []byte) float64 {
+	if len(sample) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range sample {
+		counts[b]++
+	}
+
+	entropy := 0.0
+	total := float64(len(sample))
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// LooksCompressed reports whether sample is too random to be worth
+// compressing, as is the case for archives, media and encrypted data
+func LooksCompressed(sample []byte) bool {
+	return Entropy(sample) >= EntropyThreshold
+}
@@ -0,0 +1,147 @@
+// Package config loads user defaults from the FileVault configuration file.
+package config
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// FileName is the name of the configuration file inside the config directory
+const FileName = "config.json"
+
+// Config holds user defaults. Zero values mean "use the built-in default".
+type Config struct {
+	DefaultAlgorithm  string `json:"default_algorithm,omitempty"`
+	DefaultIterations int    `json:"default_iterations,omitempty"`
+	DefaultKDF        string `json:"default_kdf,omitempty"`
+	Argon2Memory      uint32 `json:"argon2_memory,omitempty"` // MiB
+	Argon2Time        uint32 `json:"argon2_time,omitempty"`
+	Argon2Parallelism uint8  `json:"argon2_parallelism,omitempty"`
+	Compression       string `json:"compression,omitempty"`
+	CompressionLevel  uint8  `json:"compression_level,omitempty"`
+	CABundle          string `json:"ca_bundle,omitempty"`  // PEM roots for --cert recipients
+	Redundancy        int    `json:"redundancy,omitempty"` // recovery record parity in percent
+}
+
+// Dir returns the configuration directory: $FILEVAULT_CONFIG_DIR if set,
+// otherwise ~/.filevault
+func Dir() (string, error) {
+	if dir := os.Getenv("FILEVAULT_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".filevault"), nil
+}
+
+// Load reads the configuration file. A missing file yields an empty Config.
+func Load() (*Config, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(filepath.Join(dir, FileName))
+}
+
+// LoadFile reads the configuration from path. A missing file yields an empty Config.
+func LoadFile(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Algorithm returns the header algorithm identifier of the configured cipher
+func (c *Config) Algorithm() (uint32, error) {
+	name := c.DefaultAlgorithm
+	if name == "" {
+		name = "aes-256-gcm"
+	}
+	return crypto.ParseCipherName(name)
+}
+
+// CompressionParams returns the compression selected by the configuration;
+// compression is off unless configured
+func (c *Config) CompressionParams() (compression.Params, error) {
+	algorithm, err := compression.ParseName(c.Compression)
+	if err != nil {
+		return compression.Params{}, err
+	}
+
+	params := compression.Params{Algorithm: algorithm}
+	if algorithm != compression.None {
+		params.Level = c.CompressionLevel
+	}
+	return params, params.Validate()
+}
+
+// CertPool loads the CA bundle that certificate recipients must chain to
+func (c *Config) CertPool() (*x509.CertPool, error) {
+	if c.CABundle == "" {
+		return nil, fmt.Errorf("no CA bundle configured (set ca_bundle in %s or use --ca-bundle)", FileName)
+	}
+
+	data, err := os.ReadFile(c.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := crypto.LoadCertPool(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.CABundle, err)
+	}
+	return pool, nil
+}
+
+// KDFParams returns the key derivation parameters selected by the configuration,
+// filling anything left unset with the built-in defaults
+func (c *Config) KDFParams() (crypto.KDFParams, error) {
+	name := c.DefaultKDF
+	if name == "" {
+		name = "pbkdf2"
+	}
+
+	algorithm, err := crypto.ParseKDFName(name)
+	if err != nil {
+		return crypto.KDFParams{}, err
+	}
+
+	if algorithm == crypto.KDFArgon2id {
+		params := crypto.DefaultArgon2idParams()
+		if c.Argon2Memory != 0 {
+			params.Memory = c.Argon2Memory * 1024
+		}
+		if c.Argon2Time != 0 {
+			params.Time = c.Argon2Time
+		}
+		if c.Argon2Parallelism != 0 {
+			params.Parallelism = c.Argon2Parallelism
+		}
+		return params, nil
+	}
+
+	iterations := c.DefaultIterations
+	if iterations == 0 {
+		iterations = crypto.DefaultIterations
+	}
+	return crypto.PBKDF2Params(iterations), nil
+}
@@ -0,0 +1,400 @@
+package core
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
+)
+
+// ErrNotArchive is returned when an archive operation is given a file that
+// was not encrypted from a directory
+var ErrNotArchive = errors.New("file is not an encrypted directory")
+
+// ArchiveEntry describes a member of an encrypted directory
+type ArchiveEntry struct {
+	Name       string // slash-separated, starting with the directory name
+	Mode       fs.FileMode
+	Size       int64
+	ModTime    time.Time
+	LinkTarget string // for symlinks
+}
+
+// EncryptDirectory encrypts the tree under dirPath into the file at
+// outputPath, as EncryptDirectoryTo does
+func EncryptDirectory(dirPath, outputPath string, creds Credentials, opts EncryptOptions) error {
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	if err := EncryptDirectoryTo(outputFile, dirPath, creds, opts); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// EncryptDirectoryTo encrypts the tree under dirPath as one tar archive,
+// keeping paths, modes, times, symlinks and empty directories, and writes
+// the encrypted file to dst. The archive is encrypted as it is written and
+// never held in memory, so its size is not known up front and it cannot
+// be padded. Symlinks are stored, not followed, and must point inside the
+// tree; sockets, devices and named pipes are skipped.
+func EncryptDirectoryTo(dst io.Writer, dirPath string, creds Credentials, opts EncryptOptions) error {
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve input directory: %w", err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to get input directory info: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dirPath)
+	}
+	name := filepath.Base(absPath)
+	if err := security.ValidateArchiveName(name); err != nil {
+		return fmt.Errorf("%s cannot be archived: %w", dirPath, err)
+	}
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := writeArchive(writer, absPath, name, opts.RecordOwner)
+		writer.CloseWithError(err)
+		done <- err
+	}()
+
+	opts.archive = true
+	err = encryptTo(dst, reader, -1, &fileops.Metadata{FileName: name}, creds, opts)
+
+	// Stop the archive writer if encryption failed early; an error of its
+	// own is the cause of the failure
+	reader.Close()
+	if archiveErr := <-done; archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+		return archiveErr
+	}
+	return err
+}
+
+// writeArchive writes the tree under dirPath to w as a tar archive whose
+// members are named under name. Owners are recorded only if recordOwner
+// is set.
+func writeArchive(w io.Writer, dirPath, name string, recordOwner bool) error {
+	archive := tar.NewWriter(w)
+	err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		member := path.Join(name, filepath.ToSlash(rel))
+		var link string
+		switch {
+		case info.Mode().IsRegular() || info.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+			if err := security.ValidateSymlink(member, filepath.ToSlash(link)); err != nil {
+				return fmt.Errorf("%s cannot be archived: %w", filePath, err)
+			}
+		default:
+			return nil
+		}
+		if err := security.ValidateArchivePath(member); err != nil {
+			return fmt.Errorf("%s cannot be archived: %w", filePath, err)
+		}
+
+		header, err := tar.FileInfoHeader(info, filepath.ToSlash(link))
+		if err != nil {
+			return fmt.Errorf("%s cannot be archived: %w", filePath, err)
+		}
+		header.Name = member
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Format = tar.FormatPAX
+		if !recordOwner {
+			header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if n, err := io.CopyN(archive, file, header.Size); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("%s shrank while it was archived (%d of %d bytes)", filePath, n, header.Size)
+			}
+			return fmt.Errorf("failed to archive %s: %w", filePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// DecryptArchive decrypts an encrypted directory and extracts it into
+// destDir, which is created if needed; the tree appears under its original
+// name there. Each member is checked before it is written: no absolute
+// paths, no ".." and no symlinks pointing outside destDir, even through
+// other symlinks.
+// Modes and times are restored, and owners too with opts.Preserve when
+// they were recorded and the process may set them.
+// Existing files are kept unless opts.Overwrite is set. Each segment is
+// authenticated before it is extracted, but a damaged file may leave part
+// of the tree behind.
+func DecryptArchive(inputPath, destDir string, creds Credentials, opts DecryptOptions) error {
+	stream, header, err := OpenStream(inputPath, creds)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if !header.HasFlag(fileops.FlagArchive) {
+		return ErrNotArchive
+	}
+	return ExtractArchive(stream, destDir, opts)
+}
+
+// ExtractTo decrypts an encrypted directory read from the stream and
+// extracts it into destDir, as DecryptArchive does
+func (s *EncryptedStream) ExtractTo(destDir string, creds Credentials, opts DecryptOptions) error {
+	if !s.header.HasFlag(fileops.FlagArchive) {
+		return ErrNotArchive
+	}
+
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		writer.CloseWithError(s.DecryptTo(writer, creds, opts))
+		close(done)
+	}()
+
+	err := ExtractArchive(reader, destDir, opts)
+	reader.Close()
+	<-done
+	return err
+}
+
+// ListArchive decrypts an encrypted directory and returns its members
+// without extracting anything
+func ListArchive(inputPath string, creds Credentials) ([]ArchiveEntry, error) {
+	_, entries, err := ReadArchive(inputPath, creds)
+	return entries, err
+}
+
+// ReadArchive decrypts an encrypted directory once and returns both its
+// metadata, as ReadMetadata does, and its members, as ListArchive does
+func ReadArchive(inputPath string, creds Credentials) (*fileops.Metadata, []ArchiveEntry, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	stream, header, meta, err := openStream(file, inputPath, creds)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	defer stream.Close()
+
+	if !header.HasFlag(fileops.FlagArchive) {
+		return nil, nil, ErrNotArchive
+	}
+
+	var entries []ArchiveEntry
+	archive := tar.NewReader(stream)
+	for {
+		member, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		entries = append(entries, ArchiveEntry{
+			Name:       member.Name,
+			Mode:       member.FileInfo().Mode(),
+			Size:       member.Size,
+			ModTime:    member.ModTime,
+			LinkTarget: member.Linkname,
+		})
+	}
+	return meta, entries, nil
+}
+
+// ExtractArchive extracts the plain tar archive read from src into destDir,
+// with the checks and options of DecryptArchive.
+// Every write goes through an os.Root, so that even a symlink already in
+// destDir cannot lead outside it. Directory modes and times are set last,
+// so that read-only directories can be filled first.
+func ExtractArchive(src io.Reader, destDir string, opts DecryptOptions) error {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return fmt.Errorf("failed to open output directory: %w", err)
+	}
+	defer root.Close()
+
+	var dirs []*tar.Header
+	archive := tar.NewReader(src)
+	for {
+		member, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		if err := security.ValidateArchivePath(member.Name); err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(path.Clean(member.Name))
+		if parent := filepath.Dir(name); parent != "." {
+			if err := root.MkdirAll(parent, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		}
+
+		switch member.Typeflag {
+		case tar.TypeDir:
+			if err := root.Mkdir(name, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			dirs = append(dirs, member)
+		case tar.TypeReg:
+			if err := extractFile(root, name, member, archive, opts); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := security.ValidateSymlink(member.Name, member.Linkname); err != nil {
+				return err
+			}
+			if err := checkRealParents(root, name); err != nil {
+				return err
+			}
+			if opts.Overwrite {
+				root.Remove(name)
+			}
+			if err := root.Symlink(filepath.FromSlash(member.Linkname), name); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+			if err := restoreOwner(root, name, member, opts); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid archive: %s has unsupported type %q", member.Name, member.Typeflag)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := filepath.FromSlash(path.Clean(dirs[i].Name))
+		if err := restoreOwner(root, name, dirs[i], opts); err != nil {
+			return err
+		}
+		if err := root.Chmod(name, dirs[i].FileInfo().Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to set directory mode: %w", err)
+		}
+		root.Chtimes(name, dirs[i].AccessTime, dirs[i].ModTime)
+	}
+
+	// Read past the end of the archive, so that the final segment is
+	// authenticated too
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+	return nil
+}
+
+// checkRealParents makes sure that none of the directories leading to name
+// under root is a symlink, whether extracted earlier or already there. A
+// symlink created below one would have its target resolved from wherever
+// that one points, not from the directory ValidateSymlink checked it against.
+func checkRealParents(root *os.Root, name string) error {
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		info, err := root.Lstat(dir)
+		if err != nil {
+			return fmt.Errorf("failed to check directory: %w", err)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("invalid archive: symlink %s would be created through symlink %s",
+				filepath.ToSlash(name), filepath.ToSlash(dir))
+		}
+	}
+	return nil
+}
+
+// extractFile writes a regular archive member to name under root
+func extractFile(root *os.Root, name string, member *tar.Header, src io.Reader, opts DecryptOptions) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if opts.Overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := root.OpenFile(name, flags, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("output file already exists: %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, src); err != nil {
+		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	// Before the mode, as a change of owner clears setuid bits
+	if err := restoreOwner(root, name, member, opts); err != nil {
+		return err
+	}
+	if err := root.Chmod(name, member.FileInfo().Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	root.Chtimes(name, member.AccessTime, member.ModTime)
+	return nil
+}
+
+// restoreOwner sets the recorded owner of a member with opts.Preserve,
+// like FileAttributes.Apply: without the privilege to do so, files
+// keep the owner of the process
+func restoreOwner(root *os.Root, name string, member *tar.Header, opts DecryptOptions) error {
+	if !opts.Preserve {
+		return nil
+	}
+	err := root.Lchown(name, member.Uid, member.Gid)
+	if err != nil && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("failed to set owner of %s: %w", name, err)
+	}
+	return nil
+}
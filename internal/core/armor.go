@@ -0,0 +1,147 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// MaxTextSize limits the text that EncryptText and DecryptText handle in
+// memory; longer input belongs in a file
+const MaxTextSize = 1 << 20
+
+// armorSniffSize is how much of a file is searched for an armor BEGIN line
+const armorSniffSize = 4096
+
+// maxTextFileSize bounds the encrypted form of a text, which adds the key
+// slots, metadata and authentication tags
+const maxTextFileSize = 4 * MaxTextSize
+
+// IsArmored reports whether the file at path is ASCII armored
+func IsArmored(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	start := make([]byte, armorSniffSize)
+	n, err := io.ReadFull(file, start)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("failed to read input file: %w", err)
+	}
+	return fileops.LooksArmored(start[:n]), nil
+}
+
+// DearmorFile decodes the ASCII-armored file at inputPath into the binary
+// encrypted file at outputPath. Nothing is decrypted.
+func DearmorFile(inputPath, outputPath string) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	if _, err := io.Copy(outputFile, fileops.NewArmorReader(inputFile)); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// Dearmored returns the path of the binary form of the encrypted file at
+// path: path itself, or a temporary file decoded from its ASCII armor,
+// which cleanup removes. Commands that read encrypted files call it first
+// so that armored input is accepted everywhere.
+func Dearmored(path string) (string, func(), error) {
+	armored, err := IsArmored(path)
+	if err != nil || !armored {
+		return path, func() {}, nil
+	}
+
+	tempFile, err := os.CreateTemp("", "filevault-dearmor-*.enc")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempFile.Close()
+	cleanup := func() { os.Remove(tempFile.Name()) }
+
+	if err := DearmorFile(path, tempFile.Name()); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tempFile.Name(), cleanup, nil
+}
+
+// EncryptText encrypts a short text, at most MaxTextSize bytes, and writes
+// it to dst as ASCII armor, e.g. for pasting into a chat. It is never
+// compressed, so that its length is all it reveals.
+func EncryptText(dst io.Writer, text []byte, creds Credentials, opts EncryptOptions) error {
+	if len(text) > MaxTextSize {
+		return fmt.Errorf("text too long: %d bytes (maximum %d, encrypt a file instead)", len(text), MaxTextSize)
+	}
+	opts.Armor = false
+	opts.Compression = compression.Params{}
+
+	armor := fileops.NewArmorWriter(dst)
+	if err := encryptStream(armor, bytes.NewReader(text), int64(len(text)), &fileops.Metadata{}, creds, opts); err != nil {
+		return err
+	}
+	return armor.Close()
+}
+
+// ReadArmor decodes the ASCII armor of a text read from src and returns the
+// binary encrypted data. Reading stops at the END line, so armor pasted
+// into a terminal can be read before a password is asked for.
+func ReadArmor(src io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(fileops.NewArmorReader(src), maxTextFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTextFileSize {
+		return nil, fmt.Errorf("armored text too long (decrypt it as a file)")
+	}
+	return data, nil
+}
+
+// DecryptText decrypts a text read from src, such as the output of
+// EncryptText or ReadArmor, and returns it. Armored input is decoded first.
+func DecryptText(src io.Reader, creds Credentials) ([]byte, error) {
+	buffered := bufio.NewReader(src)
+	var data []byte
+	if magic, _ := buffered.Peek(len(fileops.MagicBytes)); string(magic) == fileops.MagicBytes {
+		var err error
+		if data, err = io.ReadAll(io.LimitReader(buffered, maxTextFileSize+1)); err != nil {
+			return nil, err
+		}
+		if len(data) > maxTextFileSize {
+			return nil, fmt.Errorf("encrypted text too long (decrypt it as a file)")
+		}
+	} else {
+		var err error
+		if data, err = ReadArmor(buffered); err != nil {
+			return nil, err
+		}
+	}
+
+	reader, err := NewReader(bytes.NewReader(data), int64(len(data)), creds)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if reader.Size() > MaxTextSize {
+		return nil, fmt.Errorf("text too long: %d bytes (decrypt it as a file)", reader.Size())
+	}
+	return io.ReadAll(reader)
+}
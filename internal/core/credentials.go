@@ -0,0 +1,142 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// Errors for credentials that do not match the factors recorded in the header
+var (
+	ErrKeyfileRequired  = errors.New("this file requires a keyfile")
+	ErrKeyfileNotUsed   = errors.New("this file was not encrypted with a keyfile")
+	ErrNoCredentials    = errors.New("a password, keyfile or recipient is required")
+	ErrIdentityRequired = errors.New("this file can only be opened with an identity")
+)
+
+// Credentials are the factors that unlock a file: a password, keyfiles, or
+// both. Keyfiles are given by path; any file can be a keyfile and the order
+// does not matter. Identities open key slots wrapped for their recipients.
+// Shares are key shares of a threshold file collected from other holders.
+type Credentials struct {
+	Password   string
+	Keyfiles   []string
+	Identities []crypto.Identity
+	Shares     []*crypto.Share
+}
+
+// Password returns credentials consisting of a password only
+func Password(password string) Credentials {
+	return Credentials{Password: password}
+}
+
+// UsesKeyfiles reports whether keyfiles were given
+func (c Credentials) UsesKeyfiles() bool {
+	return len(c.Keyfiles) > 0
+}
+
+// UsesIdentities reports whether identities were given
+func (c Credentials) UsesIdentities() bool {
+	return len(c.Identities) > 0
+}
+
+// Factors returns the password and keyfile factors of the credentials,
+// which is what a new password slot is protected by
+func (c Credentials) Factors() fileops.Factors {
+	var factors fileops.Factors
+	if c.Password != "" {
+		factors |= fileops.FactorPassword
+	}
+	if c.UsesKeyfiles() {
+		factors |= fileops.FactorKeyfile
+	}
+	return factors
+}
+
+// checkFactors reports a keyfile that factors need but was not given, or
+// one that was given but is not used
+func (c Credentials) checkFactors(factors fileops.Factors) error {
+	if factors.RequiresKeyfile() && !c.UsesKeyfiles() {
+		return ErrKeyfileRequired
+	}
+	if !factors.RequiresKeyfile() && c.UsesKeyfiles() {
+		return ErrKeyfileNotUsed
+	}
+	return nil
+}
+
+// secret combines the credentials into the KDF input for factors.
+// A password given for a keyfile-only factor set is ignored.
+func (c Credentials) secret(factors fileops.Factors) (string, error) {
+	if err := c.checkFactors(factors); err != nil {
+		return "", err
+	}
+
+	password := c.Password
+	if !factors.RequiresPassword() {
+		password = ""
+	}
+	if !c.UsesKeyfiles() {
+		return password, nil
+	}
+
+	key, err := crypto.KeyfileKey(c.Keyfiles)
+	if err != nil {
+		return "", err
+	}
+	defer crypto.SecureZero(key)
+	return crypto.CombineKeyfile(password, key), nil
+}
+
+// openFileAEAD unlocks an existing file with creds and builds the AEAD
+// registered for the header's algorithm. It returns the number of key slot
+// bytes it consumed from r, which must be positioned just after the header;
+// files without key slots derive the key directly and consume nothing.
+func openFileAEAD(r io.Reader, header *fileops.FileHeader, creds Credentials) (crypto.AEAD, int64, error) {
+	if !header.HasKeySlots() {
+		secret, err := creds.secret(header.Factors())
+		if err != nil {
+			return nil, 0, err
+		}
+		aead, err := crypto.NewAEADFromKDF(header.Algorithm, secret, header.Salt, header.KDFParams())
+		return aead, 0, err
+	}
+
+	area, err := fileops.ReadKeySlotArea(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, err := openSlotAEAD(area, header, creds)
+	return aead, area.Size(), err
+}
+
+// openSlotAEAD unlocks the file key in the key slot area of a v4 file
+func openSlotAEAD(area *fileops.KeySlotArea, header *fileops.FileHeader, creds Credentials) (crypto.AEAD, error) {
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	key, _, err := unlockKeySlots(area, headerBytes, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.SecureZero(key)
+
+	return crypto.NewAEAD(header.Algorithm, key)
+}
+
+// ReadHeader reads and validates the header of an encrypted file, for
+// example to find out which factors it needs before asking for them
+func ReadHeader(path string) (*fileops.FileHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+	return readFileHeader(file, path)
+}
@@ -1,12 +1,16 @@
 package core
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	fverrors "github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/errors"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
 )
 
@@ -15,8 +19,28 @@ func DecryptFile(inputPath, outputPath, password string) error {
 	return DecryptFileWithProgress(inputPath, outputPath, password, nil)
 }
 
+// DecryptOptions controls how a file is decrypted
+type DecryptOptions struct {
+	// Threads is the number of segment decryption workers; zero uses one per CPU
+	Threads int
+	// Preserve restores the recorded mode, times, owner and extended
+	// attributes of the original file on the output
+	Preserve bool
+	// Overwrite replaces existing files when an archive is extracted
+	Overwrite bool
+	// Progress receives progress updates and may be nil
+	Progress ProgressCallback
+}
+
 // DecryptFileWithProgress decrypts a file with progress reporting
 func DecryptFileWithProgress(inputPath, outputPath, password string, progressCallback ProgressCallback) error {
+	return DecryptFileWithOptions(inputPath, outputPath, Password(password), DecryptOptions{Progress: progressCallback})
+}
+
+// DecryptFileWithOptions decrypts a file with creds and the given options
+func DecryptFileWithOptions(inputPath, outputPath string, creds Credentials, opts DecryptOptions) error {
+	progressCallback := opts.Progress
+
 	// Open input file
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -30,24 +54,36 @@ func DecryptFileWithProgress(inputPath, outputPath, password string, progressCal
 	}
 
 	// Read and validate header
-	var header fileops.FileHeader
-	_, err = header.ReadFrom(inputFile)
+	header, err := readFileHeader(inputFile, inputPath)
 	if err != nil {
-		return fmt.Errorf("failed to read header: %w", err)
+		return err
 	}
 
-	if err := header.IsValid(); err != nil {
-		return fmt.Errorf("invalid file format: %w", err)
+	// Report progress
+	if progressCallback != nil {
+		progressCallback(10, 100, "Validating file format")
+	}
+
+	// Unlock the file key and build the AEAD registered for the header's algorithm
+	aead, _, err := openFileAEAD(inputFile, header, creds)
+	if err != nil {
+		return fmt.Errorf("failed to unlock file: %w", err)
 	}
 
 	// Report progress
 	if progressCallback != nil {
-		progressCallback(10, 100, "Validating file format")
+		progressCallback(20, 100, "Deriving decryption key")
+	}
+
+	// The original name may only be known once the metadata block is decrypted
+	meta, _, err := readMetadata(inputFile, header, aead)
+	if err != nil {
+		return err
 	}
 
 	// Determine output path if not specified
 	if outputPath == "" {
-		outputPath = header.GetBaseFileName()
+		outputPath = meta.GetBaseFileName()
 		if outputPath == "" {
 			// Fallback: remove .enc extension
 			baseName := filepath.Base(inputPath)
@@ -59,65 +95,114 @@ func DecryptFileWithProgress(inputPath, outputPath, password string, progressCal
 		}
 	}
 
-	// Create AES cipher from password and salt
-	cipher, err := crypto.NewAESCipherFromPassword(password, header.Salt)
-	if err != nil {
-		return fmt.Errorf("failed to create cipher: %w", err)
+	// Legacy v1 files hold a single GCM message and are decrypted in memory
+	if !header.IsStreaming() {
+		return decryptV1(inputFile, outputPath, header, aead, progressCallback)
 	}
 
-	// Report progress
-	if progressCallback != nil {
-		progressCallback(20, 100, "Deriving decryption key")
+	if err := decryptStreaming(inputFile, outputPath, header, meta, aead, opts); err != nil {
+		return err
 	}
 
-	// Calculate encrypted data size (total - header - auth tag)
-	inputInfo, err := inputFile.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get input file info: %w", err)
+	if opts.Preserve && meta.Attributes != nil {
+		return meta.Attributes.Apply(outputPath)
 	}
+	return nil
+}
 
-	encryptedDataSize := int64(inputInfo.Size()) - int64(header.GetTotalSize()) - fileops.AuthTagSize
+// readFileHeader reads and validates the header of an encrypted file, mapping
+// damaged headers and newer formats to their user-facing errors
+func readFileHeader(r io.Reader, path string) (*fileops.FileHeader, error) {
+	var header fileops.FileHeader
+	if _, err := header.ReadFrom(r); err != nil {
+		if errors.Is(err, fileops.ErrHeaderCorrupted) {
+			return nil, fverrors.NewHeaderCorruptedError(path, err)
+		}
+		if errors.Is(err, fileops.ErrUnsupportedVersion) {
+			return nil, fverrors.NewUnsupportedVersionError(path, err)
+		}
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
 
-	// Report progress
-	if progressCallback != nil {
-		progressCallback(30, 100, "Reading encrypted data")
+	if err := header.IsValid(); err != nil {
+		if errors.Is(err, fileops.ErrUnsupportedVersion) || errors.Is(err, fileops.ErrUnsupportedFeature) {
+			return nil, fverrors.NewUnsupportedVersionError(path, err)
+		}
+		return nil, fmt.Errorf("invalid file format: %w", err)
 	}
 
-	// Read encrypted data
-	encryptedData := make([]byte, encryptedDataSize)
-	_, err = io.ReadFull(inputFile, encryptedData)
+	return &header, nil
+}
+
+// decryptStreaming decrypts a segmented payload in constant memory, reading
+// from just after the header and any metadata block.
+// Plaintext is written as each segment authenticates, so the output file
+// is removed again if any later segment fails.
+func decryptStreaming(inputFile *os.File, outputPath string, header *fileops.FileHeader, meta *fileops.Metadata, aead crypto.AEAD, opts DecryptOptions) (err error) {
+	progressCallback := opts.Progress
+
+	end, err := protectedSize(inputFile, header)
 	if err != nil {
-		return fmt.Errorf("failed to read encrypted data: %w", err)
+		return err
 	}
+	payloadAt, err := inputFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to get payload offset: %w", err)
+	}
+	payloadSize := end - payloadAt - signatureSize(header)
 
-	// Report progress
-	if progressCallback != nil {
-		progressCallback(50, 100, "Reading authentication tag")
+	// Create output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
 	}
+	defer func() {
+		outputFile.Close()
+		if err != nil {
+			os.Remove(outputPath)
+		}
+	}()
+
+	source := withProgress(io.LimitReader(inputFile, payloadSize), payloadSize, "Decrypting data", progressCallback)
+	segments, err := newPayloadReader(source, header, meta, aead, opts.Threads)
+	if err != nil {
+		return err
+	}
+	defer segments.Close()
 
-	// Read authentication tag
-	authTag := make([]byte, fileops.AuthTagSize)
-	_, err = io.ReadFull(inputFile, authTag)
+	writer := bufio.NewWriterSize(outputFile, fileops.LargeFileBuffer)
+	written, err := io.Copy(writer, segments)
 	if err != nil {
-		return fmt.Errorf("failed to read auth tag: %w", err)
+		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+
+	// Verify original size; streamed files end with their final segment
+	if expected := plaintextSize(header, meta); expected >= 0 && written != expected {
+		return fmt.Errorf("decrypted size mismatch: expected %d, got %d", expected, written)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write decrypted data: %w", err)
 	}
 
-	// Create encrypted data structure
-	cryptoData := &crypto.EncryptedData{
-		Nonce:      header.IV[:12], // Use first 12 bytes of IV as nonce
-		Ciphertext: encryptedData,
-		Tag:        authTag,
+	// Report completion
+	if progressCallback != nil {
+		progressCallback(payloadSize, payloadSize, "Decryption completed")
 	}
 
+	return nil
+}
+
+// decryptV1 decrypts a legacy v1 file whose payload is one GCM message
+func decryptV1(inputFile *os.File, outputPath string, header *fileops.FileHeader, aead crypto.AEAD, progressCallback ProgressCallback) error {
 	// Report progress
 	if progressCallback != nil {
-		progressCallback(70, 100, "Decrypting data")
+		progressCallback(30, 100, "Decrypting data")
 	}
 
-	// Decrypt
-	plaintext, err := cipher.Decrypt(cryptoData)
+	plaintext, err := readV1Payload(inputFile, header, aead)
 	if err != nil {
-		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+		return err
 	}
 
 	// Verify original size
@@ -150,7 +235,81 @@ func DecryptFileWithProgress(inputPath, outputPath, password string, progressCal
 
 	// Secure cleanup
 	crypto.SecureZero(plaintext)
-	crypto.SecureZero(encryptedData)
 
 	return nil
 }
+
+// newPayloadReader returns a reader that decrypts, and if needed decompresses
+// or unpads, the segmented payload read from r, which must be positioned at
+// the first segment. Segments are decrypted on threads workers (zero for one
+// per CPU). The caller must Close it.
+func newPayloadReader(r io.Reader, header *fileops.FileHeader, meta *fileops.Metadata, aead crypto.AEAD, threads int) (io.ReadCloser, error) {
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	segments := newSegmentDecrypter(r, aead, header.BaseNonce(aead.NonceSize()), headerBytes, int(header.SegmentSize()), threads)
+	if !header.HasFlag(fileops.FlagCompressed) {
+		return newUnpaddingReader(segments, header, meta)
+	}
+
+	decompressor, err := compression.NewReader(segments, header.Compression().Algorithm)
+	if err != nil {
+		segments.Close()
+		return nil, fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+
+	// Never inflate past the recorded size; one extra byte exposes the
+	// mismatch. Streamed files record none.
+	var limited io.Reader = decompressor
+	if !header.HasFlag(fileops.FlagStreamed) {
+		limited = io.LimitReader(decompressor, int64(header.OriginalSize)+1)
+	}
+	return &decompressingReader{
+		Reader:       limited,
+		decompressor: decompressor,
+		segments:     segments,
+	}, nil
+}
+
+// decompressingReader closes the decompressor and the segments beneath it
+type decompressingReader struct {
+	io.Reader
+	decompressor io.Closer
+	segments     io.Closer
+}
+
+func (d *decompressingReader) Close() error {
+	d.decompressor.Close()
+	return d.segments.Close()
+}
+
+// readV1Payload reads and decrypts the single GCM message of a v1 file
+func readV1Payload(inputFile *os.File, header *fileops.FileHeader, aead crypto.AEAD) ([]byte, error) {
+	// Calculate encrypted data size (total - header), including the auth tag
+	inputInfo, err := inputFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input file info: %w", err)
+	}
+
+	encryptedDataSize := int64(inputInfo.Size()) - int64(header.GetTotalSize())
+	if encryptedDataSize < fileops.AuthTagSize {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", ErrTruncated)
+	}
+
+	// Read encrypted data followed by the authentication tag
+	encryptedData := make([]byte, encryptedDataSize)
+	if _, err := io.ReadFull(inputFile, encryptedData); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	defer crypto.SecureZero(encryptedData)
+
+	// Decrypt; v1 uses the first 12 bytes of the IV as nonce and no associated data
+	plaintext, err := aead.Open(nil, header.BaseNonce(aead.NonceSize()), encryptedData, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password or corrupted file): %w: %v", crypto.ErrDecryptionFailed, err)
+	}
+
+	return plaintext, nil
+}
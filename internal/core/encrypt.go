@@ -1,11 +1,14 @@
 package core
 
 import (
+	"bufio"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
 )
@@ -18,8 +21,64 @@ func EncryptFile(inputPath, outputPath, password string) error {
 	return EncryptFileWithProgress(inputPath, outputPath, password, nil)
 }
 
+// EncryptOptions controls how a file is encrypted
+type EncryptOptions struct {
+	// KDF selects the password key derivation; the zero value uses the default
+	KDF crypto.KDFParams
+	// Algorithm selects the AEAD by header identifier; zero means AES-256-GCM
+	Algorithm uint32
+	// PlaintextMetadata keeps the original filename in the cleartext header
+	// instead of an encrypted metadata block; such files record no attributes
+	PlaintextMetadata bool
+	// Pad hides the exact size: the plaintext is padded with zeros to the
+	// next PADMÉ size, which is all the header and ciphertext length show.
+	// The real size goes into the encrypted metadata block, so padding
+	// needs it and cannot be combined with compression.
+	Pad bool
+	// RecordOwner adds the owner UID and GID to the file attributes that
+	// are recorded (mode, times and extended attributes)
+	RecordOwner bool
+	// Compression compresses the plaintext before encryption. It is skipped
+	// when a sample of the input looks already compressed.
+	Compression compression.Params
+	// Recipients get a key slot each, opened by their identities. With
+	// recipients, the credentials may be empty.
+	Recipients []crypto.Recipient
+	// Threshold, if at least 2, splits the file key into Shamir shares, one
+	// per holder: the credentials if they hold a password or keyfiles, each
+	// of ShareHolders, then each recipient. Any Threshold of them together
+	// decrypt the file; fewer learn nothing about the key.
+	Threshold    int
+	ShareHolders []Credentials
+	// Signer, if set, signs the header and payload; the signature is
+	// appended to the file and reported by verify
+	Signer *crypto.MinisignPrivateKey
+	// Recovery appends Reed-Solomon parity of this many percent of the file
+	// size, from 1 to 100, so that damage can be repaired without the
+	// password; zero adds none. It needs a seekable output file.
+	Recovery int
+	// Armor writes the file as ASCII armor, Base64 between BEGIN and END
+	// lines, for channels that mangle binary attachments. Armored files
+	// cannot carry a recovery record, which is repaired in place.
+	Armor bool
+	// Threads is the number of segment encryption workers; zero uses one per
+	// CPU. Memory use grows with it, by about four segments per worker.
+	Threads int
+	// Progress receives progress updates and may be nil
+	Progress ProgressCallback
+
+	// archive marks the payload as a tar archive; set by EncryptDirectory
+	archive bool
+}
+
 // EncryptFileWithProgress encrypts a file with progress reporting
 func EncryptFileWithProgress(inputPath, outputPath, password string, progressCallback ProgressCallback) error {
+	return EncryptFileWithOptions(inputPath, outputPath, Password(password), EncryptOptions{Progress: progressCallback})
+}
+
+// EncryptFileWithOptions encrypts a file under creds with the given options.
+// The header records whether the key needs a password, keyfiles or both.
+func EncryptFileWithOptions(inputPath, outputPath string, creds Credentials, opts EncryptOptions) error {
 	// Open input file
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -27,113 +86,334 @@ func EncryptFileWithProgress(inputPath, outputPath, password string, progressCal
 	}
 	defer inputFile.Close()
 
+	// Create output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	if err := encryptFile(outputFile, inputFile, inputPath, creds, opts); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// EncryptFileTo encrypts the file at inputPath and writes the encrypted
+// file to dst, e.g. stdout. A recovery record needs dst to be a file.
+func EncryptFileTo(dst io.Writer, inputPath string, creds Credentials, opts EncryptOptions) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	return encryptFile(dst, inputFile, inputPath, creds, opts)
+}
+
+// EncryptStream encrypts everything read from src, e.g. stdin, and writes
+// the encrypted file to dst without buffering it. The header is written
+// before the size is known, so it records neither size nor name, and the
+// input cannot be padded. A recovery record needs dst to be a file.
+func EncryptStream(dst io.Writer, src io.Reader, creds Credentials, opts EncryptOptions) error {
+	return encryptTo(dst, src, -1, &fileops.Metadata{}, creds, opts)
+}
+
+// encryptFile encrypts inputFile, opened from inputPath, to dst
+func encryptFile(dst io.Writer, inputFile *os.File, inputPath string, creds Credentials, opts EncryptOptions) error {
 	// Get input file info
 	inputInfo, err := inputFile.Stat()
 	if err != nil {
 		return fmt.Errorf("failed to get input file info: %w", err)
 	}
 
+	meta := &fileops.Metadata{FileName: filepath.Base(inputPath)}
+	if !opts.PlaintextMetadata {
+		if meta.Attributes, err = fileops.ReadAttributes(inputPath, opts.RecordOwner); err != nil {
+			return err
+		}
+	}
+	return encryptTo(dst, inputFile, inputInfo.Size(), meta, creds, opts)
+}
+
+// encryptTo writes the encrypted file to dst like encryptStream, as armor
+// or followed by a recovery record if opts ask for either
+func encryptTo(dst io.Writer, src io.Reader, size int64, meta *fileops.Metadata, creds Credentials, opts EncryptOptions) error {
+	if opts.Armor && opts.Recovery > 0 {
+		return fmt.Errorf("invalid encryption options: a recovery record cannot be armored")
+	}
+
+	if opts.Armor {
+		armor := fileops.NewArmorWriter(dst)
+		if err := encryptStream(armor, src, size, meta, creds, opts); err != nil {
+			return err
+		}
+		if err := armor.Close(); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		return nil
+	}
+
+	// The recovery record covers the whole file, so it must start at the
+	// beginning of a file that can be read back
+	outputFile, _ := dst.(*os.File)
+	if opts.Recovery > 0 {
+		if outputFile == nil {
+			return fmt.Errorf("invalid encryption options: a recovery record needs an output file")
+		}
+		info, err := outputFile.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to get output file info: %w", err)
+		}
+		if offset, err := outputFile.Seek(0, io.SeekCurrent); err != nil || offset != 0 || !info.Mode().IsRegular() {
+			return fmt.Errorf("invalid encryption options: a recovery record needs an output file, not a pipe")
+		}
+	}
+
+	if err := encryptStream(dst, src, size, meta, creds, opts); err != nil {
+		return err
+	}
+
+	// The recovery record covers everything written so far
+	if opts.Recovery > 0 {
+		end, err := outputFile.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("failed to get output size: %w", err)
+		}
+		if err := writeRecoveryRecord(outputFile, end, opts.Recovery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptStream writes a complete encrypted file to dst: the header for a
+// payload of size bytes described by meta, followed by the segmented
+// payload read from src. A negative size means that src is read to its end
+// and the header records no size.
+func encryptStream(dst io.Writer, src io.Reader, size int64, meta *fileops.Metadata, creds Credentials, opts EncryptOptions) error {
+	progressCallback := opts.Progress
+
+	kdfParams := opts.KDF
+	if kdfParams.Algorithm == crypto.KDFLegacy {
+		kdfParams = crypto.DefaultKDFParams()
+	}
+	if err := kdfParams.Validate(); err != nil {
+		return fmt.Errorf("invalid key derivation parameters: %w", err)
+	}
+
+	algorithm := opts.Algorithm
+	if algorithm == 0 {
+		algorithm = fileops.AlgorithmAES256GCM
+	}
+
+	cipherAlgorithm, err := crypto.LookupAlgorithm(algorithm)
+	if err != nil {
+		return fmt.Errorf("invalid encryption options: %w", err)
+	}
+
+	compressionParams := opts.Compression
+	if err := compressionParams.Validate(); err != nil {
+		return fmt.Errorf("invalid encryption options: %w", err)
+	}
+
+	if opts.Recovery < 0 || opts.Recovery > fileops.MaxRecoveryPercent {
+		return fmt.Errorf("invalid encryption options: redundancy must be between 1 and %d%%", fileops.MaxRecoveryPercent)
+	}
+
+	// The header only records the padded size; the real one is encrypted
+	storedSize := size
+	streamed := size < 0
+	if streamed {
+		if opts.Pad {
+			return fmt.Errorf("invalid encryption options: padding needs the size of the input up front")
+		}
+		storedSize = 0
+	}
+	if opts.Pad {
+		if opts.PlaintextMetadata {
+			return fmt.Errorf("invalid encryption options: padding needs the encrypted metadata block (no plain name)")
+		}
+		if compressionParams.Enabled() {
+			return fmt.Errorf("invalid encryption options: padding cannot be combined with compression")
+		}
+		storedSize = int64(fileops.PadmeSize(uint64(size)))
+		padded := *meta
+		padded.Size = uint64(size)
+		meta = &padded
+	}
+
+	// Sample the start of the input; archives, media and encrypted data
+	// would only grow, so they are stored as is
+	if compressionParams.Enabled() {
+		buffered := bufio.NewReaderSize(src, compression.SampleSize)
+		sample, err := buffered.Peek(compression.SampleSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		if compression.LooksCompressed(sample) {
+			compressionParams = compression.Params{}
+		}
+		src = buffered
+	}
+
 	// Generate cryptographic parameters
 	salt, err := crypto.GenerateSalt32()
 	if err != nil {
 		return fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	iv, err := crypto.GenerateIV16()
+	nonce, err := crypto.GenerateRandomBytes(cipherAlgorithm.NonceSize)
 	if err != nil {
 		return fmt.Errorf("failed to generate IV: %w", err)
 	}
 
-	// Create file header
-	originalFileName := filepath.Base(inputPath)
-	header := fileops.NewFileHeader(uint64(inputInfo.Size()), originalFileName, salt, iv)
+	// Create file header; by default the name only goes into the encrypted metadata
+	headerName := meta.FileName
+	if !opts.PlaintextMetadata {
+		headerName = ""
+	}
+
+	header := fileops.NewFileHeader(uint64(storedSize), headerName, salt, [16]byte{})
+	header.SetFlag(fileops.FlagEncryptedMetadata, !opts.PlaintextMetadata)
+	header.Algorithm = algorithm
+	header.SetBaseNonce(nonce)
+	header.SetKDFParams(kdfParams)
+	header.SetCompression(compressionParams)
+	header.SetFlag(fileops.FlagKeySlots, true)
+	header.SetFlag(fileops.FlagSigned, opts.Signer != nil)
+	header.SetFlag(fileops.FlagPadded, opts.Pad)
+	header.SetFlag(fileops.FlagRecovery, opts.Recovery > 0)
+	header.SetFlag(fileops.FlagStreamed, streamed)
+	header.SetFlag(fileops.FlagArchive, opts.archive)
+	if err := header.IsValid(); err != nil {
+		return fmt.Errorf("invalid encryption options: %w", err)
+	}
 
-	// Create output file
-	outputFile, err := os.Create(outputPath)
+	// Write header; the same bytes are authenticated with every segment
+	headerBytes, err := header.MarshalBinary()
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("failed to serialize header: %w", err)
 	}
-	defer outputFile.Close()
 
-	// Write header
-	_, err = header.WriteTo(outputFile)
+	// The payload is encrypted under a random key, wrapped for creds in the
+	// first key slot and for each recipient in the next; more slots can be
+	// added later without re-encrypting
+	key, err := crypto.GenerateFileKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate file key: %w", err)
+	}
+	defer crypto.SecureZero(key)
+
+	var area *fileops.KeySlotArea
+	if opts.Threshold > 0 {
+		holders := opts.ShareHolders
+		if creds.Factors() != 0 {
+			holders = append([]Credentials{creds}, holders...)
+		}
+		area, err = newShareSlotArea(holders, opts.Recipients, opts.Threshold, kdfParams, key, headerBytes)
+	} else {
+		area, err = newKeySlotArea(creds, opts.Recipients, kdfParams, key, headerBytes)
+	}
+	if err != nil {
+		return err
+	}
+	areaBytes, err := area.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(headerBytes)
 	if err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
+	if _, err := dst.Write(areaBytes); err != nil {
+		return fmt.Errorf("failed to write key slots: %w", err)
+	}
+
+	// The signature covers the header and everything after the key slots
+	out := dst
+	var digest hash.Hash
+	if opts.Signer != nil {
+		digest = crypto.NewMinisignHash()
+		digest.Write(headerBytes)
+		out = io.MultiWriter(dst, digest)
+	}
 
-	// Create AES cipher from password
-	cipher, err := crypto.NewAESCipherFromPassword(password, salt)
+	// Build the selected AEAD from the file key
+	aead, err := crypto.NewAEAD(algorithm, key)
 	if err != nil {
 		return fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	// For small files, read all at once
-	if inputInfo.Size() <= 64*1024*1024 { // 64MB threshold
-		return encryptSmallFile(inputFile, outputFile, cipher, iv, inputInfo.Size(), progressCallback)
+	baseNonce := header.BaseNonce(aead.NonceSize())
+	if header.HasFlag(fileops.FlagEncryptedMetadata) {
+		if err := writeMetadataBlock(out, meta, aead, baseNonce, headerBytes); err != nil {
+			return err
+		}
 	}
 
-	// For large files, use streaming encryption
-	return encryptLargeFile(inputFile, outputFile, cipher, iv, inputInfo.Size(), progressCallback)
-}
-
-// encryptSmallFile encrypts smaller files in one go
-func encryptSmallFile(inputFile, outputFile *os.File, cipher *crypto.AESCipher, iv [16]byte, fileSize int64, progressCallback ProgressCallback) error {
 	// Report initial progress
 	if progressCallback != nil {
-		progressCallback(0, fileSize, "Reading file")
+		progressCallback(0, size, "Encrypting")
 	}
 
-	// Read entire file content
-	plaintext, err := io.ReadAll(inputFile)
-	if err != nil {
-		return fmt.Errorf("failed to read input file: %w", err)
-	}
+	// Stream the payload through fixed-size authenticated segments
+	writer := bufio.NewWriterSize(out, fileops.LargeFileBuffer)
+	segments := newSegmentEncrypter(writer, aead, baseNonce, headerBytes, int(header.SegmentSize()), opts.Threads)
 
-	// Report progress after reading
-	if progressCallback != nil {
-		progressCallback(fileSize/2, fileSize, "Encrypting")
+	// Compressed output is what gets split into segments
+	var sink io.WriteCloser = segments
+	if compressionParams.Enabled() {
+		sink, err = compression.NewWriter(segments, compressionParams)
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
 	}
 
-	// Encrypt the content using the nonce from header
-	nonce := iv[:12] // Use first 12 bytes of IV as nonce
-	encryptedData, err := cipher.EncryptWithNonce(plaintext, nonce)
+	var source io.Reader = withProgress(src, size, "Encrypting", progressCallback)
+	if opts.Pad && storedSize > size {
+		source = io.MultiReader(source, io.LimitReader(zeroReader{}, storedSize-size))
+	}
+	written, err := io.Copy(sink, source)
 	if err != nil {
+		segments.Close()
 		return fmt.Errorf("failed to encrypt: %w", err)
 	}
 
-	// Report progress after encryption
-	if progressCallback != nil {
-		progressCallback(fileSize*3/4, fileSize, "Writing encrypted data")
+	if sink != segments {
+		if err := sink.Close(); err != nil {
+			segments.Close()
+			return fmt.Errorf("failed to compress: %w", err)
+		}
 	}
 
-	// Write encrypted data
-	_, err = outputFile.Write(encryptedData.Ciphertext)
-	if err != nil {
+	if err := segments.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	if err := writer.Flush(); err != nil {
 		return fmt.Errorf("failed to write encrypted data: %w", err)
 	}
 
-	// Write authentication tag at the end
-	_, err = outputFile.Write(encryptedData.Tag)
-	if err != nil {
-		return fmt.Errorf("failed to write auth tag: %w", err)
+	// The header already promised size bytes
+	if !streamed && written != storedSize {
+		return fmt.Errorf("input changed during encryption: expected %d bytes, read %d", size, written-(storedSize-size))
+	}
+
+	if digest != nil {
+		if err := writeSignatureTrailer(dst, opts.Signer, digest.Sum(nil)); err != nil {
+			return err
+		}
 	}
 
 	// Report completion
 	if progressCallback != nil {
-		progressCallback(fileSize, fileSize, "Encryption completed")
+		if streamed {
+			size = written
+		}
+		progressCallback(size, size, "Encryption completed")
 	}
 
-	// Secure cleanup
-	crypto.SecureZero(plaintext)
-
 	return nil
 }
-
-// encryptLargeFile encrypts large files with streaming
-func encryptLargeFile(inputFile, outputFile *os.File, cipher *crypto.AESCipher, iv [16]byte, fileSize int64, progressCallback ProgressCallback) error {
-	const chunkSize = 64 * 1024 // 64KB chunks
-	
-	// For now, fallback to small file method
-	// TODO: Implement proper streaming encryption in future sprints
-	return encryptSmallFile(inputFile, outputFile, cipher, iv, fileSize, progressCallback)
-}
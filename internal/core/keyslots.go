@@ -0,0 +1,461 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// Errors for key slot management
+var (
+	ErrNoKeySlots   = errors.New("file has no key slots (run 'filevault upgrade')")
+	ErrLastKeySlot  = errors.New("cannot remove the last key slot")
+	ErrNoSuchSlot   = errors.New("no such key slot")
+	ErrTooManySlots = fmt.Errorf("file already has %d key slots", fileops.MaxKeySlots)
+)
+
+// KeySlotInfo describes a key slot without unlocking it. Share slots of
+// threshold files also give the share number and how many are needed.
+type KeySlotInfo struct {
+	Index     int
+	Type      string
+	Factors   fileops.Factors
+	KDF       string
+	Share     int
+	Threshold int
+}
+
+// keySlotAAD returns the data authenticated with a wrapped key: the header,
+// which binds the slot to this file, and the slot's own parameters
+func keySlotAAD(headerBytes []byte, slot *fileops.PasswordSlot) []byte {
+	aad := make([]byte, 0, len(headerBytes)+len(slot.Params()))
+	aad = append(aad, headerBytes...)
+	return append(aad, slot.Params()...)
+}
+
+// passwordSlotKEK derives the key-encryption key of slot from creds
+func passwordSlotKEK(slot *fileops.PasswordSlot, creds Credentials) ([]byte, error) {
+	secret, err := creds.secret(slot.Factors)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := slot.KDF.DeriveKey(secret, slot.Salt[:])
+	if err != nil {
+		return nil, fmt.Errorf("key derivation failed: %w", err)
+	}
+	return kek, nil
+}
+
+// newPasswordSlot wraps the file key in a new slot protected by creds,
+// deriving the key-encryption key with kdf and a fresh salt
+func newPasswordSlot(creds Credentials, kdf crypto.KDFParams, key, headerBytes []byte) (fileops.KeySlot, error) {
+	slot := &fileops.PasswordSlot{Factors: creds.Factors(), KDF: kdf}
+	if slot.Factors == 0 {
+		return fileops.KeySlot{}, ErrNoCredentials
+	}
+
+	salt, err := crypto.GenerateSalt32()
+	if err != nil {
+		return fileops.KeySlot{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	slot.Salt = salt
+
+	kek, err := passwordSlotKEK(slot, creds)
+	if err != nil {
+		return fileops.KeySlot{}, err
+	}
+	defer crypto.SecureZero(kek)
+
+	slot.WrappedKey, err = crypto.WrapKey(kek, key, keySlotAAD(headerBytes, slot))
+	if err != nil {
+		return fileops.KeySlot{}, err
+	}
+	return slot.KeySlot(), nil
+}
+
+// unlockRecipientSlot tries the identities on a recipient slot
+func unlockRecipientSlot(slot fileops.KeySlot, headerBytes []byte, identities []crypto.Identity) ([]byte, error) {
+	for _, identity := range identities {
+		key, err := identity.Unwrap(slot.Type, slot.Data, headerBytes)
+		if err == nil {
+			return key, nil
+		}
+		if !errors.Is(err, crypto.ErrIdentityMismatch) {
+			return nil, err
+		}
+	}
+	return nil, crypto.ErrIdentityMismatch
+}
+
+// unlockKeySlots tries every slot whose factors creds can provide and
+// returns the file key with the index of the slot that opened it. The key
+// of a threshold file is combined from several slots and the index is -1.
+func unlockKeySlots(area *fileops.KeySlotArea, headerBytes []byte, creds Credentials) ([]byte, int, error) {
+	if threshold := areaThreshold(area); threshold > 0 {
+		key, err := unlockThreshold(area, headerBytes, creds, threshold)
+		return key, -1, err
+	}
+
+	tried, keyfileSlots, recipientSlots := 0, 0, 0
+	for i, s := range area.Slots {
+		if fileops.IsRecipientSlot(s.Type) {
+			recipientSlots++
+			if !creds.UsesIdentities() {
+				continue
+			}
+			tried++
+			key, err := unlockRecipientSlot(s, headerBytes, creds.Identities)
+			if err == nil {
+				return key, i, nil
+			}
+			if !errors.Is(err, crypto.ErrIdentityMismatch) {
+				return nil, -1, err
+			}
+			continue
+		}
+		if s.Type != fileops.KeySlotPassword {
+			continue
+		}
+		slot, err := fileops.ParsePasswordSlot(s)
+		if err != nil {
+			continue
+		}
+		if slot.Factors.RequiresKeyfile() {
+			keyfileSlots++
+		}
+		if creds.checkFactors(slot.Factors) != nil || (slot.Factors.RequiresPassword() && creds.Password == "") {
+			continue
+		}
+
+		tried++
+		kek, err := passwordSlotKEK(slot, creds)
+		if err != nil {
+			return nil, -1, err
+		}
+		key, err := crypto.UnwrapKey(kek, slot.WrappedKey, keySlotAAD(headerBytes, slot))
+		crypto.SecureZero(kek)
+		if err == nil {
+			return key, i, nil
+		}
+	}
+
+	switch {
+	case tried == 0 && recipientSlots == len(area.Slots) && !creds.UsesIdentities():
+		return nil, -1, ErrIdentityRequired
+	case tried == 0 && keyfileSlots > 0 && !creds.UsesKeyfiles():
+		return nil, -1, ErrKeyfileRequired
+	case tried == 0 && keyfileSlots == 0 && creds.UsesKeyfiles():
+		return nil, -1, ErrKeyfileNotUsed
+	}
+	return nil, -1, fmt.Errorf("decryption failed (wrong password, identity or corrupted file): %w: no key slot matches", crypto.ErrDecryptionFailed)
+}
+
+// newRecipientSlot wraps the file key for recipient
+func newRecipientSlot(recipient crypto.Recipient, key, headerBytes []byte) (fileops.KeySlot, error) {
+	body, err := recipient.Wrap(key, headerBytes)
+	if err != nil {
+		return fileops.KeySlot{}, fmt.Errorf("failed to wrap key for %s: %w", recipient, err)
+	}
+	return fileops.KeySlot{Type: recipient.SlotType(), Data: body}, nil
+}
+
+// newKeySlotArea creates the key slot area of a new file: a slot for creds
+// if they hold a password or keyfiles, then one slot per recipient
+func newKeySlotArea(creds Credentials, recipients []crypto.Recipient, kdf crypto.KDFParams, key, headerBytes []byte) (*fileops.KeySlotArea, error) {
+	if creds.Factors() == 0 && len(recipients) == 0 {
+		return nil, ErrNoCredentials
+	}
+	if len(recipients) >= fileops.MaxKeySlots {
+		return nil, ErrTooManySlots
+	}
+
+	area := &fileops.KeySlotArea{Capacity: fileops.DefaultKeySlotCapacity}
+	if creds.Factors() != 0 {
+		slot, err := newPasswordSlot(creds, kdf, key, headerBytes)
+		if err != nil {
+			return nil, err
+		}
+		area.Slots = append(area.Slots, slot)
+	}
+	for _, recipient := range recipients {
+		slot, err := newRecipientSlot(recipient, key, headerBytes)
+		if err != nil {
+			return nil, err
+		}
+		area.Slots = append(area.Slots, slot)
+	}
+
+	area.Grow()
+	return area, nil
+}
+
+// ListKeySlots describes the key slots of an encrypted file. No credentials
+// are needed; the slots only reveal which factors unlock the file.
+func ListKeySlots(path string) ([]KeySlotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return nil, err
+	}
+	if !header.HasKeySlots() {
+		return nil, ErrNoKeySlots
+	}
+
+	area, err := fileops.ReadKeySlotArea(file)
+	if err != nil {
+		return nil, err
+	}
+	return describeSlots(area), nil
+}
+
+// describeSlots describes each slot in area
+func describeSlots(area *fileops.KeySlotArea) []KeySlotInfo {
+	infos := make([]KeySlotInfo, 0, len(area.Slots))
+	for i, s := range area.Slots {
+		info := KeySlotInfo{Index: i, Type: fileops.KeySlotTypeName(s.Type)}
+		if share, err := fileops.ParseShareSlot(s); err == nil {
+			info.Share, info.Threshold = int(share.Index), int(share.Threshold)
+			info.Type = fileops.KeySlotTypeName(share.Inner.Type)
+			s = share.Inner
+		}
+		if slot, err := fileops.ParsePasswordSlot(s); err == nil {
+			info.Factors = slot.Factors
+			info.KDF = slot.KDF.String()
+		} else if fileops.IsRecipientSlot(s.Type) {
+			info.Factors = fileops.FactorIdentity
+			info.KDF = info.Type + " public key"
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// UnlockFactors returns the factor sets that can unlock a file: one per
+// key slot, or the single set recorded in the header of older files
+func UnlockFactors(path string) ([]fileops.Factors, error) {
+	header, err := ReadHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	if !header.HasKeySlots() {
+		return []fileops.Factors{header.Factors()}, nil
+	}
+
+	slots, err := ListKeySlots(path)
+	if err != nil {
+		return nil, err
+	}
+	return slotFactors(slots), nil
+}
+
+// slotFactors returns the factor sets of slots, skipping unknown types
+func slotFactors(slots []KeySlotInfo) []fileops.Factors {
+	factors := make([]fileops.Factors, 0, len(slots))
+	for _, slot := range slots {
+		if slot.Factors != 0 {
+			factors = append(factors, slot.Factors)
+		}
+	}
+	return factors
+}
+
+// AddKeySlot unlocks the file at path with creds and adds a key slot for
+// newCreds, using the file's key derivation settings. Only the key slot
+// area is rewritten; it returns the index of the new slot.
+func AddKeySlot(path string, creds, newCreds Credentials) (int, error) {
+	index := -1
+	err := updateKeySlots(path, creds, func(area *fileops.KeySlotArea, header *fileops.FileHeader, headerBytes, key []byte, _ int) error {
+		if len(area.Slots) >= fileops.MaxKeySlots {
+			return ErrTooManySlots
+		}
+		slot, err := newPasswordSlot(newCreds, header.KDFParams(), key, headerBytes)
+		if err != nil {
+			return err
+		}
+		area.Slots = append(area.Slots, slot)
+		index = len(area.Slots) - 1
+		return nil
+	})
+	return index, err
+}
+
+// ChangeKeySlot replaces the key slot that creds unlock with one for
+// newCreds, keeping its key derivation settings, and returns its index
+func ChangeKeySlot(path string, creds, newCreds Credentials) (int, error) {
+	index := -1
+	err := updateKeySlots(path, creds, func(area *fileops.KeySlotArea, _ *fileops.FileHeader, headerBytes, key []byte, unlocked int) error {
+		if fileops.IsRecipientSlot(area.Slots[unlocked].Type) {
+			return fmt.Errorf("key slot %d belongs to a recipient; add a new slot and remove it instead", unlocked)
+		}
+		old, err := fileops.ParsePasswordSlot(area.Slots[unlocked])
+		if err != nil {
+			return err
+		}
+		slot, err := newPasswordSlot(newCreds, old.KDF, key, headerBytes)
+		if err != nil {
+			return err
+		}
+		area.Slots[unlocked] = slot
+		index = unlocked
+		return nil
+	})
+	return index, err
+}
+
+// RemoveKeySlot unlocks the file with creds and removes key slot index, or
+// the slot creds unlock when index is negative. The last slot cannot be
+// removed. It returns the index of the removed slot.
+func RemoveKeySlot(path string, creds Credentials, index int) (int, error) {
+	removed := -1
+	err := updateKeySlots(path, creds, func(area *fileops.KeySlotArea, _ *fileops.FileHeader, _, _ []byte, unlocked int) error {
+		if index < 0 {
+			index = unlocked
+		}
+		if index >= len(area.Slots) {
+			return fmt.Errorf("%w: %d", ErrNoSuchSlot, index)
+		}
+		if len(area.Slots) == 1 {
+			return ErrLastKeySlot
+		}
+		area.Slots = append(area.Slots[:index], area.Slots[index+1:]...)
+		removed = index
+		return nil
+	})
+	return removed, err
+}
+
+// keySlotEdit changes the key slots of an unlocked file. key is the file
+// key and unlocked the index of the slot that opened it.
+type keySlotEdit func(area *fileops.KeySlotArea, header *fileops.FileHeader, headerBytes, key []byte, unlocked int) error
+
+// updateKeySlots unlocks the file at path, applies edit and writes the key
+// slot area back. The payload is never decrypted or re-encrypted, but the
+// area holds the only copy of the wrapped file key, so it is never
+// overwritten in place: the file is copied with the new area, grown if
+// the slots no longer fit, and atomically replaces the original.
+func updateKeySlots(path string, creds Credentials, edit keySlotEdit) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return err
+	}
+	if !header.HasKeySlots() {
+		return ErrNoKeySlots
+	}
+
+	area, err := fileops.ReadKeySlotArea(file)
+	if err != nil {
+		return err
+	}
+	if areaThreshold(area) > 0 {
+		return ErrThresholdSlots
+	}
+	oldSize := area.Size()
+
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	key, unlocked, err := unlockKeySlots(area, headerBytes, creds)
+	if err != nil {
+		return err
+	}
+	defer crypto.SecureZero(key)
+
+	if err := edit(area, header, headerBytes, key, unlocked); err != nil {
+		return err
+	}
+
+	// The recovery record covers the key slots, so it is rebuilt after them
+	// with the same redundancy
+	recovery, err := intactRecoveryFooter(file, header)
+	if err != nil {
+		return err
+	}
+
+	if !area.Fits() {
+		area.Grow()
+	}
+	return rewriteWithKeySlots(path, file, headerBytes, area, int64(len(headerBytes))+oldSize, recovery)
+}
+
+// rewriteWithKeySlots copies the file with a new key slot area. The
+// rest of the file, from restAt on, is copied verbatim, except for a
+// recovery record, which is computed anew for the copy.
+func rewriteWithKeySlots(path string, file *os.File, headerBytes []byte, area *fileops.KeySlotArea, restAt int64, recovery *fileops.RecoveryFooter) error {
+	areaBytes, err := area.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get input file info: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".keyslots-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if tempFile != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	if _, err := tempFile.Write(headerBytes); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err := tempFile.Write(areaBytes); err != nil {
+		return fmt.Errorf("failed to write key slots: %w", err)
+	}
+	end := info.Size()
+	if recovery != nil {
+		end = recovery.ProtectedSize
+	}
+	copied, err := io.Copy(tempFile, io.NewSectionReader(file, restAt, end-restAt))
+	if err != nil {
+		return fmt.Errorf("failed to copy payload: %w", err)
+	}
+	if recovery != nil {
+		protected := int64(len(headerBytes)+len(areaBytes)) + copied
+		if err := writeRecoveryRecord(tempFile, protected, int(recovery.Percent)); err != nil {
+			return err
+		}
+	}
+
+	if err := tempFile.Chmod(info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	file.Close()
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to replace original file: %w", err)
+	}
+	tempFile = nil
+	return nil
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// metadataLengthSize is the size of the length prefix of the metadata block
+const metadataLengthSize = 4
+
+// writeMetadataBlock encrypts meta and writes it right after the header as a
+// little-endian length followed by the sealed block. The header bytes are
+// authenticated with it, like with every segment.
+func writeMetadataBlock(dst io.Writer, meta *fileops.Metadata, aead crypto.AEAD, baseNonce, headerBytes []byte) error {
+	plaintext, err := meta.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+	defer crypto.SecureZero(plaintext)
+	if len(plaintext) > fileops.MaxMetadataSize {
+		return fmt.Errorf("metadata too large: %d bytes (maximum %d)", len(plaintext), fileops.MaxMetadataSize)
+	}
+
+	sealed := aead.Seal(nil, crypto.MetadataNonce(baseNonce), plaintext, headerBytes)
+
+	var length [metadataLengthSize]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := dst.Write(length[:]); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if _, err := dst.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// readMetadata returns the file metadata and the number of bytes it occupies
+// after the header. r must be positioned just after the header. Files without
+// an encrypted metadata block take their metadata from the header and
+// consume nothing.
+func readMetadata(r io.Reader, header *fileops.FileHeader, aead crypto.AEAD) (*fileops.Metadata, int64, error) {
+	if !header.HasFlag(fileops.FlagEncryptedMetadata) {
+		return &fileops.Metadata{FileName: header.FileName}, 0, nil
+	}
+
+	var length [metadataLengthSize]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, 0, fmt.Errorf("failed to read metadata: %w", ErrTruncated)
+	}
+
+	size := binary.LittleEndian.Uint32(length[:])
+	if size < uint32(aead.Overhead()) || size > fileops.MaxMetadataSize+uint32(aead.Overhead()) {
+		return nil, 0, fmt.Errorf("invalid metadata block size: %d", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, 0, fmt.Errorf("failed to read metadata: %w", ErrTruncated)
+	}
+
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	plaintext, err := aead.Open(nil, crypto.MetadataNonce(header.BaseNonce(aead.NonceSize())), sealed, headerBytes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decryption failed (wrong password or corrupted file): %w: metadata: %v", crypto.ErrDecryptionFailed, err)
+	}
+	defer crypto.SecureZero(plaintext)
+
+	var meta fileops.Metadata
+	if err := meta.UnmarshalBinary(plaintext); err != nil {
+		return nil, 0, err
+	}
+
+	return &meta, int64(metadataLengthSize) + int64(size), nil
+}
+
+// metadataBlockSize returns the number of bytes the encrypted metadata block
+// occupies after the header, read from its cleartext length prefix without
+// decrypting it. r must be positioned just after the header.
+func metadataBlockSize(r io.Reader, header *fileops.FileHeader) (int64, error) {
+	if !header.HasFlag(fileops.FlagEncryptedMetadata) {
+		return 0, nil
+	}
+
+	var length [metadataLengthSize]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return 0, fmt.Errorf("failed to read metadata: %w", ErrTruncated)
+	}
+	return int64(metadataLengthSize) + int64(binary.LittleEndian.Uint32(length[:])), nil
+}
+
+// ReadMetadata decrypts the metadata of an encrypted file without touching
+// its payload. Files that keep their metadata in the header are read
+// without checking the credentials.
+func ReadMetadata(path string, creds Credentials) (*fileops.Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return nil, err
+	}
+	if !header.HasFlag(fileops.FlagEncryptedMetadata) {
+		return &fileops.Metadata{FileName: header.FileName}, nil
+	}
+
+	aead, _, err := openFileAEAD(file, header, creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock file: %w", err)
+	}
+
+	meta, _, err := readMetadata(file, header, aead)
+	return meta, err
+}
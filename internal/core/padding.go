@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// plaintextSize returns the size of the original file. The header of a
+// padded file records the padded size; the real one is in the metadata.
+// It is -1 for streamed files, whose size only the payload tells.
+func plaintextSize(header *fileops.FileHeader, meta *fileops.Metadata) int64 {
+	if header.HasFlag(fileops.FlagStreamed) {
+		return -1
+	}
+	if header.HasFlag(fileops.FlagPadded) {
+		return int64(meta.Size)
+	}
+	return int64(header.OriginalSize)
+}
+
+// zeroReader returns an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// unpaddingReader returns the real bytes of a padded payload. At their end
+// it reads the padding as well, so that every segment is authenticated
+// before io.EOF, and checks that it is as long as the header promised and
+// all zeros.
+type unpaddingReader struct {
+	io.ReadCloser
+	remaining int64 // real bytes not yet returned
+	padding   int64 // padding bytes that must follow
+	err       error // result of reading the padding
+	drained   bool
+}
+
+// newUnpaddingReader strips the padding from payload if the file is padded
+func newUnpaddingReader(payload io.ReadCloser, header *fileops.FileHeader, meta *fileops.Metadata) (io.ReadCloser, error) {
+	if !header.HasFlag(fileops.FlagPadded) {
+		return payload, nil
+	}
+	if meta.Size > header.OriginalSize {
+		payload.Close()
+		return nil, fmt.Errorf("invalid padding: real size %d exceeds padded size %d", meta.Size, header.OriginalSize)
+	}
+	return &unpaddingReader{
+		ReadCloser: payload,
+		remaining:  int64(meta.Size),
+		padding:    int64(header.OriginalSize - meta.Size),
+	}, nil
+}
+
+func (u *unpaddingReader) Read(p []byte) (int, error) {
+	if u.remaining == 0 {
+		if !u.drained {
+			u.err = u.drain()
+			u.drained = true
+		}
+		if u.err != nil {
+			return 0, u.err
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > u.remaining {
+		p = p[:u.remaining]
+	}
+	n, err := u.ReadCloser.Read(p)
+	u.remaining -= int64(n)
+	if err == io.EOF {
+		if u.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// drain reads and checks the padding after the real bytes
+func (u *unpaddingReader) drain() error {
+	buf := make([]byte, 32*1024)
+	var read int64
+	for {
+		n, err := u.ReadCloser.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return fmt.Errorf("invalid padding: non-zero byte")
+			}
+		}
+		read += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if read != u.padding {
+		return fmt.Errorf("invalid padding: expected %d bytes, got %d", u.padding, read)
+	}
+	return nil
+}
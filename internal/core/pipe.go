@@ -0,0 +1,189 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// ErrPipeUnsupported is returned when reading from a pipe a file that can
+// only be decrypted from a file: v1 files and files with a recovery record
+var ErrPipeUnsupported = errors.New("file cannot be decrypted from a pipe")
+
+// EncryptedStream is an encrypted file read sequentially, e.g. from stdin.
+// Its header and key slots are read first, so that the factors it needs
+// are known before any credentials are asked for; the payload is then
+// decrypted as it arrives and never buffered as a whole.
+type EncryptedStream struct {
+	src    io.Reader
+	header *fileops.FileHeader
+	area   *fileops.KeySlotArea // nil for files without key slots
+	read   bool
+}
+
+// ReadEncryptedStream reads the header and key slots of an encrypted file
+// from src. ASCII armor is recognized and decoded as it is read.
+func ReadEncryptedStream(src io.Reader) (*EncryptedStream, error) {
+	buffered := bufio.NewReaderSize(src, max(armorSniffSize, fileops.LargeFileBuffer))
+	src = buffered
+	if start, _ := buffered.Peek(armorSniffSize); fileops.LooksArmored(start) {
+		src = fileops.NewArmorReader(buffered)
+	}
+
+	header, err := readFileHeader(src, "")
+	if err != nil {
+		return nil, err
+	}
+	if !header.IsStreaming() {
+		return nil, fmt.Errorf("%w: format v1 (run 'filevault upgrade')", ErrPipeUnsupported)
+	}
+	if header.HasFlag(fileops.FlagRecovery) {
+		// The record's size is only known from its footer at the very end
+		return nil, fmt.Errorf("%w: it has a recovery record (decrypt the file itself)", ErrPipeUnsupported)
+	}
+
+	stream := &EncryptedStream{src: src, header: header}
+	if header.HasKeySlots() {
+		if stream.area, err = fileops.ReadKeySlotArea(src); err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+// Header returns the header of the file
+func (s *EncryptedStream) Header() *fileops.FileHeader {
+	return s.header
+}
+
+// Factors returns the factor sets that can unlock the file, like
+// UnlockFactors
+func (s *EncryptedStream) Factors() []fileops.Factors {
+	if s.area == nil {
+		return []fileops.Factors{s.header.Factors()}
+	}
+	return slotFactors(describeSlots(s.area))
+}
+
+// ThresholdInfo returns how the key of a threshold file is split, like
+// ReadThreshold, or ErrNotThreshold
+func (s *EncryptedStream) ThresholdInfo() (*ThresholdInfo, error) {
+	if s.area == nil || areaThreshold(s.area) == 0 {
+		return nil, ErrNotThreshold
+	}
+	headerBytes, err := s.header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+	return &ThresholdInfo{Threshold: areaThreshold(s.area), Shares: len(s.area.Slots), FileID: shareFileID(headerBytes)}, nil
+}
+
+// UnlockShares returns the shares of a threshold file that creds open,
+// like UnlockShares, so that several holders can be asked in turn
+func (s *EncryptedStream) UnlockShares(creds Credentials) ([]*crypto.Share, error) {
+	if s.area == nil || areaThreshold(s.area) == 0 {
+		return nil, ErrNotThreshold
+	}
+	headerBytes, err := s.header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+	return openShares(s.area, headerBytes, creds)
+}
+
+// DecryptTo decrypts the payload with creds and writes it to dst. Each
+// segment is authenticated before its plaintext is written, but dst may
+// have received the start of the plaintext when a later segment fails.
+// It can only be called once.
+func (s *EncryptedStream) DecryptTo(dst io.Writer, creds Credentials, opts DecryptOptions) error {
+	if s.read {
+		return fmt.Errorf("encrypted stream already read")
+	}
+	s.read = true
+
+	var aead crypto.AEAD
+	var err error
+	if s.area != nil {
+		aead, err = openSlotAEAD(s.area, s.header, creds)
+	} else {
+		aead, _, err = openFileAEAD(s.src, s.header, creds)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unlock file: %w", err)
+	}
+
+	meta, _, err := readMetadata(s.src, s.header, aead)
+	if err != nil {
+		return err
+	}
+
+	// The segments end where the signature trailer begins
+	source := s.src
+	if s.header.HasFlag(fileops.FlagSigned) {
+		source = newTailReader(source, fileops.SignatureTrailerSize)
+	}
+	payload, err := newPayloadReader(source, s.header, meta, aead, opts.Threads)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+
+	writer := bufio.NewWriterSize(dst, fileops.LargeFileBuffer)
+	written, err := io.Copy(writer, payload)
+	if err != nil {
+		writer.Flush()
+		return fmt.Errorf("decryption failed (wrong password or corrupted file): %w", err)
+	}
+	if expected := plaintextSize(s.header, meta); expected >= 0 && written != expected {
+		return fmt.Errorf("decrypted size mismatch: expected %d, got %d", expected, written)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write decrypted data: %w", err)
+	}
+	return nil
+}
+
+// DecryptStream decrypts the encrypted file read from src, e.g. stdin, and
+// writes the plaintext to dst, as EncryptedStream.DecryptTo does
+func DecryptStream(dst io.Writer, src io.Reader, creds Credentials, opts DecryptOptions) error {
+	stream, err := ReadEncryptedStream(src)
+	if err != nil {
+		return err
+	}
+	return stream.DecryptTo(dst, creds, opts)
+}
+
+// tailReader reads all but the last n bytes of r, holding them back until
+// r ends, so that a trailer is not taken for data
+type tailReader struct {
+	r   io.Reader
+	n   int
+	buf []byte
+	err error
+}
+
+func newTailReader(r io.Reader, n int) *tailReader {
+	return &tailReader{r: r, n: n, buf: make([]byte, 0, n+fileops.LargeFileBuffer)}
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	for len(t.buf) <= t.n && t.err == nil {
+		var m int
+		m, t.err = t.r.Read(t.buf[len(t.buf):cap(t.buf)])
+		t.buf = t.buf[:len(t.buf)+m]
+	}
+	if len(t.buf) <= t.n {
+		if t.err == io.EOF && len(t.buf) < t.n {
+			return 0, ErrTruncated
+		}
+		return 0, t.err
+	}
+
+	k := copy(p, t.buf[:len(t.buf)-t.n])
+	t.buf = t.buf[:copy(t.buf, t.buf[k:])]
+	return k, nil
+}
@@ -0,0 +1,369 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// MaxThreads caps the number of segment workers
+const MaxThreads = 256
+
+// queueDepthPerThread is how many segments each worker may have in flight.
+// Two keeps every worker busy while the writer drains the previous result.
+const queueDepthPerThread = 2
+
+// resolveThreads maps a requested thread count to the number of workers;
+// zero or less selects one per available CPU
+func resolveThreads(threads int) int {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	if threads > MaxThreads {
+		threads = MaxThreads
+	}
+	return threads
+}
+
+// newSegmentEncrypter returns a writer that seals segments on threads
+// workers; with a single thread segments are sealed inline
+func newSegmentEncrypter(w io.Writer, aead crypto.AEAD, baseNonce, aad []byte, segmentSize, threads int) io.WriteCloser {
+	if threads = resolveThreads(threads); threads == 1 {
+		return newSegmentWriter(w, aead, baseNonce, aad, segmentSize)
+	}
+	return newParallelSegmentWriter(w, aead, baseNonce, aad, segmentSize, threads)
+}
+
+// newSegmentDecrypter returns a reader that opens segments on threads
+// workers; with a single thread segments are opened inline
+func newSegmentDecrypter(r io.Reader, aead crypto.AEAD, baseNonce, aad []byte, segmentSize, threads int) io.ReadCloser {
+	if threads = resolveThreads(threads); threads == 1 {
+		return io.NopCloser(newSegmentReader(r, aead, baseNonce, aad, segmentSize))
+	}
+	return newParallelSegmentReader(r, aead, baseNonce, aad, segmentSize, threads)
+}
+
+// segmentJob carries one segment through the pipeline. Jobs are allocated
+// once per pipeline and recycled, so memory stays bounded by the queue depth.
+type segmentJob struct {
+	index uint64
+	final bool
+	in    []byte
+	out   []byte
+	err   error
+	done  chan struct{}
+}
+
+// segmentPipeline is the worker pool shared by the parallel reader and
+// writer. Jobs are handed to workers in any order and collected from
+// pending in the order they were submitted.
+type segmentPipeline struct {
+	free    chan *segmentJob
+	work    chan *segmentJob
+	pending chan *segmentJob
+	workers sync.WaitGroup
+}
+
+func newSegmentPipeline(threads, inSize, outSize int, process func(*segmentJob)) *segmentPipeline {
+	depth := threads * queueDepthPerThread
+	p := &segmentPipeline{
+		free:    make(chan *segmentJob, depth),
+		work:    make(chan *segmentJob, depth),
+		pending: make(chan *segmentJob, depth),
+	}
+
+	for i := 0; i < depth; i++ {
+		p.free <- &segmentJob{
+			in:   make([]byte, 0, inSize),
+			out:  make([]byte, 0, outSize),
+			done: make(chan struct{}, 1),
+		}
+	}
+
+	p.workers.Add(threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			defer p.workers.Done()
+			for job := range p.work {
+				if job.err == nil {
+					process(job)
+				}
+				job.done <- struct{}{}
+			}
+		}()
+	}
+
+	return p
+}
+
+// submit queues job for processing; results are collected in submit order
+func (p *segmentPipeline) submit(job *segmentJob) {
+	p.pending <- job
+	p.work <- job
+}
+
+// release wipes a finished job and returns it to the free list
+func (p *segmentPipeline) release(job *segmentJob) {
+	crypto.SecureZero(job.in[:cap(job.in)])
+	crypto.SecureZero(job.out[:cap(job.out)])
+	job.in = job.in[:0]
+	job.out = job.out[:0]
+	job.err = nil
+	p.free <- job
+}
+
+// stop shuts down the workers once no more jobs will be submitted
+func (p *segmentPipeline) stop() {
+	close(p.work)
+	close(p.pending)
+	p.workers.Wait()
+}
+
+// parallelSegmentWriter produces the same output as segmentWriter but seals
+// segments on several workers. A background goroutine writes the sealed
+// segments in order, so reading, sealing and writing overlap.
+type parallelSegmentWriter struct {
+	w         io.Writer
+	pipeline  *segmentPipeline
+	current   *segmentJob
+	index     uint64
+	closed    bool
+	writeDone chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// newParallelSegmentWriter creates a segment writer backed by threads workers
+func newParallelSegmentWriter(w io.Writer, aead crypto.AEAD, baseNonce, aad []byte, segmentSize, threads int) *parallelSegmentWriter {
+	seal := func(job *segmentJob) {
+		nonce := crypto.SegmentNonce(baseNonce, job.index, job.final)
+		job.out = aead.Seal(job.out[:0], nonce, job.in, aad)
+	}
+
+	sw := &parallelSegmentWriter{
+		w:         w,
+		pipeline:  newSegmentPipeline(threads, segmentSize, segmentSize+aead.Overhead(), seal),
+		writeDone: make(chan struct{}),
+	}
+	sw.current = <-sw.pipeline.free
+
+	go sw.writeLoop()
+	return sw
+}
+
+// writeLoop writes sealed segments in order. After a failed write it keeps
+// draining so that Write and Close never block on a full queue.
+func (sw *parallelSegmentWriter) writeLoop() {
+	defer close(sw.writeDone)
+
+	for job := range sw.pipeline.pending {
+		<-job.done
+		if sw.failed() == nil {
+			if _, err := sw.w.Write(job.out); err != nil {
+				sw.fail(fmt.Errorf("failed to write segment %d: %w", job.index, err))
+			}
+		}
+		sw.pipeline.release(job)
+	}
+}
+
+func (sw *parallelSegmentWriter) fail(err error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.err == nil {
+		sw.err = err
+	}
+}
+
+func (sw *parallelSegmentWriter) failed() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.err
+}
+
+// Write buffers plaintext and dispatches every segment that is known not to be the last
+func (sw *parallelSegmentWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, fmt.Errorf("write to closed segment writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if err := sw.failed(); err != nil {
+			return written, err
+		}
+
+		job := sw.current
+		if len(job.in) == cap(job.in) {
+			sw.dispatch(false)
+			job = sw.current
+		}
+
+		n := copy(job.in[len(job.in):cap(job.in)], p)
+		job.in = job.in[:len(job.in)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// dispatch hands the buffered segment to the workers and takes a free buffer
+func (sw *parallelSegmentWriter) dispatch(final bool) {
+	job := sw.current
+	job.index = sw.index
+	job.final = final
+	sw.index++
+	sw.pipeline.submit(job)
+
+	if !final {
+		sw.current = <-sw.pipeline.free
+	}
+}
+
+// Close seals the buffered data as the final segment and waits until every
+// segment has been written
+func (sw *parallelSegmentWriter) Close() error {
+	if sw.closed {
+		return sw.failed()
+	}
+	sw.closed = true
+
+	sw.dispatch(true)
+	sw.pipeline.stop()
+	<-sw.writeDone
+	return sw.failed()
+}
+
+// parallelSegmentReader returns the same plaintext as segmentReader but opens
+// segments on several workers. A background goroutine reads ahead, bounded by
+// the queue depth; segments are still returned strictly in order and each
+// one only after it authenticated.
+type parallelSegmentReader struct {
+	r        *bufio.Reader
+	pipeline *segmentPipeline
+	current  *segmentJob
+	plain    []byte
+	err      error
+	quit     chan struct{}
+	readDone chan struct{}
+}
+
+// newParallelSegmentReader creates a segment reader backed by threads workers
+func newParallelSegmentReader(r io.Reader, aead crypto.AEAD, baseNonce, aad []byte, segmentSize, threads int) *parallelSegmentReader {
+	open := func(job *segmentJob) {
+		nonce := crypto.SegmentNonce(baseNonce, job.index, job.final)
+		plaintext, err := aead.Open(job.out[:0], nonce, job.in, aad)
+		if err != nil {
+			job.err = segmentError(job.index, job.final, err)
+			return
+		}
+		job.out = plaintext
+	}
+
+	sr := &parallelSegmentReader{
+		r:        bufio.NewReaderSize(r, segmentSize+fileops.AuthTagSize),
+		pipeline: newSegmentPipeline(threads, segmentSize+fileops.AuthTagSize, segmentSize, open),
+		quit:     make(chan struct{}),
+		readDone: make(chan struct{}),
+	}
+
+	go sr.readLoop()
+	return sr
+}
+
+// readLoop reads sealed segments and queues them until the final segment,
+// an error, or Close
+func (sr *parallelSegmentReader) readLoop() {
+	defer close(sr.readDone)
+	defer sr.pipeline.stop()
+
+	for index := uint64(0); ; index++ {
+		var job *segmentJob
+		select {
+		case job = <-sr.pipeline.free:
+		case <-sr.quit:
+			return
+		}
+
+		job.index = index
+		job.in = job.in[:cap(job.in)]
+		n, final, err := readSegment(sr.r, job.in, index)
+		job.in = job.in[:n]
+		job.final = final
+		job.err = err
+
+		sr.pipeline.submit(job)
+		if final || err != nil {
+			return
+		}
+	}
+}
+
+// Read returns decrypted plaintext
+func (sr *parallelSegmentReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+
+		if sr.current != nil {
+			final := sr.current.final
+			sr.pipeline.release(sr.current)
+			sr.current = nil
+			if final {
+				sr.err = io.EOF
+				continue
+			}
+		}
+
+		job, ok := <-sr.pipeline.pending
+		if !ok {
+			sr.err = io.ErrUnexpectedEOF
+			continue
+		}
+		<-job.done
+
+		sr.current = job
+		if job.err != nil {
+			sr.err = job.err
+			continue
+		}
+		sr.plain = job.out
+	}
+
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// Close stops reading ahead and releases the workers
+func (sr *parallelSegmentReader) Close() error {
+	select {
+	case <-sr.quit:
+		return nil
+	default:
+	}
+	close(sr.quit)
+
+	// Unblock the read loop if it is waiting for a free job, then drain
+	go func() {
+		for job := range sr.pipeline.pending {
+			<-job.done
+			sr.pipeline.release(job)
+		}
+	}()
+	if sr.current != nil {
+		sr.pipeline.release(sr.current)
+		sr.current = nil
+	}
+	<-sr.readDone
+	sr.plain = nil
+	return nil
+}
@@ -0,0 +1,376 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// ErrRandomAccessUnsupported is returned when opening a file whose payload
+// cannot be decrypted in parts: v1 files and compressed files
+var ErrRandomAccessUnsupported = errors.New("file does not support random access")
+
+// Reader gives random access to the plaintext of an encrypted file. Only the
+// segments covering a requested range are read and decrypted, and each one
+// is authenticated before any of its bytes are returned.
+//
+// ReadAt may be called concurrently; Read and Seek share one offset and
+// follow the usual io.ReadSeeker rules.
+type Reader struct {
+	src         io.ReaderAt
+	closer      io.Closer
+	header      *fileops.FileHeader
+	metadata    *fileops.Metadata
+	aead        crypto.AEAD
+	baseNonce   []byte
+	aad         []byte
+	payloadAt   int64
+	segmentSize int64
+	segments    int64
+	storedSize  int64 // payload bytes, including any padding
+	size        int64
+
+	mu         sync.Mutex
+	cacheIndex int64
+	cache      []byte
+	cipherBuf  []byte
+	offset     int64
+}
+
+// OpenReader opens an encrypted file for random access with creds
+func OpenReader(path string, creds Credentials) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to get input file info: %w", err)
+	}
+
+	r, err := newReader(file, info.Size(), path, creds)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.closer = file
+	return r, nil
+}
+
+// NewReader gives random access to an encrypted file of size bytes read from src
+func NewReader(src io.ReaderAt, size int64, creds Credentials) (*Reader, error) {
+	return newReader(src, size, "", creds)
+}
+
+func newReader(src io.ReaderAt, size int64, path string, creds Credentials) (*Reader, error) {
+	header, err := readFileHeader(io.NewSectionReader(src, 0, size), path)
+	if err != nil {
+		return nil, err
+	}
+	if !header.IsStreaming() {
+		return nil, fmt.Errorf("%w: format v1 (run 'filevault upgrade')", ErrRandomAccessUnsupported)
+	}
+	if header.HasFlag(fileops.FlagCompressed) {
+		return nil, fmt.Errorf("%w: payload is compressed", ErrRandomAccessUnsupported)
+	}
+
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	// Segments start after the key slots and the encrypted metadata block
+	rest := io.NewSectionReader(src, int64(len(headerBytes)), size)
+	aead, slotSize, err := openFileAEAD(rest, header, creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock file: %w", err)
+	}
+
+	meta, metaSize, err := readMetadata(rest, header, aead)
+	if err != nil {
+		return nil, err
+	}
+
+	// Padded files hold more segments than the plaintext needs
+	payloadAt := int64(len(headerBytes)) + slotSize + metaSize
+	segmentSize := int64(header.SegmentSize())
+	storedSize := int64(header.OriginalSize)
+	plainSize := plaintextSize(header, meta)
+	if header.HasFlag(fileops.FlagStreamed) {
+		if storedSize, err = streamedSize(src, size, payloadAt, header); err != nil {
+			return nil, err
+		}
+		plainSize = storedSize
+	}
+	if plainSize > storedSize {
+		return nil, fmt.Errorf("invalid padding: real size %d exceeds padded size %d", plainSize, storedSize)
+	}
+
+	// An empty file still has one (empty) final segment
+	segments := (storedSize + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+
+	if expected := payloadAt + storedSize + segments*int64(aead.Overhead()); size < expected {
+		return nil, fmt.Errorf("%w: expected %d bytes, file has %d", ErrTruncated, expected, size)
+	}
+
+	r := &Reader{
+		src:         src,
+		header:      header,
+		metadata:    meta,
+		aead:        aead,
+		baseNonce:   header.BaseNonce(aead.NonceSize()),
+		aad:         headerBytes,
+		payloadAt:   payloadAt,
+		segmentSize: segmentSize,
+		segments:    segments,
+		storedSize:  storedSize,
+		size:        plainSize,
+		cacheIndex:  -1,
+		cipherBuf:   make([]byte, segmentSize+int64(aead.Overhead())),
+	}
+
+	// Authenticate the first segment now so a wrong password fails at open
+	if _, err := r.segment(0); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// streamedSize works out the payload size of a streamed file of size bytes
+// from where its payload starts and ends
+func streamedSize(src io.ReaderAt, size, payloadAt int64, header *fileops.FileHeader) (int64, error) {
+	end := size
+	if header.HasFlag(fileops.FlagRecovery) {
+		footer, err := findRecoveryFooter(src, size, true)
+		if err != nil {
+			return 0, fmt.Errorf("invalid recovery record: %w (run 'filevault repair')", err)
+		}
+		end = footer.ProtectedSize
+	}
+	stored, err := segmentedSize(end-payloadAt-signatureSize(header), int64(header.SegmentSize()))
+	return int64(stored), err
+}
+
+// Size returns the plaintext size
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Header returns the parsed file header
+func (r *Reader) Header() *fileops.FileHeader {
+	return r.header
+}
+
+// Metadata returns the decrypted file metadata
+func (r *Reader) Metadata() *fileops.Metadata {
+	return r.metadata
+}
+
+// ReadAt reads len(p) plaintext bytes starting at off
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+
+		index := pos / r.segmentSize
+		plaintext, err := r.segment(index)
+		if err != nil {
+			return n, err
+		}
+
+		// The padding after the plaintext is never returned
+		start := index * r.segmentSize
+		end := min(int64(len(plaintext)), r.size-start)
+		n += copy(p[n:], plaintext[pos-start:end])
+	}
+
+	return n, nil
+}
+
+// Read reads plaintext from the current offset
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position: %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close wipes the cached plaintext and closes the file opened by OpenReader
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	crypto.SecureZero(r.cache[:cap(r.cache)])
+	r.cache = nil
+	r.cacheIndex = -1
+
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// segment returns the authenticated plaintext of segment index. The most
+// recently used segment is cached so sequential reads decrypt each once.
+// The caller must hold r.mu.
+func (r *Reader) segment(index int64) ([]byte, error) {
+	if index == r.cacheIndex {
+		return r.cache, nil
+	}
+
+	final := index == r.segments-1
+	length := r.segmentSize
+	if final {
+		length = r.storedSize - index*r.segmentSize
+	}
+	length += int64(r.aead.Overhead())
+
+	offset := r.payloadAt + index*(r.segmentSize+int64(r.aead.Overhead()))
+	ciphertext := r.cipherBuf[:length]
+	if _, err := r.src.ReadAt(ciphertext, offset); err != nil {
+		if err == io.EOF {
+			err = ErrTruncated
+		}
+		return nil, fmt.Errorf("failed to read segment %d: %w", index, err)
+	}
+
+	nonce := crypto.SegmentNonce(r.baseNonce, uint64(index), final)
+	plaintext, err := r.aead.Open(r.cache[:0], nonce, ciphertext, r.aad)
+	if err != nil {
+		r.cacheIndex = -1
+		return nil, fmt.Errorf("segment %d: %w: %v", index, crypto.ErrDecryptionFailed, err)
+	}
+
+	r.cache = plaintext
+	r.cacheIndex = index
+	return plaintext, nil
+}
+
+// OpenStream opens an encrypted file for sequential reading of its
+// plaintext. Unlike OpenReader it works for every format, including v1 and
+// compressed files, but the plaintext can only be read from the start.
+// The returned header gives the plaintext size (for padded files, a copy
+// with the real size); the caller must Close the stream, and should treat
+// a read error as a failed decryption.
+func OpenStream(path string, creds Credentials) (io.ReadCloser, *fileops.FileHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	stream, header, _, err := openStream(file, path, creds)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return stream, header, nil
+}
+
+// openStream is OpenStream on an open file, also returning the decrypted
+// metadata
+func openStream(file *os.File, path string, creds Credentials) (io.ReadCloser, *fileops.FileHeader, *fileops.Metadata, error) {
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	aead, _, err := openFileAEAD(file, header, creds)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to unlock file: %w", err)
+	}
+
+	meta, _, err := readMetadata(file, header, aead)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// v1 payloads are one message and must be authenticated as a whole first
+	if !header.IsStreaming() {
+		plaintext, err := readV1Payload(file, header, aead)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		file.Close()
+		return &v1Stream{Reader: bytes.NewReader(plaintext), plaintext: plaintext}, header, meta, nil
+	}
+
+	source, err := limitPayload(file, header)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	payload, err := newPayloadReader(source, header, meta, aead, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if header.HasFlag(fileops.FlagPadded) {
+		unpadded := *header
+		unpadded.OriginalSize = meta.Size
+		header = &unpadded
+	}
+	return &fileStream{ReadCloser: payload, file: file}, header, meta, nil
+}
+
+// fileStream closes the underlying file together with the payload reader
+type fileStream struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (s *fileStream) Close() error {
+	s.ReadCloser.Close()
+	return s.file.Close()
+}
+
+// v1Stream serves an in-memory v1 plaintext and wipes it on Close
+type v1Stream struct {
+	*bytes.Reader
+	plaintext []byte
+}
+
+func (s *v1Stream) Close() error {
+	crypto.SecureZero(s.plaintext)
+	return nil
+}
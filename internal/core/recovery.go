@@ -0,0 +1,513 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// ErrUnrepairable is returned when a stripe lost more blocks than it has parity
+var ErrUnrepairable = errors.New("file is too damaged to repair")
+
+// recoveryBatch is the number of stripes read and encoded together
+const recoveryBatch = 16
+
+// DamagedRange is a damaged region of an encrypted file
+type DamagedRange struct {
+	Offset int64
+	Length int64
+}
+
+// RecoveryReport describes the recovery record of a file and the damage it found
+type RecoveryReport struct {
+	Redundancy    float64        // parity as a percentage of the protected bytes
+	ProtectedSize int64          // bytes covered by the record
+	Damaged       []DamagedRange // damaged regions of the protected bytes
+	DamagedBlocks int64          // damaged data blocks
+	RecordDamaged bool           // parity, checksums or footer are damaged
+	Repairable    bool           // every damaged block can be rebuilt
+}
+
+// Intact reports whether no damage was found
+func (r *RecoveryReport) Intact() bool {
+	return r.DamagedBlocks == 0 && !r.RecordDamaged
+}
+
+// recoveryScan holds the checksums and damaged blocks of a recovery record
+type recoveryScan struct {
+	footer *fileops.RecoveryFooter
+	table  []uint32 // data blocks, then parity blocks
+	known  []bool   // table entries that can be trusted; nil if all can
+	data   []int64  // damaged data blocks, in order
+	parity []int64  // damaged parity blocks, numbered from zero
+	record bool     // the footer copy or a checksum table is damaged
+}
+
+// protectedSize returns the size of the file without its recovery record,
+// which is where the payload and any signature trailer end
+func protectedSize(file *os.File, header *fileops.FileHeader) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get input file info: %w", err)
+	}
+	if !header.HasFlag(fileops.FlagRecovery) {
+		return info.Size(), nil
+	}
+	footer, err := findRecoveryFooter(file, info.Size(), true)
+	if err != nil {
+		return 0, fmt.Errorf("invalid recovery record: %w (run 'filevault repair')", err)
+	}
+	return footer.ProtectedSize, nil
+}
+
+// intactRecoveryFooter returns the footer of the recovery record of file,
+// or nil if it has none, once every block checked out. A record rebuilt
+// over damaged blocks would make the damage permanent.
+func intactRecoveryFooter(file *os.File, header *fileops.FileHeader) (*fileops.RecoveryFooter, error) {
+	if !header.HasFlag(fileops.FlagRecovery) {
+		return nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input file info: %w", err)
+	}
+	scan, err := scanRecoveryRecord(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery record: %w", err)
+	}
+	if report := scan.report(); !report.Intact() {
+		return nil, fmt.Errorf("file is damaged; run 'filevault repair' first")
+	}
+	return scan.footer, nil
+}
+
+// findRecoveryFooter reads the footer at the end of file. If it is damaged
+// and scan is set, the copy at the start of the record is searched for.
+func findRecoveryFooter(file io.ReaderAt, size int64, scan bool) (*fileops.RecoveryFooter, error) {
+	footer, err := fileops.ReadRecoveryFooter(file, size)
+	if err == nil || !scan {
+		return footer, err
+	}
+
+	chunk := make([]byte, 1<<20)
+	for at := int64(0); at < size; at += int64(len(chunk)) - fileops.RecoveryFooterSize {
+		n, readErr := file.ReadAt(chunk, at)
+		for i := 0; ; i++ {
+			j := bytes.Index(chunk[i:n], []byte(fileops.RecoveryMagic))
+			if j < 0 {
+				break
+			}
+			i += j
+			if i+fileops.RecoveryFooterSize > n {
+				break
+			}
+			copyAt := at + int64(i)
+			candidate, err := fileops.ParseRecoveryFooter(chunk[i : i+fileops.RecoveryFooterSize])
+			if err == nil && candidate.ProtectedSize == copyAt && copyAt+candidate.RecordSize() == size {
+				return candidate, nil
+			}
+		}
+		if readErr != nil || int64(n) < int64(len(chunk)) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// writeRecoveryRecord computes a record with percent redundancy for the
+// first protected bytes of file and writes it after them, replacing any
+// record that was there
+func writeRecoveryRecord(file *os.File, protected int64, percent int) error {
+	footer, err := fileops.NewRecoveryFooter(protected, percent)
+	if err != nil {
+		return err
+	}
+	dataShards, parityShards := int(footer.DataShards), int(footer.ParityShards)
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return fmt.Errorf("failed to create Reed-Solomon encoder: %w", err)
+	}
+
+	blockSize := int64(footer.BlockSize)
+	blocks, stripes := footer.Blocks(), footer.Stripes()
+	table := make([]uint32, blocks+footer.ParityBlocks())
+
+	// Shard j of consecutive stripes is a run of consecutive blocks, so each
+	// batch reads one run per data shard
+	runs := make([][]byte, dataShards)
+	for j := range runs {
+		runs[j] = make([]byte, recoveryBatch*blockSize)
+	}
+	parity := make([]byte, recoveryBatch*int64(parityShards)*blockSize)
+	shards := make([][]byte, dataShards+parityShards)
+
+	for first := int64(0); first < stripes; first += recoveryBatch {
+		batch := min(recoveryBatch, stripes-first)
+		for j := range runs {
+			start := first + int64(j)*stripes
+			count := max(0, min(batch, blocks-start))
+			run := runs[j][:batch*blockSize]
+			clear(run)
+			if count == 0 {
+				continue
+			}
+			length := min(count*blockSize, protected-start*blockSize)
+			if _, err := file.ReadAt(run[:length], start*blockSize); err != nil {
+				return fmt.Errorf("failed to read file: %w", err)
+			}
+			for k := int64(0); k < count; k++ {
+				_, blockLength := footer.BlockAt(start + k)
+				table[start+k] = fileops.BlockChecksum(run[k*blockSize : k*blockSize+blockLength])
+			}
+		}
+
+		for s := int64(0); s < batch; s++ {
+			for j := range runs {
+				shards[j] = runs[j][s*blockSize : (s+1)*blockSize]
+			}
+			for m := 0; m < parityShards; m++ {
+				at := (s*int64(parityShards) + int64(m)) * blockSize
+				shards[dataShards+m] = parity[at : at+blockSize]
+			}
+			if err := enc.Encode(shards); err != nil {
+				return fmt.Errorf("failed to compute parity: %w", err)
+			}
+			for m := 0; m < parityShards; m++ {
+				index := (first+s)*int64(parityShards) + int64(m)
+				table[blocks+index] = fileops.BlockChecksum(shards[dataShards+m])
+			}
+		}
+
+		out := parity[:batch*int64(parityShards)*blockSize]
+		if _, err := file.WriteAt(out, footer.ParityAt()+first*int64(parityShards)*blockSize); err != nil {
+			return fmt.Errorf("failed to write recovery record: %w", err)
+		}
+	}
+
+	tableBytes := fileops.MarshalChecksumTable(table)
+	footer.TableChecksum = fileops.BlockChecksum(tableBytes)
+	footerBytes, err := footer.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for _, part := range []struct {
+		data []byte
+		at   int64
+	}{
+		{tableBytes, footer.TableAt(0)},
+		{tableBytes, footer.TableAt(1)},
+		{footerBytes, protected},
+		{footerBytes, footer.TableAt(2)},
+	} {
+		if _, err := file.WriteAt(part.data, part.at); err != nil {
+			return fmt.Errorf("failed to write recovery record: %w", err)
+		}
+	}
+	if err := file.Truncate(protected + footer.RecordSize()); err != nil {
+		return fmt.Errorf("failed to write recovery record: %w", err)
+	}
+	return nil
+}
+
+// scanRecoveryRecord checks every block of file against the record
+func scanRecoveryRecord(file *os.File, size int64) (*recoveryScan, error) {
+	footer, err := findRecoveryFooter(file, size, true)
+	if err != nil {
+		return nil, err
+	}
+	scan := &recoveryScan{footer: footer}
+
+	// The footer at the end may be the damaged one
+	footerBytes, err := footer.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	for _, at := range []int64{footer.ProtectedSize, size - fileops.RecoveryFooterSize} {
+		stored := make([]byte, len(footerBytes))
+		if _, err := file.ReadAt(stored, at); err != nil {
+			return nil, fmt.Errorf("failed to read recovery record: %w", err)
+		}
+		if !bytes.Equal(stored, footerBytes) {
+			scan.record = true
+		}
+	}
+
+	if err := scan.readTable(file); err != nil {
+		return nil, err
+	}
+
+	blockSize := int64(footer.BlockSize)
+	check := func(at, count, first int64, length func(int64) int64, damaged *[]int64) error {
+		chunk := make([]byte, 256*blockSize)
+		for done := int64(0); done < count; {
+			n := min(256, count-done)
+			end := (n-1)*blockSize + length(done+n-1)
+			if _, err := file.ReadAt(chunk[:end], at+done*blockSize); err != nil {
+				return fmt.Errorf("failed to read file: %w", err)
+			}
+			for k := int64(0); k < n; k++ {
+				index := first + done + k
+				block := chunk[k*blockSize : k*blockSize+length(done+k)]
+				if !scan.trusted(index) || fileops.BlockChecksum(block) != scan.table[index] {
+					*damaged = append(*damaged, done+k)
+				}
+			}
+			done += n
+		}
+		return nil
+	}
+	dataLength := func(i int64) int64 {
+		_, length := footer.BlockAt(i)
+		return length
+	}
+	if err := check(0, footer.Blocks(), 0, dataLength, &scan.data); err != nil {
+		return nil, err
+	}
+	fullBlock := func(int64) int64 { return blockSize }
+	if err := check(footer.ParityAt(), footer.ParityBlocks(), footer.Blocks(), fullBlock, &scan.parity); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// readTable loads the block checksums from whichever copy of the table is
+// intact. If both are damaged, only entries on which they agree are used.
+func (s *recoveryScan) readTable(file *os.File) error {
+	size := s.footer.TableSize()
+	copies := make([][]byte, 2)
+	for i := range copies {
+		copies[i] = make([]byte, size)
+		if _, err := file.ReadAt(copies[i], s.footer.TableAt(i)); err != nil {
+			return fmt.Errorf("failed to read recovery record: %w", err)
+		}
+	}
+
+	first, second := fileops.ParseChecksumTable(copies[0]), fileops.ParseChecksumTable(copies[1])
+	switch {
+	case fileops.BlockChecksum(copies[0]) == s.footer.TableChecksum:
+		s.table = first
+		s.record = s.record || !bytes.Equal(copies[0], copies[1])
+	case fileops.BlockChecksum(copies[1]) == s.footer.TableChecksum:
+		s.table = second
+		s.record = true
+	default:
+		s.table = first
+		s.known = make([]bool, len(first))
+		for i := range first {
+			s.known[i] = first[i] == second[i]
+		}
+		s.record = true
+	}
+	return nil
+}
+
+// trusted reports whether the checksum of block index can be relied on
+func (s *recoveryScan) trusted(index int64) bool {
+	return s.known == nil || s.known[index]
+}
+
+// stripeDamage groups the damaged data blocks by stripe and counts the
+// damaged parity blocks of each
+func (s *recoveryScan) stripeDamage() (map[int64][]int64, map[int64]int) {
+	stripes := s.footer.Stripes()
+	data := make(map[int64][]int64)
+	for _, index := range s.data {
+		data[index%stripes] = append(data[index%stripes], index)
+	}
+	parity := make(map[int64]int)
+	for _, index := range s.parity {
+		parity[index/int64(s.footer.ParityShards)]++
+	}
+	return data, parity
+}
+
+// report summarizes the scan
+func (s *recoveryScan) report() *RecoveryReport {
+	r := &RecoveryReport{
+		Redundancy:    s.footer.Redundancy(),
+		ProtectedSize: s.footer.ProtectedSize,
+		DamagedBlocks: int64(len(s.data)),
+		RecordDamaged: s.record || len(s.parity) > 0,
+		Repairable:    true,
+	}
+
+	data, parity := s.stripeDamage()
+	for stripe, blocks := range data {
+		if len(blocks)+parity[stripe] > int(s.footer.ParityShards) {
+			r.Repairable = false
+		}
+	}
+
+	// Neighbouring damaged blocks form one range
+	for _, index := range s.data {
+		offset, length := s.footer.BlockAt(index)
+		if n := len(r.Damaged); n > 0 && r.Damaged[n-1].Offset+r.Damaged[n-1].Length == offset {
+			r.Damaged[n-1].Length += length
+			continue
+		}
+		r.Damaged = append(r.Damaged, DamagedRange{Offset: offset, Length: length})
+	}
+	return r
+}
+
+// CheckRecovery checks a file against its recovery record without the
+// password and reports the damaged regions. It returns
+// fileops.ErrNoRecoveryRecord for files without one.
+func CheckRecovery(path string) (*RecoveryReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if !mayHaveRecoveryRecord(file, info.Size()) {
+		return nil, fileops.ErrNoRecoveryRecord
+	}
+
+	scan, err := scanRecoveryRecord(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return scan.report(), nil
+}
+
+// mayHaveRecoveryRecord reports whether a record is worth searching for:
+// there is a footer at the end, or the header says there should be one, or
+// it is too damaged to tell
+func mayHaveRecoveryRecord(file *os.File, size int64) bool {
+	if _, err := fileops.ReadRecoveryFooter(file, size); !errors.Is(err, fileops.ErrNoRecoveryRecord) {
+		return true
+	}
+	var header fileops.FileHeader
+	_, err := header.ReadFrom(io.NewSectionReader(file, 0, size))
+	if errors.Is(err, fileops.ErrHeaderCorrupted) {
+		return true
+	}
+	return err == nil && header.HasFlag(fileops.FlagRecovery)
+}
+
+// RepairFile rebuilds the damaged blocks of a file from its recovery record
+// and rewrites the record itself if it was damaged. Every rebuilt block is
+// checked against its checksum before anything is written, so a file that
+// cannot be fully repaired is left untouched. It returns the damage found.
+func RepairFile(path string) (*RecoveryReport, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	scan, err := scanRecoveryRecord(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	report := scan.report()
+	if report.Intact() {
+		return report, nil
+	}
+	if !report.Repairable {
+		return report, ErrUnrepairable
+	}
+
+	footer := scan.footer
+	dataShards, parityShards := int(footer.DataShards), int(footer.ParityShards)
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Reed-Solomon decoder: %w", err)
+	}
+
+	blockSize := int64(footer.BlockSize)
+	damagedData, _ := scan.stripeDamage()
+	damagedParity := make(map[int64]bool, len(scan.parity))
+	for _, index := range scan.parity {
+		damagedParity[index] = true
+	}
+
+	stripes := make([]int64, 0, len(damagedData))
+	for stripe := range damagedData {
+		stripes = append(stripes, stripe)
+	}
+	sort.Slice(stripes, func(i, j int) bool { return stripes[i] < stripes[j] })
+
+	repaired := make(map[int64][]byte)
+	for _, stripe := range stripes {
+		damaged := make(map[int64]bool)
+		for _, index := range damagedData[stripe] {
+			damaged[index] = true
+		}
+
+		shards := make([][]byte, dataShards+parityShards)
+		for j := 0; j < dataShards; j++ {
+			index := footer.DataBlock(stripe, int64(j))
+			if damaged[index] {
+				continue
+			}
+			shards[j] = make([]byte, blockSize)
+			if index < 0 {
+				continue
+			}
+			offset, length := footer.BlockAt(index)
+			if _, err := file.ReadAt(shards[j][:length], offset); err != nil {
+				return nil, fmt.Errorf("failed to read file: %w", err)
+			}
+		}
+		for m := 0; m < parityShards; m++ {
+			index := stripe*int64(parityShards) + int64(m)
+			if damagedParity[index] {
+				continue
+			}
+			shards[dataShards+m] = make([]byte, blockSize)
+			if _, err := file.ReadAt(shards[dataShards+m], footer.ParityAt()+index*blockSize); err != nil {
+				return nil, fmt.Errorf("failed to read recovery record: %w", err)
+			}
+		}
+
+		if err := enc.ReconstructData(shards); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnrepairable, err)
+		}
+		for j := 0; j < dataShards; j++ {
+			index := footer.DataBlock(stripe, int64(j))
+			if !damaged[index] {
+				continue
+			}
+			_, length := footer.BlockAt(index)
+			block := shards[j][:length]
+			if scan.trusted(index) && fileops.BlockChecksum(block) != scan.table[index] {
+				return nil, fmt.Errorf("%w: rebuilt block %d does not match its checksum", ErrUnrepairable, index)
+			}
+			repaired[index] = block
+		}
+	}
+
+	for index, block := range repaired {
+		offset, _ := footer.BlockAt(index)
+		if _, err := file.WriteAt(block, offset); err != nil {
+			return nil, fmt.Errorf("failed to write repaired data: %w", err)
+		}
+	}
+	if report.RecordDamaged {
+		if err := writeRecoveryRecord(file, footer.ProtectedSize, int(footer.Percent)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync file: %w", err)
+	}
+	return report, file.Close()
+}
@@ -0,0 +1,273 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// Errors for threshold files, whose key is split into Shamir shares
+var (
+	ErrMoreSharesNeeded = errors.New("more key shares are needed")
+	ErrNotThreshold     = errors.New("file key is not split into shares")
+	ErrThresholdSlots   = errors.New("the key slots of a threshold file cannot be changed")
+	ErrDuplicateHolder  = errors.New("two share holders have the same credentials")
+)
+
+// ThresholdInfo describes how the key of a threshold file is split
+type ThresholdInfo struct {
+	Threshold int // shares needed to decrypt
+	Shares    int // shares in the file, one per holder
+	FileID    [crypto.ShareFileIDSize]byte
+}
+
+// shareFileID identifies the file a share belongs to. It is taken from
+// the header, whose salt and nonce are unique to each file.
+func shareFileID(headerBytes []byte) [crypto.ShareFileIDSize]byte {
+	sum := sha256.Sum256(headerBytes)
+	var id [crypto.ShareFileIDSize]byte
+	copy(id[:], sum[:])
+	return id
+}
+
+// shareContext returns the data authenticated with a wrapped share: the
+// header and the share slot's threshold, index and inner slot type
+func shareContext(headerBytes []byte, slot *fileops.ShareSlot) []byte {
+	context := make([]byte, 0, len(headerBytes)+len(slot.Params()))
+	context = append(context, headerBytes...)
+	return append(context, slot.Params()...)
+}
+
+// CheckShareHolder returns ErrDuplicateHolder if creds would open the
+// share of any of holders: with the same factors, the same password and
+// keyfiles. One person could then unlock several shares alone.
+func CheckShareHolder(holders []Credentials, creds Credentials) error {
+	secret, err := creds.secret(creds.Factors())
+	if err != nil {
+		return err
+	}
+	for _, holder := range holders {
+		if holder.Factors() != creds.Factors() {
+			continue
+		}
+		other, err := holder.secret(holder.Factors())
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(other)) == 1 {
+			return ErrDuplicateHolder
+		}
+	}
+	return nil
+}
+
+// newShareSlotArea splits the file key so that any threshold of the
+// holders (password and keyfile credentials) and recipients can decrypt,
+// and wraps one share for each of them. Holders and recipients must all
+// be distinct, or one of them would hold several shares.
+func newShareSlotArea(holders []Credentials, recipients []crypto.Recipient, threshold int, kdf crypto.KDFParams, key, headerBytes []byte) (*fileops.KeySlotArea, error) {
+	count := len(holders) + len(recipients)
+	if count > fileops.MaxKeySlots {
+		return nil, ErrTooManySlots
+	}
+	for i, holder := range holders {
+		if err := CheckShareHolder(holders[:i], holder); err != nil {
+			return nil, fmt.Errorf("share holder %d: %w", i+1, err)
+		}
+	}
+	for i, recipient := range recipients {
+		for _, other := range recipients[:i] {
+			if recipient.String() == other.String() {
+				return nil, fmt.Errorf("recipient %s is given twice: %w", recipient, ErrDuplicateHolder)
+			}
+		}
+	}
+	shares, err := crypto.SplitSecret(key, threshold, count)
+	if err != nil {
+		return nil, err
+	}
+
+	area := &fileops.KeySlotArea{Capacity: fileops.DefaultKeySlotCapacity}
+	for i, share := range shares {
+		slot := &fileops.ShareSlot{Threshold: share.Threshold, Index: share.Index}
+		if i < len(holders) {
+			slot.Inner.Type = fileops.KeySlotPassword
+			slot.Inner, err = newPasswordSlot(holders[i], kdf, share.Value, shareContext(headerBytes, slot))
+		} else {
+			recipient := recipients[i-len(holders)]
+			slot.Inner.Type = recipient.SlotType()
+			slot.Inner, err = newRecipientSlot(recipient, share.Value, shareContext(headerBytes, slot))
+		}
+		crypto.SecureZero(share.Value)
+		if err != nil {
+			return nil, err
+		}
+		area.Slots = append(area.Slots, slot.KeySlot())
+	}
+
+	area.Grow()
+	return area, nil
+}
+
+// areaThreshold returns the threshold of an area of share slots, or zero
+// if any single slot opens the file
+func areaThreshold(area *fileops.KeySlotArea) int {
+	for _, s := range area.Slots {
+		if slot, err := fileops.ParseShareSlot(s); err == nil {
+			return int(slot.Threshold)
+		}
+	}
+	return 0
+}
+
+// unlockShareSlots returns the shares in the slots that creds open
+func unlockShareSlots(area *fileops.KeySlotArea, headerBytes []byte, creds Credentials) ([]*crypto.Share, error) {
+	id := shareFileID(headerBytes)
+	var shares []*crypto.Share
+	for _, s := range area.Slots {
+		slot, err := fileops.ParseShareSlot(s)
+		if err != nil {
+			continue
+		}
+		context := shareContext(headerBytes, slot)
+
+		var value []byte
+		if fileops.IsRecipientSlot(slot.Inner.Type) {
+			if !creds.UsesIdentities() {
+				continue
+			}
+			value, err = unlockRecipientSlot(slot.Inner, context, creds.Identities)
+			if errors.Is(err, crypto.ErrIdentityMismatch) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			inner, err := fileops.ParsePasswordSlot(slot.Inner)
+			if err != nil || creds.checkFactors(inner.Factors) != nil || (inner.Factors.RequiresPassword() && creds.Password == "") {
+				continue
+			}
+			kek, err := passwordSlotKEK(inner, creds)
+			if err != nil {
+				return nil, err
+			}
+			value, err = crypto.UnwrapKey(kek, inner.WrappedKey, keySlotAAD(context, inner))
+			crypto.SecureZero(kek)
+			if err != nil {
+				continue
+			}
+		}
+		shares = append(shares, &crypto.Share{Threshold: slot.Threshold, Index: slot.Index, FileID: id, Value: value})
+	}
+	return shares, nil
+}
+
+// mergeShares adds the shares from extra that belong to the file and are
+// not in shares yet
+func mergeShares(shares, extra []*crypto.Share, id [crypto.ShareFileIDSize]byte, threshold int) []*crypto.Share {
+	for _, share := range extra {
+		if share.FileID != id || int(share.Threshold) != threshold {
+			continue
+		}
+		known := false
+		for _, have := range shares {
+			known = known || have.Index == share.Index
+		}
+		if !known {
+			shares = append(shares, share)
+		}
+	}
+	return shares
+}
+
+// unlockThreshold recovers the file key of a threshold file from the
+// shares that creds carry and the share slots they open
+func unlockThreshold(area *fileops.KeySlotArea, headerBytes []byte, creds Credentials, threshold int) ([]byte, error) {
+	opened, err := unlockShareSlots(area, headerBytes, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, share := range opened {
+			crypto.SecureZero(share.Value)
+		}
+	}()
+
+	shares := mergeShares(nil, opened, shareFileID(headerBytes), threshold)
+	shares = mergeShares(shares, creds.Shares, shareFileID(headerBytes), threshold)
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%w: %d of %d", ErrMoreSharesNeeded, len(shares), threshold)
+	}
+	return crypto.CombineShares(shares)
+}
+
+// readShareSlots reads the header and key slots of a threshold file
+func readShareSlots(path string) (*fileops.KeySlotArea, []byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !header.HasKeySlots() {
+		return nil, nil, ErrNotThreshold
+	}
+	area, err := fileops.ReadKeySlotArea(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if areaThreshold(area) == 0 {
+		return nil, nil, ErrNotThreshold
+	}
+
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+	return area, headerBytes, nil
+}
+
+// ReadThreshold returns how the key of a threshold file is split, or
+// ErrNotThreshold if any single key slot opens the file. No credentials
+// are needed.
+func ReadThreshold(path string) (*ThresholdInfo, error) {
+	area, headerBytes, err := readShareSlots(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ThresholdInfo{Threshold: areaThreshold(area), Shares: len(area.Slots), FileID: shareFileID(headerBytes)}, nil
+}
+
+// UnlockShares returns the shares of a threshold file that creds open. It
+// is used to print share cards, and to collect shares from several holders
+// in turn before decrypting with Credentials.Shares.
+func UnlockShares(path string, creds Credentials) ([]*crypto.Share, error) {
+	area, headerBytes, err := readShareSlots(path)
+	if err != nil {
+		return nil, err
+	}
+	return openShares(area, headerBytes, creds)
+}
+
+// openShares returns the shares in the slots that creds open, failing if
+// there are none
+func openShares(area *fileops.KeySlotArea, headerBytes []byte, creds Credentials) ([]*crypto.Share, error) {
+	shares, err := unlockShareSlots(area, headerBytes, creds)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%w: no share slot matches", crypto.ErrDecryptionFailed)
+	}
+	return shares, nil
+}
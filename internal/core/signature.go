@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// ErrNotSigned is returned when a file carries no embedded signature
+var ErrNotSigned = errors.New("file has no embedded signature")
+
+// signatureSize returns the size of the signature trailer the file ends with
+func signatureSize(header *fileops.FileHeader) int64 {
+	if header.HasFlag(fileops.FlagSigned) {
+		return int64(fileops.SignatureTrailerSize)
+	}
+	return 0
+}
+
+// limitPayload stops reads from file, positioned at the first segment, at
+// the end of the payload so a signature trailer or recovery record is not
+// taken for a segment
+func limitPayload(file *os.File, header *fileops.FileHeader) (io.Reader, error) {
+	if !header.HasFlag(fileops.FlagSigned) && !header.HasFlag(fileops.FlagRecovery) {
+		return file, nil
+	}
+	end, err := protectedSize(file, header)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payload offset: %w", err)
+	}
+	return io.LimitReader(file, end-offset-signatureSize(header)), nil
+}
+
+// writeSignatureTrailer signs digest and appends the signature trailer
+func writeSignatureTrailer(dst io.Writer, signer *crypto.MinisignPrivateKey, digest []byte) error {
+	trailer := fileops.SignatureTrailer{KeyID: signer.ID}
+	copy(trailer.Signature[:], signer.SignPrehashed(digest))
+	data, err := trailer.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(data); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	return nil
+}
+
+// ReadSignature returns the embedded signature of a file without checking it
+func ReadSignature(path string) (*fileops.SignatureTrailer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return nil, err
+	}
+	return readSignatureTrailer(file, header)
+}
+
+// readSignatureTrailer reads the trailer at the end of file, before any
+// recovery record
+func readSignatureTrailer(file *os.File, header *fileops.FileHeader) (*fileops.SignatureTrailer, error) {
+	if !header.HasFlag(fileops.FlagSigned) {
+		return nil, ErrNotSigned
+	}
+	end, err := protectedSize(file, header)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, fileops.SignatureTrailerSize)
+	at := end - int64(len(data))
+	if at < int64(header.GetTotalSize()) {
+		return nil, fmt.Errorf("failed to read signature: %w", ErrTruncated)
+	}
+	if _, err := file.ReadAt(data, at); err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	return fileops.ParseSignatureTrailer(data)
+}
+
+// VerifySignature checks the embedded signature of a file against keys
+// and returns the key that made it. The signature covers the header,
+// metadata block and payload, but not the key slots, so it stays valid
+// when slots are added or removed. ASCII-armored files are decoded first.
+func VerifySignature(path string, keys []*crypto.MinisignPublicKey) (*crypto.MinisignPublicKey, error) {
+	path, cleanup, err := Dearmored(path)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := readSignatureTrailer(file, header)
+	if err != nil {
+		return nil, err
+	}
+
+	key := crypto.FindMinisignKey(keys, trailer.KeyID)
+	if key == nil {
+		return nil, fmt.Errorf("%w: signed by unknown key %s", crypto.ErrSignatureInvalid, crypto.FormatKeyID(trailer.KeyID))
+	}
+
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+	if header.HasKeySlots() {
+		if _, err := fileops.ReadKeySlotArea(file); err != nil {
+			return nil, fmt.Errorf("failed to read key slots: %w", err)
+		}
+	}
+	payload, err := limitPayload(file, header)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := crypto.NewMinisignHash()
+	digest.Write(headerBytes)
+	if _, err := io.Copy(digest, payload); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := key.VerifyDigest(trailer.KeyID, digest.Sum(nil), trailer.Signature[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
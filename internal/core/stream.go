@@ -0,0 +1,219 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// ErrTruncated is returned when a segmented payload ends before its final segment
+var ErrTruncated = errors.New("encrypted payload is truncated")
+
+// segmentWriter encrypts a plaintext stream into fixed-size authenticated segments.
+// A full segment is only sealed once more data arrives, so Close always has
+// a segment left to seal with the final flag set.
+type segmentWriter struct {
+	w         io.Writer
+	aead      crypto.AEAD
+	baseNonce []byte
+	aad       []byte
+	buf       []byte
+	sealed    []byte
+	index     uint64
+	closed    bool
+}
+
+// newSegmentWriter creates a writer that seals segments of segmentSize bytes.
+// aad is authenticated with every segment, binding the payload to the header.
+func newSegmentWriter(w io.Writer, aead crypto.AEAD, baseNonce, aad []byte, segmentSize int) *segmentWriter {
+	return &segmentWriter{
+		w:         w,
+		aead:      aead,
+		baseNonce: baseNonce,
+		aad:       aad,
+		buf:       make([]byte, 0, segmentSize),
+		sealed:    make([]byte, 0, segmentSize+aead.Overhead()),
+	}
+}
+
+// Write buffers plaintext and seals every segment that is known not to be the last
+func (sw *segmentWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, fmt.Errorf("write to closed segment writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if len(sw.buf) == cap(sw.buf) {
+			if err := sw.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals the buffered data as the final segment
+func (sw *segmentWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+
+	err := sw.flush(true)
+	crypto.SecureZero(sw.buf[:cap(sw.buf)])
+	return err
+}
+
+// flush seals and writes the buffered segment
+func (sw *segmentWriter) flush(final bool) error {
+	nonce := crypto.SegmentNonce(sw.baseNonce, sw.index, final)
+	sw.sealed = sw.aead.Seal(sw.sealed[:0], nonce, sw.buf, sw.aad)
+
+	if _, err := sw.w.Write(sw.sealed); err != nil {
+		return fmt.Errorf("failed to write segment %d: %w", sw.index, err)
+	}
+
+	sw.index++
+	sw.buf = sw.buf[:0]
+	return nil
+}
+
+// segmentReader decrypts a segmented payload, authenticating each segment
+// before any of its plaintext is returned.
+type segmentReader struct {
+	r           *bufio.Reader
+	aead        crypto.AEAD
+	baseNonce   []byte
+	aad         []byte
+	segmentSize int
+	buf         []byte
+	opened      []byte
+	plain       []byte
+	index       uint64
+	done        bool
+	err         error
+}
+
+// newSegmentReader creates a reader over a payload of segmentSize-byte segments
+func newSegmentReader(r io.Reader, aead crypto.AEAD, baseNonce, aad []byte, segmentSize int) *segmentReader {
+	return &segmentReader{
+		r:           bufio.NewReaderSize(r, segmentSize+fileops.AuthTagSize),
+		aead:        aead,
+		baseNonce:   baseNonce,
+		aad:         aad,
+		segmentSize: segmentSize,
+		buf:         make([]byte, segmentSize+fileops.AuthTagSize),
+		opened:      make([]byte, 0, segmentSize),
+	}
+}
+
+// Read returns decrypted plaintext
+func (sr *segmentReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.next()
+	}
+
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// next reads, authenticates and decrypts the next segment
+func (sr *segmentReader) next() error {
+	n, final, err := readSegment(sr.r, sr.buf, sr.index)
+	if err != nil {
+		return err
+	}
+
+	nonce := crypto.SegmentNonce(sr.baseNonce, sr.index, final)
+	plaintext, err := sr.aead.Open(sr.opened[:0], nonce, sr.buf[:n], sr.aad)
+	if err != nil {
+		return segmentError(sr.index, final, err)
+	}
+
+	sr.index++
+	sr.done = final
+	sr.opened = plaintext
+	sr.plain = plaintext
+	return nil
+}
+
+// readSegment reads the sealed segment with the given index into buf, which
+// holds one full sealed segment, and reports whether it is the final one
+func readSegment(r *bufio.Reader, buf []byte, index uint64) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	final := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		final = true
+	case err != nil:
+		return n, false, fmt.Errorf("failed to read segment %d: %w", index, err)
+	default:
+		// A full segment is the last one only if nothing follows it
+		if _, err := r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return n, false, fmt.Errorf("failed to read segment %d: %w", index, err)
+		}
+	}
+
+	if n < fileops.AuthTagSize {
+		return n, final, ErrTruncated
+	}
+	if final && n == fileops.AuthTagSize && index > 0 {
+		return n, final, fmt.Errorf("%w: unexpected empty final segment", ErrTruncated)
+	}
+	return n, final, nil
+}
+
+// segmentError describes a segment that failed to authenticate
+func segmentError(index uint64, final bool, err error) error {
+	if !final {
+		return fmt.Errorf("segment %d: %w: %v", index, crypto.ErrDecryptionFailed, err)
+	}
+	return fmt.Errorf("segment %d (final): %w: %v", index, crypto.ErrDecryptionFailed, err)
+}
+
+// progressReader reports the number of bytes read through it
+type progressReader struct {
+	r        io.Reader
+	current  int64
+	total    int64
+	message  string
+	callback ProgressCallback
+}
+
+// Read reads from the underlying reader and reports progress
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.current += int64(n)
+	if pr.callback != nil && n > 0 {
+		pr.callback(pr.current, pr.total, pr.message)
+	}
+	return n, err
+}
+
+// withProgress wraps r so that callback is invoked as data is read
+func withProgress(r io.Reader, total int64, message string, callback ProgressCallback) io.Reader {
+	if callback == nil {
+		return r
+	}
+	return &progressReader{r: r, total: total, message: message, callback: callback}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// UpgradeResult describes the outcome of UpgradeFile
+type UpgradeResult struct {
+	FromVersion uint32
+	ToVersion   uint32
+	Upgraded    bool
+}
+
+// UpgradeFile rewrites an encrypted file that uses an older format version
+// in the current format, keeping its credentials, cipher and original name.
+//
+// The new file is written next to the original, synced, and decrypted once
+// more to prove it is readable before it atomically replaces the original.
+// If anything fails the original is left untouched.
+func UpgradeFile(path string, creds Credentials) (*UpgradeResult, error) {
+	inputFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	inputInfo, err := inputFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input file info: %w", err)
+	}
+
+	header, err := readFileHeader(inputFile, path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UpgradeResult{FromVersion: header.Version, ToVersion: header.Version}
+	if !header.NeedsUpgrade() {
+		return result, nil
+	}
+
+	aead, _, err := openFileAEAD(inputFile, header, creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock file: %w", err)
+	}
+
+	meta, _, err := readMetadata(inputFile, header, aead)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticate the old payload; v1 must be read whole before anything is written
+	var payload io.Reader
+	if header.IsStreaming() {
+		segments, err := newPayloadReader(inputFile, header, meta, aead, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer segments.Close()
+		payload = segments
+	} else {
+		plaintext, err := readV1Payload(inputFile, header, aead)
+		if err != nil {
+			return nil, err
+		}
+		defer crypto.SecureZero(plaintext)
+		payload = bytes.NewReader(plaintext)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".upgrade-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if tempFile != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	opts := EncryptOptions{
+		KDF:         header.KDFParams(),
+		Algorithm:   header.Algorithm,
+		Compression: header.Compression(),
+	}
+	if err := encryptStream(tempFile, payload, int64(header.OriginalSize), meta, creds, opts); err != nil {
+		return nil, fmt.Errorf("failed to write upgraded file: %w", err)
+	}
+
+	if err := tempFile.Chmod(inputInfo.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync upgraded file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close upgraded file: %w", err)
+	}
+
+	if err := checkDecrypts(tempPath, creds, header.OriginalSize); err != nil {
+		return nil, fmt.Errorf("upgraded file failed verification: %w", err)
+	}
+
+	inputFile.Close()
+	if err := os.Rename(tempPath, path); err != nil {
+		return nil, fmt.Errorf("failed to replace original file: %w", err)
+	}
+	tempFile = nil
+
+	result.ToVersion = fileops.FormatVersion
+	result.Upgraded = true
+	return result, nil
+}
+
+// checkDecrypts decrypts path without writing the plaintext anywhere and
+// checks that it yields size bytes
+func checkDecrypts(path string, creds Credentials, size uint64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file, path)
+	if err != nil {
+		return err
+	}
+
+	aead, _, err := openFileAEAD(file, header, creds)
+	if err != nil {
+		return err
+	}
+
+	meta, _, err := readMetadata(file, header, aead)
+	if err != nil {
+		return err
+	}
+
+	payload, err := newPayloadReader(file, header, meta, aead, 0)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+
+	written, err := io.Copy(io.Discard, payload)
+	if err != nil {
+		return err
+	}
+	if uint64(written) != size {
+		return fmt.Errorf("size mismatch: expected %d, got %d", size, written)
+	}
+	return nil
+}
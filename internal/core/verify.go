@@ -1,32 +1,50 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
 )
 
 // VerificationResult represents the result of file verification
 type VerificationResult struct {
-	IsValid          bool
-	FormatValid      bool
-	HeaderValid      bool
-	SizeConsistent   bool
-	FileAccessible   bool
-	Filename         string
-	OriginalFilename string
-	FileSize         int64
-	OriginalSize     uint64
-	Algorithm        string
-	FormatVersion    uint32
-	ErrorMessage     string
-	VerificationTime time.Duration
+	IsValid           bool
+	FormatValid       bool
+	HeaderValid       bool
+	SizeConsistent    bool
+	FileAccessible    bool
+	Filename          string
+	OriginalFilename  string
+	MetadataEncrypted bool   // filename hidden in the encrypted metadata block
+	FileSize          int64  // of the binary form, for armored files
+	Armored           bool   // the file is ASCII armor, checked after decoding
+	OriginalSize      uint64 // padded size for padded files, until the metadata is unlocked
+	Padded            bool   // the real size is hidden in the encrypted metadata block
+	Streamed          bool   // written from a pipe; compressed ones have no OriginalSize
+	Archive           bool   // an encrypted directory: the payload is a tar archive
+	StoredSize        uint64 // payload size after compression, before encryption
+	Compression       string
+	Algorithm         string
+	KDF               string
+	Unlock            string // factors that unlock the file, e.g. "password + keyfile"
+	KeySlots          int
+	SignerKeyID       string          // key ID of the embedded signature
+	SignatureVerified bool            // set once the signature was checked against a key
+	Recovery          *RecoveryReport // set for files with a recovery record
+	FormatVersion     uint32
+	ErrorMessage      string
+	VerificationTime  time.Duration
 }
 
-// VerifyFile performs comprehensive verification of an encrypted file
+// VerifyFile performs comprehensive verification of an encrypted file.
+// ASCII-armored files are decoded first; a damaged armor makes them invalid.
 func VerifyFile(filePath string) (*VerificationResult, error) {
 	startTime := time.Now()
 	result := &VerificationResult{
@@ -44,8 +62,42 @@ func VerifyFile(filePath string) (*VerificationResult, error) {
 	result.FileAccessible = true
 	result.FileSize = fileInfo.Size()
 
+	// Armored files are checked in their binary form
+	path, cleanup, err := Dearmored(filePath)
+	if err != nil {
+		result.Armored = true
+		result.ErrorMessage = err.Error()
+		result.VerificationTime = time.Since(startTime)
+		return result, nil
+	}
+	defer cleanup()
+	if path != filePath {
+		result.Armored = true
+		if fileInfo, err = os.Stat(path); err != nil {
+			return nil, fmt.Errorf("failed to get file info: %w", err)
+		}
+		result.FileSize = fileInfo.Size()
+	}
+
+	// The recovery record locates damage anywhere, the header included, so
+	// it is checked before anything else is read
+	report, err := CheckRecovery(path)
+	if err != nil && !errors.Is(err, fileops.ErrNoRecoveryRecord) {
+		result.ErrorMessage = fmt.Sprintf("Invalid recovery record: %v", err)
+		result.VerificationTime = time.Since(startTime)
+		return result, nil
+	}
+	if report != nil {
+		result.Recovery = report
+		if !report.Intact() {
+			result.ErrorMessage = describeDamage(report)
+			result.VerificationTime = time.Since(startTime)
+			return result, nil
+		}
+	}
+
 	// Check if file is encrypted
-	isEncrypted, err := security.IsEncryptedFile(filePath)
+	isEncrypted, err := security.IsEncryptedFile(path)
 	if err != nil {
 		result.ErrorMessage = fmt.Sprintf("Failed to check file format: %v", err)
 		result.VerificationTime = time.Since(startTime)
@@ -61,7 +113,7 @@ func VerifyFile(filePath string) (*VerificationResult, error) {
 	result.FormatValid = true
 
 	// Open file for detailed validation
-	file, err := os.Open(filePath)
+	file, err := os.Open(path)
 	if err != nil {
 		result.ErrorMessage = fmt.Sprintf("Failed to open file: %v", err)
 		result.VerificationTime = time.Since(startTime)
@@ -72,6 +124,11 @@ func VerifyFile(filePath string) (*VerificationResult, error) {
 	// Read and validate header
 	var header fileops.FileHeader
 	_, err = header.ReadFrom(file)
+	if errors.Is(err, fileops.ErrHeaderCorrupted) {
+		result.ErrorMessage = "Header corrupted: checksum mismatch (file is damaged, password was not checked)"
+		result.VerificationTime = time.Since(startTime)
+		return result, nil
+	}
 	if err != nil {
 		result.ErrorMessage = fmt.Sprintf("Failed to read header: %v", err)
 		result.VerificationTime = time.Since(startTime)
@@ -87,15 +144,25 @@ func VerifyFile(filePath string) (*VerificationResult, error) {
 
 	result.HeaderValid = true
 	result.OriginalFilename = header.FileName
+	result.MetadataEncrypted = header.HasFlag(fileops.FlagEncryptedMetadata)
 	result.OriginalSize = header.OriginalSize
+	result.Padded = header.HasFlag(fileops.FlagPadded)
+	result.Streamed = header.HasFlag(fileops.FlagStreamed)
+	result.Archive = header.HasFlag(fileops.FlagArchive)
 	result.FormatVersion = header.Version
 
-	// Set algorithm name
-	switch header.Algorithm {
-	case fileops.AlgorithmAES256GCM:
-		result.Algorithm = "AES-256-GCM"
-	default:
-		result.Algorithm = fmt.Sprintf("Unknown (%d)", header.Algorithm)
+	result.Algorithm = crypto.AlgorithmName(header.Algorithm)
+	result.KDF = header.KDFParams().String()
+	result.Compression = header.Compression().String()
+
+	if header.HasFlag(fileops.FlagRecovery) != (result.Recovery != nil) {
+		result.ErrorMessage = "Recovery record missing or does not match the header"
+		result.VerificationTime = time.Since(startTime)
+		return result, nil
+	}
+	protected := result.FileSize
+	if result.Recovery != nil {
+		protected = result.Recovery.ProtectedSize
 	}
 
 	// Check size consistency
@@ -106,8 +173,49 @@ func VerifyFile(filePath string) (*VerificationResult, error) {
 		return result, nil
 	}
 
+	// Files with key slots record the factors per slot
+	var slotSize int64
+	result.Unlock = header.Factors().String()
+	if header.HasKeySlots() {
+		area, err := fileops.ReadKeySlotArea(file)
+		if err != nil {
+			result.ErrorMessage = fmt.Sprintf("Invalid key slots: %v", err)
+			result.VerificationTime = time.Since(startTime)
+			return result, nil
+		}
+		slotSize = area.Size()
+		result.KeySlots = len(area.Slots)
+		result.Unlock = describeKeySlots(area)
+	}
+
+	storedSize, err := storedPayloadSize(file, &header, protected-slotSize-signatureSize(&header))
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("File too small: %v", err)
+		result.VerificationTime = time.Since(startTime)
+		return result, nil
+	}
+	if result.Streamed && !header.HasFlag(fileops.FlagCompressed) {
+		// Only the payload tells the size of a streamed file
+		result.OriginalSize = storedSize
+	} else if !result.Streamed && !header.HasFlag(fileops.FlagCompressed) && storedSize != header.OriginalSize {
+		result.ErrorMessage = fmt.Sprintf("Payload size mismatch: header records %d bytes, file holds %d", header.OriginalSize, storedSize)
+		result.VerificationTime = time.Since(startTime)
+		return result, nil
+	}
+
+	result.StoredSize = storedSize
 	result.SizeConsistent = true
 
+	if header.HasFlag(fileops.FlagSigned) {
+		trailer, err := readSignatureTrailer(file, &header)
+		if err != nil {
+			result.ErrorMessage = fmt.Sprintf("Invalid signature: %v", err)
+			result.VerificationTime = time.Since(startTime)
+			return result, nil
+		}
+		result.SignerKeyID = crypto.FormatKeyID(trailer.KeyID)
+	}
+
 	// All checks passed
 	result.IsValid = true
 	result.VerificationTime = time.Since(startTime)
@@ -115,7 +223,90 @@ func VerifyFile(filePath string) (*VerificationResult, error) {
 	return result, nil
 }
 
-// VerifyIntegrity performs deep integrity verification (requires password)
+// describeDamage summarizes the damage a recovery record found
+func describeDamage(report *RecoveryReport) string {
+	var damage string
+	switch {
+	case report.DamagedBlocks == 0:
+		damage = "Recovery record damaged"
+	case len(report.Damaged) == 1:
+		damage = fmt.Sprintf("File damaged: %d bytes at offset %d", report.Damaged[0].Length, report.Damaged[0].Offset)
+	default:
+		damage = fmt.Sprintf("File damaged: %d regions", len(report.Damaged))
+	}
+	if report.Repairable {
+		return damage + " (repairable with 'filevault repair')"
+	}
+	return damage + " (too damaged to repair)"
+}
+
+// describeKeySlots lists the distinct factor sets of the key slots,
+// e.g. "password or password + keyfile", or "any 2 of 3 shares
+// (password, x25519 identity)" for a threshold file
+func describeKeySlots(area *fileops.KeySlotArea) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, s := range area.Slots {
+		if share, err := fileops.ParseShareSlot(s); err == nil {
+			s = share.Inner
+		}
+		name := fileops.KeySlotTypeName(s.Type)
+		if slot, err := fileops.ParsePasswordSlot(s); err == nil {
+			name = slot.Factors.String()
+		} else if fileops.IsRecipientSlot(s.Type) {
+			name += " identity"
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if threshold := areaThreshold(area); threshold > 0 {
+		return fmt.Sprintf("any %d of %d shares (%s)", threshold, len(area.Slots), strings.Join(names, ", "))
+	}
+	return strings.Join(names, " or ")
+}
+
+// storedPayloadSize works out how many payload bytes were encrypted, which
+// for compressed files is the compressed size, from the length of the file
+// alone, not counting key slots. file must be positioned just after the
+// header and any key slots.
+func storedPayloadSize(file io.Reader, header *fileops.FileHeader, fileSize int64) (uint64, error) {
+	metaSize, err := metadataBlockSize(file, header)
+	if err != nil {
+		return 0, err
+	}
+
+	ciphertext := fileSize - int64(header.GetTotalSize()) - metaSize
+	if !header.IsStreaming() {
+		ciphertext -= fileops.AuthTagSize
+		if ciphertext < 0 {
+			return 0, ErrTruncated
+		}
+		return uint64(ciphertext), nil
+	}
+	return segmentedSize(ciphertext, int64(header.SegmentSize()))
+}
+
+// segmentedSize returns how many bytes were sealed into ciphertext bytes of
+// segments of segmentSize
+func segmentedSize(ciphertext, segmentSize int64) (uint64, error) {
+	// Every segment but the last is full; each one carries a tag
+	sealedSegment := segmentSize + fileops.AuthTagSize
+	segments := (ciphertext + sealedSegment - 1) / sealedSegment
+	if segments == 0 {
+		segments = 1
+	}
+
+	stored := ciphertext - segments*fileops.AuthTagSize
+	if stored < 0 {
+		return 0, ErrTruncated
+	}
+	return uint64(stored), nil
+}
+
+// VerifyIntegrity performs deep integrity verification (requires password);
+// ASCII-armored files are decoded first
 func VerifyIntegrity(filePath, password string) (*VerificationResult, error) {
 	// First perform basic verification
 	result, err := VerifyFile(filePath)
@@ -127,7 +318,12 @@ func VerifyIntegrity(filePath, password string) (*VerificationResult, error) {
 	startTime := time.Now()
 
 	// Open file for decryption test
-	file, err := os.Open(filePath)
+	path, cleanup, err := Dearmored(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	file, err := os.Open(path)
 	if err != nil {
 		result.IsValid = false
 		result.ErrorMessage = fmt.Sprintf("Failed to open for integrity check: %v", err)
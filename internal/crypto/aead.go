@@ -0,0 +1,216 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// Nonce sizes
+const (
+	XNonceSize   = chacha20poly1305.NonceSizeX // XChaCha20-Poly1305 nonce size
+	MaxNonceSize = 24                          // largest base nonce the file header can store
+)
+
+// ErrUnknownAlgorithm is returned for an algorithm identifier that is not registered
+var ErrUnknownAlgorithm = errors.New("unknown encryption algorithm")
+
+// AEAD seals and opens messages under a single key. It has the method set of
+// crypto/cipher.AEAD, so standard library implementations satisfy it directly.
+// Implementations build their cipher state once, when the key is supplied,
+// and must be safe for concurrent use.
+type AEAD interface {
+	NonceSize() int
+	Overhead() int
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// Algorithm describes an AEAD selectable by its file header identifier
+type Algorithm struct {
+	ID        uint32
+	Name      string
+	NonceSize int
+	New       func(key []byte) (AEAD, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[uint32]Algorithm{}
+)
+
+func init() {
+	Register(Algorithm{
+		ID:        AlgorithmAES256GCM,
+		Name:      "AES-256-GCM",
+		NonceSize: NonceSize,
+		New:       newAESGCM,
+	})
+	Register(Algorithm{
+		ID:        AlgorithmXChaCha20Poly1305,
+		Name:      "XChaCha20-Poly1305",
+		NonceSize: XNonceSize,
+		New: func(key []byte) (AEAD, error) {
+			return chacha20poly1305.NewX(key)
+		},
+	})
+}
+
+// Register adds or replaces an algorithm in the registry. Every AEAD must use
+// KeySize-byte keys and TagSize-byte tags, and its nonce must fit the header.
+func Register(alg Algorithm) error {
+	if alg.ID == 0 || alg.New == nil {
+		return fmt.Errorf("invalid algorithm registration: %q", alg.Name)
+	}
+	if alg.NonceSize < NonceSize || alg.NonceSize > MaxNonceSize {
+		return fmt.Errorf("algorithm %s: nonce size must be between %d and %d bytes", alg.Name, NonceSize, MaxNonceSize)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[alg.ID] = alg
+	return nil
+}
+
+// Unregister removes an algorithm, for example to restrict a build to an
+// approved set. Files using it can no longer be encrypted or decrypted.
+func Unregister(id uint32) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, id)
+}
+
+// LookupAlgorithm returns the registered algorithm with the given identifier
+func LookupAlgorithm(id uint32) (Algorithm, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	alg, ok := registry[id]
+	if !ok {
+		return Algorithm{}, fmt.Errorf("%w: %d", ErrUnknownAlgorithm, id)
+	}
+	return alg, nil
+}
+
+// Algorithms returns the registered algorithms ordered by identifier
+func Algorithms() []Algorithm {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	algs := make([]Algorithm, 0, len(registry))
+	for _, alg := range registry {
+		algs = append(algs, alg)
+	}
+	sort.Slice(algs, func(i, j int) bool { return algs[i].ID < algs[j].ID })
+	return algs
+}
+
+// NewAEAD builds the AEAD for algorithm id keyed with key
+func NewAEAD(id uint32, key []byte) (AEAD, error) {
+	alg, err := LookupAlgorithm(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidKeySize, len(key))
+	}
+
+	aead, err := alg.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s: %w", alg.Name, err)
+	}
+	if aead.NonceSize() != alg.NonceSize || aead.Overhead() != TagSize {
+		return nil, fmt.Errorf("algorithm %s: implementation does not match its registration", alg.Name)
+	}
+	return aead, nil
+}
+
+// NewAEADFromKDF derives a key from password and builds the AEAD for algorithm id.
+// The derived key is wiped once the AEAD has been built.
+func NewAEADFromKDF(id uint32, password string, salt [32]byte, params KDFParams) (AEAD, error) {
+	key, err := params.DeriveKey(password, salt[:])
+	if err != nil {
+		return nil, fmt.Errorf("key derivation failed: %w", err)
+	}
+	defer SecureZero(key)
+	return NewAEAD(id, key)
+}
+
+// newAESGCM builds AES-256-GCM once for key
+func newAESGCM(key []byte) (AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AlgorithmName returns the display name of a header algorithm identifier
+func AlgorithmName(id uint32) string {
+	alg, err := LookupAlgorithm(id)
+	if err != nil {
+		return fmt.Sprintf("Unknown (%d)", id)
+	}
+	return alg.Name
+}
+
+// ParseCipherName maps a command-line cipher name to its algorithm identifier.
+// "auto" picks AES-256-GCM when the CPU accelerates it, XChaCha20-Poly1305 otherwise.
+func ParseCipherName(name string) (uint32, error) {
+	var id uint32
+	switch strings.ToLower(name) {
+	case "aes", "aes256gcm":
+		id = AlgorithmAES256GCM
+	case "xchacha20", "xchacha", "chacha20", "chacha":
+		id = AlgorithmXChaCha20Poly1305
+	case "auto":
+		id = PreferredAlgorithm()
+	default:
+		for _, alg := range Algorithms() {
+			if strings.EqualFold(alg.Name, name) {
+				return alg.ID, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown cipher: %s (use aes-256-gcm, xchacha20-poly1305 or auto)", name)
+	}
+
+	if _, err := LookupAlgorithm(id); err != nil {
+		return 0, fmt.Errorf("cipher %s is not available: %w", name, err)
+	}
+	return id, nil
+}
+
+// PreferredAlgorithm returns the fastest safe cipher for this CPU. Without
+// hardware AES and carry-less multiply, GCM is slow and its software
+// fallback is harder to keep constant-time, so XChaCha20-Poly1305 is used.
+func PreferredAlgorithm() uint32 {
+	if hasAESGCMHardwareSupport() {
+		return AlgorithmAES256GCM
+	}
+	return AlgorithmXChaCha20Poly1305
+}
+
+// hasAESGCMHardwareSupport mirrors the check crypto/tls uses for cipher preference
+func hasAESGCMHardwareSupport() bool {
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return cpu.S390X.HasAES && cpu.S390X.HasAESCBC && cpu.S390X.HasAESCTR &&
+			(cpu.S390X.HasGHASH || cpu.S390X.HasAESGCM)
+	case "ppc64", "ppc64le":
+		return true
+	default:
+		return false
+	}
+}
@@ -1,14 +1,13 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"fmt"
 )
 
-// AESCipher handles AES-256-GCM encryption/decryption
+// AESCipher handles AES-256-GCM encryption/decryption.
+// The GCM state is built once when the cipher is created.
 type AESCipher struct {
-	key []byte
+	aead AEAD
 }
 
 // NewAESCipher creates a new AES cipher with the given key
@@ -17,18 +16,26 @@ func NewAESCipher(key []byte) (*AESCipher, error) {
 		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidKeySize, len(key))
 	}
 
-	// Validate key by creating cipher
-	_, err := aes.NewCipher(key)
+	aead, err := newAESGCM(key)
 	if err != nil {
 		return nil, fmt.Errorf("invalid AES key: %w", err)
 	}
 
-	return &AESCipher{key: key}, nil
+	return &AESCipher{aead: aead}, nil
 }
 
 // NewAESCipherFromPassword creates cipher from password using PBKDF2
 func NewAESCipherFromPassword(password string, salt [32]byte) (*AESCipher, error) {
-	key := DeriveKey(password, salt[:], DefaultIterations)
+	return NewAESCipherFromKDF(password, salt, DefaultKDFParams())
+}
+
+// NewAESCipherFromKDF creates cipher from password using the given KDF parameters
+func NewAESCipherFromKDF(password string, salt [32]byte, params KDFParams) (*AESCipher, error) {
+	key, err := params.DeriveKey(password, salt[:])
+	if err != nil {
+		return nil, fmt.Errorf("key derivation failed: %w", err)
+	}
+	defer SecureZero(key)
 	return NewAESCipher(key)
 }
 
@@ -45,24 +52,18 @@ func (c *AESCipher) Encrypt(plaintext []byte) (*EncryptedData, error) {
 
 // EncryptWithNonce encrypts plaintext using AES-256-GCM with specified nonce
 func (c *AESCipher) EncryptWithNonce(plaintext []byte, nonce []byte) (*EncryptedData, error) {
+	return c.EncryptWithAAD(plaintext, nonce, nil)
+}
+
+// EncryptWithAAD encrypts plaintext with the specified nonce and authenticates
+// additionalData alongside it without encrypting it
+func (c *AESCipher) EncryptWithAAD(plaintext, nonce, additionalData []byte) (*EncryptedData, error) {
 	if len(nonce) != NonceSize {
 		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidNonceSize, len(nonce))
 	}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(c.key)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
 	// Encrypt and authenticate
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext := c.aead.Seal(nil, nonce, plaintext, additionalData)
 
 	// Split ciphertext and tag (GCM appends tag to ciphertext)
 	tagStart := len(ciphertext) - TagSize
@@ -78,6 +79,12 @@ func (c *AESCipher) EncryptWithNonce(plaintext []byte, nonce []byte) (*Encrypted
 
 // Decrypt decrypts ciphertext using AES-256-GCM
 func (c *AESCipher) Decrypt(data *EncryptedData) ([]byte, error) {
+	return c.DecryptWithAAD(data, nil)
+}
+
+// DecryptWithAAD decrypts ciphertext and verifies the additional data that
+// was authenticated at encryption time
+func (c *AESCipher) DecryptWithAAD(data *EncryptedData, additionalData []byte) ([]byte, error) {
 	// Validate inputs
 	if len(data.Nonce) != NonceSize {
 		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidNonceSize, len(data.Nonce))
@@ -87,23 +94,13 @@ func (c *AESCipher) Decrypt(data *EncryptedData) ([]byte, error) {
 		return nil, fmt.Errorf("invalid tag size: expected %d, got %d", TagSize, len(data.Tag))
 	}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(c.key)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	// Reconstruct full ciphertext with tag
-	fullCiphertext := append(data.Ciphertext, data.Tag...)
+	// Reconstruct full ciphertext with tag without touching the caller's buffer
+	fullCiphertext := make([]byte, 0, len(data.Ciphertext)+len(data.Tag))
+	fullCiphertext = append(fullCiphertext, data.Ciphertext...)
+	fullCiphertext = append(fullCiphertext, data.Tag...)
 
 	// Decrypt and verify
-	plaintext, err := gcm.Open(nil, data.Nonce, fullCiphertext, nil)
+	plaintext, err := c.aead.Open(nil, data.Nonce, fullCiphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
 	}
@@ -0,0 +1,289 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Certificate recipients wrap file keys for the public key of an X.509
+// certificate issued by a trusted CA: RSA keys with RSA-OAEP-SHA256, ECDSA
+// keys by ECDH with an ephemeral key on the same curve. The certificate is
+// checked against the CA bundle and its validity period when encrypting;
+// decrypting only needs the private key. Slot bodies start with a tag of
+// the subject public key so identities skip slots that are not theirs.
+const (
+	x509RSALabel  = "FileVault x509-rsa v1"
+	x509ECDHLabel = "FileVault x509-ecdh v1"
+	certTagSize   = 4
+)
+
+// CertRecipient wraps file keys for the key of a verified certificate
+type CertRecipient struct {
+	cert *x509.Certificate // nil when built from a private key
+	tag  []byte
+	rsa  *rsa.PublicKey
+	ecdh *ecdh.PublicKey
+}
+
+// CertIdentity is the private key of a certificate
+type CertIdentity struct {
+	recipient *CertRecipient
+	rsa       *rsa.PrivateKey
+	ecdh      *ecdh.PrivateKey
+}
+
+// certTag identifies a subject public key inside a slot body
+func certTag(spki []byte) []byte {
+	sum := sha256.Sum256(spki)
+	return sum[:certTagSize]
+}
+
+// LoadCertPool reads a CA bundle of PEM certificates
+func LoadCertPool(pemBytes []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in CA bundle")
+	}
+	return pool, nil
+}
+
+// parseCertificates reads every CERTIFICATE block of a PEM file
+func parseCertificates(pemBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+// ParseCertRecipient parses a PEM certificate and verifies it at now
+// against the CA roots. Further certificates in the file are used as
+// intermediates. The key must be RSA (2048 bits or more) or ECDSA on a
+// NIST curve, and allowed to encipher keys or agree on them.
+func ParseCertRecipient(pemBytes []byte, roots *x509.CertPool, now time.Time) (*CertRecipient, error) {
+	if roots == nil {
+		return nil, fmt.Errorf("no CA bundle to verify the certificate against")
+	}
+
+	certs, err := parseCertificates(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	cert := certs[0]
+	name := cert.Subject.String()
+
+	if now.Before(cert.NotBefore) {
+		return nil, fmt.Errorf("certificate %q is not valid until %s", name, cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("certificate %q expired on %s", name, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("certificate %q is not trusted: %w", name, err)
+	}
+
+	recipient, err := newCertRecipient(cert.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %q: %w", name, err)
+	}
+	recipient.cert = cert
+
+	// A key usage extension, if present, must allow the operation we use
+	if recipient.rsa != nil && cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		return nil, fmt.Errorf("certificate %q does not allow key encipherment", name)
+	}
+	if recipient.ecdh != nil && cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageKeyAgreement == 0 {
+		return nil, fmt.Errorf("certificate %q does not allow key agreement", name)
+	}
+	return recipient, nil
+}
+
+// newCertRecipient builds the recipient for a certificate public key
+func newCertRecipient(public interface{}) (*CertRecipient, error) {
+	spki, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported public key: %w", err)
+	}
+	recipient := &CertRecipient{tag: certTag(spki)}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < MinSSHRSABits {
+			return nil, fmt.Errorf("RSA key too small: %d bits (minimum %d)", key.N.BitLen(), MinSSHRSABits)
+		}
+		recipient.rsa = key
+	case *ecdsa.PublicKey:
+		recipient.ecdh, err = key.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("unsupported ECDSA key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T (use RSA or ECDSA)", public)
+	}
+	return recipient, nil
+}
+
+// SlotType implements Recipient
+func (r *CertRecipient) SlotType() uint8 {
+	if r.rsa != nil {
+		return SlotX509RSA
+	}
+	return SlotX509ECDH
+}
+
+// Wrap seals fileKey for the certificate key after the key tag
+func (r *CertRecipient) Wrap(fileKey, context []byte) ([]byte, error) {
+	var body []byte
+	var err error
+	if r.rsa != nil {
+		body, err = wrapRSA(x509RSALabel, r.rsa, fileKey, context)
+	} else {
+		body, err = wrapECDH(x509ECDHLabel, r.ecdh, fileKey, append(append([]byte{}, context...), r.tag...))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, r.tag...), body...), nil
+}
+
+// String names the certificate subject, or the key tag without a certificate
+func (r *CertRecipient) String() string {
+	if r.cert != nil {
+		return r.cert.Subject.String()
+	}
+	return "x509 key " + hex.EncodeToString(r.tag)
+}
+
+// ParseCertIdentity parses the private key of a certificate (PKCS#1,
+// PKCS#8 or SEC 1 PEM). Certificate blocks in the same file are skipped.
+// passphrase is only called if the key is encrypted and may be nil.
+func ParseCertIdentity(pemBytes []byte, passphrase SSHPassphraseFunc) (*CertIdentity, error) {
+	raw, err := parsePrivateKey(privateKeyBlock(pemBytes), passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return newCertIdentity(raw)
+}
+
+// ParsePrivateKeyIdentities parses a PEM private key into every identity
+// it can act as: an SSH identity for Ed25519 keys, a certificate identity
+// for ECDSA keys, and both for RSA keys. The key is decrypted only once.
+func ParsePrivateKeyIdentities(pemBytes []byte, passphrase SSHPassphraseFunc) ([]Identity, error) {
+	raw, err := parsePrivateKey(privateKeyBlock(pemBytes), passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	var identities []Identity
+	sshIdentity, sshErr := newSSHIdentity(raw)
+	if sshErr == nil {
+		identities = append(identities, sshIdentity)
+	}
+	if certIdentity, err := newCertIdentity(raw); err == nil {
+		identities = append(identities, certIdentity)
+	}
+	if len(identities) == 0 {
+		return nil, sshErr
+	}
+	return identities, nil
+}
+
+// privateKeyBlock returns the first PEM block holding a private key, so
+// that files bundling the certificate and its key can be used as is
+func privateKeyBlock(pemBytes []byte) []byte {
+	rest := pemBytes
+	for {
+		block, next := pem.Decode(rest)
+		if block == nil {
+			return pemBytes
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return pem.EncodeToMemory(block)
+		}
+		rest = next
+	}
+}
+
+// newCertIdentity builds the certificate identity of a parsed private key
+func newCertIdentity(raw interface{}) (*CertIdentity, error) {
+	identity := &CertIdentity{}
+	var public interface{}
+	switch key := raw.(type) {
+	case *rsa.PrivateKey:
+		identity.rsa = key
+		public = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		converted, err := key.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("unsupported ECDSA key: %w", err)
+		}
+		identity.ecdh = converted
+		public = &key.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T (use RSA or ECDSA)", raw)
+	}
+
+	recipient, err := newCertRecipient(public)
+	if err != nil {
+		return nil, err
+	}
+	identity.recipient = recipient
+	return identity, nil
+}
+
+// Unwrap implements Identity
+func (i *CertIdentity) Unwrap(slotType uint8, body, context []byte) ([]byte, error) {
+	tag := i.recipient.tag
+	if len(body) < certTagSize || !bytes.Equal(body[:certTagSize], tag) {
+		return nil, ErrIdentityMismatch
+	}
+
+	switch {
+	case slotType == SlotX509RSA && i.rsa != nil:
+		return unwrapRSA(x509RSALabel, i.rsa, body[certTagSize:], context)
+	case slotType == SlotX509ECDH && i.ecdh != nil:
+		return unwrapECDH(x509ECDHLabel, i.ecdh, body[certTagSize:], append(append([]byte{}, context...), tag...))
+	default:
+		return nil, ErrIdentityMismatch
+	}
+}
+
+// Recipient returns the public key of the identity
+func (i *CertIdentity) Recipient() Recipient {
+	return i.recipient
+}
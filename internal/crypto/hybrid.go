@@ -0,0 +1,197 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Hybrid post-quantum recipients combine ML-KEM-768 with X25519. The file
+// key stays safe as long as either one holds: ML-KEM against a future
+// quantum computer recording ciphertexts today, X25519 against a flaw in
+// the much younger ML-KEM.
+//
+// Slot body: ML-KEM ciphertext, ephemeral X25519 public key, wrapped key.
+// The key-encryption key is HKDF-SHA256 over both shared secrets, salted
+// with the ML-KEM ciphertext and both X25519 public keys.
+const (
+	HybridRecipientPrefix = "fvpq1"
+	HybridIdentityPrefix  = "FVPQSK1"
+
+	hybridLabel         = "FileVault mlkem768x25519 v1"
+	hybridPublicKeySize = mlkem.EncapsulationKeySize768 + 32
+	hybridSeedSize      = mlkem.SeedSize + 32
+	hybridBodySize      = mlkem.CiphertextSize768 + 32 + WrappedKeySize
+)
+
+// HybridRecipient is an ML-KEM-768 + X25519 public key
+type HybridRecipient struct {
+	kem    *mlkem.EncapsulationKey768
+	x25519 *ecdh.PublicKey
+}
+
+// HybridIdentity is the private key matching a HybridRecipient
+type HybridIdentity struct {
+	kem    *mlkem.DecapsulationKey768
+	x25519 *ecdh.PrivateKey
+}
+
+// GenerateHybridIdentity creates a new random ML-KEM-768 + X25519 identity
+func GenerateHybridIdentity() (*HybridIdentity, error) {
+	kem, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ML-KEM key: %w", err)
+	}
+	x25519, err := GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	return &HybridIdentity{kem: kem, x25519: x25519.key}, nil
+}
+
+// ParseHybridRecipient parses an encoded public key ("fvpq1...")
+func ParseHybridRecipient(s string) (*HybridRecipient, error) {
+	raw, err := decodeKey(HybridRecipientPrefix, s, hybridPublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	kem, err := mlkem.NewEncapsulationKey768(raw[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ML-KEM-768 public key: %w", err)
+	}
+	x25519, err := ecdh.X25519().NewPublicKey(raw[mlkem.EncapsulationKeySize768:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 public key: %w", err)
+	}
+	return &HybridRecipient{kem: kem, x25519: x25519}, nil
+}
+
+// ParseHybridIdentity parses an encoded private key ("FVPQSK1...")
+func ParseHybridIdentity(s string) (*HybridIdentity, error) {
+	raw, err := decodeKey(HybridIdentityPrefix, s, hybridSeedSize)
+	if err != nil {
+		return nil, err
+	}
+	defer SecureZero(raw)
+
+	kem, err := mlkem.NewDecapsulationKey768(raw[:mlkem.SeedSize])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ML-KEM-768 private key: %w", err)
+	}
+	x25519, err := ecdh.X25519().NewPrivateKey(raw[mlkem.SeedSize:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 private key: %w", err)
+	}
+	return &HybridIdentity{kem: kem, x25519: x25519}, nil
+}
+
+// hybridKEK combines both shared secrets into the key-encryption key
+func hybridKEK(kemShared, x25519Shared, kemCiphertext, ephemeral, recipient []byte) ([]byte, error) {
+	secret := append(append([]byte{}, kemShared...), x25519Shared...)
+	defer SecureZero(secret)
+
+	salt := make([]byte, 0, len(kemCiphertext)+len(ephemeral)+len(recipient))
+	salt = append(salt, kemCiphertext...)
+	salt = append(salt, ephemeral...)
+	salt = append(salt, recipient...)
+	return hkdf.Key(sha256.New, secret, salt, hybridLabel, KeySize)
+}
+
+// SlotType implements Recipient
+func (r *HybridRecipient) SlotType() uint8 {
+	return SlotMLKEM768X25519
+}
+
+// Wrap encapsulates to the ML-KEM key, agrees an ephemeral X25519 key and
+// wraps fileKey under a key derived from both
+func (r *HybridRecipient) Wrap(fileKey, context []byte) ([]byte, error) {
+	kemShared, kemCiphertext := r.kem.Encapsulate()
+	defer SecureZero(kemShared)
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	x25519Shared, err := ephemeral.ECDH(r.x25519)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	defer SecureZero(x25519Shared)
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	kek, err := hybridKEK(kemShared, x25519Shared, kemCiphertext, ephemeralPublic, r.x25519.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer SecureZero(kek)
+
+	wrapped, err := WrapKey(kek, fileKey, context)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0, hybridBodySize)
+	body = append(body, kemCiphertext...)
+	body = append(body, ephemeralPublic...)
+	return append(body, wrapped...), nil
+}
+
+// String returns the encoded public key
+func (r *HybridRecipient) String() string {
+	return encodeKey(HybridRecipientPrefix, append(r.kem.Bytes(), r.x25519.Bytes()...))
+}
+
+// Unwrap implements Identity
+func (i *HybridIdentity) Unwrap(slotType uint8, body, context []byte) ([]byte, error) {
+	if slotType != SlotMLKEM768X25519 || len(body) != hybridBodySize {
+		return nil, ErrIdentityMismatch
+	}
+
+	kemCiphertext := body[:mlkem.CiphertextSize768]
+	ephemeralPublic := body[mlkem.CiphertextSize768 : mlkem.CiphertextSize768+32]
+	wrapped := body[mlkem.CiphertextSize768+32:]
+
+	// Decapsulation never fails on a well-sized ciphertext; a ciphertext
+	// for another key yields an unrelated secret and the unwrap fails
+	kemShared, err := i.kem.Decapsulate(kemCiphertext)
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+	defer SecureZero(kemShared)
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralPublic)
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+	x25519Shared, err := i.x25519.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+	defer SecureZero(x25519Shared)
+
+	kek, err := hybridKEK(kemShared, x25519Shared, kemCiphertext, ephemeralPublic, i.x25519.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer SecureZero(kek)
+
+	key, err := UnwrapKey(kek, wrapped, context)
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+	return key, nil
+}
+
+// Recipient returns the public key of the identity
+func (i *HybridIdentity) Recipient() Recipient {
+	return &HybridRecipient{kem: i.kem.EncapsulationKey(), x25519: i.x25519.PublicKey()}
+}
+
+// String returns the encoded private key
+func (i *HybridIdentity) String() string {
+	return encodeKey(HybridIdentityPrefix, append(i.kem.Bytes(), i.x25519.Bytes()...))
+}
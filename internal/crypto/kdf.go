@@ -2,9 +2,184 @@ package crypto
 
 import (
     "crypto/sha256"
+    "encoding/binary"
+    "fmt"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/argon2"
     "golang.org/x/crypto/pbkdf2"
 )
 
+// KDF algorithm identifiers stored in the file header
+const (
+    KDFLegacy       = 0 // v1 files: PBKDF2-SHA256 with DefaultIterations
+    KDFPBKDF2SHA256 = 1 // PBKDF2-SHA256 with a stored iteration count
+    KDFArgon2id     = 2 // Argon2id with stored memory, time and parallelism
+)
+
+// PBKDF2 iteration bounds accepted for encryption and decryption
+const (
+    MinIterations = 10000
+    MaxIterations = 100000000
+)
+
+// Argon2id defaults (RFC 9106 second recommended option) and bounds
+const (
+    DefaultArgon2Memory      = 64 * 1024 // KiB
+    DefaultArgon2Time        = 3
+    DefaultArgon2Parallelism = 4
+
+    MinArgon2Memory = 8 * 1024        // KiB
+    MaxArgon2Memory = 4 * 1024 * 1024 // KiB
+    MaxArgon2Time   = 100
+)
+
+// KDFDescriptorSize is the encoded size of KDFParams in the file header
+const KDFDescriptorSize = 10
+
+// KDFParams describes a key derivation function and its cost parameters
+type KDFParams struct {
+    Algorithm   uint8
+    Iterations  uint32 // PBKDF2 iteration count
+    Memory      uint32 // Argon2id memory in KiB
+    Time        uint32 // Argon2id passes
+    Parallelism uint8  // Argon2id lanes
+}
+
+// DefaultKDFParams returns the KDF used when nothing else is configured
+func DefaultKDFParams() KDFParams {
+    return KDFParams{
+        Algorithm:  KDFPBKDF2SHA256,
+        Iterations: DefaultIterations,
+    }
+}
+
+// PBKDF2Params returns PBKDF2-SHA256 parameters with the given iteration count
+func PBKDF2Params(iterations int) KDFParams {
+    return KDFParams{
+        Algorithm:  KDFPBKDF2SHA256,
+        Iterations: uint32(iterations),
+    }
+}
+
+// Argon2idParams returns Argon2id parameters; memory is given in KiB
+func Argon2idParams(memory, time uint32, parallelism uint8) KDFParams {
+    return KDFParams{
+        Algorithm:   KDFArgon2id,
+        Memory:      memory,
+        Time:        time,
+        Parallelism: parallelism,
+    }
+}
+
+// DefaultArgon2idParams returns the recommended Argon2id parameters
+func DefaultArgon2idParams() KDFParams {
+    return Argon2idParams(DefaultArgon2Memory, DefaultArgon2Time, DefaultArgon2Parallelism)
+}
+
+// Encode serializes the parameters into the fixed-size header descriptor:
+// algorithm id, then two little-endian uint32 cost values and one byte.
+func (p KDFParams) Encode() [KDFDescriptorSize]byte {
+    var b [KDFDescriptorSize]byte
+    b[0] = p.Algorithm
+    switch p.Algorithm {
+    case KDFArgon2id:
+        binary.LittleEndian.PutUint32(b[1:5], p.Memory)
+        binary.LittleEndian.PutUint32(b[5:9], p.Time)
+        b[9] = p.Parallelism
+    default:
+        binary.LittleEndian.PutUint32(b[1:5], p.Iterations)
+    }
+    return b
+}
+
+// DecodeKDFParams parses a header descriptor written by Encode
+func DecodeKDFParams(b [KDFDescriptorSize]byte) KDFParams {
+    p := KDFParams{Algorithm: b[0]}
+    switch p.Algorithm {
+    case KDFArgon2id:
+        p.Memory = binary.LittleEndian.Uint32(b[1:5])
+        p.Time = binary.LittleEndian.Uint32(b[5:9])
+        p.Parallelism = b[9]
+    default:
+        p.Iterations = binary.LittleEndian.Uint32(b[1:5])
+    }
+    return p
+}
+
+// Validate checks that the parameters are known and within safe bounds.
+// Decryption validates before deriving so a crafted header cannot demand
+// an unbounded amount of work.
+func (p KDFParams) Validate() error {
+    switch p.Algorithm {
+    case KDFLegacy:
+        return nil
+    case KDFPBKDF2SHA256:
+        if p.Iterations < MinIterations || p.Iterations > MaxIterations {
+            return fmt.Errorf("PBKDF2 iterations must be between %d and %d, got %d",
+                MinIterations, MaxIterations, p.Iterations)
+        }
+        return nil
+    case KDFArgon2id:
+        if p.Memory < MinArgon2Memory || p.Memory > MaxArgon2Memory {
+            return fmt.Errorf("Argon2id memory must be between %d and %d MiB, got %d KiB",
+                MinArgon2Memory/1024, MaxArgon2Memory/1024, p.Memory)
+        }
+        if p.Time < 1 || p.Time > MaxArgon2Time {
+            return fmt.Errorf("Argon2id time must be between 1 and %d, got %d", MaxArgon2Time, p.Time)
+        }
+        if p.Parallelism < 1 {
+            return fmt.Errorf("Argon2id parallelism must be at least 1")
+        }
+        return nil
+    default:
+        return fmt.Errorf("unknown key derivation function: %d", p.Algorithm)
+    }
+}
+
+// String returns a human-readable description of the parameters
+func (p KDFParams) String() string {
+    switch p.Algorithm {
+    case KDFLegacy:
+        return fmt.Sprintf("PBKDF2-SHA256 (%s iterations)", formatCount(DefaultIterations))
+    case KDFPBKDF2SHA256:
+        return fmt.Sprintf("PBKDF2-SHA256 (%s iterations)", formatCount(int(p.Iterations)))
+    case KDFArgon2id:
+        return fmt.Sprintf("Argon2id (%d MiB memory, %d passes, %d lanes)", p.Memory/1024, p.Time, p.Parallelism)
+    default:
+        return fmt.Sprintf("Unknown (%d)", p.Algorithm)
+    }
+}
+
+// DeriveKey derives a KeySize-byte key from password and salt
+func (p KDFParams) DeriveKey(password string, salt []byte) ([]byte, error) {
+    if err := p.Validate(); err != nil {
+        return nil, err
+    }
+
+    switch p.Algorithm {
+    case KDFLegacy:
+        return DeriveKey(password, salt, DefaultIterations), nil
+    case KDFArgon2id:
+        return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Parallelism, KeySize), nil
+    default:
+        return DeriveKey(password, salt, int(p.Iterations)), nil
+    }
+}
+
+// ParseKDFName maps a command-line KDF name to its algorithm identifier
+func ParseKDFName(name string) (uint8, error) {
+    switch strings.ToLower(name) {
+    case "pbkdf2", "pbkdf2-sha256":
+        return KDFPBKDF2SHA256, nil
+    case "argon2id", "argon2":
+        return KDFArgon2id, nil
+    default:
+        return 0, fmt.Errorf("unknown key derivation function: %s (use pbkdf2 or argon2id)", name)
+    }
+}
+
 // DeriveKey derives encryption key from password using PBKDF2
 func DeriveKey(password string, salt []byte, iterations int) []byte {
     if iterations <= 0 {
@@ -24,10 +199,140 @@ func CreateKeyDerivationParams() (*KeyDerivationParams, error) {
     if err != nil {
         return nil, err
     }
-    
+
     return &KeyDerivationParams{
         Salt:       salt,
         Iterations: DefaultIterations,
         KeyLength:  KeySize,
     }, nil
-}
\ No newline at end of file
+}
+
+// CalibrateKDF scales the cost of base so that one derivation takes roughly
+// target on the current machine. For PBKDF2 the iteration count is scaled;
+// for Argon2id memory and parallelism are kept and the pass count is scaled.
+// It returns the parameters and the measured duration of a derivation.
+func CalibrateKDF(base KDFParams, target time.Duration) (KDFParams, time.Duration, error) {
+    if target <= 0 {
+        return KDFParams{}, 0, fmt.Errorf("target time must be positive")
+    }
+
+    switch base.Algorithm {
+    case KDFPBKDF2SHA256:
+        return calibratePBKDF2(target)
+    case KDFArgon2id:
+        return calibrateArgon2id(base, target)
+    default:
+        return KDFParams{}, 0, fmt.Errorf("cannot calibrate key derivation function: %d", base.Algorithm)
+    }
+}
+
+// calibratePBKDF2 measures a probe run and scales the iteration count linearly
+func calibratePBKDF2(target time.Duration) (KDFParams, time.Duration, error) {
+    salt, err := GenerateSalt()
+    if err != nil {
+        return KDFParams{}, 0, err
+    }
+
+    // Grow the probe until it runs long enough to measure reliably
+    probe := MinIterations
+    var elapsed time.Duration
+    for {
+        start := time.Now()
+        DeriveKey("calibration", salt, probe)
+        elapsed = time.Since(start)
+        if elapsed >= 50*time.Millisecond || probe >= MaxIterations/2 {
+            break
+        }
+        probe *= 2
+    }
+
+    iterations := int(float64(probe) * float64(target) / float64(elapsed))
+    iterations = (iterations / 1000) * 1000
+    if iterations < MinIterations {
+        iterations = MinIterations
+    }
+    if iterations > MaxIterations {
+        iterations = MaxIterations
+    }
+
+    params := PBKDF2Params(iterations)
+    return params, measureKDF(params, salt), nil
+}
+
+// calibrateArgon2id measures one and three passes and extrapolates the pass
+// count, since a derivation has a fixed setup cost on top of the per-pass
+// cost. If a single pass already exceeds the target, memory is halved down
+// to the minimum.
+func calibrateArgon2id(base KDFParams, target time.Duration) (KDFParams, time.Duration, error) {
+    salt, err := GenerateSalt()
+    if err != nil {
+        return KDFParams{}, 0, err
+    }
+
+    params := base
+    if params.Memory == 0 {
+        params.Memory = DefaultArgon2Memory
+    }
+    if params.Parallelism == 0 {
+        params.Parallelism = DefaultArgon2Parallelism
+    }
+    params.Time = 1
+    if err := params.Validate(); err != nil {
+        return KDFParams{}, 0, err
+    }
+
+    // The first run pays for allocating the memory; measure a warm pass
+    measureKDF(params, salt)
+    onePass := measureKDF(params, salt)
+    for onePass > target && params.Memory/2 >= MinArgon2Memory {
+        params.Memory /= 2
+        onePass = measureKDF(params, salt)
+    }
+
+    params.Time = 3
+    perPass := (measureKDF(params, salt) - onePass) / 2
+    if perPass <= 0 {
+        perPass = onePass
+    }
+
+    passes := int64(1)
+    if target > onePass {
+        passes += int64(target-onePass) / int64(perPass)
+    }
+    if passes > MaxArgon2Time {
+        passes = MaxArgon2Time
+    }
+    params.Time = uint32(passes)
+
+    // Correct the estimate once against a full-length measurement
+    measured := measureKDF(params, salt)
+    passes = passes * int64(target) / int64(measured)
+    if passes < 1 {
+        passes = 1
+    }
+    if passes > MaxArgon2Time {
+        passes = MaxArgon2Time
+    }
+    if uint32(passes) == params.Time {
+        return params, measured, nil
+    }
+    params.Time = uint32(passes)
+
+    return params, measureKDF(params, salt), nil
+}
+
+// measureKDF times a single derivation with params
+func measureKDF(params KDFParams, salt []byte) time.Duration {
+    start := time.Now()
+    params.DeriveKey("calibration", salt)
+    return time.Since(start)
+}
+
+// formatCount formats n with thousands separators
+func formatCount(n int) string {
+    s := fmt.Sprintf("%d", n)
+    for i := len(s) - 3; i > 0; i -= 3 {
+        s = s[:i] + "," + s[i:]
+    }
+    return s
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// keyfileDomain separates keyfile key material from any other use of SHA-256
+const keyfileDomain = "FileVault keyfile v1"
+
+// ErrEmptyKeyfile is returned for keyfiles without content
+var ErrEmptyKeyfile = errors.New("keyfile is empty")
+
+// HashKeyfile hashes the whole content of a keyfile. Any file can serve as
+// a keyfile; only its bytes matter, not its name or location.
+func HashKeyfile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keyfile: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile %s: %w", path, err)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyKeyfile, path)
+	}
+	return hash.Sum(nil), nil
+}
+
+// KeyfileKey hashes keyfiles into a single 32-byte key. The order in which
+// the keyfiles are given does not matter.
+func KeyfileKey(paths []string) ([]byte, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no keyfiles given")
+	}
+
+	digests := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		digest, err := HashKeyfile(path)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	sort.Slice(digests, func(i, j int) bool { return bytes.Compare(digests[i], digests[j]) < 0 })
+
+	hash := sha256.New()
+	hash.Write([]byte(keyfileDomain))
+	for _, digest := range digests {
+		hash.Write(digest)
+	}
+	return hash.Sum(nil), nil
+}
+
+// CombineKeyfile mixes keyfile key material into password and returns the
+// secret to feed the KDF. Without keyfile material the password is returned
+// unchanged, so files without keyfiles derive the same key as before.
+func CombineKeyfile(password string, keyfileKey []byte) string {
+	if len(keyfileKey) == 0 {
+		return password
+	}
+
+	mac := hmac.New(sha256.New, keyfileKey)
+	mac.Write([]byte(keyfileDomain))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// WrappedKeySize is the size of a file key wrapped by WrapKey:
+// a random XChaCha20-Poly1305 nonce, the sealed key and its tag
+const WrappedKeySize = XNonceSize + KeySize + TagSize
+
+// GenerateFileKey returns a random key for encrypting a file's payload
+func GenerateFileKey() ([]byte, error) {
+	return GenerateRandomBytes(KeySize)
+}
+
+// WrapKey seals a file key under a key-encryption key. The wrapping cipher
+// is fixed, independent of the payload algorithm; additionalData binds the
+// wrapped key to the file and to the parameters of its key slot.
+func WrapKey(kek, key, additionalData []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidKeySize, len(key))
+	}
+
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize key wrapping: %w", err)
+	}
+
+	nonce, err := GenerateRandomBytes(XNonceSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, key, additionalData), nil
+}
+
+// UnwrapKey opens a key sealed by WrapKey. It fails with ErrDecryptionFailed
+// when kek or additionalData do not match.
+func UnwrapKey(kek, wrapped, additionalData []byte) ([]byte, error) {
+	if len(wrapped) != WrappedKeySize {
+		return nil, fmt.Errorf("invalid wrapped key size: %d", len(wrapped))
+	}
+
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize key wrapping: %w", err)
+	}
+
+	key, err := aead.Open(nil, wrapped[:XNonceSize], wrapped[XNonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return key, nil
+}
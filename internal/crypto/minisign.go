@@ -0,0 +1,422 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Signatures use the minisign format (https://jedisct1.github.io/minisign/),
+// so minisign and compatible tools can check them and we can check theirs.
+// Keys are Ed25519 with a random 8-byte key ID. Secret keys are encrypted
+// with scrypt and checked with BLAKE2b-256. Signatures are made over the
+// BLAKE2b-512 digest of the message ("ED"); legacy signatures over the
+// message itself ("Ed") are verified too. Each signature carries a trusted
+// comment, signed together with the signature, and an untrusted one.
+const (
+	MinisignKeyIDSize = 8
+
+	minisignEdDSA       = "Ed" // signature over the message
+	minisignHashedEdDSA = "ED" // signature over BLAKE2b-512(message)
+	minisignKDFScrypt   = "Sc"
+	minisignKDFNone     = "\x00\x00"
+	minisignChecksumB2  = "B2"
+
+	minisignPublicKeySize = 2 + MinisignKeyIDSize + ed25519.PublicKeySize
+	minisignSignatureSize = 2 + MinisignKeyIDSize + ed25519.SignatureSize
+	minisignKeynumSize    = MinisignKeyIDSize + ed25519.PrivateKeySize + blake2b.Size256
+	minisignSecretKeySize = 2 + 2 + 2 + 32 + 8 + 8 + minisignKeynumSize
+
+	// libsodium's "sensitive" scrypt limits, which minisign uses
+	minisignOpsLimit = 1 << 25
+	minisignMemLimit = 1 << 30
+
+	untrustedCommentPrefix = "untrusted comment: "
+	trustedCommentPrefix   = "trusted comment: "
+)
+
+// ErrSignatureInvalid is returned when a signature does not match
+var ErrSignatureInvalid = errors.New("signature verification failed")
+
+// MinisignPublicKey verifies minisign signatures
+type MinisignPublicKey struct {
+	ID  [MinisignKeyIDSize]byte
+	Key ed25519.PublicKey
+}
+
+// MinisignPrivateKey makes minisign signatures
+type MinisignPrivateKey struct {
+	ID  [MinisignKeyIDSize]byte
+	Key ed25519.PrivateKey
+}
+
+// MinisignSignature is a parsed .minisig file
+type MinisignSignature struct {
+	Algorithm        string // "ED" (prehashed) or "Ed"
+	KeyID            [MinisignKeyIDSize]byte
+	Signature        []byte
+	TrustedComment   string
+	UntrustedComment string
+	CommentSignature []byte // over Signature || TrustedComment
+}
+
+// FormatKeyID renders a key ID the way minisign prints it
+func FormatKeyID(id [MinisignKeyIDSize]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// GenerateMinisignKey creates a new signing key with a random key ID
+func GenerateMinisignKey() (*MinisignPrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+	}
+	private := &MinisignPrivateKey{Key: key}
+	if _, err := rand.Read(private.ID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	return private, nil
+}
+
+// Public returns the public key
+func (k *MinisignPrivateKey) Public() *MinisignPublicKey {
+	return &MinisignPublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// String returns the base64 line of the public key, as given to minisign -P
+func (k *MinisignPublicKey) String() string {
+	raw := make([]byte, 0, minisignPublicKeySize)
+	raw = append(raw, minisignEdDSA...)
+	raw = append(raw, k.ID[:]...)
+	raw = append(raw, k.Key...)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// MarshalText returns the contents of a minisign .pub file
+func (k *MinisignPublicKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%sminisign public key %s\n%s\n", untrustedCommentPrefix, FormatKeyID(k.ID), k)), nil
+}
+
+// ParseMinisignPublicKey parses a .pub file or its bare base64 line
+func ParseMinisignPublicKey(text string) (*MinisignPublicKey, error) {
+	line := strings.TrimSpace(text)
+	if strings.HasPrefix(line, untrustedCommentPrefix) {
+		lines := strings.SplitN(line, "\n", 3)
+		if len(lines) < 2 {
+			return nil, fmt.Errorf("invalid minisign public key: missing key line")
+		}
+		line = strings.TrimSpace(lines[1])
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != minisignPublicKeySize {
+		return nil, fmt.Errorf("invalid minisign public key")
+	}
+	if string(raw[:2]) != minisignEdDSA {
+		return nil, fmt.Errorf("unsupported minisign key algorithm %q", raw[:2])
+	}
+
+	key := &MinisignPublicKey{Key: ed25519.PublicKey(raw[2+MinisignKeyIDSize:])}
+	copy(key.ID[:], raw[2:])
+	return key, nil
+}
+
+// minisignScryptParams maps libsodium's opslimit and memlimit to scrypt's
+// N, r and p exactly as crypto_pwhash_scryptsalsa208sha256 does
+func minisignScryptParams(ops, mem uint64) (int, int, int) {
+	if ops < 32768 {
+		ops = 32768
+	}
+	r := uint64(8)
+	var maxN uint64
+	p := uint64(1)
+	if ops < mem/32 {
+		maxN = ops / (r * 4)
+	} else {
+		maxN = mem / (r * 128)
+	}
+
+	logN := uint64(1)
+	for ; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+
+	if ops >= mem/32 {
+		maxRP := (ops / 4) / (uint64(1) << logN)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = maxRP / r
+	}
+	return 1 << logN, int(r), int(p)
+}
+
+// minisignChecksum authenticates the key ID and secret key of a keynum
+func minisignChecksum(id, key []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(minisignEdDSA))
+	h.Write(id)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// xorMinisignKeynum encrypts or decrypts a keynum with the scrypt stream
+func xorMinisignKeynum(keynum, password, salt []byte, ops, mem uint64) error {
+	if ops > minisignOpsLimit || mem > minisignMemLimit {
+		return fmt.Errorf("secret key scrypt limits too high")
+	}
+	n, r, p := minisignScryptParams(ops, mem)
+	stream, err := scrypt.Key(password, salt, n, r, p, len(keynum))
+	if err != nil {
+		return fmt.Errorf("scrypt failed: %w", err)
+	}
+	defer SecureZero(stream)
+	subtle.XORBytes(keynum, keynum, stream)
+	return nil
+}
+
+// Marshal returns the contents of a minisign secret key file. The key
+// is encrypted under password, or stored unencrypted (like minisign -W)
+// when password is empty.
+func (k *MinisignPrivateKey) Marshal(password []byte) ([]byte, error) {
+	raw := make([]byte, 0, minisignSecretKeySize)
+	raw = append(raw, minisignEdDSA...)
+	kdf := minisignKDFScrypt
+	if len(password) == 0 {
+		kdf = minisignKDFNone
+	}
+	raw = append(raw, kdf...)
+	raw = append(raw, minisignChecksumB2...)
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	raw = append(raw, salt...)
+	raw = binary.LittleEndian.AppendUint64(raw, minisignOpsLimit)
+	raw = binary.LittleEndian.AppendUint64(raw, minisignMemLimit)
+
+	keynum := make([]byte, 0, minisignKeynumSize)
+	keynum = append(keynum, k.ID[:]...)
+	keynum = append(keynum, k.Key...)
+	keynum = append(keynum, minisignChecksum(k.ID[:], k.Key)...)
+	defer SecureZero(keynum)
+
+	comment := "minisign unencrypted secret key"
+	if len(password) > 0 {
+		comment = "minisign encrypted secret key"
+		if err := xorMinisignKeynum(keynum, password, salt, minisignOpsLimit, minisignMemLimit); err != nil {
+			return nil, err
+		}
+	}
+	raw = append(raw, keynum...)
+
+	return []byte(fmt.Sprintf("%s%s\n%s\n", untrustedCommentPrefix, comment, base64.StdEncoding.EncodeToString(raw))), nil
+}
+
+// ParseMinisignPrivateKey parses a minisign secret key file. passphrase is
+// only called if the key is encrypted and may be nil.
+func ParseMinisignPrivateKey(data []byte, passphrase SSHPassphraseFunc) (*MinisignPrivateKey, error) {
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, untrustedCommentPrefix) {
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = strings.TrimSpace(text[i+1:])
+		}
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(raw) != minisignSecretKeySize {
+		return nil, fmt.Errorf("invalid minisign secret key")
+	}
+	defer SecureZero(raw)
+	if string(raw[:2]) != minisignEdDSA || string(raw[4:6]) != minisignChecksumB2 {
+		return nil, fmt.Errorf("unsupported minisign secret key algorithm")
+	}
+
+	salt := raw[6:38]
+	ops := binary.LittleEndian.Uint64(raw[38:46])
+	mem := binary.LittleEndian.Uint64(raw[46:54])
+	keynum := raw[54:]
+
+	switch string(raw[2:4]) {
+	case minisignKDFNone:
+	case minisignKDFScrypt:
+		if passphrase == nil {
+			return nil, fmt.Errorf("secret key is encrypted")
+		}
+		secret, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key password: %w", err)
+		}
+		err = xorMinisignKeynum(keynum, secret, salt, ops, mem)
+		SecureZero(secret)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported minisign key derivation %q", raw[2:4])
+	}
+
+	id := keynum[:MinisignKeyIDSize]
+	key := keynum[MinisignKeyIDSize : MinisignKeyIDSize+ed25519.PrivateKeySize]
+	checksum := keynum[MinisignKeyIDSize+ed25519.PrivateKeySize:]
+	if subtle.ConstantTimeCompare(minisignChecksum(id, key), checksum) != 1 {
+		if string(raw[2:4]) == minisignKDFScrypt {
+			return nil, fmt.Errorf("wrong key password")
+		}
+		return nil, fmt.Errorf("invalid minisign secret key: checksum mismatch")
+	}
+
+	private := &MinisignPrivateKey{Key: ed25519.PrivateKey(bytes.Clone(key))}
+	copy(private.ID[:], id)
+	return private, nil
+}
+
+// NewMinisignHash returns the BLAKE2b-512 hash that prehashed signatures
+// are made over
+func NewMinisignHash() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// SignDigest signs the BLAKE2b-512 digest of a message. The trusted comment
+// is signed too; minisign puts "timestamp:<unix time>" and the file name in it.
+func (k *MinisignPrivateKey) SignDigest(digest []byte, trustedComment string) *MinisignSignature {
+	signature := k.SignPrehashed(digest)
+	return &MinisignSignature{
+		Algorithm:        minisignHashedEdDSA,
+		KeyID:            k.ID,
+		Signature:        signature,
+		TrustedComment:   trustedComment,
+		UntrustedComment: "signature from filevault secret key",
+		CommentSignature: ed25519.Sign(k.Key, append(bytes.Clone(signature), trustedComment...)),
+	}
+}
+
+// SignPrehashed returns the bare Ed25519 signature of a BLAKE2b-512
+// digest, as embedded in encrypted files
+func (k *MinisignPrivateKey) SignPrehashed(digest []byte) []byte {
+	return ed25519.Sign(k.Key, digest)
+}
+
+// Sign reads message to the end and signs its digest
+func (k *MinisignPrivateKey) Sign(message io.Reader, trustedComment string) (*MinisignSignature, error) {
+	h := NewMinisignHash()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return k.SignDigest(h.Sum(nil), trustedComment), nil
+}
+
+// MarshalText returns the contents of a .minisig file
+func (s *MinisignSignature) MarshalText() ([]byte, error) {
+	raw := make([]byte, 0, minisignSignatureSize)
+	raw = append(raw, s.Algorithm...)
+	raw = append(raw, s.KeyID[:]...)
+	raw = append(raw, s.Signature...)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s%s\n", untrustedCommentPrefix, s.UntrustedComment)
+	fmt.Fprintf(&buf, "%s\n", base64.StdEncoding.EncodeToString(raw))
+	fmt.Fprintf(&buf, "%s%s\n", trustedCommentPrefix, s.TrustedComment)
+	fmt.Fprintf(&buf, "%s\n", base64.StdEncoding.EncodeToString(s.CommentSignature))
+	return buf.Bytes(), nil
+}
+
+// ParseMinisignSignature parses the contents of a .minisig file
+func ParseMinisignSignature(data []byte) (*MinisignSignature, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 ||
+		!strings.HasPrefix(lines[0], untrustedCommentPrefix) ||
+		!strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return nil, fmt.Errorf("invalid minisign signature file")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != minisignSignatureSize {
+		return nil, fmt.Errorf("invalid minisign signature")
+	}
+	commentSignature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(commentSignature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid minisign comment signature")
+	}
+
+	s := &MinisignSignature{
+		Algorithm:        string(raw[:2]),
+		Signature:        raw[2+MinisignKeyIDSize:],
+		TrustedComment:   strings.TrimPrefix(lines[2], trustedCommentPrefix),
+		UntrustedComment: strings.TrimPrefix(lines[0], untrustedCommentPrefix),
+		CommentSignature: commentSignature,
+	}
+	copy(s.KeyID[:], raw[2:])
+	if s.Algorithm != minisignEdDSA && s.Algorithm != minisignHashedEdDSA {
+		return nil, fmt.Errorf("unsupported minisign signature algorithm %q", s.Algorithm)
+	}
+	return s, nil
+}
+
+// FindMinisignKey returns the key with the given ID, or nil
+func FindMinisignKey(keys []*MinisignPublicKey, id [MinisignKeyIDSize]byte) *MinisignPublicKey {
+	for _, key := range keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// VerifyDigest checks a signature made over the BLAKE2b-512 digest of a
+// message, without a trusted comment, as embedded in encrypted files
+func (k *MinisignPublicKey) VerifyDigest(id [MinisignKeyIDSize]byte, digest, signature []byte) error {
+	return k.verify(id, digest, signature)
+}
+
+// verify checks an Ed25519 signature by the key with the given ID
+func (k *MinisignPublicKey) verify(id [MinisignKeyIDSize]byte, signed, signature []byte) error {
+	if id != k.ID {
+		return fmt.Errorf("%w: signed by key %s, not %s", ErrSignatureInvalid, FormatKeyID(id), FormatKeyID(k.ID))
+	}
+	if !ed25519.Verify(k.Key, signed, signature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Verify checks s and its trusted comment against message, which is read
+// to the end
+func (k *MinisignPublicKey) Verify(s *MinisignSignature, message io.Reader) error {
+	var signed []byte
+	if s.Algorithm == minisignHashedEdDSA {
+		h := NewMinisignHash()
+		if _, err := io.Copy(h, message); err != nil {
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+		signed = h.Sum(nil)
+	} else {
+		var err error
+		if signed, err = io.ReadAll(message); err != nil {
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+	}
+
+	if err := k.verify(s.KeyID, signed, s.Signature); err != nil {
+		return err
+	}
+	if !ed25519.Verify(k.Key, append(bytes.Clone(s.Signature), s.TrustedComment...), s.CommentSignature) {
+		return fmt.Errorf("%w: trusted comment was modified", ErrSignatureInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Key slot types of public-key recipients. Password slots are type 1.
+const (
+	SlotX25519     uint8 = 2
+	SlotSSHEd25519 uint8 = 3
+	SlotSSHRSA     uint8 = 4
+
+	SlotMLKEM768X25519 uint8 = 5
+
+	SlotX509RSA  uint8 = 6
+	SlotX509ECDH uint8 = 7
+)
+
+// ErrIdentityMismatch is returned when a key slot was not wrapped for an identity
+var ErrIdentityMismatch = errors.New("key slot is not for this identity")
+
+// Recipient wraps file keys so that only the holder of the matching
+// identity can unwrap them
+type Recipient interface {
+	// SlotType is the key slot type the recipient produces
+	SlotType() uint8
+	// Wrap returns the key slot body holding fileKey; context is
+	// authenticated with it and must be given again to unwrap
+	Wrap(fileKey, context []byte) ([]byte, error)
+	// String returns the encoded public key
+	String() string
+}
+
+// Identity unwraps file keys wrapped for its recipient
+type Identity interface {
+	// Unwrap returns the file key from a key slot, or ErrIdentityMismatch
+	// if the slot was not wrapped for this identity
+	Unwrap(slotType uint8, body, context []byte) ([]byte, error)
+	// Recipient returns the public half of the identity
+	Recipient() Recipient
+}
+
+// Encoded key prefixes. Public keys are lower case so they are easy to
+// paste; identities are upper case so they stand out as secrets.
+const (
+	X25519RecipientPrefix = "fvpk1"
+	X25519IdentityPrefix  = "FVSK1"
+
+	x25519Label     = "FileVault X25519 v1"
+	keyChecksumSize = 4
+)
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// encodeKey renders key with prefix and a short checksum that catches
+// typos, in the letter case of the prefix
+func encodeKey(prefix string, key []byte) string {
+	sum := sha256.Sum256(append([]byte(prefix), key...))
+	encoded := keyEncoding.EncodeToString(append(append([]byte{}, key...), sum[:keyChecksumSize]...))
+	if prefix == strings.ToLower(prefix) {
+		encoded = strings.ToLower(encoded)
+	}
+	return prefix + encoded
+}
+
+// decodeKey parses a key encoded by encodeKey. Case is ignored.
+func decodeKey(prefix, s string, size int) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return nil, fmt.Errorf("not a %s key", prefix)
+	}
+
+	raw, err := keyEncoding.DecodeString(strings.ToUpper(s[len(prefix):]))
+	if err != nil || len(raw) != size+keyChecksumSize {
+		return nil, fmt.Errorf("malformed %s key", prefix)
+	}
+
+	key, checksum := raw[:size], raw[size:]
+	sum := sha256.Sum256(append([]byte(prefix), key...))
+	if !bytes.Equal(checksum, sum[:keyChecksumSize]) {
+		return nil, fmt.Errorf("%s key checksum mismatch (typo?)", prefix)
+	}
+	return key, nil
+}
+
+// X25519Recipient is a public key that file keys can be wrapped for
+type X25519Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// X25519Identity is the private key matching an X25519Recipient
+type X25519Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateX25519Identity creates a new random identity
+func GenerateX25519Identity() (*X25519Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &X25519Identity{key: key}, nil
+}
+
+// ParseX25519Recipient parses an encoded public key ("fvpk1...")
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	raw, err := decodeKey(X25519RecipientPrefix, s, 32)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 public key: %w", err)
+	}
+	return &X25519Recipient{key: key}, nil
+}
+
+// ParseX25519Identity parses an encoded private key ("FVSK1...")
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	raw, err := decodeKey(X25519IdentityPrefix, s, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer SecureZero(raw)
+
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 private key: %w", err)
+	}
+	return &X25519Identity{key: key}, nil
+}
+
+// ecdhKEK derives the key-encryption key from a shared secret, bound to
+// both public keys involved and to the slot type through label
+func ecdhKEK(label string, shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, label, KeySize)
+}
+
+// wrapECDH seals fileKey to a fresh ephemeral key on the recipient's curve.
+// The result is the ephemeral public key followed by the wrapped key.
+func wrapECDH(label string, recipient *ecdh.PublicKey, fileKey, context []byte) ([]byte, error) {
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	defer SecureZero(shared)
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	kek, err := ecdhKEK(label, shared, ephemeralPublic, recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer SecureZero(kek)
+
+	wrapped, err := WrapKey(kek, fileKey, append(append([]byte{}, context...), ephemeralPublic...))
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralPublic, wrapped...), nil
+}
+
+// unwrapECDH opens a body produced by wrapECDH with the private key
+func unwrapECDH(label string, key *ecdh.PrivateKey, body, context []byte) ([]byte, error) {
+	publicSize := len(key.PublicKey().Bytes())
+	if len(body) != publicSize+WrappedKeySize {
+		return nil, ErrIdentityMismatch
+	}
+
+	ephemeralPublic := body[:publicSize]
+	ephemeral, err := key.Curve().NewPublicKey(ephemeralPublic)
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+	defer SecureZero(shared)
+
+	kek, err := ecdhKEK(label, shared, ephemeralPublic, key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer SecureZero(kek)
+
+	fileKey, err := UnwrapKey(kek, body[publicSize:], append(append([]byte{}, context...), ephemeralPublic...))
+	if err != nil {
+		return nil, ErrIdentityMismatch
+	}
+	return fileKey, nil
+}
+
+// SlotType implements Recipient
+func (r *X25519Recipient) SlotType() uint8 {
+	return SlotX25519
+}
+
+// Wrap seals fileKey to a fresh ephemeral key agreed with the recipient.
+// The body is the ephemeral public key followed by the wrapped key.
+func (r *X25519Recipient) Wrap(fileKey, context []byte) ([]byte, error) {
+	return wrapECDH(x25519Label, r.key, fileKey, context)
+}
+
+// String returns the encoded public key
+func (r *X25519Recipient) String() string {
+	return encodeKey(X25519RecipientPrefix, r.key.Bytes())
+}
+
+// Unwrap implements Identity
+func (i *X25519Identity) Unwrap(slotType uint8, body, context []byte) ([]byte, error) {
+	if slotType != SlotX25519 {
+		return nil, ErrIdentityMismatch
+	}
+	return unwrapECDH(x25519Label, i.key, body, context)
+}
+
+// Recipient returns the public key of the identity
+func (i *X25519Identity) Recipient() Recipient {
+	return &X25519Recipient{key: i.key.PublicKey()}
+}
+
+// String returns the encoded private key
+func (i *X25519Identity) String() string {
+	return encodeKey(X25519IdentityPrefix, i.key.Bytes())
+}
+
+// ParseRecipient parses an encoded public key of any supported type: a
+// FileVault key ("fvpk1...", or "fvpq1..." for post-quantum hybrid keys)
+// or an SSH public key line as found in .pub and authorized_keys files
+func ParseRecipient(s string) (Recipient, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToLower(s), X25519RecipientPrefix) {
+		return ParseX25519Recipient(s)
+	}
+	if strings.HasPrefix(strings.ToLower(s), HybridRecipientPrefix) {
+		return ParseHybridRecipient(s)
+	}
+	if strings.Contains(s, "ssh-") {
+		return ParseSSHRecipient(s)
+	}
+	return nil, fmt.Errorf("unknown recipient type: %q", truncateKey(s))
+}
+
+// ParseIdentities reads identities from an identity file: one encoded
+// private key per line; blank lines and lines starting with # are ignored
+func ParseIdentities(r io.Reader) ([]Identity, error) {
+	var identities []Identity
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var identity Identity
+		var err error
+		switch upper := strings.ToUpper(text); {
+		case strings.HasPrefix(upper, X25519IdentityPrefix):
+			identity, err = ParseX25519Identity(text)
+		case strings.HasPrefix(upper, HybridIdentityPrefix):
+			identity, err = ParseHybridIdentity(text)
+		default:
+			return nil, fmt.Errorf("line %d: unknown identity type", line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read identities: %w", err)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no identities found")
+	}
+	return identities, nil
+}
+
+// truncateKey shortens a key for error messages without echoing all of it
+func truncateKey(s string) string {
+	if len(s) > 12 {
+		return s[:12] + "..."
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package crypto
+
+import "encoding/binary"
+
+// SegmentNonce derives the nonce for one payload segment of a streaming file.
+//
+// The segment index is XORed into bytes [n-9:n-1] of the per-file base nonce
+// and the last byte carries the final-segment flag. Every segment therefore
+// gets a unique nonce, and a segment cannot be moved to another position or
+// presented as the last one without failing authentication.
+func SegmentNonce(base []byte, index uint64, final bool) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], index)
+	offset := len(nonce) - 9
+	for i := range counter {
+		nonce[offset+i] ^= counter[i]
+	}
+
+	if final {
+		nonce[len(nonce)-1] ^= 0x01
+	}
+
+	return nonce
+}
+
+// MetadataNonce derives the nonce for the encrypted metadata block. It sets
+// a bit of the last byte that SegmentNonce never touches, so it cannot
+// collide with any segment nonce of the same file.
+func MetadataNonce(base []byte) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	nonce[len(nonce)-1] ^= 0x02
+	return nonce
+}
@@ -0,0 +1,152 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Shamir secret sharing over GF(2^8) with the AES polynomial. Each byte of
+// the secret is the constant term of a random polynomial of degree
+// threshold-1; share x holds the value of every polynomial at x, and any
+// threshold shares recover the secret by Lagrange interpolation at zero.
+// Fewer shares reveal nothing about it.
+const (
+	// SharePrefix starts an encoded share; upper case like other secrets
+	SharePrefix = "FVSHARE1"
+	// ShareFileIDSize is the size of the file identifier in a share
+	ShareFileIDSize = 8
+	// MaxShares is the number of distinct non-zero x coordinates
+	MaxShares = 255
+)
+
+// ErrNotEnoughShares is returned when fewer shares than the threshold are combined
+var ErrNotEnoughShares = errors.New("not enough shares")
+
+// Share is one share of a file key split by SplitSecret
+type Share struct {
+	Threshold uint8                 // shares needed to recover the key
+	Index     uint8                 // x coordinate, 1 to MaxShares
+	FileID    [ShareFileIDSize]byte // the file the key belongs to
+	Value     []byte
+}
+
+// gfMul multiplies in GF(2^8) without data-dependent branches or tables
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse a^254; zero maps to zero
+func gfInv(a byte) byte {
+	square := gfMul(a, a)
+	inverse := square
+	for range 6 {
+		square = gfMul(square, square)
+		inverse = gfMul(inverse, square)
+	}
+	return inverse
+}
+
+// SplitSecret splits secret into count shares, any threshold of which
+// recover it. Shares are numbered from 1.
+func SplitSecret(secret []byte, threshold, count int) ([]*Share, error) {
+	if threshold < 2 || threshold > count || count > MaxShares {
+		return nil, fmt.Errorf("invalid threshold %d of %d: need 2 <= threshold <= shares <= %d", threshold, count, MaxShares)
+	}
+
+	// coefficients[i] holds the random higher coefficients for byte i
+	coefficients, err := GenerateRandomBytes(len(secret) * (threshold - 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate shares: %w", err)
+	}
+	defer SecureZero(coefficients)
+
+	shares := make([]*Share, count)
+	for s := range shares {
+		x := byte(s + 1)
+		share := &Share{Threshold: uint8(threshold), Index: x, Value: make([]byte, len(secret))}
+		for i, constant := range secret {
+			// Horner's rule, highest coefficient first
+			var y byte
+			poly := coefficients[i*(threshold-1) : (i+1)*(threshold-1)]
+			for j := len(poly) - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ poly[j]
+			}
+			share.Value[i] = gfMul(y, x) ^ constant
+		}
+		shares[s] = share
+	}
+	return shares, nil
+}
+
+// CombineShares recovers the secret from at least Threshold distinct shares
+// of the same file
+func CombineShares(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 || len(shares) < int(shares[0].Threshold) {
+		return nil, ErrNotEnoughShares
+	}
+	shares = shares[:shares[0].Threshold]
+
+	size := len(shares[0].Value)
+	for i, share := range shares {
+		if share.Index == 0 || len(share.Value) != size || share.FileID != shares[0].FileID || share.Threshold != shares[0].Threshold {
+			return nil, fmt.Errorf("invalid share %d", share.Index)
+		}
+		for _, other := range shares[:i] {
+			if other.Index == share.Index {
+				return nil, fmt.Errorf("duplicate share %d", share.Index)
+			}
+		}
+	}
+
+	secret := make([]byte, size)
+	for i, share := range shares {
+		// Lagrange basis polynomial of share i at zero
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other.Index, gfInv(other.Index^share.Index)))
+			}
+		}
+		for k, y := range share.Value {
+			secret[k] ^= gfMul(y, basis)
+		}
+	}
+	return secret, nil
+}
+
+// String encodes the share with a checksum, for share cards
+func (s *Share) String() string {
+	payload := make([]byte, 0, 2+ShareFileIDSize+len(s.Value))
+	payload = append(payload, s.Threshold, s.Index)
+	payload = append(payload, s.FileID[:]...)
+	return encodeKey(SharePrefix, append(payload, s.Value...))
+}
+
+// ParseShare decodes a share encoded by String. Spaces, line breaks and
+// dashes are ignored, so it can be typed back from a card in groups.
+func ParseShare(text string) (*Share, error) {
+	text = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, text)
+
+	raw, err := decodeKey(SharePrefix, text, 2+ShareFileIDSize+KeySize)
+	if err != nil {
+		return nil, err
+	}
+	s := &Share{Threshold: raw[0], Index: raw[1], Value: raw[2+ShareFileIDSize:]}
+	copy(s.FileID[:], raw[2:])
+	if s.Threshold < 2 || s.Index == 0 {
+		return nil, fmt.Errorf("invalid share %d of threshold %d", s.Index, s.Threshold)
+	}
+	return s, nil
+}
@@ -0,0 +1,291 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH recipients wrap file keys for existing SSH keys. Ed25519 keys are
+// converted to their X25519 form and used like FileVault identities; RSA
+// keys encrypt the file key with RSA-OAEP-SHA256. Both slot bodies start
+// with a short tag of the SSH public key so an identity can skip slots
+// that are not its own without trying them.
+const (
+	sshEd25519Label = "FileVault ssh-ed25519 v1"
+	sshRSALabel     = "FileVault ssh-rsa v1"
+	sshTagSize      = 4
+
+	// MinSSHRSABits is the smallest RSA key accepted as a recipient
+	MinSSHRSABits = 2048
+)
+
+// SSHPassphraseFunc is called for the passphrase of an encrypted private key
+type SSHPassphraseFunc func() ([]byte, error)
+
+// sshTag identifies an SSH public key inside a slot body
+func sshTag(key ssh.PublicKey) []byte {
+	sum := sha256.Sum256(key.Marshal())
+	return sum[:sshTagSize]
+}
+
+// SSHEd25519Recipient wraps file keys for an ssh-ed25519 public key
+type SSHEd25519Recipient struct {
+	sshKey ssh.PublicKey
+	key    *ecdh.PublicKey
+}
+
+// SSHRSARecipient wraps file keys for an ssh-rsa public key
+type SSHRSARecipient struct {
+	sshKey ssh.PublicKey
+	key    *rsa.PublicKey
+}
+
+// ParseSSHRecipient parses an SSH public key line, e.g. the contents of
+// id_ed25519.pub or one line of authorized_keys (options are allowed)
+func ParseSSHRecipient(line string) (Recipient, error) {
+	sshKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH public key: %w", err)
+	}
+	return newSSHRecipient(sshKey)
+}
+
+// newSSHRecipient builds the recipient for a parsed SSH public key
+func newSSHRecipient(sshKey ssh.PublicKey) (Recipient, error) {
+	cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH key type: %s", sshKey.Type())
+	}
+
+	switch key := cryptoKey.CryptoPublicKey().(type) {
+	case ed25519.PublicKey:
+		converted, err := ed25519PublicToX25519(key)
+		if err != nil {
+			return nil, err
+		}
+		return &SSHEd25519Recipient{sshKey: sshKey, key: converted}, nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < MinSSHRSABits {
+			return nil, fmt.Errorf("SSH RSA key too small: %d bits (minimum %d)", key.N.BitLen(), MinSSHRSABits)
+		}
+		return &SSHRSARecipient{sshKey: sshKey, key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type: %s (use ssh-ed25519 or ssh-rsa)", sshKey.Type())
+	}
+}
+
+// fieldPrime is 2^255 - 19, the prime of the Curve25519 field
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// ed25519PublicToX25519 maps an Edwards point to the Montgomery u
+// coordinate of the same point: u = (1 + y) / (1 - y)
+func ed25519PublicToX25519(key ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+
+	// y is little-endian with the sign of x in the top bit
+	le := bytes.Clone(key)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+
+	one := big.NewInt(1)
+	denominator := new(big.Int).Sub(one, y)
+	denominator.Mod(denominator, fieldPrime)
+	if denominator.Sign() == 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, new(big.Int).ModInverse(denominator, fieldPrime))
+	u.Mod(u, fieldPrime)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return ecdh.X25519().NewPublicKey(reverse(out))
+}
+
+// ed25519PrivateToX25519 derives the X25519 scalar an Ed25519 key signs
+// with, which matches ed25519PublicToX25519 of its public key
+func ed25519PrivateToX25519(key ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(key.Seed())
+	defer SecureZero(h[:])
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// reverse returns b in reverse byte order, in place
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// SlotType implements Recipient
+func (r *SSHEd25519Recipient) SlotType() uint8 {
+	return SlotSSHEd25519
+}
+
+// Wrap seals fileKey like an X25519 recipient; the body starts with the
+// key tag, which is also authenticated
+func (r *SSHEd25519Recipient) Wrap(fileKey, context []byte) ([]byte, error) {
+	tag := sshTag(r.sshKey)
+	body, err := wrapECDH(sshEd25519Label, r.key, fileKey, append(append([]byte{}, context...), tag...))
+	if err != nil {
+		return nil, err
+	}
+	return append(tag, body...), nil
+}
+
+// String returns the public key in authorized_keys format
+func (r *SSHEd25519Recipient) String() string {
+	return string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(r.sshKey)))
+}
+
+// SlotType implements Recipient
+func (r *SSHRSARecipient) SlotType() uint8 {
+	return SlotSSHRSA
+}
+
+// Wrap encrypts fileKey with RSA-OAEP-SHA256 after the key tag
+func (r *SSHRSARecipient) Wrap(fileKey, context []byte) ([]byte, error) {
+	sealed, err := wrapRSA(sshRSALabel, r.key, fileKey, context)
+	if err != nil {
+		return nil, err
+	}
+	return append(sshTag(r.sshKey), sealed...), nil
+}
+
+// wrapRSA encrypts fileKey with RSA-OAEP-SHA256; the OAEP label binds it to
+// the slot type and to context
+func wrapRSA(label string, key *rsa.PublicKey, fileKey, context []byte) ([]byte, error) {
+	sealed, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, fileKey, append([]byte(label), context...))
+	if err != nil {
+		return nil, fmt.Errorf("RSA encryption failed: %w", err)
+	}
+	return sealed, nil
+}
+
+// unwrapRSA opens a body produced by wrapRSA
+func unwrapRSA(label string, key *rsa.PrivateKey, body, context []byte) ([]byte, error) {
+	fileKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, body, append([]byte(label), context...))
+	if err != nil || len(fileKey) != KeySize {
+		return nil, ErrIdentityMismatch
+	}
+	return fileKey, nil
+}
+
+// String returns the public key in authorized_keys format
+func (r *SSHRSARecipient) String() string {
+	return string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(r.sshKey)))
+}
+
+// SSHIdentity unwraps file keys wrapped for an SSH public key
+type SSHIdentity struct {
+	sshKey    ssh.PublicKey
+	recipient Recipient
+	x25519    *ecdh.PrivateKey // set for Ed25519 keys
+	rsa       *rsa.PrivateKey  // set for RSA keys
+}
+
+// parsePrivateKey parses a private key in OpenSSH, PKCS#1, PKCS#8 or SEC 1
+// PEM format, asking passphrase for encrypted keys. Ed25519 keys are
+// returned as values.
+func parsePrivateKey(pemBytes []byte, passphrase SSHPassphraseFunc) (interface{}, error) {
+	raw, err := ssh.ParseRawPrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == nil {
+			return nil, fmt.Errorf("private key is encrypted")
+		}
+		secret, perr := passphrase()
+		if perr != nil {
+			return nil, fmt.Errorf("failed to get key passphrase: %w", perr)
+		}
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, secret)
+		SecureZero(secret)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, fmt.Errorf("wrong key passphrase")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenSSH keys come back as a pointer, PKCS#8 keys as a value
+	if key, ok := raw.(*ed25519.PrivateKey); ok {
+		raw = *key
+	}
+	return raw, nil
+}
+
+// ParseSSHIdentity parses an SSH private key in OpenSSH or PEM format.
+// passphrase is only called if the key is encrypted and may be nil.
+func ParseSSHIdentity(pemBytes []byte, passphrase SSHPassphraseFunc) (*SSHIdentity, error) {
+	raw, err := parsePrivateKey(pemBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH private key: %w", err)
+	}
+	return newSSHIdentity(raw)
+}
+
+// newSSHIdentity builds the SSH identity of a parsed private key
+func newSSHIdentity(raw interface{}) (*SSHIdentity, error) {
+	var err error
+
+	identity := &SSHIdentity{}
+	switch key := raw.(type) {
+	case ed25519.PrivateKey:
+		identity.x25519, err = ed25519PrivateToX25519(key)
+		if err == nil {
+			identity.sshKey, err = ssh.NewPublicKey(key.Public())
+		}
+	case *rsa.PrivateKey:
+		identity.rsa = key
+		identity.sshKey, err = ssh.NewPublicKey(&key.PublicKey)
+	default:
+		return nil, fmt.Errorf("unsupported SSH private key type %T (use ed25519 or rsa)", raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH private key: %w", err)
+	}
+
+	identity.recipient, err = newSSHRecipient(identity.sshKey)
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// Unwrap implements Identity
+func (i *SSHIdentity) Unwrap(slotType uint8, body, context []byte) ([]byte, error) {
+	tag := sshTag(i.sshKey)
+	if len(body) < sshTagSize || !bytes.Equal(body[:sshTagSize], tag) {
+		return nil, ErrIdentityMismatch
+	}
+
+	switch {
+	case slotType == SlotSSHEd25519 && i.x25519 != nil:
+		return unwrapECDH(sshEd25519Label, i.x25519, body[sshTagSize:], append(append([]byte{}, context...), tag...))
+	case slotType == SlotSSHRSA && i.rsa != nil:
+		return unwrapRSA(sshRSALabel, i.rsa, body[sshTagSize:], context)
+	default:
+		return nil, ErrIdentityMismatch
+	}
+}
+
+// Recipient returns the SSH public key of the identity
+func (i *SSHIdentity) Recipient() Recipient {
+	return i.recipient
+}
@@ -21,7 +21,8 @@ const (
 
 // Algorithm constants
 const (
-	AlgorithmAES256GCM = 1 // AES-256-GCM algorithm identifier
+	AlgorithmAES256GCM         = 1 // AES-256-GCM algorithm identifier
+	AlgorithmXChaCha20Poly1305 = 2 // XChaCha20-Poly1305 algorithm identifier
 )
 
 // EncryptedData represents encrypted data with metadata
@@ -1,7 +1,9 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
+	"os"
 )
 
 // ErrorCode represents different types of errors
@@ -12,7 +14,7 @@ const (
 	ErrUnknown ErrorCode = iota
 	ErrInvalidArguments
 	ErrInvalidConfig
-	
+
 	// File operation errors
 	ErrFileNotFound
 	ErrFileAlreadyExists
@@ -21,7 +23,7 @@ const (
 	ErrFileWriteError
 	ErrFileCorrupted
 	ErrFileTooLarge
-	
+
 	// Cryptographic errors
 	ErrInvalidPassword
 	ErrWeakPassword
@@ -31,7 +33,7 @@ const (
 	ErrDecryptionFailed
 	ErrInvalidFormat
 	ErrUnsupportedVersion
-	
+
 	// Security errors
 	ErrInvalidInput
 	ErrSecurityViolation
@@ -69,7 +71,7 @@ func (e *FileVaultError) GetExitCode() int {
 		return 3
 	case ErrInvalidPassword, ErrAuthenticationFailed:
 		return 4
-	case ErrFileCorrupted, ErrInvalidFormat:
+	case ErrFileCorrupted, ErrInvalidFormat, ErrUnsupportedVersion:
 		return 5
 	case ErrFileTooLarge, ErrMemoryError:
 		return 6
@@ -109,7 +111,7 @@ func (e *FileVaultError) GetSuggestions() []string {
 	if len(e.Suggestions) > 0 {
 		return e.Suggestions
 	}
-	
+
 	switch e.Code {
 	case ErrFileNotFound:
 		return []string{
@@ -190,6 +192,31 @@ func NewCorruptedFileError(filename string, cause error) *FileVaultError {
 	return err
 }
 
+// NewHeaderCorruptedError creates an error for a header whose checksum does not match.
+// It is reported before any key derivation, so it never means a wrong password.
+func NewHeaderCorruptedError(filename string, cause error) *FileVaultError {
+	err := NewError(ErrFileCorrupted, fmt.Sprintf("file header is corrupted: %s", filename), cause)
+	err.Context["filename"] = filename
+	err.Suggestions = []string{
+		"The header checksum does not match, so the password was not checked",
+		"Restore the file from a backup copy",
+		"Run 'filevault verify' to check other copies of the file",
+	}
+	return err
+}
+
+// NewUnsupportedVersionError creates an error for a file written in a newer
+// format version, or with format features this build does not understand
+func NewUnsupportedVersionError(filename string, cause error) *FileVaultError {
+	err := NewError(ErrUnsupportedVersion, fmt.Sprintf("unsupported file format: %s", filename), cause)
+	err.Context["filename"] = filename
+	err.Suggestions = []string{
+		"The file was created by a newer FileVault release",
+		"Update FileVault and try again ('filevault version' shows this build)",
+	}
+	return err
+}
+
 // NewInvalidFormatError creates an invalid format error
 func NewInvalidFormatError(filename string) *FileVaultError {
 	err := NewError(ErrInvalidFormat, fmt.Sprintf("invalid file format: %s", filename), nil)
@@ -216,21 +243,23 @@ func GetErrorCode(err error) ErrorCode {
 	return ErrUnknown
 }
 
-// HandleError provides centralized error handling and user feedback
+// HandleError provides centralized error handling and user feedback. It
+// reports on stderr, since stdout may carry data in a pipeline.
 func HandleError(err error, quiet bool) int {
 	if err == nil {
 		return 0
 	}
 
-	if fvErr, ok := err.(*FileVaultError); ok {
+	var fvErr *FileVaultError
+	if stderrors.As(err, &fvErr) {
 		if !quiet {
-			fmt.Printf("❌ %s\n", fvErr.GetUserFriendlyMessage())
-			
+			fmt.Fprintf(os.Stderr, "❌ %s\n", fvErr.GetUserFriendlyMessage())
+
 			suggestions := fvErr.GetSuggestions()
 			if len(suggestions) > 0 {
-				fmt.Println("\nSuggestions:")
+				fmt.Fprintln(os.Stderr, "\nSuggestions:")
 				for _, suggestion := range suggestions {
-					fmt.Printf("  • %s\n", suggestion)
+					fmt.Fprintf(os.Stderr, "  • %s\n", suggestion)
 				}
 			}
 		}
@@ -239,7 +268,7 @@ func HandleError(err error, quiet bool) int {
 
 	// Handle regular errors
 	if !quiet {
-		fmt.Printf("❌ Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
 	}
 	return 1
-}
\ No newline at end of file
+}
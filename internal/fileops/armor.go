@@ -0,0 +1,216 @@
+package fileops
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// ASCII armor. An armored file is the binary file in Base64 between BEGIN
+// and END lines, like PEM, so that it survives email, tickets and chat:
+//
+//	-----BEGIN FILEVAULT ENCRYPTED FILE-----
+//	RlZMVAQAAAAB...
+//	=Tq2bXw
+//	-----END FILEVAULT ENCRYPTED FILE-----
+//
+// The line before END is "=" and the Base64 CRC-32C of the binary data, so
+// that text damaged in transit is reported as such rather than as a wrong
+// password. Readers skip any text before BEGIN and ignore line lengths,
+// blank lines and trailing spaces.
+const (
+	ArmorBegin = "-----BEGIN FILEVAULT ENCRYPTED FILE-----"
+	ArmorEnd   = "-----END FILEVAULT ENCRYPTED FILE-----"
+
+	// ArmorLineLength is the number of Base64 characters per line written
+	ArmorLineLength = 64
+)
+
+// Errors for armored input
+var (
+	ErrArmorInvalid  = errors.New("invalid ASCII armor")
+	ErrArmorChecksum = errors.New("ASCII armor checksum mismatch (the text was changed in transit)")
+)
+
+// LooksArmored reports whether data, the start of a file, holds an armor
+// BEGIN line
+func LooksArmored(data []byte) bool {
+	return !bytes.HasPrefix(data, []byte(MagicBytes)) && bytes.Contains(data, []byte(ArmorBegin))
+}
+
+// armorChecksum encodes the checksum line
+func armorChecksum(sum uint32) string {
+	var raw [4]byte
+	binary.BigEndian.PutUint32(raw[:], sum)
+	return "=" + base64.RawStdEncoding.EncodeToString(raw[:])
+}
+
+// armorWriter encodes everything written to it as ASCII armor
+type armorWriter struct {
+	w       io.Writer
+	lines   *lineWriter
+	encoder io.WriteCloser
+	crc     uint32
+	begun   bool
+}
+
+// NewArmorWriter returns a writer that writes armor to w. Close writes the
+// checksum and END lines; it does not close w.
+func NewArmorWriter(w io.Writer) io.WriteCloser {
+	lines := &lineWriter{w: w}
+	return &armorWriter{w: w, lines: lines, encoder: base64.NewEncoder(base64.StdEncoding, lines)}
+}
+
+func (a *armorWriter) begin() error {
+	if a.begun {
+		return nil
+	}
+	a.begun = true
+	_, err := io.WriteString(a.w, ArmorBegin+"\n")
+	return err
+}
+
+func (a *armorWriter) Write(p []byte) (int, error) {
+	if err := a.begin(); err != nil {
+		return 0, err
+	}
+	a.crc = crc32.Update(a.crc, castagnoli, p)
+	return a.encoder.Write(p)
+}
+
+func (a *armorWriter) Close() error {
+	if err := a.begin(); err != nil {
+		return err
+	}
+	if err := a.encoder.Close(); err != nil {
+		return err
+	}
+	if a.lines.column > 0 {
+		if _, err := io.WriteString(a.w, "\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(a.w, armorChecksum(a.crc)+"\n"+ArmorEnd+"\n")
+	return err
+}
+
+// lineWriter breaks Base64 text into lines of ArmorLineLength characters
+type lineWriter struct {
+	w      io.Writer
+	column int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), ArmorLineLength-l.column)
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.column += n
+		p = p[n:]
+		if l.column == ArmorLineLength {
+			if _, err := io.WriteString(l.w, "\n"); err != nil {
+				return written, err
+			}
+			l.column = 0
+		}
+	}
+	return written, nil
+}
+
+// armorReader decodes ASCII armor
+type armorReader struct {
+	r       *bufio.Reader
+	pending []byte // Base64 characters not decoded yet, fewer than four
+	out     []byte // decoded bytes not returned yet
+	crc     uint32
+	begun   bool
+	sum     string
+	err     error
+}
+
+// NewArmorReader returns a reader of the binary data armored in r. It stops
+// at the END line, so armor pasted into a terminal needs no Ctrl-D. The
+// checksum is checked at the END line: a mismatch is returned instead of
+// io.EOF after the data.
+func NewArmorReader(r io.Reader) io.Reader {
+	return &armorReader{r: bufio.NewReader(r)}
+}
+
+func (a *armorReader) Read(p []byte) (int, error) {
+	for len(a.out) == 0 && a.err == nil {
+		a.err = a.next()
+	}
+	if len(a.out) > 0 {
+		n := copy(p, a.out)
+		a.out = a.out[n:]
+		return n, nil
+	}
+	return 0, a.err
+}
+
+// next reads one line and decodes what it can; it returns io.EOF after a
+// valid END line
+func (a *armorReader) next() error {
+	line, err := a.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		if !a.begun {
+			return fmt.Errorf("%w: no %s line", ErrArmorInvalid, ArmorBegin)
+		}
+		return fmt.Errorf("%w: truncated, no %s line", ErrArmorInvalid, ArmorEnd)
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	line = strings.TrimSpace(line)
+
+	switch {
+	case !a.begun:
+		a.begun = line == ArmorBegin
+		return nil
+	case line == "":
+		return nil
+	case line == ArmorEnd:
+		return a.end()
+	case a.sum != "":
+		return fmt.Errorf("%w: data after the checksum line", ErrArmorInvalid)
+	case strings.HasPrefix(line, "=") && len(line) == len(armorChecksum(0)):
+		// Base64 padding reflowed onto a line of its own is shorter
+		a.sum = line
+		return nil
+	}
+
+	a.pending = append(a.pending, line...)
+	whole := len(a.pending) / 4 * 4
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(whole))
+	n, err := base64.StdEncoding.Decode(decoded, a.pending[:whole])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrArmorInvalid, err)
+	}
+	a.pending = append(a.pending[:0], a.pending[whole:]...)
+	a.crc = crc32.Update(a.crc, castagnoli, decoded[:n])
+	a.out = decoded[:n]
+	return nil
+}
+
+// end checks the checksum once the END line is read
+func (a *armorReader) end() error {
+	if len(a.pending) != 0 {
+		return fmt.Errorf("%w: truncated Base64", ErrArmorInvalid)
+	}
+	if a.sum == "" {
+		return fmt.Errorf("%w: no checksum line", ErrArmorInvalid)
+	}
+	if a.sum != armorChecksum(a.crc) {
+		return ErrArmorChecksum
+	}
+	return io.EOF
+}
@@ -0,0 +1,78 @@
+package fileops
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// attributeModeMask selects the mode bits recorded for a file
+const attributeModeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// FileAttributes are the file system attributes of the original file. They
+// are kept in the encrypted metadata block, so they are authenticated with
+// the file and only visible to someone who can unlock it.
+type FileAttributes struct {
+	Mode       uint32            `json:"mode"`            // permission, setuid, setgid and sticky bits (os.FileMode)
+	ModTime    int64             `json:"mtime"`           // nanoseconds since the Unix epoch
+	AccessTime int64             `json:"atime,omitempty"` // nanoseconds since the Unix epoch
+	UID        *int              `json:"uid,omitempty"`
+	GID        *int              `json:"gid,omitempty"`
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"` // Linux extended attributes
+}
+
+// ReadAttributes records the attributes of the file at path. The owner is
+// only recorded when owner is set. Access times, owners and extended
+// attributes are only read on Linux.
+func ReadAttributes(path string, owner bool) (*FileAttributes, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file attributes: %w", err)
+	}
+
+	attrs := &FileAttributes{
+		Mode:    uint32(info.Mode() & attributeModeMask),
+		ModTime: info.ModTime().UnixNano(),
+	}
+	if err := readPlatformAttributes(path, info, attrs, owner); err != nil {
+		return nil, fmt.Errorf("failed to read file attributes: %w", err)
+	}
+	return attrs, nil
+}
+
+// FileMode returns the recorded mode bits
+func (a *FileAttributes) FileMode() os.FileMode {
+	return os.FileMode(a.Mode) & attributeModeMask
+}
+
+// Modified returns the recorded modification time
+func (a *FileAttributes) Modified() time.Time {
+	return time.Unix(0, a.ModTime)
+}
+
+// Accessed returns the recorded access time, or the modification time if
+// none was recorded
+func (a *FileAttributes) Accessed() time.Time {
+	if a.AccessTime == 0 {
+		return a.Modified()
+	}
+	return time.Unix(0, a.AccessTime)
+}
+
+// Apply restores the attributes on the file at path. Owners and extended
+// attributes the process is not allowed to set, such as another user's
+// UID or trusted.* attributes without root, are skipped like tar does.
+func (a *FileAttributes) Apply(path string) error {
+	// Before chmod: chown clears setuid bits and setting user.* attributes
+	// needs write permission
+	if err := applyPlatformAttributes(path, a); err != nil {
+		return fmt.Errorf("failed to restore file attributes: %w", err)
+	}
+	if err := os.Chmod(path, a.FileMode()); err != nil {
+		return fmt.Errorf("failed to restore file mode: %w", err)
+	}
+	if err := os.Chtimes(path, a.Accessed(), a.Modified()); err != nil {
+		return fmt.Errorf("failed to restore file times: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package fileops
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readPlatformAttributes adds the access time, owner and extended
+// attributes from the stat result and the file at path
+func readPlatformAttributes(path string, info os.FileInfo, attrs *FileAttributes, owner bool) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		attrs.AccessTime = time.Unix(stat.Atim.Unix()).UnixNano()
+		if owner {
+			uid, gid := int(stat.Uid), int(stat.Gid)
+			attrs.UID, attrs.GID = &uid, &gid
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	attrs.Xattrs = xattrs
+	return nil
+}
+
+// readXattrs returns the extended attributes of the file at path, or nil
+// if it has none or the file system does not support them
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Listxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	list := make([]byte, size)
+	size, err = unix.Listxattr(path, list)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		size, err := unix.Getxattr(path, name, nil)
+		if errors.Is(err, unix.ENODATA) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		size, err = unix.Getxattr(path, name, value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value[:size]
+	}
+	return xattrs, nil
+}
+
+// applyPlatformAttributes restores the extended attributes and owner
+func applyPlatformAttributes(path string, attrs *FileAttributes) error {
+	names := make([]string, 0, len(attrs.Xattrs))
+	for name := range attrs.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := unix.Setxattr(path, name, attrs.Xattrs[name], 0)
+		if err != nil && !errors.Is(err, unix.EPERM) && !errors.Is(err, unix.ENOTSUP) {
+			return err
+		}
+	}
+
+	if attrs.UID == nil && attrs.GID == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if attrs.UID != nil {
+		uid = *attrs.UID
+	}
+	if attrs.GID != nil {
+		gid = *attrs.GID
+	}
+	if err := os.Chown(path, uid, gid); err != nil && !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	return nil
+}
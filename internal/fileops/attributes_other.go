@@ -0,0 +1,17 @@
+//go:build !linux
+
+package fileops
+
+import "os"
+
+// readPlatformAttributes records nothing beyond the mode and modification
+// time outside Linux
+func readPlatformAttributes(path string, info os.FileInfo, attrs *FileAttributes, owner bool) error {
+	return nil
+}
+
+// applyPlatformAttributes restores nothing beyond the mode and times
+// outside Linux
+func applyPlatformAttributes(path string, attrs *FileAttributes) error {
+	return nil
+}
@@ -1,18 +1,77 @@
 package fileops
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// ErrHeaderCorrupted is returned when the stored header checksum does not match
+// the header fields, which means the header was damaged rather than the
+// password being wrong.
+var ErrHeaderCorrupted = errors.New("header corrupted: checksum mismatch")
+
+// Errors for files written by a newer FileVault
+var (
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	ErrUnsupportedFeature = errors.New("unsupported format feature")
+)
+
+// Feature flags stored in reserved bytes [4:8]. A flag marks a feature that
+// a reader must understand to decrypt the file; readers reject any flag
+// outside SupportedFlags instead of misinterpreting the file.
+const (
+	// FlagEncryptedMetadata: the filename is not in the header; a metadata
+	// block encrypted under the file key follows the header instead (v3+)
+	FlagEncryptedMetadata uint32 = 1 << 0
+	// FlagCompressed: the plaintext was compressed before encryption with
+	// the algorithm recorded in reserved bytes [26:28]
+	FlagCompressed uint32 = 1 << 1
+	// FlagKeyfile: the key was derived with keyfiles mixed into the secret
+	FlagKeyfile uint32 = 1 << 2
+	// FlagNoPassword: the key was derived from keyfiles alone (requires FlagKeyfile)
+	FlagNoPassword uint32 = 1 << 3
+	// FlagKeySlots: the payload key is random and wrapped in the key slot
+	// area that follows the header; the factors are recorded per slot (v4+)
+	FlagKeySlots uint32 = 1 << 4
+	// FlagSigned: the file ends with a signature trailer (see SignatureTrailer)
+	FlagSigned uint32 = 1 << 5
+	// FlagPadded: the plaintext is followed by zeros up to the PADMÉ size
+	// recorded as OriginalSize; the real size is in the metadata block
+	FlagPadded uint32 = 1 << 6
+	// FlagRecovery: the file ends with a Reed-Solomon recovery record
+	// covering everything before it (see RecoveryFooter)
+	FlagRecovery uint32 = 1 << 7
+	// FlagStreamed: the size was unknown when the header was written, e.g.
+	// for stdin; OriginalSize is zero and the payload ends with its final
+	// segment
+	FlagStreamed uint32 = 1 << 8
+	// FlagArchive: the plaintext is a tar archive of a directory tree, which
+	// decrypt extracts
+	FlagArchive uint32 = 1 << 9
+
+	SupportedFlags = FlagEncryptedMetadata | FlagCompressed | FlagKeyfile | FlagNoPassword | FlagKeySlots | FlagSigned | FlagPadded | FlagRecovery | FlagStreamed | FlagArchive
 )
 
 // FileVault binary format constants
 const (
-	MagicBytes         = "FVLT"
-	FormatVersion      = 1
-	AlgorithmAES256GCM = 1
+	MagicBytes      = "FVLT"
+	FormatVersionV1 = 1 // whole payload sealed as a single GCM message
+	FormatVersionV2 = 2 // payload split into authenticated segments
+	FormatVersionV3 = 3 // v2 plus an optional encrypted metadata block
+	FormatVersionV4 = 4 // v3 plus key slots wrapping a random file key
+	FormatVersion   = FormatVersionV4
+
+	AlgorithmAES256GCM         = 1
+	AlgorithmXChaCha20Poly1305 = 2
 
 	MagicSize          = 4
 	VersionSize        = 4
@@ -29,6 +88,10 @@ const (
 		ReservedSize + ChecksumSize
 
 	AuthTagSize = 16
+
+	// Segment sizes for the v2 streaming format (plaintext bytes per segment)
+	DefaultSegmentSize = 64 * 1024
+	MaxSegmentSize     = 16 * 1024 * 1024
 )
 
 // FileHeader represents the FileVault file header
@@ -58,13 +121,19 @@ func NewFileHeader(originalSize uint64, fileName string, salt [32]byte, iv [16]b
 	}
 
 	copy(header.Magic[:], []byte(MagicBytes))
-	header.calculateChecksum()
+	header.SetSegmentSize(DefaultSegmentSize)
+	header.UpdateChecksum()
 
 	return header
 }
 
-// calculateChecksum calculates and sets the header checksum
-func (h *FileHeader) calculateChecksum() {
+// UpdateChecksum recalculates the header checksum after fields were changed
+func (h *FileHeader) UpdateChecksum() {
+	h.Checksum = h.computeChecksum()
+}
+
+// computeChecksum hashes every header field except the checksum itself
+func (h *FileHeader) computeChecksum() [16]byte {
 	hasher := sha256.New()
 
 	hasher.Write(h.Magic[:])
@@ -77,8 +146,28 @@ func (h *FileHeader) calculateChecksum() {
 	hasher.Write([]byte(h.FileName))
 	hasher.Write(h.Reserved[:])
 
-	hash := hasher.Sum(nil)
-	copy(h.Checksum[:], hash[:16])
+	var checksum [16]byte
+	copy(checksum[:], hasher.Sum(nil))
+	return checksum
+}
+
+// VerifyChecksum checks the stored checksum against the header fields
+func (h *FileHeader) VerifyChecksum() error {
+	expected := h.computeChecksum()
+	if subtle.ConstantTimeCompare(expected[:], h.Checksum[:]) != 1 {
+		return ErrHeaderCorrupted
+	}
+	return nil
+}
+
+// MarshalBinary returns the serialized header exactly as it is written to disk.
+// These bytes are bound as associated data to every encrypted segment.
+func (h *FileHeader) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // IsValid checks if the header is valid
@@ -87,13 +176,204 @@ func (h *FileHeader) IsValid() error {
 		return fmt.Errorf("invalid magic number")
 	}
 
-	if h.Version != FormatVersion {
-		return fmt.Errorf("unsupported version: %d", h.Version)
+	switch h.Version {
+	case FormatVersionV1:
+	case FormatVersionV2, FormatVersionV3, FormatVersionV4:
+		segmentSize := h.SegmentSize()
+		if segmentSize == 0 || segmentSize > MaxSegmentSize {
+			return fmt.Errorf("invalid segment size: %d", segmentSize)
+		}
+	default:
+		return unsupportedVersion(h.Version)
+	}
+
+	if unknown := h.Flags() &^ SupportedFlags; unknown != 0 {
+		return fmt.Errorf("%w: flags 0x%08x (this file needs a newer FileVault)", ErrUnsupportedFeature, unknown)
+	}
+
+	if h.HasFlag(FlagEncryptedMetadata) {
+		if h.Version < FormatVersionV3 {
+			return fmt.Errorf("encrypted metadata requires format version %d", FormatVersionV3)
+		}
+		if h.FileNameLength != 0 {
+			return fmt.Errorf("header stores a filename although metadata is encrypted")
+		}
+	}
+
+	// The compression bytes are only meaningful with the flag set
+	if h.HasFlag(FlagCompressed) != h.Compression().Enabled() {
+		return fmt.Errorf("compression flag does not match compression parameters")
+	}
+	if h.HasFlag(FlagCompressed) {
+		if !h.IsStreaming() {
+			return fmt.Errorf("compression requires format version %d", FormatVersionV2)
+		}
+		if err := h.Compression().Validate(); err != nil {
+			return fmt.Errorf("invalid compression parameters: %w", err)
+		}
+	}
+
+	if h.HasFlag(FlagNoPassword) && !h.HasFlag(FlagKeyfile) {
+		return fmt.Errorf("header requires neither a password nor a keyfile")
+	}
+	if h.HasFlag(FlagKeySlots) {
+		if h.Version < FormatVersionV4 {
+			return fmt.Errorf("key slots require format version %d", FormatVersionV4)
+		}
+		if h.Flags()&(FlagKeyfile|FlagNoPassword) != 0 {
+			return fmt.Errorf("header records factors although they are kept in key slots")
+		}
+	}
+	if h.HasFlag(FlagSigned) && !h.HasKeySlots() {
+		return fmt.Errorf("embedded signatures require format version %d", FormatVersionV4)
+	}
+	if h.HasFlag(FlagPadded) {
+		if !h.HasFlag(FlagEncryptedMetadata) {
+			return fmt.Errorf("padded files require an encrypted metadata block")
+		}
+		if h.HasFlag(FlagCompressed) {
+			return fmt.Errorf("padding cannot be combined with compression")
+		}
+		if PadmeSize(h.OriginalSize) != h.OriginalSize {
+			return fmt.Errorf("padded size %d is not a PADMÉ size", h.OriginalSize)
+		}
+	}
+	if h.HasFlag(FlagStreamed) {
+		if !h.HasKeySlots() {
+			return fmt.Errorf("streamed files require format version %d", FormatVersionV4)
+		}
+		if h.HasFlag(FlagPadded) || h.OriginalSize != 0 {
+			return fmt.Errorf("streamed files record no size")
+		}
+	}
+
+	if _, err := crypto.LookupAlgorithm(h.Algorithm); err != nil {
+		return fmt.Errorf("unsupported algorithm: %w", err)
+	}
+	if h.Version == FormatVersionV1 && h.Algorithm != AlgorithmAES256GCM {
+		return fmt.Errorf("algorithm %d requires format version %d", h.Algorithm, FormatVersionV2)
+	}
+
+	if err := h.KDFParams().Validate(); err != nil {
+		return fmt.Errorf("invalid key derivation parameters: %w", err)
 	}
 
 	return nil
 }
 
+// SegmentSize returns the plaintext segment size of a v2 file.
+// It is stored in the first four bytes of the reserved area.
+func (h *FileHeader) SegmentSize() uint32 {
+	return binary.LittleEndian.Uint32(h.Reserved[0:4])
+}
+
+// SetSegmentSize sets the plaintext segment size and refreshes the checksum
+func (h *FileHeader) SetSegmentSize(size uint32) {
+	binary.LittleEndian.PutUint32(h.Reserved[0:4], size)
+	h.UpdateChecksum()
+}
+
+// Flags returns the feature flags stored in reserved bytes [4:8]
+func (h *FileHeader) Flags() uint32 {
+	return binary.LittleEndian.Uint32(h.Reserved[4:8])
+}
+
+// HasFlag reports whether every bit of flag is set
+func (h *FileHeader) HasFlag(flag uint32) bool {
+	return h.Flags()&flag == flag
+}
+
+// SetFlag sets or clears flag and refreshes the checksum
+func (h *FileHeader) SetFlag(flag uint32, on bool) {
+	flags := h.Flags()
+	if on {
+		flags |= flag
+	} else {
+		flags &^= flag
+	}
+	binary.LittleEndian.PutUint32(h.Reserved[4:8], flags)
+	h.UpdateChecksum()
+}
+
+// Factors returns the factors the file key was derived from. Files with
+// key slots record factors per slot instead.
+func (h *FileHeader) Factors() Factors {
+	var factors Factors
+	if !h.HasFlag(FlagNoPassword) {
+		factors |= FactorPassword
+	}
+	if h.HasFlag(FlagKeyfile) {
+		factors |= FactorKeyfile
+	}
+	return factors
+}
+
+// HasKeySlots reports whether the payload key is wrapped in key slots
+func (h *FileHeader) HasKeySlots() bool {
+	return h.HasFlag(FlagKeySlots)
+}
+
+// NeedsUpgrade reports whether the file uses an older format than FormatVersion
+func (h *FileHeader) NeedsUpgrade() bool {
+	return h.Version < FormatVersion
+}
+
+// KDFParams returns the key derivation parameters recorded in the header.
+// They live in reserved bytes [8:18]; v1 files leave them zero, which
+// decodes as the legacy PBKDF2 default.
+func (h *FileHeader) KDFParams() crypto.KDFParams {
+	var descriptor [crypto.KDFDescriptorSize]byte
+	copy(descriptor[:], h.Reserved[8:8+crypto.KDFDescriptorSize])
+	return crypto.DecodeKDFParams(descriptor)
+}
+
+// SetKDFParams records the key derivation parameters and refreshes the checksum
+func (h *FileHeader) SetKDFParams(params crypto.KDFParams) {
+	descriptor := params.Encode()
+	copy(h.Reserved[8:8+crypto.KDFDescriptorSize], descriptor[:])
+	h.UpdateChecksum()
+}
+
+// BaseNonce returns the first size bytes of the per-file nonce that segment
+// nonces are derived from. The nonce is the IV followed by an 8-byte
+// extension in reserved bytes [18:26], so up to crypto.MaxNonceSize bytes
+// are available; AES-256-GCM uses the first 12 bytes of the IV.
+func (h *FileHeader) BaseNonce(size int) []byte {
+	nonce := make([]byte, 0, crypto.MaxNonceSize)
+	nonce = append(nonce, h.IV[:]...)
+	nonce = append(nonce, h.Reserved[18:26]...)
+	return nonce[:size]
+}
+
+// SetBaseNonce stores a per-file nonce of up to crypto.MaxNonceSize bytes
+// and refreshes the checksum
+func (h *FileHeader) SetBaseNonce(nonce []byte) {
+	h.IV = [16]byte{}
+	clear(h.Reserved[18:26])
+	n := copy(h.IV[:], nonce)
+	copy(h.Reserved[18:26], nonce[n:])
+	h.UpdateChecksum()
+}
+
+// Compression returns the compression applied to the plaintext, stored as
+// algorithm and level in reserved bytes [26:28]
+func (h *FileHeader) Compression() compression.Params {
+	return compression.Params{Algorithm: h.Reserved[26], Level: h.Reserved[27]}
+}
+
+// SetCompression records the compression parameters, sets FlagCompressed
+// accordingly and refreshes the checksum
+func (h *FileHeader) SetCompression(params compression.Params) {
+	h.Reserved[26] = params.Algorithm
+	h.Reserved[27] = params.Level
+	h.SetFlag(FlagCompressed, params.Enabled())
+}
+
+// IsStreaming reports whether the payload uses the segmented v2 layout
+func (h *FileHeader) IsStreaming() bool {
+	return h.Version >= FormatVersionV2
+}
+
 // GetTotalSize returns the total header size
 func (h *FileHeader) GetTotalSize() int {
 	return BaseHeaderSize + len(h.FileName)
@@ -164,6 +444,28 @@ func (h *FileHeader) WriteTo(w io.Writer) (int64, error) {
 	return bytesWritten, nil
 }
 
+// headerDecoder reads the part of a header that follows the magic and version
+type headerDecoder func(h *FileHeader, r io.Reader) (int64, error)
+
+// headerDecoders holds one decoder per format version this build can read.
+// v1 to v4 share the fixed layout; a future layout adds its own decoder
+// here, and older builds reject it by version before parsing anything else.
+var headerDecoders = map[uint32]headerDecoder{
+	FormatVersionV1: (*FileHeader).readFixedLayout,
+	FormatVersionV2: (*FileHeader).readFixedLayout,
+	FormatVersionV3: (*FileHeader).readFixedLayout,
+	FormatVersionV4: (*FileHeader).readFixedLayout,
+}
+
+// unsupportedVersion describes a version that has no decoder
+func unsupportedVersion(version uint32) error {
+	if version > FormatVersion {
+		return fmt.Errorf("%w: file uses format v%d, this FileVault reads up to v%d; please update FileVault",
+			ErrUnsupportedVersion, version, FormatVersion)
+	}
+	return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+}
+
 // ReadFrom reads the header from an io.Reader
 func (h *FileHeader) ReadFrom(r io.Reader) (int64, error) {
 	bytesRead := int64(0)
@@ -173,11 +475,37 @@ func (h *FileHeader) ReadFrom(r io.Reader) (int64, error) {
 	}
 	bytesRead += MagicSize
 
+	if string(h.Magic[:]) != MagicBytes {
+		return bytesRead, fmt.Errorf("invalid magic number")
+	}
+
 	if err := binary.Read(r, binary.LittleEndian, &h.Version); err != nil {
 		return bytesRead, fmt.Errorf("failed to read version: %w", err)
 	}
 	bytesRead += VersionSize
 
+	decode, ok := headerDecoders[h.Version]
+	if !ok {
+		return bytesRead, unsupportedVersion(h.Version)
+	}
+
+	n, err := decode(h, r)
+	bytesRead += n
+	if err != nil {
+		return bytesRead, err
+	}
+
+	if err := h.VerifyChecksum(); err != nil {
+		return bytesRead, err
+	}
+
+	return bytesRead, nil
+}
+
+// readFixedLayout decodes the v1-v4 header fields after the version
+func (h *FileHeader) readFixedLayout(r io.Reader) (int64, error) {
+	bytesRead := int64(0)
+
 	if err := binary.Read(r, binary.LittleEndian, &h.Algorithm); err != nil {
 		return bytesRead, fmt.Errorf("failed to read algorithm: %w", err)
 	}
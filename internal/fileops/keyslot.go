@@ -0,0 +1,318 @@
+package fileops
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// Key slots (format v4). The payload is encrypted under a random file key;
+// each key slot holds that key wrapped under one set of credentials. The
+// slots live in an area of fixed capacity right after the header that is
+// not covered by the payload's associated data, so slots can be added,
+// changed or removed by rewriting the area alone.
+//
+// Area layout: capacity (uint32 LE), then capacity bytes holding slots back
+// to back as type (1 byte), length (uint16 LE) and body, padded with zeros.
+// A zero type byte ends the list.
+const (
+	KeySlotAreaLengthSize = 4
+	KeySlotHeaderSize     = 3
+
+	// DefaultKeySlotCapacity leaves room for several password slots
+	DefaultKeySlotCapacity = 1024
+	// MaxKeySlotCapacity bounds the area read from a file
+	MaxKeySlotCapacity = 1 << 20
+	// MaxKeySlots bounds the number of slots in one file
+	MaxKeySlots = 64
+)
+
+// Key slot types
+const (
+	KeySlotEnd      uint8 = 0
+	KeySlotPassword uint8 = 1                 // password and/or keyfiles through a KDF
+	KeySlotX25519   uint8 = crypto.SlotX25519 // file key sealed to an X25519 public key
+
+	KeySlotSSHEd25519 uint8 = crypto.SlotSSHEd25519 // sealed to an ssh-ed25519 key
+	KeySlotSSHRSA     uint8 = crypto.SlotSSHRSA     // RSA-OAEP to an ssh-rsa key
+
+	KeySlotHybrid uint8 = crypto.SlotMLKEM768X25519 // post-quantum ML-KEM-768 + X25519
+
+	KeySlotX509RSA  uint8 = crypto.SlotX509RSA  // RSA-OAEP to a certificate key
+	KeySlotX509ECDH uint8 = crypto.SlotX509ECDH // ECDH with a certificate key
+
+	KeySlotShare uint8 = 8 // a Shamir share of the file key, wrapped by an inner slot
+)
+
+// ErrKeySlotAreaFull is returned when slots no longer fit the area capacity
+var ErrKeySlotAreaFull = errors.New("key slot area is full")
+
+// Factors is the set of secrets a key slot, or an older file, requires
+type Factors uint8
+
+// Unlock factors
+const (
+	FactorPassword Factors = 1 << 0
+	FactorKeyfile  Factors = 1 << 1
+	FactorIdentity Factors = 1 << 2 // the private key of a recipient slot
+)
+
+// RequiresPassword reports whether a password is part of the set
+func (f Factors) RequiresPassword() bool {
+	return f&FactorPassword != 0
+}
+
+// RequiresKeyfile reports whether keyfiles are part of the set
+func (f Factors) RequiresKeyfile() bool {
+	return f&FactorKeyfile != 0
+}
+
+// RequiresIdentity reports whether the set is a recipient's private key
+func (f Factors) RequiresIdentity() bool {
+	return f&FactorIdentity != 0
+}
+
+// String describes the set, e.g. "password + keyfile"
+func (f Factors) String() string {
+	switch f {
+	case FactorPassword:
+		return "password"
+	case FactorKeyfile:
+		return "keyfile"
+	case FactorPassword | FactorKeyfile:
+		return "password + keyfile"
+	case FactorIdentity:
+		return "identity"
+	default:
+		return fmt.Sprintf("unknown (0x%02x)", uint8(f))
+	}
+}
+
+// KeySlot is one encoded slot; Data is interpreted according to Type
+type KeySlot struct {
+	Type uint8
+	Data []byte
+}
+
+// KeySlotArea is the list of key slots stored after a v4 header
+type KeySlotArea struct {
+	Capacity uint32
+	Slots    []KeySlot
+}
+
+// Used returns the number of bytes the slots take up inside the area
+func (a *KeySlotArea) Used() int {
+	used := 0
+	for _, slot := range a.Slots {
+		used += KeySlotHeaderSize + len(slot.Data)
+	}
+	return used
+}
+
+// Size returns the number of bytes the area occupies in the file
+func (a *KeySlotArea) Size() int64 {
+	return KeySlotAreaLengthSize + int64(a.Capacity)
+}
+
+// Fits reports whether the slots fit the capacity
+func (a *KeySlotArea) Fits() bool {
+	return a.Used() <= int(a.Capacity)
+}
+
+// Grow raises the capacity to the next multiple of DefaultKeySlotCapacity
+// that holds every slot
+func (a *KeySlotArea) Grow() {
+	for !a.Fits() {
+		a.Capacity += DefaultKeySlotCapacity
+	}
+}
+
+// MarshalBinary encodes the area, padded to its capacity
+func (a *KeySlotArea) MarshalBinary() ([]byte, error) {
+	if len(a.Slots) > MaxKeySlots {
+		return nil, fmt.Errorf("too many key slots: %d (max %d)", len(a.Slots), MaxKeySlots)
+	}
+	if a.Capacity > MaxKeySlotCapacity {
+		return nil, fmt.Errorf("key slot area too large: %d bytes", a.Capacity)
+	}
+	if !a.Fits() {
+		return nil, fmt.Errorf("%w: %d bytes needed, %d available", ErrKeySlotAreaFull, a.Used(), a.Capacity)
+	}
+
+	buf := make([]byte, a.Size())
+	binary.LittleEndian.PutUint32(buf, a.Capacity)
+
+	pos := KeySlotAreaLengthSize
+	for _, slot := range a.Slots {
+		if slot.Type == KeySlotEnd || len(slot.Data) > 0xffff {
+			return nil, fmt.Errorf("invalid key slot of type %d", slot.Type)
+		}
+		buf[pos] = slot.Type
+		binary.LittleEndian.PutUint16(buf[pos+1:], uint16(len(slot.Data)))
+		pos += KeySlotHeaderSize
+		pos += copy(buf[pos:], slot.Data)
+	}
+
+	return buf, nil
+}
+
+// ReadKeySlotArea reads a key slot area from r, positioned just after the header
+func ReadKeySlotArea(r io.Reader) (*KeySlotArea, error) {
+	var length [KeySlotAreaLengthSize]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, fmt.Errorf("failed to read key slots: %w", err)
+	}
+
+	area := &KeySlotArea{Capacity: binary.LittleEndian.Uint32(length[:])}
+	if area.Capacity > MaxKeySlotCapacity {
+		return nil, fmt.Errorf("invalid key slot area size: %d", area.Capacity)
+	}
+
+	buf := make([]byte, area.Capacity)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("failed to read key slots: %w", err)
+	}
+
+	for len(buf) > 0 && buf[0] != KeySlotEnd {
+		if len(buf) < KeySlotHeaderSize {
+			return nil, fmt.Errorf("key slot area is corrupted")
+		}
+		size := int(binary.LittleEndian.Uint16(buf[1:3]))
+		if len(buf) < KeySlotHeaderSize+size || len(area.Slots) == MaxKeySlots {
+			return nil, fmt.Errorf("key slot area is corrupted")
+		}
+		area.Slots = append(area.Slots, KeySlot{
+			Type: buf[0],
+			Data: buf[KeySlotHeaderSize : KeySlotHeaderSize+size],
+		})
+		buf = buf[KeySlotHeaderSize+size:]
+	}
+
+	return area, nil
+}
+
+// PasswordSlot wraps the file key under a key derived from a password,
+// keyfiles or both with its own KDF parameters and salt
+type PasswordSlot struct {
+	Factors    Factors
+	KDF        crypto.KDFParams
+	Salt       [SaltSize]byte
+	WrappedKey []byte
+}
+
+// passwordSlotParamsSize is the size of the body before the wrapped key
+const passwordSlotParamsSize = 1 + crypto.KDFDescriptorSize + SaltSize
+
+// Params returns the encoded slot parameters; they are authenticated
+// together with the header when the file key is wrapped
+func (s *PasswordSlot) Params() []byte {
+	params := make([]byte, 0, passwordSlotParamsSize)
+	params = append(params, byte(s.Factors))
+	kdf := s.KDF.Encode()
+	params = append(params, kdf[:]...)
+	return append(params, s.Salt[:]...)
+}
+
+// KeySlot encodes the slot
+func (s *PasswordSlot) KeySlot() KeySlot {
+	return KeySlot{Type: KeySlotPassword, Data: append(s.Params(), s.WrappedKey...)}
+}
+
+// ParsePasswordSlot decodes a slot of type KeySlotPassword
+func ParsePasswordSlot(slot KeySlot) (*PasswordSlot, error) {
+	if slot.Type != KeySlotPassword || len(slot.Data) != passwordSlotParamsSize+crypto.WrappedKeySize {
+		return nil, fmt.Errorf("invalid password key slot")
+	}
+
+	s := &PasswordSlot{Factors: Factors(slot.Data[0])}
+	var kdf [crypto.KDFDescriptorSize]byte
+	copy(kdf[:], slot.Data[1:])
+	s.KDF = crypto.DecodeKDFParams(kdf)
+	copy(s.Salt[:], slot.Data[1+crypto.KDFDescriptorSize:])
+	s.WrappedKey = slot.Data[passwordSlotParamsSize:]
+
+	if s.Factors == 0 || s.Factors&^(FactorPassword|FactorKeyfile) != 0 {
+		return nil, fmt.Errorf("invalid key slot factors: 0x%02x", uint8(s.Factors))
+	}
+	if err := s.KDF.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid key slot: %w", err)
+	}
+	return s, nil
+}
+
+// ShareSlot holds one Shamir share of the file key instead of the key
+// itself. The share is wrapped by an inner password or recipient slot;
+// Threshold of these slots must be opened to decrypt the file.
+type ShareSlot struct {
+	Threshold uint8
+	Index     uint8 // x coordinate of the share
+	Inner     KeySlot
+}
+
+// shareSlotParamsSize is the size of the body before the inner slot body
+const shareSlotParamsSize = 3
+
+// Params returns the encoded threshold, index and inner slot type; they
+// are authenticated together with the header when the share is wrapped
+func (s *ShareSlot) Params() []byte {
+	return []byte{s.Threshold, s.Index, s.Inner.Type}
+}
+
+// KeySlot encodes the slot
+func (s *ShareSlot) KeySlot() KeySlot {
+	return KeySlot{Type: KeySlotShare, Data: append(s.Params(), s.Inner.Data...)}
+}
+
+// ParseShareSlot decodes a slot of type KeySlotShare
+func ParseShareSlot(slot KeySlot) (*ShareSlot, error) {
+	if slot.Type != KeySlotShare || len(slot.Data) < shareSlotParamsSize {
+		return nil, fmt.Errorf("invalid share key slot")
+	}
+	s := &ShareSlot{
+		Threshold: slot.Data[0],
+		Index:     slot.Data[1],
+		Inner:     KeySlot{Type: slot.Data[2], Data: slot.Data[shareSlotParamsSize:]},
+	}
+	if s.Threshold < 2 || s.Index == 0 || (s.Inner.Type != KeySlotPassword && !IsRecipientSlot(s.Inner.Type)) {
+		return nil, fmt.Errorf("invalid share key slot")
+	}
+	return s, nil
+}
+
+// IsRecipientSlot reports whether slots of type t are opened by an identity
+func IsRecipientSlot(t uint8) bool {
+	switch t {
+	case KeySlotX25519, KeySlotSSHEd25519, KeySlotSSHRSA, KeySlotHybrid,
+		KeySlotX509RSA, KeySlotX509ECDH:
+		return true
+	default:
+		return false
+	}
+}
+
+// KeySlotTypeName returns a display name for a slot type
+func KeySlotTypeName(t uint8) string {
+	switch t {
+	case KeySlotPassword:
+		return "password"
+	case KeySlotX25519:
+		return "x25519"
+	case KeySlotSSHEd25519:
+		return "ssh-ed25519"
+	case KeySlotSSHRSA:
+		return "ssh-rsa"
+	case KeySlotHybrid:
+		return "mlkem768-x25519"
+	case KeySlotX509RSA:
+		return "x509-rsa"
+	case KeySlotX509ECDH:
+		return "x509-ecdh"
+	case KeySlotShare:
+		return "share"
+	default:
+		return fmt.Sprintf("unknown (%d)", t)
+	}
+}
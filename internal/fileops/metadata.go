@@ -0,0 +1,41 @@
+package fileops
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// MaxMetadataSize bounds the encrypted metadata block read from a file
+const MaxMetadataSize = 1 << 20
+
+// Metadata holds identifying information about the original file. Files with
+// FlagEncryptedMetadata keep it in an encrypted block after the header, so it
+// is only visible to someone who knows the password. Only that block
+// records the file attributes.
+type Metadata struct {
+	FileName   string          `json:"name,omitempty"`
+	Size       uint64          `json:"size,omitempty"` // real size of a padded payload (FlagPadded)
+	Attributes *FileAttributes `json:"attrs,omitempty"`
+}
+
+// MarshalBinary encodes the metadata for the encrypted block
+func (m *Metadata) MarshalBinary() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// UnmarshalBinary decodes a metadata block
+func (m *Metadata) UnmarshalBinary(data []byte) error {
+	if err := json.Unmarshal(data, m); err != nil {
+		return fmt.Errorf("invalid metadata block: %w", err)
+	}
+	return nil
+}
+
+// GetBaseFileName returns the base of the stored filename
+func (m *Metadata) GetBaseFileName() string {
+	if m.FileName == "" {
+		return ""
+	}
+	return filepath.Base(m.FileName)
+}
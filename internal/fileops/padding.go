@@ -0,0 +1,18 @@
+package fileops
+
+import "math/bits"
+
+// PadmeSize returns the size a payload of size bytes is padded to with the
+// PADMÉ scheme (Nikitin et al., "Reducing Metadata Leakage from Encrypted
+// Files and Communication with PURBs", 2019). Sizes are rounded up so that
+// only the top bits of the length remain, O(log log size) bits of
+// information leak, and the overhead stays below 12%.
+func PadmeSize(size uint64) uint64 {
+	if size < 2 {
+		return size
+	}
+	exponent := bits.Len64(size) - 1            // floor(log2(size))
+	significant := bits.Len64(uint64(exponent)) // floor(log2(exponent)) + 1
+	mask := uint64(1)<<(exponent-significant) - 1
+	return (size + mask) &^ mask
+}
@@ -0,0 +1,216 @@
+package fileops
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Recovery records. A file with FlagRecovery ends with Reed-Solomon parity
+// over everything before it, header and signature included, so that bit
+// rot can be repaired without the password. The protected bytes are split
+// into RecoveryBlockSize blocks; with S stripes, block i belongs to stripe
+// i mod S, so a burst of neighbouring damaged blocks is spread over many
+// stripes. Each stripe of DataShards blocks gets ParityShards parity
+// blocks and survives as many damaged blocks.
+//
+// The record is a copy of the footer, the parity blocks stripe by stripe,
+// a CRC-32C table of every data and parity block stored twice, and the
+// footer, which readers find at the end of the file.
+const (
+	RecoveryMagic      = "FVRR"
+	RecoveryVersion    = 1
+	RecoveryBlockSize  = 4096
+	RecoveryFooterSize = 32
+
+	// MaxRecoveryShards is the Reed-Solomon limit on data plus parity blocks
+	MaxRecoveryShards = 256
+	// MaxRecoveryPercent allows as much parity as data
+	MaxRecoveryPercent = 100
+)
+
+// Errors for missing or unreadable recovery records
+var (
+	ErrNoRecoveryRecord      = errors.New("file has no recovery record")
+	ErrRecoveryFooterInvalid = errors.New("recovery record footer is damaged")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// BlockChecksum returns the CRC-32C recorded for a block
+func BlockChecksum(block []byte) uint32 {
+	return crc32.Checksum(block, castagnoli)
+}
+
+// RecoveryFooter describes the recovery record at the end of a file
+type RecoveryFooter struct {
+	Percent       uint8  // requested redundancy, kept when the record is rebuilt
+	BlockSize     uint32 // size of data and parity blocks
+	DataShards    uint16 // data blocks per stripe
+	ParityShards  uint16 // parity blocks per stripe
+	ProtectedSize int64  // bytes before the record
+	TableChecksum uint32 // CRC-32C of one copy of the block checksum table
+}
+
+// NewRecoveryFooter lays out a record with percent parity for protectedSize
+// bytes: stripes are as wide as the shard limit allows, so parity per
+// stripe, and with it the longest repairable burst, is as large as possible
+func NewRecoveryFooter(protectedSize int64, percent int) (*RecoveryFooter, error) {
+	if percent < 1 || percent > MaxRecoveryPercent {
+		return nil, fmt.Errorf("invalid redundancy %d%%: must be between 1 and %d", percent, MaxRecoveryPercent)
+	}
+	if protectedSize <= 0 {
+		return nil, fmt.Errorf("invalid recovery record: nothing to protect")
+	}
+
+	f := &RecoveryFooter{Percent: uint8(percent), BlockSize: RecoveryBlockSize, ProtectedSize: protectedSize}
+	data := min(f.Blocks(), MaxRecoveryShards-1)
+	for data+parityShards(data, percent) > MaxRecoveryShards {
+		data--
+	}
+	f.DataShards = uint16(data)
+	f.ParityShards = uint16(parityShards(data, percent))
+	return f, nil
+}
+
+// parityShards rounds the parity for data blocks up, to at least one block
+func parityShards(data int64, percent int) int64 {
+	return (data*int64(percent) + 99) / 100
+}
+
+// Blocks returns the number of data blocks
+func (f *RecoveryFooter) Blocks() int64 {
+	return (f.ProtectedSize + int64(f.BlockSize) - 1) / int64(f.BlockSize)
+}
+
+// Stripes returns the number of stripes
+func (f *RecoveryFooter) Stripes() int64 {
+	return (f.Blocks() + int64(f.DataShards) - 1) / int64(f.DataShards)
+}
+
+// ParityBlocks returns the number of parity blocks
+func (f *RecoveryFooter) ParityBlocks() int64 {
+	return f.Stripes() * int64(f.ParityShards)
+}
+
+// TableSize returns the size of one copy of the block checksum table
+func (f *RecoveryFooter) TableSize() int64 {
+	return 4 * (f.Blocks() + f.ParityBlocks())
+}
+
+// ParityAt returns the file offset of the parity blocks
+func (f *RecoveryFooter) ParityAt() int64 {
+	return f.ProtectedSize + RecoveryFooterSize
+}
+
+// TableAt returns the file offset of copy (0 or 1) of the checksum table
+func (f *RecoveryFooter) TableAt(copy int) int64 {
+	return f.ParityAt() + f.ParityBlocks()*int64(f.BlockSize) + int64(copy)*f.TableSize()
+}
+
+// RecordSize returns the size of the whole recovery record
+func (f *RecoveryFooter) RecordSize() int64 {
+	return f.TableAt(2) + RecoveryFooterSize - f.ProtectedSize
+}
+
+// Redundancy returns the parity as a percentage of the protected bytes
+func (f *RecoveryFooter) Redundancy() float64 {
+	return float64(f.ParityBlocks()*int64(f.BlockSize)) * 100 / float64(f.ProtectedSize)
+}
+
+// BlockAt returns the file offset and length of data block index
+func (f *RecoveryFooter) BlockAt(index int64) (int64, int64) {
+	offset := index * int64(f.BlockSize)
+	return offset, min(int64(f.BlockSize), f.ProtectedSize-offset)
+}
+
+// DataBlock returns the index of shard of stripe, or -1 past the last block
+func (f *RecoveryFooter) DataBlock(stripe, shard int64) int64 {
+	index := stripe + shard*f.Stripes()
+	if index >= f.Blocks() {
+		return -1
+	}
+	return index
+}
+
+// MarshalBinary returns the footer as written to disk
+func (f *RecoveryFooter) MarshalBinary() ([]byte, error) {
+	out := make([]byte, RecoveryFooterSize)
+	copy(out, RecoveryMagic)
+	out[4] = RecoveryVersion
+	out[5] = f.Percent
+	binary.LittleEndian.PutUint16(out[6:8], f.DataShards)
+	binary.LittleEndian.PutUint16(out[8:10], f.ParityShards)
+	binary.LittleEndian.PutUint32(out[12:16], f.BlockSize)
+	binary.LittleEndian.PutUint64(out[16:24], uint64(f.ProtectedSize))
+	binary.LittleEndian.PutUint32(out[24:28], f.TableChecksum)
+	binary.LittleEndian.PutUint32(out[28:32], BlockChecksum(out[:28]))
+	return out, nil
+}
+
+// ParseRecoveryFooter parses and checks a footer
+func ParseRecoveryFooter(data []byte) (*RecoveryFooter, error) {
+	if len(data) != RecoveryFooterSize || string(data[:4]) != RecoveryMagic {
+		return nil, ErrNoRecoveryRecord
+	}
+	if binary.LittleEndian.Uint32(data[28:32]) != BlockChecksum(data[:28]) {
+		return nil, ErrRecoveryFooterInvalid
+	}
+	if data[4] != RecoveryVersion {
+		return nil, fmt.Errorf("%w: recovery record version %d", ErrUnsupportedVersion, data[4])
+	}
+
+	f := &RecoveryFooter{
+		Percent:       data[5],
+		DataShards:    binary.LittleEndian.Uint16(data[6:8]),
+		ParityShards:  binary.LittleEndian.Uint16(data[8:10]),
+		BlockSize:     binary.LittleEndian.Uint32(data[12:16]),
+		ProtectedSize: int64(binary.LittleEndian.Uint64(data[16:24])),
+		TableChecksum: binary.LittleEndian.Uint32(data[24:28]),
+	}
+	if f.BlockSize == 0 || f.BlockSize > 1<<20 || f.DataShards == 0 || f.ParityShards == 0 ||
+		int(f.DataShards)+int(f.ParityShards) > MaxRecoveryShards || f.ProtectedSize <= 0 || f.ProtectedSize > 1<<56 {
+		return nil, fmt.Errorf("%w: invalid layout", ErrRecoveryFooterInvalid)
+	}
+	return f, nil
+}
+
+// ReadRecoveryFooter reads the footer at the end of a file of size bytes
+// and checks that the record it describes fills the rest of the file
+func ReadRecoveryFooter(r io.ReaderAt, size int64) (*RecoveryFooter, error) {
+	if size < RecoveryFooterSize {
+		return nil, ErrNoRecoveryRecord
+	}
+	data := make([]byte, RecoveryFooterSize)
+	if _, err := r.ReadAt(data, size-RecoveryFooterSize); err != nil {
+		return nil, fmt.Errorf("failed to read recovery record: %w", err)
+	}
+	f, err := ParseRecoveryFooter(data)
+	if err != nil {
+		return nil, err
+	}
+	if f.ProtectedSize+f.RecordSize() != size {
+		return nil, fmt.Errorf("%w: file is %d bytes, record expects %d", ErrRecoveryFooterInvalid, size, f.ProtectedSize+f.RecordSize())
+	}
+	return f, nil
+}
+
+// ParseChecksumTable decodes one copy of the block checksum table
+func ParseChecksumTable(data []byte) []uint32 {
+	table := make([]uint32, len(data)/4)
+	for i := range table {
+		table[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return table
+}
+
+// MarshalChecksumTable encodes the block checksum table
+func MarshalChecksumTable(table []uint32) []byte {
+	out := make([]byte, 4*len(table))
+	for i, sum := range table {
+		binary.LittleEndian.PutUint32(out[4*i:], sum)
+	}
+	return out
+}
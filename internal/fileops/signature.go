@@ -0,0 +1,51 @@
+package fileops
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// Embedded signatures. A file with FlagSigned ends with a fixed-size
+// trailer: magic, the minisign algorithm "ED", the signer's key ID and an
+// Ed25519 signature over the BLAKE2b-512 digest of the header, the metadata
+// block and the segments. The key slot area is left out so that slots can
+// still be added or removed without invalidating the signature.
+const (
+	SignatureMagic       = "FVSG"
+	SignatureAlgorithm   = "ED"
+	SignatureTrailerSize = len(SignatureMagic) + len(SignatureAlgorithm) + crypto.MinisignKeyIDSize + ed25519.SignatureSize
+)
+
+// SignatureTrailer is the signature at the end of a signed file
+type SignatureTrailer struct {
+	KeyID     [crypto.MinisignKeyIDSize]byte
+	Signature [ed25519.SignatureSize]byte
+}
+
+// MarshalBinary returns the trailer as written to disk
+func (t *SignatureTrailer) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, SignatureTrailerSize)
+	out = append(out, SignatureMagic...)
+	out = append(out, SignatureAlgorithm...)
+	out = append(out, t.KeyID[:]...)
+	return append(out, t.Signature[:]...), nil
+}
+
+// ParseSignatureTrailer parses the last SignatureTrailerSize bytes of a file
+func ParseSignatureTrailer(data []byte) (*SignatureTrailer, error) {
+	if len(data) != SignatureTrailerSize {
+		return nil, fmt.Errorf("invalid signature trailer size: %d", len(data))
+	}
+	magic, rest := data[:len(SignatureMagic)], data[len(SignatureMagic):]
+	algorithm, rest := rest[:len(SignatureAlgorithm)], rest[len(SignatureAlgorithm):]
+	if string(magic) != SignatureMagic || string(algorithm) != SignatureAlgorithm {
+		return nil, fmt.Errorf("invalid signature trailer")
+	}
+
+	var t SignatureTrailer
+	copy(t.KeyID[:], rest)
+	copy(t.Signature[:], rest[crypto.MinisignKeyIDSize:])
+	return &t, nil
+}
@@ -0,0 +1,112 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/errors"
+)
+
+// ValidateArchiveName checks a single element of an archive member path
+// as ValidateFilename does, except that it only refuses the elements "."
+// and "..", not names that merely contain dots, such as "v1..2.txt"
+func ValidateArchiveName(element string) error {
+	switch element {
+	case "":
+		return errors.NewError(errors.ErrInvalidInput, "archive member has an empty path element", nil)
+	case ".", "..":
+		return errors.NewError(errors.ErrSecurityViolation,
+			fmt.Sprintf("archive member contains a %q path element", element), nil)
+	}
+	return validateName(element)
+}
+
+// ValidateArchivePath checks the slash-separated path of an archive member
+// before it is extracted: it must be relative, and each of its elements a
+// valid name, so that no member lands outside the target directory
+func ValidateArchivePath(name string) error {
+	name = strings.TrimSuffix(name, "/")
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return errors.NewError(errors.ErrSecurityViolation,
+			fmt.Sprintf("archive member has an absolute path: %s", name), nil)
+	}
+	if strings.Contains(name, `\`) {
+		// A separator on Windows, which could hide a ".." element
+		return errors.NewError(errors.ErrSecurityViolation,
+			fmt.Sprintf("archive member contains a backslash: %s", name), nil)
+	}
+	for _, element := range strings.Split(name, "/") {
+		if err := ValidateArchiveName(element); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateSymlink checks that the symlink member name of an archive points
+// inside the target directory. The elements of its target are checked as
+// they are, not cleaned: ".." may only lead the target and climb no higher
+// than the top of the archive. A ".." after a name could climb out of
+// another symlink, which the kernel follows where a cleaned path does not.
+// Together with links being created only in real directories, each link
+// then resolves inside the target directory, however they are chained.
+func ValidateSymlink(name, target string) error {
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return errors.NewError(errors.ErrSecurityViolation,
+			fmt.Sprintf("symlink %s points to an absolute path: %s", name, target), nil)
+	}
+	if target == "" || strings.Contains(target, `\`) {
+		return errors.NewError(errors.ErrSecurityViolation,
+			fmt.Sprintf("symlink %s has an invalid target: %q", name, target), nil)
+	}
+
+	depth := 0
+	if dir := path.Dir(strings.TrimSuffix(name, "/")); dir != "." {
+		depth = strings.Count(dir, "/") + 1
+	}
+	named := false
+	for _, element := range strings.Split(target, "/") {
+		switch {
+		case element == "" || element == ".":
+		case element == ".." && !named:
+			if depth--; depth < 0 {
+				return errors.NewError(errors.ErrSecurityViolation,
+					fmt.Sprintf("symlink %s escapes the archive: %s", name, target), nil)
+			}
+		case element == "..":
+			return errors.NewError(errors.ErrSecurityViolation,
+				fmt.Sprintf("symlink %s has a \"..\" after a name: %s", name, target), nil)
+		default:
+			if err := ValidateArchiveName(element); err != nil {
+				return errors.NewError(errors.ErrSecurityViolation,
+					fmt.Sprintf("symlink %s has an invalid target: %s", name, target), err)
+			}
+			named = true
+		}
+	}
+	return nil
+}
+
+// ValidateInputDirectory validates a directory to be encrypted as an archive
+func ValidateInputDirectory(dirPath string) error {
+	info, err := os.Stat(dirPath)
+	if os.IsNotExist(err) {
+		return errors.NewFileNotFoundError(dirPath)
+	}
+	if err != nil {
+		return errors.NewError(errors.ErrFileReadError, "cannot access directory", err)
+	}
+	if !info.IsDir() {
+		return errors.NewError(errors.ErrInvalidInput, fmt.Sprintf("not a directory: %s", dirPath), nil)
+	}
+
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return errors.NewPermissionDeniedError(dirPath, err)
+	}
+	dir.Close()
+	return nil
+}
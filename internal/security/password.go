@@ -3,7 +3,9 @@ package security
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
 	"strings"
 	"syscall"
 	"unicode"
@@ -60,15 +62,32 @@ func DefaultPasswordPolicy() PasswordPolicy {
 
 // ReadPassword safely reads password from terminal (hidden input)
 func ReadPassword(prompt string) (string, error) {
-	fmt.Print(prompt)
+	return readPassword(os.Stdout, prompt)
+}
+
+// ReadPasswordStderr reads a password like ReadPassword but prints the prompt
+// to stderr, for commands whose stdout carries data
+func ReadPasswordStderr(prompt string) (string, error) {
+	return readPassword(os.Stderr, prompt)
+}
+
+// readPassword prints prompt to out and reads a password without echo
+func readPassword(out io.Writer, prompt string) (string, error) {
+	return readPasswordFrom(int(syscall.Stdin), out, prompt)
+}
+
+// readPasswordFrom prints prompt to out and reads a password without echo
+// from the terminal fd
+func readPasswordFrom(fd int, out io.Writer, prompt string) (string, error) {
+	fmt.Fprint(out, prompt)
 
 	// Read password without echo
-	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+	bytePassword, err := term.ReadPassword(fd)
 	if err != nil {
 		return "", fmt.Errorf("failed to read password: %w", err)
 	}
 
-	fmt.Println() // New line after hidden input
+	fmt.Fprintln(out) // New line after hidden input
 	password := string(bytePassword)
 
 	// Clear sensitive data from memory
@@ -107,6 +126,43 @@ func ReadPasswordFromStdin() (string, error) {
 	return strings.TrimSpace(password), nil
 }
 
+// ReadPasswordTTY reads a password from the controlling terminal instead of
+// stdin and prints the prompt to stderr, for commands whose stdin and
+// stdout carry data
+func ReadPasswordTTY(prompt string) (string, error) {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	tty, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: no terminal: %w", err)
+	}
+	defer tty.Close()
+
+	return readPasswordFrom(int(tty.Fd()), os.Stderr, prompt)
+}
+
+// ReadPasswordFile reads a password from the first line of a file, such as
+// one only the user can read, or a descriptor like /dev/fd/3 in scripts
+func ReadPasswordFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open password file: %w", err)
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(file, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return password, nil
+}
+
 // ValidatePassword checks if password meets policy requirements
 func ValidatePassword(password string, policy PasswordPolicy) error {
 	if len(password) < policy.MinLength {
@@ -202,6 +258,19 @@ func CheckPasswordStrength(password string) PasswordStrength {
 	}
 }
 
+// WeakPasswords returns the indexes of the passwords that CheckPasswordStrength
+// rates Weak, such as those of several share holders. Empty passwords, of
+// keyfile-only credentials, are skipped.
+func WeakPasswords(passwords []string) []int {
+	var weak []int
+	for i, password := range passwords {
+		if password != "" && CheckPasswordStrength(password) == Weak {
+			weak = append(weak, i)
+		}
+	}
+	return weak
+}
+
 // PromptForPasswordWithValidation prompts for password with policy validation
 func PromptForPasswordWithValidation(policy PasswordPolicy) (string, error) {
 	fmt.Println("Password Requirements:")
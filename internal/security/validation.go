@@ -32,13 +32,6 @@ func ValidateInputFile(filePath string) error {
 	}
 	file.Close()
 
-	// Check file size limits (prevent extremely large files)
-	const maxFileSize = 10 * 1024 * 1024 * 1024 // 10GB limit
-	if info.Size() > maxFileSize {
-		return errors.NewError(errors.ErrFileTooLarge, 
-			fmt.Sprintf("file too large: %d bytes (max %d)", info.Size(), maxFileSize), nil)
-	}
-
 	return nil
 }
 
@@ -113,6 +106,12 @@ func ValidateFilename(filename string) error {
 			fmt.Sprintf("filename contains path traversal sequence: %s", filename), nil)
 	}
 
+	return validateName(filename)
+}
+
+// validateName holds the checks of ValidateFilename other than the one
+// for ".." sequences, which archive member names apply differently
+func validateName(filename string) error {
 	// Check for absolute paths
 	if filepath.IsAbs(filename) {
 		return errors.NewError(errors.ErrSecurityViolation, 
@@ -4,16 +4,33 @@ package filevault
 
 import (
 	"fmt"
+	"io"
 	"path/filepath"
+	"strings"
 
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
 	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/security"
 )
 
 // Client represents the main FileVault client for encryption/decryption operations
 type Client struct {
 	// Configuration options for the client
-	verbose bool
+	verbose    bool
+	kdf        crypto.KDFParams
+	cipherName string
+	plainName  bool
+	pad        bool
+	recovery   int
+	armor      bool
+	compress   string
+	level      uint8
+	threads    int
+	keyfiles   []string
+	recipients []string
+	identities []string
+	signingKey string
 }
 
 // ClientOption represents configuration options for the FileVault client
@@ -39,21 +56,159 @@ func WithVerbose(verbose bool) ClientOption {
 	}
 }
 
-// EncryptFile encrypts a file using AES-256-GCM with the provided password
+// WithPBKDF2 derives keys with PBKDF2-SHA256 using the given iteration count
+func WithPBKDF2(iterations int) ClientOption {
+	return func(c *Client) {
+		c.kdf = crypto.PBKDF2Params(iterations)
+	}
+}
+
+// WithArgon2id derives keys with Argon2id; memory is given in MiB
+func WithArgon2id(memoryMiB, time uint32, parallelism uint8) ClientOption {
+	return func(c *Client) {
+		c.kdf = crypto.Argon2idParams(memoryMiB*1024, time, parallelism)
+	}
+}
+
+// WithCipher selects the cipher by name: "aes-256-gcm", "xchacha20-poly1305"
+// or "auto". Unknown names are reported by the next encryption.
+func WithCipher(name string) ClientOption {
+	return func(c *Client) {
+		c.cipherName = name
+	}
+}
+
+// WithPlainFilename stores the original filename unencrypted in the header,
+// as in format v2, instead of in the encrypted metadata block
+func WithPlainFilename(plain bool) ClientOption {
+	return func(c *Client) {
+		c.plainName = plain
+	}
+}
+
+// WithPadding pads new files to a PADMÉ size so their length does not
+// reveal the exact plaintext size. It cannot be combined with compression
+// or a plain filename.
+func WithPadding(pad bool) ClientOption {
+	return func(c *Client) {
+		c.pad = pad
+	}
+}
+
+// WithRecovery appends a Reed-Solomon recovery record with percent parity
+// (1-100) to new files, so that RepairFile can undo damage to them later
+func WithRecovery(percent int) ClientOption {
+	return func(c *Client) {
+		c.recovery = percent
+	}
+}
+
+// WithArmor writes new files as ASCII armor, Base64 between BEGIN and END
+// lines, for email and chat. It cannot be combined with WithRecovery.
+// Armored files are decrypted and verified like binary ones.
+func WithArmor(armor bool) ClientOption {
+	return func(c *Client) {
+		c.armor = armor
+	}
+}
+
+// WithCompression compresses files before encryption. name is "none",
+// "gzip" or "zstd"; level 0 selects the default level. Inputs that already
+// look compressed are stored as is. Invalid settings are reported by the
+// next encryption.
+func WithCompression(name string, level uint8) ClientOption {
+	return func(c *Client) {
+		c.compress = name
+		c.level = level
+	}
+}
+
+// WithThreads sets the number of workers that encrypt and decrypt segments
+// in parallel; zero, the default, uses one per CPU
+func WithThreads(threads int) ClientOption {
+	return func(c *Client) {
+		c.threads = threads
+	}
+}
+
+// WithKeyfiles protects new files with keyfiles in addition to the password,
+// and supplies the keyfiles needed to decrypt files that require them. Any
+// file can be a keyfile; the order does not matter. With keyfiles the
+// password may be empty, in which case the keyfiles alone unlock the file.
+func WithKeyfiles(paths ...string) ClientOption {
+	return func(c *Client) {
+		c.keyfiles = append([]string(nil), paths...)
+	}
+}
+
+// WithRecipients also encrypts new files to the given public keys
+// ("fvpk1...", post-quantum "fvpq1..." or SSH "ssh-ed25519 ..." and
+// "ssh-rsa ..." lines), so their identities can decrypt without a password.
+// With recipients the password may be empty. Invalid keys are reported by
+// the next encryption.
+func WithRecipients(publicKeys ...string) ClientOption {
+	return func(c *Client) {
+		c.recipients = append([]string(nil), publicKeys...)
+	}
+}
+
+// WithIdentities supplies private keys ("FVSK1...", "FVPQSK1..." or
+// unencrypted SSH or certificate private keys in PEM form) that decrypt
+// files encrypted to their public keys. Invalid keys are reported by the next decryption.
+func WithIdentities(privateKeys ...string) ClientOption {
+	return func(c *Client) {
+		c.identities = append([]string(nil), privateKeys...)
+	}
+}
+
+// WithSigningKey embeds a signature in new files, made with an unencrypted
+// minisign secret key (the contents of a key file from 'filevault keygen
+// --type minisign --no-password')
+func WithSigningKey(secretKey string) ClientOption {
+	return func(c *Client) {
+		c.signingKey = secretKey
+	}
+}
+
+// credentials combines password with the configured keyfiles and identities
+func (c *Client) credentials(password string) (core.Credentials, error) {
+	creds := core.Credentials{Password: password, Keyfiles: c.keyfiles}
+	for _, key := range c.identities {
+		if strings.Contains(key, "PRIVATE KEY-----") {
+			identities, err := crypto.ParsePrivateKeyIdentities([]byte(key), nil)
+			if err != nil {
+				return creds, fmt.Errorf("invalid identity: %w", err)
+			}
+			creds.Identities = append(creds.Identities, identities...)
+			continue
+		}
+		identities, err := crypto.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return creds, fmt.Errorf("invalid identity: %w", err)
+		}
+		creds.Identities = append(creds.Identities, identities...)
+	}
+	return creds, nil
+}
+
+// EncryptFile encrypts a file using the configured cipher with the provided password
 func (c *Client) EncryptFile(inputPath, password string) error {
 	return c.EncryptFileWithOutput(inputPath, "", password)
 }
 
 // EncryptFileWithOutput encrypts a file with a custom output path
 func (c *Client) EncryptFileWithOutput(inputPath, outputPath, password string) error {
-	// Validate password strength
-	if err := security.ValidatePasswordBasic(password); err != nil {
-		return fmt.Errorf("password validation failed: %w", err)
+	opts, err := c.encryptOptions(password)
+	if err != nil {
+		return err
 	}
 
 	// Generate default output path if not provided
 	if outputPath == "" {
 		outputPath = inputPath + ".enc"
+		if c.armor {
+			outputPath += ".asc"
+		}
 	}
 
 	// Validate input file
@@ -71,7 +226,119 @@ func (c *Client) EncryptFileWithOutput(inputPath, outputPath, password string) e
 		fmt.Printf("Encrypting: %s -> %s\n", inputPath, outputPath)
 	}
 
-	return core.EncryptFile(inputPath, outputPath, password)
+	return core.EncryptFileWithOptions(inputPath, outputPath, core.Credentials{Password: password, Keyfiles: c.keyfiles}, opts)
+}
+
+// EncryptStream encrypts everything read from src, e.g. a database dump,
+// and writes the encrypted file to dst as it goes, without buffering it.
+// The size is not known up front, so padding is not available, and a
+// recovery record needs dst to be an *os.File.
+func (c *Client) EncryptStream(dst io.Writer, src io.Reader, password string) error {
+	opts, err := c.encryptOptions(password)
+	if err != nil {
+		return err
+	}
+	return core.EncryptStream(dst, src, core.Credentials{Password: password, Keyfiles: c.keyfiles}, opts)
+}
+
+// EncryptDirectory encrypts the tree under dirPath, with its modes,
+// symlinks and empty directories, as one archive into outputPath, which
+// defaults to the directory name with ".enc". Padding is not available.
+func (c *Client) EncryptDirectory(dirPath, outputPath, password string) error {
+	opts, err := c.encryptOptions(password)
+	if err != nil {
+		return err
+	}
+	if err := security.ValidateInputDirectory(dirPath); err != nil {
+		return fmt.Errorf("input directory validation failed: %w", err)
+	}
+	if outputPath == "" {
+		outputPath = filepath.Clean(dirPath) + ".enc"
+		if c.armor {
+			outputPath += ".asc"
+		}
+	}
+	if err := security.ValidateOutputFile(outputPath, false); err != nil {
+		return fmt.Errorf("output validation failed: %w", err)
+	}
+
+	if c.verbose {
+		fmt.Printf("Encrypting directory: %s -> %s\n", dirPath, outputPath)
+	}
+	return core.EncryptDirectory(dirPath, outputPath, core.Credentials{Password: password, Keyfiles: c.keyfiles}, opts)
+}
+
+// ExtractDirectory decrypts a file made by EncryptDirectory and extracts
+// the tree into destDir without overwriting existing files. Paths that
+// would land outside destDir are refused.
+func (c *Client) ExtractDirectory(encryptedPath, destDir, password string) error {
+	source, cleanup, err := core.Dearmored(encryptedPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	creds, err := c.credentials(password)
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		fmt.Printf("Extracting: %s -> %s\n", encryptedPath, destDir)
+	}
+	return core.DecryptArchive(source, destDir, creds, core.DecryptOptions{Threads: c.threads})
+}
+
+// encryptOptions validates the password and the client's settings for new
+// files and turns them into core options
+func (c *Client) encryptOptions(password string) (core.EncryptOptions, error) {
+	// Validate password strength; keyfiles or recipients may stand in for
+	// the password
+	if password != "" || (len(c.keyfiles) == 0 && len(c.recipients) == 0) {
+		if err := security.ValidatePasswordBasic(password); err != nil {
+			return core.EncryptOptions{}, fmt.Errorf("password validation failed: %w", err)
+		}
+	}
+
+	// Validate key derivation settings (zero means the default)
+	if c.kdf != (crypto.KDFParams{}) {
+		if err := c.kdf.Validate(); err != nil {
+			return core.EncryptOptions{}, fmt.Errorf("key derivation settings invalid: %w", err)
+		}
+	}
+
+	opts := core.EncryptOptions{KDF: c.kdf, PlaintextMetadata: c.plainName, Pad: c.pad, Recovery: c.recovery, Armor: c.armor, Threads: c.threads}
+	if c.compress != "" {
+		algorithm, err := compression.ParseName(c.compress)
+		if err != nil {
+			return opts, err
+		}
+		opts.Compression = compression.Params{Algorithm: algorithm, Level: c.level}
+		if err := opts.Compression.Validate(); err != nil {
+			return opts, fmt.Errorf("compression settings invalid: %w", err)
+		}
+	}
+	if c.cipherName != "" {
+		algorithm, err := crypto.ParseCipherName(c.cipherName)
+		if err != nil {
+			return opts, err
+		}
+		opts.Algorithm = algorithm
+	}
+	for _, key := range c.recipients {
+		recipient, err := crypto.ParseRecipient(key)
+		if err != nil {
+			return opts, fmt.Errorf("invalid recipient: %w", err)
+		}
+		opts.Recipients = append(opts.Recipients, recipient)
+	}
+	if c.signingKey != "" {
+		signer, err := crypto.ParseMinisignPrivateKey([]byte(c.signingKey), nil)
+		if err != nil {
+			return opts, fmt.Errorf("invalid signing key: %w", err)
+		}
+		opts.Signer = signer
+	}
+	return opts, nil
 }
 
 // DecryptFile decrypts a FileVault encrypted file using the provided password
@@ -81,8 +348,15 @@ func (c *Client) DecryptFile(encryptedPath, password string) error {
 
 // DecryptFileWithOutput decrypts a file with a custom output path
 func (c *Client) DecryptFileWithOutput(encryptedPath, outputPath, password string) error {
+	// Armored files are decrypted from a decoded copy
+	source, cleanup, err := core.Dearmored(encryptedPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	// Validate input file
-	if err := security.ValidateEncryptedFile(encryptedPath); err != nil {
+	if err := security.ValidateEncryptedFile(source); err != nil {
 		return fmt.Errorf("encrypted file validation failed: %w", err)
 	}
 
@@ -101,13 +375,80 @@ func (c *Client) DecryptFileWithOutput(encryptedPath, outputPath, password strin
 		fmt.Printf("Decrypting: %s -> %s\n", encryptedPath, outputPath)
 	}
 
-	return core.DecryptFile(encryptedPath, outputPath, password)
+	creds, err := c.credentials(password)
+	if err != nil {
+		return err
+	}
+	return core.DecryptFileWithOptions(source, outputPath, creds, core.DecryptOptions{Threads: c.threads})
+}
+
+// DecryptStream decrypts an encrypted file read from src, e.g. a network
+// connection, and writes the plaintext to dst as it goes. Files with a
+// recovery record must be decrypted from a file instead.
+func (c *Client) DecryptStream(dst io.Writer, src io.Reader, password string) error {
+	creds, err := c.credentials(password)
+	if err != nil {
+		return err
+	}
+	return core.DecryptStream(dst, src, creds, core.DecryptOptions{Threads: c.threads})
+}
+
+// Reader gives random access to the plaintext of an encrypted file.
+// Only the segments covering each read are decrypted and authenticated.
+type Reader interface {
+	io.ReaderAt
+	io.ReadSeeker
+	io.Closer
+	// Size returns the plaintext size in bytes
+	Size() int64
+}
+
+// Open opens an encrypted file for random-access reading without writing
+// any plaintext to disk. The caller must Close the returned Reader.
+func (c *Client) Open(encryptedPath, password string) (Reader, error) {
+	source, cleanup, err := core.Dearmored(encryptedPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := security.ValidateEncryptedFile(source); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("encrypted file validation failed: %w", err)
+	}
+
+	if c.verbose {
+		fmt.Printf("Opening: %s\n", encryptedPath)
+	}
+
+	creds, err := c.credentials(password)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	reader, err := core.OpenReader(source, creds)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &dearmoredReader{Reader: reader, cleanup: cleanup}, nil
+}
+
+// dearmoredReader removes the decoded copy of an armored file on Close
+type dearmoredReader struct {
+	*core.Reader
+	cleanup func()
+}
+
+func (r *dearmoredReader) Close() error {
+	defer r.cleanup()
+	return r.Reader.Close()
 }
 
 // VerifyFile checks the integrity and format of an encrypted file
 func (c *Client) VerifyFile(encryptedPath string) (*VerificationResult, error) {
-	if err := security.ValidateEncryptedFile(encryptedPath); err != nil {
-		return nil, fmt.Errorf("file validation failed: %w", err)
+	if armored, _ := core.IsArmored(encryptedPath); !armored {
+		if err := security.ValidateEncryptedFile(encryptedPath); err != nil {
+			return nil, fmt.Errorf("file validation failed: %w", err)
+		}
 	}
 
 	if c.verbose {
@@ -121,37 +462,64 @@ func (c *Client) VerifyFile(encryptedPath string) (*VerificationResult, error) {
 
 	// Convert from core.VerificationResult to our VerificationResult
 	result := &VerificationResult{
-		Valid:            coreResult.IsValid,
-		FormatValid:      coreResult.FormatValid,
-		HeaderValid:      coreResult.HeaderValid,
-		SizeConsistent:   coreResult.SizeConsistent,
-		FileAccessible:   coreResult.FileAccessible,
-		Filename:         coreResult.Filename,
-		OriginalFilename: coreResult.OriginalFilename,
-		FileSize:         coreResult.FileSize,
-		OriginalSize:     coreResult.OriginalSize,
-		Algorithm:        coreResult.Algorithm,
-		FormatVersion:    coreResult.FormatVersion,
-		ErrorMessage:     coreResult.ErrorMessage,
+		Valid:             coreResult.IsValid,
+		FormatValid:       coreResult.FormatValid,
+		HeaderValid:       coreResult.HeaderValid,
+		SizeConsistent:    coreResult.SizeConsistent,
+		FileAccessible:    coreResult.FileAccessible,
+		Filename:          coreResult.Filename,
+		OriginalFilename:  coreResult.OriginalFilename,
+		MetadataEncrypted: coreResult.MetadataEncrypted,
+		Compression:       coreResult.Compression,
+		StoredSize:        coreResult.StoredSize,
+		FileSize:          coreResult.FileSize,
+		OriginalSize:      coreResult.OriginalSize,
+		Algorithm:         coreResult.Algorithm,
+		KDF:               coreResult.KDF,
+		Unlock:            coreResult.Unlock,
+		FormatVersion:     coreResult.FormatVersion,
+		Armored:           coreResult.Armored,
+		ErrorMessage:      coreResult.ErrorMessage,
 	}
 
 	return result, nil
 }
 
+// RepairFile rebuilds the damaged parts of an encrypted file, in place, from
+// its recovery record; no password is needed. It reports whether anything
+// had to be repaired. A file that cannot be fully repaired is not changed.
+func (c *Client) RepairFile(encryptedPath string) (bool, error) {
+	if c.verbose {
+		fmt.Printf("Repairing file: %s\n", encryptedPath)
+	}
+
+	report, err := core.RepairFile(encryptedPath)
+	if err != nil {
+		return false, err
+	}
+	return !report.Intact(), nil
+}
+
 // VerificationResult contains the result of file verification
 type VerificationResult struct {
-	Valid            bool   `json:"valid"`
-	FormatValid      bool   `json:"format_valid"`
-	HeaderValid      bool   `json:"header_valid"`
-	SizeConsistent   bool   `json:"size_consistent"`
-	FileAccessible   bool   `json:"file_accessible"`
-	Filename         string `json:"filename"`
-	OriginalFilename string `json:"original_filename"`
-	FileSize         int64  `json:"file_size"`
-	OriginalSize     uint64 `json:"original_size"`
-	Algorithm        string `json:"algorithm"`
-	FormatVersion    uint32 `json:"format_version"`
-	ErrorMessage     string `json:"error_message"`
+	Valid             bool   `json:"valid"`
+	FormatValid       bool   `json:"format_valid"`
+	HeaderValid       bool   `json:"header_valid"`
+	SizeConsistent    bool   `json:"size_consistent"`
+	FileAccessible    bool   `json:"file_accessible"`
+	Filename          string `json:"filename"`
+	OriginalFilename  string `json:"original_filename"`
+	FileSize          int64  `json:"file_size"`
+	OriginalSize      uint64 `json:"original_size"`
+	Algorithm         string `json:"algorithm"`
+	KDF               string `json:"kdf"`
+	FormatVersion     uint32 `json:"format_version"`
+	ErrorMessage      string `json:"error_message"`
+	MetadataEncrypted bool   `json:"metadata_encrypted"` // filename hidden until unlocked
+	Compression       string `json:"compression"`
+	StoredSize        uint64 `json:"stored_size"` // payload size after compression
+	Unlock            string `json:"unlock"`      // "password", "keyfile" or "password + keyfile"
+	Armored           bool   `json:"armored"`     // ASCII armor, checked after decoding
 }
 
 // IsValid returns true if the file passed all verification checks
@@ -167,6 +535,7 @@ func (vr *VerificationResult) GetErrorMessage() string {
 // getOriginalFilename attempts to determine the original filename from an encrypted file
 func (c *Client) getOriginalFilename(encryptedPath string) string {
 	// Fallback: remove .enc extension if present
+	encryptedPath = strings.TrimSuffix(encryptedPath, ".asc")
 	if filepath.Ext(encryptedPath) == ".enc" {
 		return encryptedPath[:len(encryptedPath)-4]
 	}
@@ -83,8 +83,74 @@ func BenchmarkMemoryUsage(b *testing.B) {
 	})
 }
 
+// BenchmarkParallelEncryption measures how encryption throughput scales with
+// the number of segment workers. The cheapest KDF keeps key derivation from
+// dominating, so the numbers reflect the segment pipeline.
+func BenchmarkParallelEncryption(b *testing.B) {
+	for _, threads := range parallelThreadCounts() {
+		b.Run(fmt.Sprintf("Threads%d", threads), func(b *testing.B) {
+			benchmarkParallelFile(b, 64*1024*1024, threads, false)
+		})
+	}
+}
+
+// BenchmarkParallelDecryption measures decryption throughput per worker count
+func BenchmarkParallelDecryption(b *testing.B) {
+	for _, threads := range parallelThreadCounts() {
+		b.Run(fmt.Sprintf("Threads%d", threads), func(b *testing.B) {
+			benchmarkParallelFile(b, 64*1024*1024, threads, true)
+		})
+	}
+}
+
 // Helper functions
 
+// parallelThreadCounts returns powers of two up to GOMAXPROCS, plus GOMAXPROCS
+// itself; use -cpu to benchmark other limits
+func parallelThreadCounts() []int {
+	cpus := runtime.GOMAXPROCS(0)
+	counts := []int{}
+	for threads := 1; threads < cpus; threads *= 2 {
+		counts = append(counts, threads)
+	}
+	return append(counts, cpus)
+}
+
+func benchmarkParallelFile(b *testing.B, fileSize int64, threads int, decrypt bool) {
+	tempDir := b.TempDir()
+	testFile := filepath.Join(tempDir, "test_input.dat")
+	encryptedFile := filepath.Join(tempDir, "test_encrypted.enc")
+	decryptedFile := filepath.Join(tempDir, "test_decrypted.dat")
+
+	if err := createRandomFile(testFile, fileSize); err != nil {
+		b.Fatal(err)
+	}
+
+	encryptOpts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Threads: threads}
+	if decrypt {
+		if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(TestPassword), encryptOpts); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	b.SetBytes(fileSize)
+
+	for i := 0; i < b.N; i++ {
+		var err error
+		if decrypt {
+			os.Remove(decryptedFile)
+			err = core.DecryptFileWithOptions(encryptedFile, decryptedFile, core.Password(TestPassword), core.DecryptOptions{Threads: threads})
+		} else {
+			os.Remove(encryptedFile)
+			err = core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(TestPassword), encryptOpts)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func benchmarkEncryptFile(b *testing.B, fileSize int64) {
 	// Create temporary directory
 	tempDir, err := os.MkdirTemp("", "filevault_benchmark")
@@ -308,6 +374,8 @@ func BenchmarkSuite(b *testing.B) {
 	b.Run("DecryptionSpeed", BenchmarkDecryptionSpeed)
 	b.Run("CryptoOperations", BenchmarkCryptoOperations)
 	b.Run("MemoryUsage", BenchmarkMemoryUsage)
+	b.Run("ParallelEncryption", BenchmarkParallelEncryption)
+	b.Run("ParallelDecryption", BenchmarkParallelDecryption)
 }
 
 // Throughput measurement helpers
@@ -0,0 +1,240 @@
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// writeTree creates a small project tree under dir and returns its root
+func writeTree(t *testing.T, dir string) string {
+	t.Helper()
+	root := filepath.Join(dir, "project")
+	for _, sub := range []string{"src/pkg", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	files := map[string]os.FileMode{
+		"README.md":         0644,
+		"run.sh":            0755,
+		"src/pkg/secret.go": 0600,
+		"v1..2.txt":         0644,
+	}
+	for name, mode := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.WriteFile(path, bytes.Repeat([]byte(name+"\n"), 1000), mode); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		os.Chmod(path, mode)
+	}
+	if err := os.Symlink(filepath.Join("..", "README.md"), filepath.Join(root, "src", "readme")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	return root
+}
+
+func TestEncryptDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks and modes need a Unix filesystem")
+	}
+	tempDir := t.TempDir()
+	source := writeTree(t, tempDir)
+	creds := core.Password("archive-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+
+	encryptedFile := filepath.Join(tempDir, "project.enc")
+	if err := core.EncryptDirectory(source, encryptedFile, creds, opts); err != nil {
+		t.Fatalf("Failed to encrypt directory: %v", err)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid || !result.Archive || !result.Streamed {
+		t.Fatalf("Unexpected verification result: %v %+v", err, result)
+	}
+
+	members, err := core.ListArchive(encryptedFile, creds)
+	if err != nil {
+		t.Fatalf("Failed to list archive: %v", err)
+	}
+	var names []string
+	for _, member := range members {
+		names = append(names, member.Name)
+	}
+	sort.Strings(names)
+	expected := []string{"project/", "project/README.md", "project/empty/", "project/run.sh",
+		"project/src/", "project/src/pkg/", "project/src/pkg/secret.go", "project/src/readme",
+		"project/v1..2.txt"}
+	if len(names) != len(expected) {
+		t.Fatalf("Unexpected members: %v", names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("Unexpected members: %v", names)
+		}
+	}
+	meta, listed, err := core.ReadArchive(encryptedFile, creds)
+	if err != nil || meta.FileName != "project" || len(listed) != len(members) {
+		t.Errorf("Unexpected archive contents: %v %+v %d", err, meta, len(listed))
+	}
+	if _, err := core.ListArchive(encryptedFile, core.Password("wrong-password")); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+
+	// The tree comes back under its own name, with modes and symlinks
+	restoreDir := filepath.Join(tempDir, "restore")
+	if err := core.DecryptArchive(encryptedFile, restoreDir, creds, core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to extract archive: %v", err)
+	}
+	restored := filepath.Join(restoreDir, "project")
+	for _, name := range []string{"README.md", "run.sh", "src/pkg/secret.go", "v1..2.txt"} {
+		original, _ := os.ReadFile(filepath.Join(source, filepath.FromSlash(name)))
+		data, err := os.ReadFile(filepath.Join(restored, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(data, original) {
+			t.Errorf("%s did not round-trip: %v", name, err)
+		}
+		originalInfo, _ := os.Stat(filepath.Join(source, filepath.FromSlash(name)))
+		info, err := os.Stat(filepath.Join(restored, filepath.FromSlash(name)))
+		if err != nil || info.Mode().Perm() != originalInfo.Mode().Perm() {
+			t.Errorf("%s lost its mode: %v", name, err)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(restored, "src", "readme")); err != nil || link != filepath.Join("..", "README.md") {
+		t.Errorf("Symlink did not round-trip: %q %v", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(restored, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("Empty directory did not round-trip: %v", err)
+	}
+
+	// Existing files are kept unless asked otherwise
+	if err := core.DecryptArchive(encryptedFile, restoreDir, creds, core.DecryptOptions{}); err == nil {
+		t.Error("Expected extracting over existing files to fail")
+	}
+	if err := core.DecryptArchive(encryptedFile, restoreDir, creds, core.DecryptOptions{Overwrite: true}); err != nil {
+		t.Errorf("Failed to extract archive with overwrite: %v", err)
+	}
+
+	// From a pipe
+	input, err := os.Open(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to open encrypted file: %v", err)
+	}
+	defer input.Close()
+	stream, err := core.ReadEncryptedStream(onlyReader{input})
+	if err != nil {
+		t.Fatalf("Failed to read stream header: %v", err)
+	}
+	pipeDir := filepath.Join(tempDir, "pipe")
+	if err := stream.ExtractTo(pipeDir, creds, core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to extract stream: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(pipeDir, "project", "src", "pkg", "secret.go")); err != nil {
+		t.Errorf("Stream was not extracted: %v", err)
+	}
+}
+
+func TestEncryptDirectoryRestrictions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need a Unix filesystem")
+	}
+	tempDir := t.TempDir()
+	creds := core.Password("archive-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+
+	// A symlink out of the tree could not be extracted, so it is refused
+	// before anything is written
+	source := writeTree(t, tempDir)
+	if err := os.Symlink("/etc/passwd", filepath.Join(source, "passwd")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := core.EncryptDirectory(source, filepath.Join(tempDir, "escape.enc"), creds, opts); err == nil {
+		t.Error("Expected a symlink out of the tree to fail")
+	}
+	os.Remove(filepath.Join(source, "passwd"))
+
+	padded := opts
+	padded.Pad = true
+	if err := core.EncryptDirectory(source, filepath.Join(tempDir, "padded.enc"), creds, padded); err == nil {
+		t.Error("Expected padding of a directory to be rejected")
+	}
+
+	plainFile := filepath.Join(tempDir, "plain.txt")
+	encryptedFile := filepath.Join(tempDir, "plain.enc")
+	if err := os.WriteFile(plainFile, []byte("not a directory"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := core.EncryptDirectory(plainFile, encryptedFile, creds, opts); err == nil {
+		t.Error("Expected a file to be rejected as a directory")
+	}
+	if err := core.EncryptFileWithOptions(plainFile, encryptedFile, creds, opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	if err := core.DecryptArchive(encryptedFile, filepath.Join(tempDir, "out"), creds, core.DecryptOptions{}); !errors.Is(err, core.ErrNotArchive) {
+		t.Errorf("Expected ErrNotArchive, got %v", err)
+	}
+	if _, err := core.ListArchive(encryptedFile, creds); !errors.Is(err, core.ErrNotArchive) {
+		t.Errorf("Expected ErrNotArchive, got %v", err)
+	}
+}
+
+func TestExtractArchiveMalicious(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need a Unix filesystem")
+	}
+	tempDir := t.TempDir()
+
+	cases := map[string][]*tar.Header{
+		"traversal":        {{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}},
+		"nested traversal": {{Name: "project/../../evil", Typeflag: tar.TypeReg, Mode: 0644}},
+		"absolute":         {{Name: filepath.Join(tempDir, "evil"), Typeflag: tar.TypeReg, Mode: 0644}},
+		"absolute symlink": {{Name: "project/evil", Typeflag: tar.TypeSymlink, Linkname: tempDir}},
+		"escaping symlink": {{Name: "project/evil", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+		// A valid symlink in the archive cannot be written through either
+		"through symlink": {
+			{Name: "project/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "project/link", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "project/link/../../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		// Chained symlinks whose targets look inside once cleaned, but
+		// resolve to tempDir/evil through "project/a" -> "."
+		"chained symlink": {
+			{Name: "project/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "project/a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "project/x/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "project/x/b", Typeflag: tar.TypeSymlink, Linkname: "../a/../../evil"},
+		},
+		"symlink under symlink": {
+			{Name: "project/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "project/a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "project/a/b", Typeflag: tar.TypeSymlink, Linkname: "../../evil"},
+		},
+		"device": {{Name: "project/null", Typeflag: tar.TypeChar, Mode: 0666}},
+	}
+	for name, members := range cases {
+		var archive bytes.Buffer
+		writer := tar.NewWriter(&archive)
+		for _, member := range members {
+			if err := writer.WriteHeader(member); err != nil {
+				t.Fatalf("%s: failed to write archive: %v", name, err)
+			}
+		}
+		writer.Close()
+
+		destDir := filepath.Join(tempDir, "dest")
+		if err := core.ExtractArchive(&archive, destDir, core.DecryptOptions{}); err == nil {
+			t.Errorf("%s: expected extraction to fail", name)
+		}
+		if _, err := os.Lstat(filepath.Join(tempDir, "evil")); err == nil {
+			t.Fatalf("%s: a file was written outside the output directory", name)
+		}
+		os.RemoveAll(destDir)
+	}
+}
@@ -0,0 +1,134 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestArmoredFile(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "notes.txt")
+	encryptedFile := testFile + ".enc.asc"
+	testData := bytes.Repeat([]byte("Armored files survive email. "), 5000)
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Armor: true}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password("armor-password"), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	armored, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read armored file: %v", err)
+	}
+	if !strings.HasPrefix(string(armored), fileops.ArmorBegin+"\n") || !strings.HasSuffix(string(armored), fileops.ArmorEnd+"\n") {
+		t.Fatalf("Expected BEGIN and END lines")
+	}
+	if isArmored, err := core.IsArmored(encryptedFile); err != nil || !isArmored {
+		t.Fatalf("Expected the file to be detected as armored: %v", err)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid || !result.Armored {
+		t.Fatalf("Unexpected verification result: %v %+v", err, result)
+	}
+
+	// Decryption works on the decoded copy
+	source, cleanup, err := core.Dearmored(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to decode armor: %v", err)
+	}
+	outputFile := filepath.Join(tempDir, "notes.out")
+	err = core.DecryptFileWithOptions(source, outputFile, core.Password("armor-password"), core.DecryptOptions{})
+	cleanup()
+	if err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("Expected cleanup to remove the decoded copy")
+	}
+	decrypted, err := os.ReadFile(outputFile)
+	if err != nil || !bytes.Equal(decrypted, testData) {
+		t.Fatalf("Decrypted data doesn't match original: %v", err)
+	}
+
+	// Binary files are used as they are
+	if err := core.EncryptFileWithOptions(testFile, testFile+".enc", core.Password("armor-password"), core.EncryptOptions{KDF: opts.KDF}); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	source, cleanup, err = core.Dearmored(testFile + ".enc")
+	cleanup()
+	if err != nil || source != testFile+".enc" {
+		t.Errorf("Expected a binary file to be used as is: %q %v", source, err)
+	}
+
+	// A character changed in transit is reported as such
+	lines := strings.Split(string(armored), "\n")
+	line := []byte(lines[5])
+	if line[20] == 'a' {
+		line[20] = 'b'
+	} else {
+		line[20] = 'a'
+	}
+	lines[5] = string(line)
+	if err := os.WriteFile(encryptedFile, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatalf("Failed to write armored file: %v", err)
+	}
+	result, err = core.VerifyFile(encryptedFile)
+	if err != nil || result.IsValid || !strings.Contains(result.ErrorMessage, "checksum") {
+		t.Errorf("Expected a checksum error: %v %+v", err, result)
+	}
+	if _, _, err := core.Dearmored(encryptedFile); !errors.Is(err, fileops.ErrArmorChecksum) {
+		t.Errorf("Expected ErrArmorChecksum, got %v", err)
+	}
+
+	opts.Recovery = 10
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password("armor-password"), opts); err == nil {
+		t.Error("Expected armor with a recovery record to be rejected")
+	}
+}
+
+func TestEncryptText(t *testing.T) {
+	text := []byte("The vault combination is 12-34-56.\n")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Pad: true}
+
+	var armored bytes.Buffer
+	if err := core.EncryptText(&armored, text, core.Password("text-password"), opts); err != nil {
+		t.Fatalf("Failed to encrypt text: %v", err)
+	}
+
+	// Pasted with text around it, as in an email reply
+	pasted := "> see below\n\n" + armored.String() + "\nthanks\n"
+	decrypted, err := core.DecryptText(strings.NewReader(pasted), core.Password("text-password"))
+	if err != nil || !bytes.Equal(decrypted, text) {
+		t.Fatalf("Text did not round-trip: %q %v", decrypted, err)
+	}
+
+	// The armor can be read first and decrypted later
+	data, err := core.ReadArmor(bytes.NewReader(armored.Bytes()))
+	if err != nil || !bytes.HasPrefix(data, []byte(fileops.MagicBytes)) {
+		t.Fatalf("Failed to read armor: %v", err)
+	}
+	decrypted, err = core.DecryptText(bytes.NewReader(data), core.Password("text-password"))
+	if err != nil || !bytes.Equal(decrypted, text) {
+		t.Fatalf("Text did not round-trip from binary: %q %v", decrypted, err)
+	}
+
+	if _, err := core.DecryptText(bytes.NewReader(armored.Bytes()), core.Password("wrong-password")); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+
+	if err := core.EncryptText(&armored, make([]byte, core.MaxTextSize+1), core.Password("text-password"), opts); err == nil {
+		t.Error("Expected a text over MaxTextSize to be rejected")
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"golang.org/x/sys/unix"
+)
+
+func TestPreserveXattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	value := []byte("deployment script")
+
+	encryptedFile, _ := encryptWithAttributes(t, tempDir, core.EncryptOptions{}, func(path string) {
+		if err := unix.Setxattr(path, "user.comment", value, 0); err != nil {
+			if errors.Is(err, unix.ENOTSUP) {
+				t.Skip("File system does not support extended attributes")
+			}
+			t.Fatalf("Failed to set extended attribute: %v", err)
+		}
+	})
+
+	meta, err := core.ReadMetadata(encryptedFile, core.Password("attributes-password"))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if !bytes.Equal(meta.Attributes.Xattrs["user.comment"], value) {
+		t.Errorf("Expected user.comment to be recorded: %v", meta.Attributes.Xattrs)
+	}
+	if meta.Attributes.AccessTime == 0 {
+		t.Error("Expected the access time to be recorded")
+	}
+
+	restored := filepath.Join(tempDir, "restored.sh")
+	if err := core.DecryptFileWithOptions(encryptedFile, restored, core.Password("attributes-password"), core.DecryptOptions{Preserve: true}); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := unix.Getxattr(restored, "user.comment", buf)
+	if err != nil || !bytes.Equal(buf[:n], value) {
+		t.Errorf("Expected user.comment to be restored: %q %v", buf[:n], err)
+	}
+}
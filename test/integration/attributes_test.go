@@ -0,0 +1,118 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// encryptWithAttributes writes an executable with old timestamps and
+// encrypts it; prepare may add more attributes before encryption
+func encryptWithAttributes(t *testing.T, dir string, opts core.EncryptOptions, prepare func(path string)) (string, time.Time) {
+	t.Helper()
+
+	testFile := filepath.Join(dir, "deploy.sh")
+	encryptedFile := filepath.Join(dir, "deploy.sh.enc")
+	if err := os.WriteFile(testFile, []byte("#!/bin/sh\necho deployed\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Chmod(testFile, 0750); err != nil {
+		t.Fatalf("Failed to chmod test file: %v", err)
+	}
+	if prepare != nil {
+		prepare(testFile)
+	}
+	modified := time.Date(2020, 2, 29, 12, 30, 45, 123456789, time.UTC)
+	if err := os.Chtimes(testFile, modified.Add(time.Hour), modified); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+
+	opts.KDF = crypto.PBKDF2Params(crypto.MinIterations)
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password("attributes-password"), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	return encryptedFile, modified
+}
+
+func TestPreserveAttributes(t *testing.T) {
+	tempDir := t.TempDir()
+	creds := core.Password("attributes-password")
+	encryptedFile, modified := encryptWithAttributes(t, tempDir, core.EncryptOptions{}, nil)
+
+	meta, err := core.ReadMetadata(encryptedFile, creds)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	attrs := meta.Attributes
+	if attrs == nil {
+		t.Fatal("Expected recorded file attributes")
+	}
+	if attrs.FileMode() != 0750 || !attrs.Modified().Equal(modified) {
+		t.Errorf("Unexpected attributes: mode %s, modified %s", attrs.FileMode(), attrs.Modified())
+	}
+	if attrs.UID != nil || attrs.GID != nil {
+		t.Error("The owner should only be recorded on request")
+	}
+
+	preserved := filepath.Join(tempDir, "preserved.sh")
+	if err := core.DecryptFileWithOptions(encryptedFile, preserved, creds, core.DecryptOptions{Preserve: true}); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+	info, err := os.Stat(preserved)
+	if err != nil {
+		t.Fatalf("Failed to stat decrypted file: %v", err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("Expected mode 0750, got %s", info.Mode())
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Errorf("Expected modification time %s, got %s", modified, info.ModTime())
+	}
+
+	plain := filepath.Join(tempDir, "plain.sh")
+	if err := core.DecryptFileWithOptions(encryptedFile, plain, creds, core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+	info, err = os.Stat(plain)
+	if err != nil {
+		t.Fatalf("Failed to stat decrypted file: %v", err)
+	}
+	if info.ModTime().Equal(modified) {
+		t.Error("Attributes should only be restored with Preserve")
+	}
+}
+
+func TestRecordOwner(t *testing.T) {
+	tempDir := t.TempDir()
+	encryptedFile, _ := encryptWithAttributes(t, tempDir, core.EncryptOptions{RecordOwner: true}, nil)
+
+	meta, err := core.ReadMetadata(encryptedFile, core.Password("attributes-password"))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if meta.Attributes.UID == nil || *meta.Attributes.UID != os.Getuid() || *meta.Attributes.GID != os.Getgid() {
+		t.Errorf("Expected the owner to be recorded: %+v", meta.Attributes)
+	}
+
+	// The owner can always be restored to itself
+	restored := filepath.Join(tempDir, "restored.sh")
+	if err := core.DecryptFileWithOptions(encryptedFile, restored, core.Password("attributes-password"), core.DecryptOptions{Preserve: true}); err != nil {
+		t.Fatalf("Failed to decrypt with the recorded owner: %v", err)
+	}
+}
+
+func TestPlainNameRecordsNoAttributes(t *testing.T) {
+	encryptedFile, _ := encryptWithAttributes(t, t.TempDir(), core.EncryptOptions{PlaintextMetadata: true}, nil)
+
+	meta, err := core.ReadMetadata(encryptedFile, core.Credentials{})
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if meta.Attributes != nil {
+		t.Error("Attributes must not be stored in the cleartext header")
+	}
+}
@@ -0,0 +1,197 @@
+package integration
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/config"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// testCA is a self-signed CA that issues test certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate for key valid from notBefore to notAfter
+// and the PKCS#8 PEM private key
+func (ca *testCA) issue(t *testing.T, name string, key stdcrypto.Signer, usage x509.KeyUsage, notBefore, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     usage,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestCertRecipients(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Hello FileVault Certificate Recipients!")
+	now := time.Now()
+
+	ca := newTestCA(t, "FileVault Test CA")
+	roots, err := crypto.LoadCertPool(ca.pem)
+	if err != nil {
+		t.Fatalf("Failed to load CA bundle: %v", err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA key: %v", err)
+	}
+	rsaCert, rsaPrivate := ca.issue(t, "alice", rsaKey, x509.KeyUsageKeyEncipherment, now.Add(-time.Hour), now.Add(time.Hour))
+	ecCert, ecPrivate := ca.issue(t, "bob", ecKey, x509.KeyUsageKeyAgreement, now.Add(-time.Hour), now.Add(time.Hour))
+
+	var recipients []crypto.Recipient
+	for _, certPEM := range [][]byte{rsaCert, ecCert} {
+		recipient, err := crypto.ParseCertRecipient(certPEM, roots, now)
+		if err != nil {
+			t.Fatalf("Failed to parse certificate recipient: %v", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if recipients[0].String() != "CN=alice" {
+		t.Errorf("Unexpected recipient name: %s", recipients[0])
+	}
+
+	testFile := filepath.Join(tempDir, "cert.txt")
+	encryptedFile := filepath.Join(tempDir, "cert.txt.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	opts := core.EncryptOptions{Recipients: recipients}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Credentials{}, opts); err != nil {
+		t.Fatalf("Failed to encrypt to certificates: %v", err)
+	}
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 2 || slots[0].Type != "x509-rsa" || slots[1].Type != "x509-ecdh" {
+		t.Fatalf("Unexpected key slots: %+v %v", slots, err)
+	}
+
+	// A file holding both the certificate and the key works as a key file
+	keys := map[string][]byte{"rsa": rsaPrivate, "ecdsa": append(ecCert, ecPrivate...)}
+	for name, keyPEM := range keys {
+		identities, err := crypto.ParsePrivateKeyIdentities(keyPEM, nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %s key: %v", name, err)
+		}
+		if !checkUnlocks(t, encryptedFile, core.Credentials{Identities: identities}, testData) {
+			t.Errorf("The %s key should unlock the file", name)
+		}
+	}
+}
+
+func TestCertRecipientChecks(t *testing.T) {
+	now := time.Now()
+	ca := newTestCA(t, "FileVault Test CA")
+	roots, err := crypto.LoadCertPool(ca.pem)
+	if err != nil {
+		t.Fatalf("Failed to load CA bundle: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA key: %v", err)
+	}
+
+	expired, _ := ca.issue(t, "expired", key, x509.KeyUsageKeyAgreement, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	future, _ := ca.issue(t, "future", key, x509.KeyUsageKeyAgreement, now.Add(time.Hour), now.Add(2*time.Hour))
+	signing, _ := ca.issue(t, "signing", key, x509.KeyUsageDigitalSignature, now.Add(-time.Hour), now.Add(time.Hour))
+	untrusted, _ := newTestCA(t, "Other CA").issue(t, "stranger", key, x509.KeyUsageKeyAgreement, now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		cert    []byte
+		wantErr string
+	}{
+		{"expired", expired, "expired"},
+		{"not yet valid", future, "not valid until"},
+		{"signing only", signing, "key agreement"},
+		{"untrusted CA", untrusted, "not trusted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := crypto.ParseCertRecipient(tt.cert, roots, now)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := crypto.ParseCertRecipient(signing, nil, now); err == nil {
+		t.Error("Expected a certificate without a CA bundle to be rejected")
+	}
+}
+
+func TestConfigCABundle(t *testing.T) {
+	if _, err := (&config.Config{}).CertPool(); err == nil {
+		t.Error("Expected an error without a configured CA bundle")
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, newTestCA(t, "FileVault Test CA").pem, 0644); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+	if _, err := (&config.Config{CABundle: path}).CertPool(); err != nil {
+		t.Errorf("Failed to load CA bundle: %v", err)
+	}
+}
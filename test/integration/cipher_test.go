@@ -0,0 +1,180 @@
+package integration
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestXChaCha20Poly1305RoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := bytes.Repeat([]byte("Hello FileVault XChaCha20-Poly1305! "), 5000)
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{Algorithm: fileops.AlgorithmXChaCha20Poly1305}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	header := readHeader(t, encryptedFile)
+	if header.Algorithm != fileops.AlgorithmXChaCha20Poly1305 {
+		t.Errorf("Expected XChaCha20-Poly1305 algorithm id, got %d", header.Algorithm)
+	}
+	if extension := header.BaseNonce(crypto.XNonceSize)[fileops.IVSize:]; bytes.Equal(extension, make([]byte, len(extension))) {
+		t.Error("Expected a random 24-byte base nonce, nonce extension is empty")
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+	}
+	if result.Algorithm != "XChaCha20-Poly1305" {
+		t.Errorf("Unexpected algorithm name: %s", result.Algorithm)
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
+
+func TestAlgorithmIsAuthenticated(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+
+	encryptedFile := encryptTestFile(t, tempDir, []byte("Hello FileVault!"), password)
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+	// Claim the other cipher; the header checksum is recomputed so only
+	// authentication can catch the change
+	rewriteHeader(t, encryptedFile, func(h *fileops.FileHeader) {
+		h.Algorithm = fileops.AlgorithmXChaCha20Poly1305
+		h.UpdateChecksum()
+	})
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err == nil {
+		t.Fatal("Decryption should fail after the algorithm id was changed")
+	}
+}
+
+func TestParseCipherName(t *testing.T) {
+	cases := map[string]uint32{
+		"aes-256-gcm":        crypto.AlgorithmAES256GCM,
+		"xchacha20-poly1305": crypto.AlgorithmXChaCha20Poly1305,
+		"auto":               crypto.PreferredAlgorithm(),
+	}
+	for name, expected := range cases {
+		algorithm, err := crypto.ParseCipherName(name)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if algorithm != expected {
+			t.Errorf("%s: expected %d, got %d", name, expected, algorithm)
+		}
+	}
+
+	if _, err := crypto.ParseCipherName("rot13"); err == nil {
+		t.Error("Unknown cipher name should be rejected")
+	}
+}
+
+// countingAEAD wraps another AEAD and counts how often it is used.
+// Segments may be sealed on several workers, so the counters are atomic.
+type countingAEAD struct {
+	crypto.AEAD
+	seals, opens atomic.Int64
+}
+
+func (c *countingAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	c.seals.Add(1)
+	return c.AEAD.Seal(dst, nonce, plaintext, additionalData)
+}
+
+func (c *countingAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	c.opens.Add(1)
+	return c.AEAD.Open(dst, nonce, ciphertext, additionalData)
+}
+
+func TestAlgorithmRegistry(t *testing.T) {
+	const testAlgorithm = 200
+
+	var instances []*countingAEAD
+	err := crypto.Register(crypto.Algorithm{
+		ID:        testAlgorithm,
+		Name:      "Test-AEAD",
+		NonceSize: crypto.NonceSize,
+		New: func(key []byte) (crypto.AEAD, error) {
+			inner, err := crypto.NewAEAD(crypto.AlgorithmAES256GCM, key)
+			if err != nil {
+				return nil, err
+			}
+			instance := &countingAEAD{AEAD: inner}
+			instances = append(instances, instance)
+			return instance, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register algorithm: %v", err)
+	}
+	defer crypto.Unregister(testAlgorithm)
+
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := make([]byte, 3*fileops.DefaultSegmentSize+1)
+
+	testFile := filepath.Join(tempDir, "test.dat")
+	encryptedFile := filepath.Join(tempDir, "test.dat.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.dat")
+
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{Algorithm: testAlgorithm}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+
+	// One AEAD per key, used once per segment and once for the metadata block
+	if len(instances) != 2 {
+		t.Fatalf("Expected one AEAD per operation, got %d", len(instances))
+	}
+	if seals, opens := instances[0].seals.Load(), instances[1].opens.Load(); seals != 5 || opens != 5 {
+		t.Errorf("Expected 5 seals and 5 opens, got %d and %d", seals, opens)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || result.Algorithm != "Test-AEAD" {
+		t.Errorf("Expected registered name in verification, got %q (%v)", result.Algorithm, err)
+	}
+
+	// Once unregistered, files using the algorithm are rejected
+	crypto.Unregister(testAlgorithm)
+	os.Remove(decryptedFile)
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err == nil {
+		t.Error("Decryption should fail for an unregistered algorithm")
+	}
+}
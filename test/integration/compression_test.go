@@ -0,0 +1,156 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	password := "testpassword123"
+	testData := []byte(strings.Repeat("FileVault compression test line with some repetition\n", 20000))
+
+	for _, algorithm := range []uint8{compression.Gzip, compression.Zstd} {
+		t.Run(compression.AlgorithmName(algorithm), func(t *testing.T) {
+			tempDir := t.TempDir()
+			testFile := filepath.Join(tempDir, "test.txt")
+			encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+			decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+			if err := os.WriteFile(testFile, testData, 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+
+			params := compression.Params{Algorithm: algorithm}
+			opts := core.EncryptOptions{Compression: params}
+			if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+				t.Fatalf("Failed to encrypt file: %v", err)
+			}
+
+			header := readHeader(t, encryptedFile)
+			if !header.HasFlag(fileops.FlagCompressed) || header.Compression() != params {
+				t.Errorf("Compression not recorded in header: %+v", header.Compression())
+			}
+
+			result, err := core.VerifyFile(encryptedFile)
+			if err != nil || !result.IsValid {
+				t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+			}
+			if result.StoredSize == 0 || result.StoredSize >= result.OriginalSize/10 {
+				t.Errorf("Expected a small compressed size, got %d of %d", result.StoredSize, result.OriginalSize)
+			}
+
+			if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+				t.Fatalf("Failed to decrypt file: %v", err)
+			}
+			decryptedData, err := os.ReadFile(decryptedFile)
+			if err != nil {
+				t.Fatalf("Failed to read decrypted file: %v", err)
+			}
+			if !bytes.Equal(decryptedData, testData) {
+				t.Error("Decrypted data doesn't match original")
+			}
+		})
+	}
+}
+
+func TestCompressionSkippedForRandomData(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+
+	testData := make([]byte, 200*1024)
+	if _, err := rand.Read(testData); err != nil {
+		t.Fatalf("Failed to generate test data: %v", err)
+	}
+
+	testFile := filepath.Join(tempDir, "photo.jpg")
+	encryptedFile := filepath.Join(tempDir, "photo.jpg.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{Compression: compression.Params{Algorithm: compression.Zstd}}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	header := readHeader(t, encryptedFile)
+	if header.HasFlag(fileops.FlagCompressed) || header.Compression().Enabled() {
+		t.Errorf("High-entropy input should be stored uncompressed, got %s", header.Compression())
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+	}
+	if result.StoredSize != result.OriginalSize || result.Compression != "none" {
+		t.Errorf("Unexpected stored size %d (%s) for %d bytes", result.StoredSize, result.Compression, result.OriginalSize)
+	}
+}
+
+func TestCompressedFileStreamAccess(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := []byte(strings.Repeat("0123456789abcdef", 10000))
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{Compression: compression.Params{Algorithm: compression.Gzip, Level: 9}}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	// Compressed payloads cannot be decrypted at arbitrary offsets
+	if _, err := core.OpenReader(encryptedFile, core.Password(password)); !errors.Is(err, core.ErrRandomAccessUnsupported) {
+		t.Fatalf("Expected random access to be refused, got: %v", err)
+	}
+
+	stream, header, err := core.OpenStream(encryptedFile, core.Password(password))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	if header.OriginalSize != uint64(len(testData)) || !bytes.Equal(data, testData) {
+		t.Error("Streamed plaintext doesn't match original")
+	}
+}
+
+func TestCompressionEntropy(t *testing.T) {
+	text := []byte(strings.Repeat("plain english text compresses well. ", 1000))
+	if compression.LooksCompressed(text) {
+		t.Errorf("Text should not look compressed (entropy %.2f)", compression.Entropy(text))
+	}
+
+	random := make([]byte, compression.SampleSize)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+	if !compression.LooksCompressed(random) {
+		t.Errorf("Random data should look compressed (entropy %.2f)", compression.Entropy(random))
+	}
+
+	if err := (compression.Params{Algorithm: compression.Gzip, Level: 12}).Validate(); err == nil {
+		t.Error("Out-of-range gzip level should be rejected")
+	}
+	if _, err := compression.ParseName("lz4"); err == nil {
+		t.Error("Unknown compression name should be rejected")
+	}
+}
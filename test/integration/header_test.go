@@ -0,0 +1,97 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// encryptTestFile writes data to a temp file and encrypts it, returning the .enc path
+func encryptTestFile(t *testing.T, dir string, data []byte, password string) string {
+	t.Helper()
+
+	testFile := filepath.Join(dir, "test.txt")
+	encryptedFile := filepath.Join(dir, "test.txt.enc")
+
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := core.EncryptFile(testFile, encryptedFile, password); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	return encryptedFile
+}
+
+// rewriteHeader parses the header of an encrypted file, applies modify and writes it back
+func rewriteHeader(t *testing.T, path string, modify func(h *fileops.FileHeader)) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+
+	var header fileops.FileHeader
+	n, err := header.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+
+	modify(&header)
+
+	var buf bytes.Buffer
+	header.WriteTo(&buf)
+	buf.Write(data[n:])
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write encrypted file: %v", err)
+	}
+}
+
+func TestHeaderChecksumDetectsCorruption(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	encryptedFile := encryptTestFile(t, tempDir, []byte("Hello FileVault Header Test!"), password)
+
+	// Flip a byte of the salt without fixing the checksum
+	rewriteHeader(t, encryptedFile, func(h *fileops.FileHeader) {
+		h.Salt[0] ^= 0x01
+	})
+
+	err := core.DecryptFile(encryptedFile, filepath.Join(tempDir, "out.txt"), password)
+	if !errors.Is(err, fileops.ErrHeaderCorrupted) {
+		t.Fatalf("Expected header corruption error, got: %v", err)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Verification returned error: %v", err)
+	}
+	if result.IsValid || result.HeaderValid {
+		t.Error("Verification should reject a header with a bad checksum")
+	}
+}
+
+func TestHeaderIsAuthenticated(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	encryptedFile := encryptTestFile(t, tempDir, []byte("Hello FileVault Header Test!"), password)
+
+	// Change the recorded size and recompute the checksum, as an attacker could
+	rewriteHeader(t, encryptedFile, func(h *fileops.FileHeader) {
+		h.OriginalSize++
+		h.UpdateChecksum()
+	})
+
+	err := core.DecryptFile(encryptedFile, filepath.Join(tempDir, "out.txt"), password)
+	if !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Fatalf("Expected authentication failure, got: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestHybridRecipientKeyEncoding(t *testing.T) {
+	identity, err := crypto.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate hybrid identity: %v", err)
+	}
+
+	publicKey := identity.Recipient().String()
+	if !strings.HasPrefix(publicKey, crypto.HybridRecipientPrefix) {
+		t.Fatalf("Unexpected public key encoding: %.20s...", publicKey)
+	}
+	recipient, err := crypto.ParseRecipient(publicKey)
+	if err != nil || recipient.String() != publicKey || recipient.SlotType() != fileops.KeySlotHybrid {
+		t.Fatalf("Public key did not round-trip: %v", err)
+	}
+
+	identities, err := crypto.ParseIdentities(strings.NewReader(identity.String() + "\n"))
+	if err != nil || len(identities) != 1 || identities[0].Recipient().String() != publicKey {
+		t.Fatalf("Identity did not round-trip: %v", err)
+	}
+}
+
+func TestHybridRecipientEncryptDecrypt(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Archive that must stay confidential for decades")
+
+	archive, err := crypto.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate hybrid identity: %v", err)
+	}
+	other, err := crypto.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate hybrid identity: %v", err)
+	}
+	classic := newIdentity(t)
+
+	testFile := filepath.Join(tempDir, "archive.tar")
+	encryptedFile := filepath.Join(tempDir, "archive.tar.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{Recipients: []crypto.Recipient{archive.Recipient(), classic.Recipient()}}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Credentials{}, opts); err != nil {
+		t.Fatalf("Failed to encrypt to hybrid recipient: %v", err)
+	}
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 2 || slots[0].Type != "mlkem768-x25519" {
+		t.Fatalf("Unexpected key slots: %+v %v", slots, err)
+	}
+
+	if !checkUnlocks(t, encryptedFile, core.Credentials{Identities: []crypto.Identity{other, archive}}, testData) {
+		t.Error("The hybrid identity should unlock the file")
+	}
+	if _, err := core.OpenReader(encryptedFile, core.Credentials{Identities: []crypto.Identity{other}}); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected an unrelated hybrid identity to fail, got: %v", err)
+	}
+
+	// The ML-KEM ciphertext is bound into the key-encryption key
+	recipient := archive.Recipient()
+	body, err := recipient.Wrap(make([]byte, crypto.KeySize), []byte("context"))
+	if err != nil {
+		t.Fatalf("Failed to wrap: %v", err)
+	}
+	body[10] ^= 0x01
+	if _, err := archive.Unwrap(recipient.SlotType(), body, []byte("context")); !errors.Is(err, crypto.ErrIdentityMismatch) {
+		t.Errorf("Expected a tampered ML-KEM ciphertext to fail, got: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package integration
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/config"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// readHeader parses the header of an encrypted file
+func readHeader(t *testing.T, path string) *fileops.FileHeader {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open encrypted file: %v", err)
+	}
+	defer file.Close()
+
+	var header fileops.FileHeader
+	if _, err := header.ReadFrom(file); err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	return &header
+}
+
+func TestKDFParamsRecordedInHeader(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := []byte("Hello FileVault KDF Test!")
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(20000)}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	params := readHeader(t, encryptedFile).KDFParams()
+	if params.Algorithm != crypto.KDFPBKDF2SHA256 || params.Iterations != 20000 {
+		t.Errorf("Unexpected KDF parameters in header: %+v", params)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+	}
+	if result.KDF != "PBKDF2-SHA256 (20,000 iterations)" {
+		t.Errorf("Unexpected KDF description: %s", result.KDF)
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
+
+func TestKDFParamsValidation(t *testing.T) {
+	if err := crypto.PBKDF2Params(1000).Validate(); err == nil {
+		t.Error("Too few PBKDF2 iterations should be rejected")
+	}
+
+	if err := crypto.PBKDF2Params(crypto.DefaultIterations).Validate(); err != nil {
+		t.Errorf("Default PBKDF2 iterations should be accepted: %v", err)
+	}
+
+	if err := crypto.Argon2idParams(1024, 3, 4).Validate(); err == nil {
+		t.Error("Too little Argon2id memory should be rejected")
+	}
+
+	if err := crypto.DefaultArgon2idParams().Validate(); err != nil {
+		t.Errorf("Default Argon2id parameters should be accepted: %v", err)
+	}
+
+	if err := (crypto.KDFParams{Algorithm: 200}).Validate(); err == nil {
+		t.Error("Unknown KDF should be rejected")
+	}
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := []byte("Hello FileVault Argon2id Test!")
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// Keep memory at the minimum so the test stays fast
+	kdf := crypto.Argon2idParams(crypto.MinArgon2Memory, 1, 2)
+	opts := core.EncryptOptions{KDF: kdf}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	if params := readHeader(t, encryptedFile).KDFParams(); params != kdf {
+		t.Errorf("Unexpected KDF parameters in header: %+v", params)
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, "wrongpassword"); err == nil {
+		t.Fatal("Decryption with wrong password should fail")
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
+
+func TestConfigSelectsKDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), config.FileName)
+	data := `{"default_kdf": "argon2id", "argon2_memory": 16, "argon2_time": 2}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	params, err := cfg.KDFParams()
+	if err != nil {
+		t.Fatalf("Failed to resolve KDF: %v", err)
+	}
+
+	expected := crypto.Argon2idParams(16*1024, 2, crypto.DefaultArgon2Parallelism)
+	if params != expected {
+		t.Errorf("Expected %+v, got %+v", expected, params)
+	}
+
+	// A missing config file falls back to the defaults
+	cfg, err = config.LoadFile(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("Missing config should not be an error: %v", err)
+	}
+	if params, _ := cfg.KDFParams(); params != crypto.DefaultKDFParams() {
+		t.Errorf("Expected default KDF, got %+v", params)
+	}
+}
@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/pkg/filevault"
+)
+
+// writeKeyfile writes a keyfile with the given content and returns its path
+func writeKeyfile(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Failed to write keyfile: %v", err)
+	}
+	return path
+}
+
+func TestKeyfileFactors(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := bytes.Repeat([]byte("Hello FileVault Keyfile Test! "), 1000)
+	keyA := writeKeyfile(t, tempDir, "a.key", []byte("first keyfile"))
+	keyB := writeKeyfile(t, tempDir, "b.jpg", bytes.Repeat([]byte{0xff, 0xd8}, 4096))
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		creds   core.Credentials
+		factors string
+	}{
+		{"password", core.Password("testpassword123"), "password"},
+		{"combined", core.Credentials{Password: "testpassword123", Keyfiles: []string{keyA}}, "password + keyfile"},
+		{"keyfile-only", core.Credentials{Keyfiles: []string{keyA, keyB}}, "keyfile"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			encryptedFile := filepath.Join(tempDir, tc.name+".enc")
+			decryptedFile := filepath.Join(tempDir, tc.name+".dec")
+
+			if err := core.EncryptFileWithOptions(testFile, encryptedFile, tc.creds, core.EncryptOptions{}); err != nil {
+				t.Fatalf("Failed to encrypt file: %v", err)
+			}
+
+			result, err := core.VerifyFile(encryptedFile)
+			if err != nil || !result.IsValid {
+				t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+			}
+			if result.Unlock != tc.factors {
+				t.Errorf("Expected factors %q, got %q", tc.factors, result.Unlock)
+			}
+
+			// Keyfile order does not matter, and a password given for a
+			// keyfile-only file is ignored
+			creds := tc.creds
+			if len(creds.Keyfiles) > 1 {
+				creds.Keyfiles = []string{keyB, keyA}
+				creds.Password = "ignored"
+			}
+			if err := core.DecryptFileWithOptions(encryptedFile, decryptedFile, creds, core.DecryptOptions{}); err != nil {
+				t.Fatalf("Failed to decrypt file: %v", err)
+			}
+
+			decryptedData, err := os.ReadFile(decryptedFile)
+			if err != nil {
+				t.Fatalf("Failed to read decrypted file: %v", err)
+			}
+			if !bytes.Equal(decryptedData, testData) {
+				t.Error("Decrypted data doesn't match original")
+			}
+		})
+	}
+}
+
+func TestKeyfileRequired(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	keyfile := writeKeyfile(t, tempDir, "right.key", []byte("right keyfile"))
+	wrongKeyfile := writeKeyfile(t, tempDir, "wrong.key", []byte("wrong keyfile"))
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+	if err := os.WriteFile(testFile, []byte("Hello FileVault!"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	creds := core.Credentials{Password: password, Keyfiles: []string{keyfile}}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, creds, core.EncryptOptions{}); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	if _, err := core.OpenReader(encryptedFile, core.Password(password)); !errors.Is(err, core.ErrKeyfileRequired) {
+		t.Errorf("Expected missing keyfile error, got: %v", err)
+	}
+
+	wrong := []core.Credentials{
+		{Password: password, Keyfiles: []string{wrongKeyfile}},
+		{Password: "wrongpassword", Keyfiles: []string{keyfile}},
+		{Password: password, Keyfiles: []string{keyfile, keyfile}},
+		{Keyfiles: []string{keyfile}},
+	}
+	for _, c := range wrong {
+		if _, err := core.OpenReader(encryptedFile, c); !errors.Is(err, crypto.ErrDecryptionFailed) {
+			t.Errorf("Expected authentication failure for %+v, got: %v", c, err)
+		}
+	}
+
+	// Keyfiles are rejected for files that do not use them
+	plainFile := encryptTestFile(t, tempDir, []byte("no keyfile"), password)
+	if _, err := core.OpenReader(plainFile, creds); !errors.Is(err, core.ErrKeyfileNotUsed) {
+		t.Errorf("Expected unused keyfile error, got: %v", err)
+	}
+
+	empty := writeKeyfile(t, tempDir, "empty.key", nil)
+	err := core.EncryptFileWithOptions(testFile, filepath.Join(tempDir, "empty.enc"), core.Credentials{Keyfiles: []string{empty}}, core.EncryptOptions{})
+	if !errors.Is(err, crypto.ErrEmptyKeyfile) {
+		t.Errorf("Expected empty keyfile error, got: %v", err)
+	}
+
+	err = core.EncryptFileWithOptions(testFile, filepath.Join(tempDir, "none.enc"), core.Credentials{}, core.EncryptOptions{})
+	if !errors.Is(err, core.ErrNoCredentials) {
+		t.Errorf("Expected missing credentials error, got: %v", err)
+	}
+}
+
+func TestClientKeyfiles(t *testing.T) {
+	tempDir := t.TempDir()
+	keyfile := writeKeyfile(t, tempDir, "client.key", []byte("client keyfile"))
+	testData := []byte("Hello FileVault Client Keyfile Test!")
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	encryptedFile := filepath.Join(tempDir, "test.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	client := filevault.NewClient(filevault.WithKeyfiles(keyfile), filevault.WithPBKDF2(crypto.MinIterations))
+	if err := client.EncryptFileWithOutput(testFile, encryptedFile, ""); err != nil {
+		t.Fatalf("Failed to encrypt with keyfile only: %v", err)
+	}
+
+	if err := filevault.NewClient().DecryptFileWithOutput(encryptedFile, decryptedFile, ""); !errors.Is(err, core.ErrKeyfileRequired) {
+		t.Errorf("Expected missing keyfile error, got: %v", err)
+	}
+
+	if err := client.DecryptFileWithOutput(encryptedFile, decryptedFile, ""); err != nil {
+		t.Fatalf("Failed to decrypt with keyfile: %v", err)
+	}
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
@@ -0,0 +1,222 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// encryptKeySlotFile encrypts data under password with a cheap KDF so that
+// tests trying several slots stay fast
+func encryptKeySlotFile(t *testing.T, dir string, data []byte, password string) string {
+	t.Helper()
+
+	testFile := filepath.Join(dir, "shared.txt")
+	encryptedFile := filepath.Join(dir, "shared.txt.enc")
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	return encryptedFile
+}
+
+// checkUnlocks reports whether creds open the file, and that the plaintext is intact
+func checkUnlocks(t *testing.T, path string, creds core.Credentials, expected []byte) bool {
+	t.Helper()
+
+	stream, _, err := core.OpenStream(path, creds)
+	if err != nil {
+		return false
+	}
+	defer stream.Close()
+
+	var plaintext bytes.Buffer
+	if _, err := plaintext.ReadFrom(stream); err != nil {
+		t.Fatalf("Failed to read plaintext: %v", err)
+	}
+	if !bytes.Equal(plaintext.Bytes(), expected) {
+		t.Fatal("Decrypted data doesn't match original")
+	}
+	return true
+}
+
+func TestKeySlotsAddChangeRemove(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := bytes.Repeat([]byte("Hello FileVault Key Slot Test! "), 5000)
+	alice, bob, carol := core.Password("alice-password"), core.Password("bob-password"), core.Password("carol-password")
+
+	encryptedFile := encryptKeySlotFile(t, tempDir, testData, alice.Password)
+	header := readHeader(t, encryptedFile)
+	if header.Version != fileops.FormatVersionV4 || !header.HasKeySlots() {
+		t.Fatalf("Expected a v4 file with key slots, got v%d (flags 0x%x)", header.Version, header.Flags())
+	}
+
+	original, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	originalInfo, err := os.Stat(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to stat encrypted file: %v", err)
+	}
+
+	index, err := core.AddKeySlot(encryptedFile, alice, bob)
+	if err != nil || index != 1 {
+		t.Fatalf("Failed to add key slot: %d %v", index, err)
+	}
+	if !checkUnlocks(t, encryptedFile, alice, testData) || !checkUnlocks(t, encryptedFile, bob, testData) {
+		t.Fatal("Both passwords should unlock the file")
+	}
+
+	// Only the key slot area changed; the payload was not re-encrypted
+	updated, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	payloadAt := header.GetTotalSize() + fileops.KeySlotAreaLengthSize + fileops.DefaultKeySlotCapacity
+	if len(updated) != len(original) || !bytes.Equal(updated[payloadAt:], original[payloadAt:]) {
+		t.Error("Adding a key slot should only rewrite the key slot area")
+	}
+
+	// The slots are never overwritten in place: a copy replaces the file,
+	// leaving no temporary file behind
+	if info, err := os.Stat(encryptedFile); err != nil || os.SameFile(info, originalInfo) {
+		t.Errorf("Expected the file to be replaced, not written in place: %v", err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 2 {
+		t.Errorf("Expected no temporary files to be left, got %d entries", len(entries))
+	}
+
+	if _, err := core.AddKeySlot(encryptedFile, carol, carol); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Adding a slot with an unknown password should fail, got: %v", err)
+	}
+
+	if _, err := core.ChangeKeySlot(encryptedFile, bob, carol); err != nil {
+		t.Fatalf("Failed to change key slot: %v", err)
+	}
+	if checkUnlocks(t, encryptedFile, bob, testData) || !checkUnlocks(t, encryptedFile, carol, testData) {
+		t.Error("Changing a slot should replace bob's password with carol's")
+	}
+
+	removed, err := core.RemoveKeySlot(encryptedFile, carol, -1)
+	if err != nil || removed != 1 {
+		t.Fatalf("Failed to remove key slot: %d %v", removed, err)
+	}
+	if checkUnlocks(t, encryptedFile, carol, testData) {
+		t.Error("A removed password should no longer unlock the file")
+	}
+
+	if _, err := core.RemoveKeySlot(encryptedFile, alice, -1); !errors.Is(err, core.ErrLastKeySlot) {
+		t.Errorf("Expected last slot error, got: %v", err)
+	}
+	if _, err := core.RemoveKeySlot(encryptedFile, alice, 5); !errors.Is(err, core.ErrNoSuchSlot) {
+		t.Errorf("Expected missing slot error, got: %v", err)
+	}
+}
+
+func TestKeySlotsMixedFactors(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Hello FileVault!")
+	keyfile := writeKeyfile(t, tempDir, "recovery.key", []byte("recovery keyfile"))
+	owner := core.Password("owner-password")
+	recovery := core.Credentials{Keyfiles: []string{keyfile}}
+
+	encryptedFile := encryptKeySlotFile(t, tempDir, testData, owner.Password)
+	if _, err := core.AddKeySlot(encryptedFile, owner, recovery); err != nil {
+		t.Fatalf("Failed to add keyfile slot: %v", err)
+	}
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 2 {
+		t.Fatalf("Expected two slots, got %v %v", slots, err)
+	}
+	if slots[0].Factors != fileops.FactorPassword || slots[1].Factors != fileops.FactorKeyfile {
+		t.Errorf("Unexpected slot factors: %v and %v", slots[0].Factors, slots[1].Factors)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+	}
+	if result.KeySlots != 2 || result.Unlock != "password or keyfile" {
+		t.Errorf("Unexpected key slot summary: %d %q", result.KeySlots, result.Unlock)
+	}
+
+	if !checkUnlocks(t, encryptedFile, recovery, testData) || !checkUnlocks(t, encryptedFile, owner, testData) {
+		t.Error("Either slot should unlock the file")
+	}
+}
+
+func TestKeySlotAreaGrows(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := bytes.Repeat([]byte("grow "), 1000)
+	owner := core.Password("owner-password")
+
+	encryptedFile := encryptKeySlotFile(t, tempDir, testData, owner.Password)
+
+	// Enough slots to overflow the default capacity; the file is then
+	// copied with a larger area instead of being re-encrypted
+	var last core.Credentials
+	for i := 0; i < 10; i++ {
+		last = core.Password(fmt.Sprintf("member-password-%d", i))
+		if _, err := core.AddKeySlot(encryptedFile, owner, last); err != nil {
+			t.Fatalf("Failed to add key slot %d: %v", i, err)
+		}
+	}
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 11 {
+		t.Fatalf("Expected 11 slots, got %d %v", len(slots), err)
+	}
+	if !checkUnlocks(t, encryptedFile, last, testData) || !checkUnlocks(t, encryptedFile, owner, testData) {
+		t.Error("Every slot should still unlock the file after the area grew")
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed after growing: %v %s", err, result.ErrorMessage)
+	}
+}
+
+func TestKeySlotTampering(t *testing.T) {
+	tempDir := t.TempDir()
+	owner := core.Password("owner-password")
+	encryptedFile := encryptKeySlotFile(t, tempDir, []byte("Hello FileVault!"), owner.Password)
+
+	// Flip a bit in the wrapped key of the only slot
+	data, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	header := readHeader(t, encryptedFile)
+	data[header.GetTotalSize()+fileops.KeySlotAreaLengthSize+fileops.KeySlotHeaderSize+60] ^= 0x01
+	if err := os.WriteFile(encryptedFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write tampered file: %v", err)
+	}
+
+	if _, err := core.OpenReader(encryptedFile, owner); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected authentication failure for a tampered slot, got: %v", err)
+	}
+}
+
+func TestKeySlotsRequireV4(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	encryptedFile := filepath.Join(tempDir, "legacy.txt.enc")
+	writeV1File(t, encryptedFile, []byte("legacy data"), password)
+
+	if _, err := core.AddKeySlot(encryptedFile, core.Password(password), core.Password("second")); !errors.Is(err, core.ErrNoKeySlots) {
+		t.Errorf("Expected no key slots error, got: %v", err)
+	}
+}
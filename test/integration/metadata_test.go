@@ -0,0 +1,136 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestFilenameHiddenByDefault(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := []byte("Hello FileVault Metadata Test!")
+
+	testFile := filepath.Join(tempDir, "quarterly-salaries.xlsx")
+	encryptedFile := filepath.Join(tempDir, "archive.enc")
+
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := core.EncryptFile(testFile, encryptedFile, password); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	raw, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	if bytes.Contains(raw, []byte("quarterly-salaries")) {
+		t.Error("Original filename appears in the encrypted file")
+	}
+
+	header := readHeader(t, encryptedFile)
+	if header.FileName != "" || !header.HasFlag(fileops.FlagEncryptedMetadata) {
+		t.Errorf("Expected hidden filename, got %q (flags 0x%x)", header.FileName, header.Flags())
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+	}
+	if !result.MetadataEncrypted || result.OriginalFilename != "" {
+		t.Errorf("Verification should not reveal the filename: %+v", result)
+	}
+
+	if _, err := core.ReadMetadata(encryptedFile, core.Password("wrongpassword")); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected authentication failure for wrong password, got: %v", err)
+	}
+
+	meta, err := core.ReadMetadata(encryptedFile, core.Password(password))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if meta.FileName != "quarterly-salaries.xlsx" {
+		t.Errorf("Unexpected filename in metadata: %q", meta.FileName)
+	}
+
+	// Without an output path the decrypted file gets its original name
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(workDir)
+
+	if err := os.Remove(testFile); err != nil {
+		t.Fatalf("Failed to remove original: %v", err)
+	}
+	if err := core.DecryptFile(encryptedFile, "", password); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+
+	decryptedData, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Decrypted file not written under its original name: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
+
+func TestPlaintextFilenameOption(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := []byte("Hello FileVault Metadata Test!")
+
+	testFile := filepath.Join(tempDir, "notes.txt")
+	encryptedFile := filepath.Join(tempDir, "notes.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{PlaintextMetadata: true}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	header := readHeader(t, encryptedFile)
+	if header.FileName != "notes.txt" || header.HasFlag(fileops.FlagEncryptedMetadata) {
+		t.Errorf("Expected plaintext filename, got %q (flags 0x%x)", header.FileName, header.Flags())
+	}
+
+	// The header name is returned without needing the password
+	meta, err := core.ReadMetadata(encryptedFile, core.Password(""))
+	if err != nil || meta.FileName != "notes.txt" {
+		t.Errorf("Unexpected metadata: %+v (%v)", meta, err)
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+}
+
+func TestMetadataFlagRequiresEmptyHeaderName(t *testing.T) {
+	header := fileops.NewFileHeader(0, "leak.txt", [32]byte{}, [16]byte{})
+	header.SetFlag(fileops.FlagEncryptedMetadata, true)
+	if err := header.IsValid(); err == nil {
+		t.Error("A header with encrypted metadata must not also store the filename")
+	}
+}
@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestPaddedRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	creds := core.Password("padding-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Pad: true}
+
+	// Sizes around segment boundaries, where the padding adds segments
+	for _, size := range []int{0, 1, 1000, 64*1024 - 10, 2*64*1024 + 7} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			testData := bytes.Repeat([]byte{0xA5}, size)
+			testFile := filepath.Join(tempDir, fmt.Sprintf("padded-%d.bin", size))
+			encryptedFile := testFile + ".enc"
+			decryptedFile := testFile + ".out"
+			if err := os.WriteFile(testFile, testData, 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			if err := core.EncryptFileWithOptions(testFile, encryptedFile, creds, opts); err != nil {
+				t.Fatalf("Failed to encrypt file: %v", err)
+			}
+
+			padded := fileops.PadmeSize(uint64(size))
+			result, err := core.VerifyFile(encryptedFile)
+			if err != nil || !result.IsValid {
+				t.Fatalf("Padded file should verify: %v %+v", err, result)
+			}
+			if !result.Padded || result.OriginalSize != padded || result.StoredSize != padded {
+				t.Errorf("Expected only the padded size %d to be visible: %+v", padded, result)
+			}
+
+			meta, err := core.ReadMetadata(encryptedFile, creds)
+			if err != nil || meta.Size != uint64(size) {
+				t.Fatalf("Expected the real size in the metadata: %+v %v", meta, err)
+			}
+
+			if err := core.DecryptFileWithOptions(encryptedFile, decryptedFile, creds, core.DecryptOptions{}); err != nil {
+				t.Fatalf("Failed to decrypt file: %v", err)
+			}
+			decrypted, err := os.ReadFile(decryptedFile)
+			if err != nil || !bytes.Equal(decrypted, testData) {
+				t.Fatalf("Decrypted data doesn't match original (%d bytes): %v", len(decrypted), err)
+			}
+
+			stream, header, err := core.OpenStream(encryptedFile, creds)
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			streamed, err := io.ReadAll(stream)
+			stream.Close()
+			if err != nil || !bytes.Equal(streamed, testData) || header.OriginalSize != uint64(size) {
+				t.Fatalf("Streamed data doesn't match original: %v", err)
+			}
+
+			reader, err := core.OpenReader(encryptedFile, creds)
+			if err != nil {
+				t.Fatalf("Failed to open reader: %v", err)
+			}
+			defer reader.Close()
+			if reader.Size() != int64(size) {
+				t.Errorf("Expected reader size %d, got %d", size, reader.Size())
+			}
+			tail := make([]byte, 16)
+			n, err := reader.ReadAt(tail, int64(size)-8)
+			if size >= 8 && (n != 8 || err != io.EOF || !bytes.Equal(tail[:n], testData[size-8:])) {
+				t.Errorf("Reads must stop at the real size: %d bytes, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestPaddingOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "options.txt")
+	if err := os.WriteFile(testFile, []byte("Hello FileVault Padding!"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	tests := map[string]core.EncryptOptions{
+		"plain name":  {Pad: true, PlaintextMetadata: true},
+		"compression": {Pad: true, Compression: compression.Params{Algorithm: compression.Gzip}},
+	}
+	for name, opts := range tests {
+		opts.KDF = crypto.PBKDF2Params(crypto.MinIterations)
+		if err := core.EncryptFileWithOptions(testFile, testFile+".enc", core.Password("padding-password"), opts); err == nil {
+			t.Errorf("Expected padding with %s to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/compression"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// onlyReader hides everything but Read, as a pipe would
+type onlyReader struct{ io.Reader }
+
+func TestEncryptStream(t *testing.T) {
+	tempDir := t.TempDir()
+	segment := fileops.DefaultSegmentSize
+	creds := core.Password("pipe-password")
+
+	for _, size := range []int{0, 1, segment, 3*segment + 17} {
+		for _, compressed := range []bool{false, true} {
+			testData := make([]byte, size)
+			if _, err := rand.Read(testData[:size/2]); err != nil {
+				t.Fatalf("Failed to generate test data: %v", err)
+			}
+
+			opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+			if compressed {
+				opts.Compression = compression.Params{Algorithm: compression.Zstd}
+			}
+			var encrypted bytes.Buffer
+			if err := core.EncryptStream(&encrypted, onlyReader{bytes.NewReader(testData)}, creds, opts); err != nil {
+				t.Fatalf("Size %d: failed to encrypt stream: %v", size, err)
+			}
+
+			stream, err := core.ReadEncryptedStream(bytes.NewReader(encrypted.Bytes()))
+			if err != nil || !stream.Header().HasFlag(fileops.FlagStreamed) || stream.Header().OriginalSize != 0 {
+				t.Fatalf("Size %d: expected a streamed header without size: %v", size, err)
+			}
+
+			// Back through a pipe
+			var decrypted bytes.Buffer
+			if err := core.DecryptStream(&decrypted, onlyReader{bytes.NewReader(encrypted.Bytes())}, creds, core.DecryptOptions{}); err != nil {
+				t.Fatalf("Size %d: failed to decrypt stream: %v", size, err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), testData) {
+				t.Fatalf("Size %d, compressed %v: stream did not round-trip", size, compressed)
+			}
+
+			// And as a file
+			encryptedFile := filepath.Join(tempDir, "stream.enc")
+			outputFile := filepath.Join(tempDir, "stream.out")
+			os.Remove(outputFile)
+			if err := os.WriteFile(encryptedFile, encrypted.Bytes(), 0644); err != nil {
+				t.Fatalf("Failed to write encrypted file: %v", err)
+			}
+			if err := core.DecryptFileWithOptions(encryptedFile, outputFile, creds, core.DecryptOptions{}); err != nil {
+				t.Fatalf("Size %d: failed to decrypt file: %v", size, err)
+			}
+			if data, err := os.ReadFile(outputFile); err != nil || !bytes.Equal(data, testData) {
+				t.Fatalf("Size %d, compressed %v: file did not round-trip: %v", size, compressed, err)
+			}
+
+			result, err := core.VerifyFile(encryptedFile)
+			if err != nil || !result.IsValid || !result.Streamed {
+				t.Fatalf("Size %d: unexpected verification result: %v %+v", size, err, result)
+			}
+			if !compressed && result.OriginalSize != uint64(size) {
+				t.Errorf("Size %d: verify reported %d bytes", size, result.OriginalSize)
+			}
+
+			if !compressed {
+				reader, err := core.OpenReader(encryptedFile, creds)
+				if err != nil {
+					t.Fatalf("Size %d: failed to open reader: %v", size, err)
+				}
+				if reader.Size() != int64(size) {
+					t.Errorf("Size %d: reader reported %d bytes", size, reader.Size())
+				}
+				reader.Close()
+			}
+		}
+	}
+}
+
+func TestDecryptStreamTruncated(t *testing.T) {
+	testData := bytes.Repeat([]byte("streamed "), fileops.DefaultSegmentSize/4)
+	creds := core.Password("pipe-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+
+	var encrypted bytes.Buffer
+	if err := core.EncryptStream(&encrypted, bytes.NewReader(testData), creds, opts); err != nil {
+		t.Fatalf("Failed to encrypt stream: %v", err)
+	}
+
+	// Without its final segment the stream must not pass for complete,
+	// even when it is cut where a segment ends
+	data := encrypted.Bytes()
+	lastSegment := len(testData)%fileops.DefaultSegmentSize + fileops.AuthTagSize
+	for _, n := range []int{len(data) - 1, len(data) - lastSegment, len(data) / 2} {
+		if err := core.DecryptStream(io.Discard, bytes.NewReader(data[:n]), creds, core.DecryptOptions{}); err == nil {
+			t.Errorf("Expected a stream cut to %d of %d bytes to fail", n, len(data))
+		}
+	}
+
+	if err := core.DecryptStream(io.Discard, bytes.NewReader(data), core.Password("wrong-password"), core.DecryptOptions{}); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+}
+
+func TestDecryptStreamSigned(t *testing.T) {
+	testData := bytes.Repeat([]byte("signed and piped "), 10000)
+	creds := core.Password("pipe-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Signer: newSigningKey(t)}
+
+	var encrypted bytes.Buffer
+	if err := core.EncryptStream(&encrypted, bytes.NewReader(testData), creds, opts); err != nil {
+		t.Fatalf("Failed to encrypt stream: %v", err)
+	}
+
+	// The trailer after the last segment is not taken for data
+	var decrypted bytes.Buffer
+	if err := core.DecryptStream(&decrypted, onlyReader{bytes.NewReader(encrypted.Bytes())}, creds, core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to decrypt signed stream: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), testData) {
+		t.Fatal("Signed stream did not round-trip")
+	}
+	if err := core.DecryptStream(io.Discard, bytes.NewReader(encrypted.Bytes()[:encrypted.Len()-10]), creds, core.DecryptOptions{}); err == nil {
+		t.Error("Expected a truncated trailer to fail")
+	}
+
+	encryptedFile := filepath.Join(t.TempDir(), "signed.enc")
+	if err := os.WriteFile(encryptedFile, encrypted.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write encrypted file: %v", err)
+	}
+	if _, err := core.VerifySignature(encryptedFile, []*crypto.MinisignPublicKey{opts.Signer.Public()}); err != nil {
+		t.Errorf("Expected the signature of a streamed file to verify: %v", err)
+	}
+}
+
+func TestEncryptStreamRestrictions(t *testing.T) {
+	tempDir := t.TempDir()
+	creds := core.Password("pipe-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+	testData := bytes.Repeat([]byte("restricted "), 5000)
+
+	padded := opts
+	padded.Pad = true
+	if err := core.EncryptStream(io.Discard, bytes.NewReader(testData), creds, padded); err == nil {
+		t.Error("Expected padding of a stream to be rejected")
+	}
+
+	// A recovery record is written back over the output, which a pipe cannot do
+	recovered := opts
+	recovered.Recovery = 10
+	if err := core.EncryptStream(&bytes.Buffer{}, bytes.NewReader(testData), creds, recovered); err == nil {
+		t.Error("Expected a recovery record to a pipe to be rejected")
+	}
+
+	encryptedFile := filepath.Join(tempDir, "recovered.enc")
+	output, err := os.Create(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to create output file: %v", err)
+	}
+	err = core.EncryptStream(output, bytes.NewReader(testData), creds, recovered)
+	output.Close()
+	if err != nil {
+		t.Fatalf("Expected a recovery record to a file to work: %v", err)
+	}
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid || result.Recovery == nil || !result.Recovery.Intact() {
+		t.Fatalf("Unexpected verification result: %v %+v", err, result)
+	}
+
+	// Which then has to be decrypted from the file
+	input, err := os.Open(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to open encrypted file: %v", err)
+	}
+	defer input.Close()
+	if err := core.DecryptStream(io.Discard, input, creds, core.DecryptOptions{}); !errors.Is(err, core.ErrPipeUnsupported) {
+		t.Errorf("Expected ErrPipeUnsupported, got %v", err)
+	}
+	if reader, err := core.OpenReader(encryptedFile, creds); err != nil {
+		t.Errorf("Failed to open the recovered file: %v", err)
+	} else {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if !bytes.Equal(data, testData) {
+			t.Error("Recovered stream did not round-trip")
+		}
+	}
+}
+
+func TestEncryptedStreamFactors(t *testing.T) {
+	tempDir := t.TempDir()
+	keyfile := filepath.Join(tempDir, "keyfile")
+	if err := os.WriteFile(keyfile, []byte("keyfile contents"), 0600); err != nil {
+		t.Fatalf("Failed to write keyfile: %v", err)
+	}
+	creds := core.Credentials{Keyfiles: []string{keyfile}}
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}
+
+	var encrypted bytes.Buffer
+	if err := core.EncryptStream(&encrypted, bytes.NewReader([]byte("keyfile only")), creds, opts); err != nil {
+		t.Fatalf("Failed to encrypt stream: %v", err)
+	}
+
+	// Header and key slots come first, so the factors are known before
+	// any credentials are asked for
+	stream, err := core.ReadEncryptedStream(bytes.NewReader(encrypted.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read stream header: %v", err)
+	}
+	factors := stream.Factors()
+	if len(factors) != 1 || !factors[0].RequiresKeyfile() || factors[0].RequiresPassword() {
+		t.Errorf("Unexpected factors: %+v", factors)
+	}
+	if _, err := stream.ThresholdInfo(); !errors.Is(err, core.ErrNotThreshold) {
+		t.Errorf("Expected ErrNotThreshold, got %v", err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := stream.DecryptTo(&decrypted, creds, core.DecryptOptions{}); err != nil || decrypted.String() != "keyfile only" {
+		t.Fatalf("Failed to decrypt stream: %q %v", decrypted.String(), err)
+	}
+	if err := stream.DecryptTo(&decrypted, creds, core.DecryptOptions{}); err == nil {
+		t.Error("Expected a second DecryptTo to fail")
+	}
+
+	// ASCII armor is decoded on the way in
+	var armored bytes.Buffer
+	armorOpts := opts
+	armorOpts.Armor = true
+	if err := core.EncryptStream(&armored, bytes.NewReader([]byte("armored pipe")), creds, armorOpts); err != nil {
+		t.Fatalf("Failed to encrypt armored stream: %v", err)
+	}
+	decrypted.Reset()
+	if err := core.DecryptStream(&decrypted, &armored, creds, core.DecryptOptions{}); err != nil || decrypted.String() != "armored pipe" {
+		t.Fatalf("Failed to decrypt armored stream: %q %v", decrypted.String(), err)
+	}
+}
@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestParallelPipelineRoundTrip(t *testing.T) {
+	password := "testpassword123"
+	segment := fileops.DefaultSegmentSize
+
+	// Sizes around segment and queue boundaries, including an empty file
+	sizes := []int{0, 1, segment, segment + 1, 17*segment + 123, 64 * segment}
+
+	for _, size := range sizes {
+		testData := make([]byte, size)
+		if _, err := rand.Read(testData); err != nil {
+			t.Fatalf("Failed to generate test data: %v", err)
+		}
+
+		for _, threads := range [][2]int{{1, 8}, {8, 1}, {3, 5}} {
+			encryptThreads, decryptThreads := threads[0], threads[1]
+			t.Run(fmt.Sprintf("%dB_enc%d_dec%d", size, encryptThreads, decryptThreads), func(t *testing.T) {
+				tempDir := t.TempDir()
+				testFile := filepath.Join(tempDir, "test.dat")
+				encryptedFile := filepath.Join(tempDir, "test.dat.enc")
+				decryptedFile := filepath.Join(tempDir, "decrypted.dat")
+
+				if err := os.WriteFile(testFile, testData, 0644); err != nil {
+					t.Fatalf("Failed to write test file: %v", err)
+				}
+
+				opts := core.EncryptOptions{Threads: encryptThreads}
+				if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(password), opts); err != nil {
+					t.Fatalf("Failed to encrypt file: %v", err)
+				}
+
+				decryptOpts := core.DecryptOptions{Threads: decryptThreads}
+				if err := core.DecryptFileWithOptions(encryptedFile, decryptedFile, core.Password(password), decryptOpts); err != nil {
+					t.Fatalf("Failed to decrypt file: %v", err)
+				}
+
+				decryptedData, err := os.ReadFile(decryptedFile)
+				if err != nil {
+					t.Fatalf("Failed to read decrypted file: %v", err)
+				}
+				if !bytes.Equal(decryptedData, testData) {
+					t.Error("Decrypted data doesn't match original")
+				}
+			})
+		}
+	}
+}
+
+func TestParallelPipelineDetectsTampering(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	segment := fileops.DefaultSegmentSize
+
+	testData := make([]byte, 20*segment)
+	encryptedFile := encryptTestFile(t, tempDir, testData, password)
+
+	data, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+
+	// Damage segment 10 of 20, counting from the end
+	tampered := filepath.Join(tempDir, "tampered.enc")
+	data[len(data)-10*(segment+fileops.AuthTagSize)+7] ^= 0x01
+	if err := os.WriteFile(tampered, data, 0644); err != nil {
+		t.Fatalf("Failed to write tampered file: %v", err)
+	}
+
+	output := filepath.Join(tempDir, "out.dat")
+	err = core.DecryptFileWithOptions(tampered, output, core.Password(password), core.DecryptOptions{Threads: 8})
+	if !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Fatalf("Expected authentication failure, got: %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Error("Output of a failed decryption should be removed")
+	}
+
+	// Dropping the final segment is detected as truncation
+	truncated := filepath.Join(tempDir, "truncated.enc")
+	if err := os.WriteFile(truncated, data[:len(data)-(segment+fileops.AuthTagSize)], 0644); err != nil {
+		t.Fatalf("Failed to write truncated file: %v", err)
+	}
+	err = core.DecryptFileWithOptions(truncated, output, core.Password(password), core.DecryptOptions{Threads: 8})
+	if err == nil {
+		t.Fatal("Decrypting a truncated file should fail")
+	}
+}
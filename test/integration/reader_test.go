@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestReaderRandomAccess(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	segment := fileops.DefaultSegmentSize
+
+	testData := make([]byte, 3*segment+123)
+	rand.Read(testData)
+	encryptedFile := encryptTestFile(t, tempDir, testData, password)
+
+	reader, err := core.OpenReader(encryptedFile, core.Password(password))
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+
+	if reader.Size() != int64(len(testData)) {
+		t.Fatalf("Expected size %d, got %d", len(testData), reader.Size())
+	}
+
+	// Ranges inside one segment, across boundaries and up to the end
+	ranges := [][2]int{
+		{0, 10},
+		{segment - 5, 10},
+		{segment, segment},
+		{2*segment - 1, segment + 2},
+		{len(testData) - 50, 50},
+	}
+	for _, rng := range ranges {
+		buf := make([]byte, rng[1])
+		n, err := reader.ReadAt(buf, int64(rng[0]))
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d, %d): %v", rng[0], rng[1], err)
+		}
+		if !bytes.Equal(buf[:n], testData[rng[0]:rng[0]+rng[1]]) {
+			t.Errorf("ReadAt(%d, %d) returned wrong data", rng[0], rng[1])
+		}
+	}
+
+	// Reading past the end returns the remainder and io.EOF
+	buf := make([]byte, 100)
+	n, err := reader.ReadAt(buf, int64(len(testData)-10))
+	if n != 10 || err != io.EOF {
+		t.Errorf("Expected 10 bytes and io.EOF at the end, got %d and %v", n, err)
+	}
+
+	// Seek and Read
+	if _, err := reader.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	tail, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(tail, testData[len(testData)-100:]) {
+		t.Error("Seek and Read returned wrong data")
+	}
+}
+
+func TestReaderDetectsTampering(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	segment := fileops.DefaultSegmentSize
+
+	testData := make([]byte, 3*segment)
+	encryptedFile := encryptTestFile(t, tempDir, testData, password)
+
+	if _, err := core.OpenReader(encryptedFile, core.Password("wrongpassword")); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Fatalf("Expected authentication failure for wrong password, got: %v", err)
+	}
+
+	// Flip one byte in the second of the three segments, counting from the end
+	data, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	data[len(data)-2*(segment+fileops.AuthTagSize)+7] ^= 0x01
+	tampered := filepath.Join(tempDir, "tampered.enc")
+	if err := os.WriteFile(tampered, data, 0644); err != nil {
+		t.Fatalf("Failed to write tampered file: %v", err)
+	}
+
+	reader, err := core.OpenReader(tampered, core.Password(password))
+	if err != nil {
+		t.Fatalf("First segment is intact, open should succeed: %v", err)
+	}
+	defer reader.Close()
+
+	// The untouched first segment is still readable
+	if _, err := reader.ReadAt(make([]byte, 16), 0); err != nil {
+		t.Errorf("Reading an intact segment failed: %v", err)
+	}
+
+	if _, err := reader.ReadAt(make([]byte, 16), int64(segment)); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected authentication failure for the tampered segment, got: %v", err)
+	}
+}
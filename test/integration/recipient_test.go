@@ -0,0 +1,179 @@
+package integration
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/pkg/filevault"
+)
+
+// newIdentity generates an X25519 identity for a test
+func newIdentity(t *testing.T) *crypto.X25519Identity {
+	t.Helper()
+
+	identity, err := crypto.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	return identity
+}
+
+func TestRecipientKeyEncoding(t *testing.T) {
+	identity := newIdentity(t)
+	publicKey := identity.Recipient().String()
+	if !strings.HasPrefix(publicKey, crypto.X25519RecipientPrefix) {
+		t.Fatalf("Unexpected public key encoding: %s", publicKey)
+	}
+
+	recipient, err := crypto.ParseRecipient(strings.ToUpper(publicKey))
+	if err != nil || recipient.String() != publicKey {
+		t.Fatalf("Public key did not round-trip: %v", err)
+	}
+
+	identities, err := crypto.ParseIdentities(strings.NewReader("# comment\n\n" + identity.String() + "\n"))
+	if err != nil || len(identities) != 1 || identities[0].Recipient().String() != publicKey {
+		t.Fatalf("Identity did not round-trip: %v", err)
+	}
+
+	// A single changed character is caught by the checksum
+	i := len(crypto.X25519RecipientPrefix) + 5
+	replacement := "a"
+	if publicKey[i] == 'a' {
+		replacement = "b"
+	}
+	typo := publicKey[:i] + replacement + publicKey[i+1:]
+	if _, err := crypto.ParseRecipient(typo); err == nil {
+		t.Error("Expected a typo in a public key to be rejected")
+	}
+	if _, err := crypto.ParseRecipient("ssh-ed25519 AAAA"); err == nil {
+		t.Error("Expected an unknown recipient type to be rejected")
+	}
+}
+
+func TestRecipientsEncryptDecrypt(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Hello FileVault Recipients!")
+	alice, bob, eve := newIdentity(t), newIdentity(t), newIdentity(t)
+	owner := core.Password("owner-password")
+
+	testFile := filepath.Join(tempDir, "shared.txt")
+	encryptedFile := filepath.Join(tempDir, "shared.txt.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{
+		KDF:        crypto.PBKDF2Params(crypto.MinIterations),
+		Recipients: []crypto.Recipient{alice.Recipient(), bob.Recipient()},
+	}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, owner, opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 3 {
+		t.Fatalf("Expected a password slot and two recipient slots, got %v %v", slots, err)
+	}
+	if slots[1].Factors != fileops.FactorIdentity || slots[1].Type != "x25519" {
+		t.Errorf("Unexpected recipient slot: %+v", slots[1])
+	}
+
+	for name, creds := range map[string]core.Credentials{
+		"owner": owner,
+		"alice": {Identities: []crypto.Identity{alice}},
+		"bob":   {Identities: []crypto.Identity{eve, bob}},
+	} {
+		if !checkUnlocks(t, encryptedFile, creds, testData) {
+			t.Errorf("%s should unlock the file", name)
+		}
+	}
+
+	if _, err := core.OpenReader(encryptedFile, core.Credentials{Identities: []crypto.Identity{eve}}); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected an unrelated identity to fail, got: %v", err)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Verification failed: %v %s", err, result.ErrorMessage)
+	}
+	if result.Unlock != "password or x25519 identity" {
+		t.Errorf("Unexpected unlock summary: %q", result.Unlock)
+	}
+}
+
+func TestRecipientsOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("no password at all")
+	identity := newIdentity(t)
+
+	testFile := filepath.Join(tempDir, "secret.txt")
+	encryptedFile := filepath.Join(tempDir, "secret.txt.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{Recipients: []crypto.Recipient{identity.Recipient()}}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Credentials{}, opts); err != nil {
+		t.Fatalf("Failed to encrypt to a recipient only: %v", err)
+	}
+
+	if _, err := core.OpenReader(encryptedFile, core.Password("guess")); !errors.Is(err, core.ErrIdentityRequired) {
+		t.Errorf("Expected identity required error, got: %v", err)
+	}
+
+	// Identities also open the file to manage its slots
+	withIdentity := core.Credentials{Identities: []crypto.Identity{identity}}
+	if _, err := core.AddKeySlot(encryptedFile, withIdentity, core.Password("backup-password")); err != nil {
+		t.Fatalf("Failed to add a password slot with an identity: %v", err)
+	}
+	if !checkUnlocks(t, encryptedFile, core.Password("backup-password"), testData) {
+		t.Error("The added password should unlock the file")
+	}
+	if _, err := core.ChangeKeySlot(encryptedFile, withIdentity, core.Password("other")); err == nil {
+		t.Error("A recipient slot should not be changed into a password slot")
+	}
+
+	decryptedFile := filepath.Join(tempDir, "secret.out")
+	if err := core.DecryptFileWithOptions(encryptedFile, decryptedFile, withIdentity, core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to decrypt with identity: %v", err)
+	}
+}
+
+func TestClientRecipients(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Hello FileVault Client!")
+	identity := newIdentity(t)
+
+	testFile := filepath.Join(tempDir, "client.txt")
+	encryptedFile := filepath.Join(tempDir, "client.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "client.out")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	sender := filevault.NewClient(filevault.WithRecipients(identity.Recipient().String()))
+	if err := sender.EncryptFileWithOutput(testFile, encryptedFile, ""); err != nil {
+		t.Fatalf("Failed to encrypt to recipient: %v", err)
+	}
+
+	receiver := filevault.NewClient(filevault.WithIdentities(identity.String()))
+	if err := receiver.DecryptFileWithOutput(encryptedFile, decryptedFile, ""); err != nil {
+		t.Fatalf("Failed to decrypt with identity: %v", err)
+	}
+
+	decrypted, err := os.ReadFile(decryptedFile)
+	if err != nil || string(decrypted) != string(testData) {
+		t.Fatalf("Decrypted data doesn't match original: %v", err)
+	}
+
+	bad := filevault.NewClient(filevault.WithRecipients("fvpk1notakey"))
+	if err := bad.EncryptFileWithOutput(testFile, filepath.Join(tempDir, "bad.enc"), ""); err == nil {
+		t.Error("Expected an invalid recipient to be rejected")
+	}
+}
@@ -0,0 +1,220 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// encryptWithRecovery encrypts size random bytes with percent redundancy
+func encryptWithRecovery(t *testing.T, dir string, size, percent int) ([]byte, string) {
+	t.Helper()
+
+	testData := make([]byte, size)
+	rand.Read(testData)
+	testFile := filepath.Join(dir, fmt.Sprintf("recovery-%d.bin", size))
+	encryptedFile := testFile + ".enc"
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Recovery: percent}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password("recovery-password"), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	return testData, encryptedFile
+}
+
+// checkRecoveredFile fails the test unless path decrypts to expected
+func checkRecoveredFile(t *testing.T, path string, expected []byte) {
+	t.Helper()
+
+	decryptedFile := path + ".out"
+	if err := core.DecryptFileWithOptions(path, decryptedFile, core.Password("recovery-password"), core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to decrypt file: %v", err)
+	}
+	decrypted, err := os.ReadFile(decryptedFile)
+	if err != nil || !bytes.Equal(decrypted, expected) {
+		t.Fatalf("Decrypted data doesn't match original: %v", err)
+	}
+}
+
+func TestRecoveryRecordRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	testData, encryptedFile := encryptWithRecovery(t, tempDir, 300*1024, 10)
+
+	header, err := core.ReadHeader(encryptedFile)
+	if err != nil || !header.HasFlag(fileops.FlagRecovery) {
+		t.Fatalf("Expected the recovery flag in the header: %v", err)
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("File with a recovery record should verify: %v %s", err, result.ErrorMessage)
+	}
+	if result.Recovery == nil || !result.Recovery.Intact() || result.Recovery.Redundancy < 10 {
+		t.Errorf("Expected an intact record with at least 10%% parity: %+v", result.Recovery)
+	}
+
+	checkRecoveredFile(t, encryptedFile, testData)
+
+	reader, err := core.OpenReader(encryptedFile, core.Password("recovery-password"))
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+	tail := make([]byte, 100)
+	if _, err := reader.ReadAt(tail, int64(len(testData)-100)); err != nil || !bytes.Equal(tail, testData[len(testData)-100:]) {
+		t.Errorf("Random access should end at the payload: %v", err)
+	}
+
+	report, err := core.RepairFile(encryptedFile)
+	if err != nil || !report.Intact() {
+		t.Errorf("Nothing should need repair: %v %+v", err, report)
+	}
+}
+
+func TestRepairDamage(t *testing.T) {
+	tempDir := t.TempDir()
+	testData, encryptedFile := encryptWithRecovery(t, tempDir, 2*1024*1024, 10)
+	original, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	size := int64(len(original))
+
+	tests := map[string]func(data []byte){
+		"flipped bits": func(data []byte) {
+			for _, at := range []int{5000, 300000, 1000001, 2000000} {
+				data[at] ^= 0x10
+			}
+		},
+		// Interleaved stripes spread a burst over all of them
+		"burst":  func(data []byte) { clear(data[700000 : 700000+200*1024]) },
+		"header": func(data []byte) { clear(data[:64]) },
+		"footer": func(data []byte) { data[size-10] ^= 0xFF },
+		"parity": func(data []byte) { clear(data[size-40000 : size-30000]) },
+	}
+
+	for name, damage := range tests {
+		t.Run(name, func(t *testing.T) {
+			damaged := filepath.Join(tempDir, name+".enc")
+			data := bytes.Clone(original)
+			damage(data)
+			if err := os.WriteFile(damaged, data, 0644); err != nil {
+				t.Fatalf("Failed to write damaged file: %v", err)
+			}
+
+			result, err := core.VerifyFile(damaged)
+			if err != nil || result.IsValid {
+				t.Fatalf("Damage should be detected: %v %+v", err, result)
+			}
+			if result.Recovery == nil || result.Recovery.Intact() || !result.Recovery.Repairable {
+				t.Fatalf("Expected repairable damage: %+v", result.Recovery)
+			}
+
+			if _, err := core.RepairFile(damaged); err != nil {
+				t.Fatalf("Failed to repair file: %v", err)
+			}
+			repaired, err := os.ReadFile(damaged)
+			if err != nil || !bytes.Equal(repaired, original) {
+				t.Fatalf("Repaired file doesn't match the original: %v", err)
+			}
+			checkRecoveredFile(t, damaged, testData)
+		})
+	}
+}
+
+func TestRepairTooDamaged(t *testing.T) {
+	tempDir := t.TempDir()
+	_, encryptedFile := encryptWithRecovery(t, tempDir, 512*1024, 5)
+
+	data, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	clear(data[100000:300000])
+	if err := os.WriteFile(encryptedFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write damaged file: %v", err)
+	}
+
+	report, err := core.CheckRecovery(encryptedFile)
+	if err != nil || report.Repairable || len(report.Damaged) != 1 {
+		t.Fatalf("Expected one unrepairable damaged region: %v %+v", err, report)
+	}
+	if _, err := core.RepairFile(encryptedFile); !errors.Is(err, core.ErrUnrepairable) {
+		t.Fatalf("Expected ErrUnrepairable, got %v", err)
+	}
+	unchanged, err := os.ReadFile(encryptedFile)
+	if err != nil || !bytes.Equal(unchanged, data) {
+		t.Error("A file that cannot be repaired must be left untouched")
+	}
+}
+
+func TestRecoveryWithSignatureAndKeySlots(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "signed.txt")
+	encryptedFile := testFile + ".enc"
+	testData := bytes.Repeat([]byte("signed and protected "), 5000)
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	secretKey := newSigningKey(t)
+	publicKey := secretKey.Public()
+	owner := core.Password("recovery-password")
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Signer: secretKey, Recovery: 20}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, owner, opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	if _, err := core.VerifySignature(encryptedFile, []*crypto.MinisignPublicKey{publicKey}); err != nil {
+		t.Fatalf("Signature should verify before the recovery record: %v", err)
+	}
+
+	// Adding slots rewrites the area in place, then copies the file once it
+	// outgrows it; the record must be rebuilt both times
+	for i := 0; i < 10; i++ {
+		if _, err := core.AddKeySlot(encryptedFile, owner, core.Password(fmt.Sprintf("member-password-%d", i))); err != nil {
+			t.Fatalf("Failed to add key slot %d: %v", i, err)
+		}
+		report, err := core.CheckRecovery(encryptedFile)
+		if err != nil || !report.Intact() {
+			t.Fatalf("Recovery record should cover the new key slots: %v %+v", err, report)
+		}
+	}
+
+	if _, err := core.VerifySignature(encryptedFile, []*crypto.MinisignPublicKey{publicKey}); err != nil {
+		t.Errorf("Signature should survive the key slot changes: %v", err)
+	}
+	checkRecoveredFile(t, encryptedFile, testData)
+}
+
+func TestRecoveryOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "options.txt")
+	if err := os.WriteFile(testFile, []byte("Hello FileVault Recovery!"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	for _, percent := range []int{-1, 101} {
+		opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Recovery: percent}
+		if err := core.EncryptFileWithOptions(testFile, testFile+".enc", core.Password("recovery-password"), opts); err == nil {
+			t.Errorf("Expected redundancy %d%% to be rejected", percent)
+		}
+	}
+
+	if err := core.EncryptFileWithOptions(testFile, testFile+".enc", core.Password("recovery-password"), core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	if _, err := core.CheckRecovery(testFile + ".enc"); !errors.Is(err, fileops.ErrNoRecoveryRecord) {
+		t.Errorf("Expected ErrNoRecoveryRecord, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// newSigningKey returns a fresh minisign key
+func newSigningKey(t *testing.T) *crypto.MinisignPrivateKey {
+	t.Helper()
+
+	key, err := crypto.GenerateMinisignKey()
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	return key
+}
+
+func TestMinisignKeys(t *testing.T) {
+	key := newSigningKey(t)
+
+	pubFile, err := key.Public().MarshalText()
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	for _, text := range []string{string(pubFile), key.Public().String()} {
+		public, err := crypto.ParseMinisignPublicKey(text)
+		if err != nil {
+			t.Fatalf("Failed to parse public key: %v", err)
+		}
+		if public.ID != key.ID || !bytes.Equal(public.Key, key.Public().Key) {
+			t.Error("Parsed public key doesn't match")
+		}
+	}
+	if !strings.HasPrefix(key.Public().String(), "RW") {
+		t.Errorf("Public key should start with RW: %s", key.Public())
+	}
+
+	unencrypted, err := key.Marshal(nil)
+	if err != nil {
+		t.Fatalf("Failed to marshal secret key: %v", err)
+	}
+	parsed, err := crypto.ParseMinisignPrivateKey(unencrypted, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse unencrypted secret key: %v", err)
+	}
+	if parsed.ID != key.ID || !bytes.Equal(parsed.Key, key.Key) {
+		t.Error("Parsed secret key doesn't match")
+	}
+
+	encrypted, err := key.Marshal([]byte("signing-password"))
+	if err != nil {
+		t.Fatalf("Failed to marshal encrypted secret key: %v", err)
+	}
+	if _, err := crypto.ParseMinisignPrivateKey(encrypted, nil); err == nil {
+		t.Error("Expected an encrypted key to need a password")
+	}
+	wrong := func() ([]byte, error) { return []byte("wrong-password"), nil }
+	if _, err := crypto.ParseMinisignPrivateKey(encrypted, wrong); err == nil || !strings.Contains(err.Error(), "wrong key password") {
+		t.Errorf("Expected a wrong password error, got: %v", err)
+	}
+	right := func() ([]byte, error) { return []byte("signing-password"), nil }
+	parsed, err = crypto.ParseMinisignPrivateKey(encrypted, right)
+	if err != nil {
+		t.Fatalf("Failed to parse encrypted secret key: %v", err)
+	}
+	if !bytes.Equal(parsed.Key, key.Key) {
+		t.Error("Decrypted secret key doesn't match")
+	}
+}
+
+func TestDetachedSignature(t *testing.T) {
+	key := newSigningKey(t)
+	message := bytes.Repeat([]byte("Hello FileVault Signatures! "), 1000)
+
+	signature, err := key.Sign(bytes.NewReader(message), "timestamp:0\tfile:message.txt")
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	text, err := signature.MarshalText()
+	if err != nil {
+		t.Fatalf("Failed to marshal signature: %v", err)
+	}
+	parsed, err := crypto.ParseMinisignSignature(text)
+	if err != nil {
+		t.Fatalf("Failed to parse signature: %v", err)
+	}
+	if parsed.TrustedComment != "timestamp:0\tfile:message.txt" {
+		t.Errorf("Unexpected trusted comment: %q", parsed.TrustedComment)
+	}
+
+	public := key.Public()
+	if err := public.Verify(parsed, bytes.NewReader(message)); err != nil {
+		t.Fatalf("Failed to verify signature: %v", err)
+	}
+
+	tampered := bytes.Clone(message)
+	tampered[100] ^= 1
+	if err := public.Verify(parsed, bytes.NewReader(tampered)); !errors.Is(err, crypto.ErrSignatureInvalid) {
+		t.Errorf("Expected a modified message to fail verification, got: %v", err)
+	}
+
+	parsed.TrustedComment = "timestamp:0\tfile:other.txt"
+	if err := public.Verify(parsed, bytes.NewReader(message)); !errors.Is(err, crypto.ErrSignatureInvalid) {
+		t.Errorf("Expected a modified trusted comment to fail verification, got: %v", err)
+	}
+
+	if err := newSigningKey(t).Public().Verify(signature, bytes.NewReader(message)); err == nil {
+		t.Error("Expected another key to fail verification")
+	}
+}
+
+func TestEmbeddedSignature(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := bytes.Repeat([]byte("Hello FileVault Embedded Signature! "), 5000)
+	alice := core.Password("alice-password")
+	key := newSigningKey(t)
+	keys := []*crypto.MinisignPublicKey{key.Public()}
+
+	testFile := filepath.Join(tempDir, "signed.txt")
+	encryptedFile := filepath.Join(tempDir, "signed.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "signed.out")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Signer: key}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, alice, opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	if err := core.DecryptFileWithOptions(encryptedFile, decryptedFile, alice, core.DecryptOptions{}); err != nil {
+		t.Fatalf("Failed to decrypt signed file: %v", err)
+	}
+	decrypted, err := os.ReadFile(decryptedFile)
+	if err != nil || !bytes.Equal(decrypted, testData) {
+		t.Fatalf("Decrypted data doesn't match original: %v", err)
+	}
+	if !checkUnlocks(t, encryptedFile, alice, testData) {
+		t.Fatal("Failed to stream signed file")
+	}
+
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid {
+		t.Fatalf("Signed file should verify: %v %+v", err, result)
+	}
+	if result.SignerKeyID != crypto.FormatKeyID(key.ID) {
+		t.Errorf("Unexpected signer: %q", result.SignerKeyID)
+	}
+
+	signer, err := core.VerifySignature(encryptedFile, keys)
+	if err != nil || signer.ID != key.ID {
+		t.Fatalf("Failed to verify embedded signature: %v", err)
+	}
+	if _, err := core.VerifySignature(encryptedFile, []*crypto.MinisignPublicKey{newSigningKey(t).Public()}); !errors.Is(err, crypto.ErrSignatureInvalid) {
+		t.Errorf("Expected an unknown key to be rejected, got: %v", err)
+	}
+
+	// Adding a key slot leaves the signed bytes alone
+	if _, err := core.AddKeySlot(encryptedFile, alice, core.Password("bob-password")); err != nil {
+		t.Fatalf("Failed to add key slot: %v", err)
+	}
+	if _, err := core.VerifySignature(encryptedFile, keys); err != nil {
+		t.Errorf("Signature should survive a new key slot: %v", err)
+	}
+
+	// Flipping a ciphertext byte breaks the signature
+	data, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	data[len(data)/2] ^= 1
+	if err := os.WriteFile(encryptedFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write encrypted file: %v", err)
+	}
+	if _, err := core.VerifySignature(encryptedFile, keys); !errors.Is(err, crypto.ErrSignatureInvalid) {
+		t.Errorf("Expected a modified file to fail verification, got: %v", err)
+	}
+
+	unsigned := encryptKeySlotFile(t, tempDir, testData, "alice-password")
+	if _, err := core.VerifySignature(unsigned, keys); !errors.Is(err, core.ErrNotSigned) {
+		t.Errorf("Expected ErrNotSigned, got: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package integration
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// sshKeyPair returns an authorized_keys line and the PEM private key for
+// key, encrypted when passphrase is not empty
+func sshKeyPair(t *testing.T, key interface{}, passphrase string) (string, []byte) {
+	t.Helper()
+
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "test key")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "test key", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(signer.PublicKey())), pem.EncodeToMemory(block)
+}
+
+func TestSSHRecipients(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Hello FileVault SSH Recipients!")
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	edPublic, edPrivate := sshKeyPair(t, edKey, "")
+	rsaPublic, rsaPrivate := sshKeyPair(t, rsaKey, "")
+
+	// authorized_keys lines may carry options in front of the key
+	var recipients []crypto.Recipient
+	for _, line := range []string{edPublic, `no-pty,command="true" ` + rsaPublic} {
+		recipient, err := crypto.ParseRecipient(line)
+		if err != nil {
+			t.Fatalf("Failed to parse SSH recipient: %v", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	testFile := filepath.Join(tempDir, "ssh.txt")
+	encryptedFile := filepath.Join(tempDir, "ssh.txt.enc")
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	opts := core.EncryptOptions{Recipients: recipients}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Credentials{}, opts); err != nil {
+		t.Fatalf("Failed to encrypt to SSH keys: %v", err)
+	}
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 2 || slots[0].Type != "ssh-ed25519" || slots[1].Type != "ssh-rsa" {
+		t.Fatalf("Unexpected key slots: %+v %v", slots, err)
+	}
+
+	for name, pemBytes := range map[string][]byte{"ed25519": edPrivate, "rsa": rsaPrivate} {
+		identity, err := crypto.ParseSSHIdentity(pemBytes, nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %s identity: %v", name, err)
+		}
+		if !checkUnlocks(t, encryptedFile, core.Credentials{Identities: []crypto.Identity{identity}}, testData) {
+			t.Errorf("The %s identity should unlock the file", name)
+		}
+	}
+
+	other, err := crypto.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	if _, err := core.OpenReader(encryptedFile, core.Credentials{Identities: []crypto.Identity{other}}); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected an unrelated identity to fail, got: %v", err)
+	}
+}
+
+func TestSSHIdentityPassphrase(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	public, private := sshKeyPair(t, key, "correct horse")
+
+	if _, err := crypto.ParseSSHIdentity(private, nil); err == nil {
+		t.Error("Expected an encrypted key without a passphrase to be rejected")
+	}
+
+	wrong := func() ([]byte, error) { return []byte("wrong"), nil }
+	if _, err := crypto.ParseSSHIdentity(private, wrong); err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("Expected a wrong passphrase error, got: %v", err)
+	}
+
+	asked := 0
+	correct := func() ([]byte, error) { asked++; return []byte("correct horse"), nil }
+	identity, err := crypto.ParseSSHIdentity(private, correct)
+	if err != nil || asked != 1 {
+		t.Fatalf("Failed to parse encrypted key: %v (asked %d times)", err, asked)
+	}
+	if identity.Recipient().String() != strings.TrimSpace(public) {
+		t.Errorf("Identity public key mismatch: %s", identity.Recipient())
+	}
+
+	// The identity unwraps what its public key wraps
+	recipient, err := crypto.ParseRecipient(public)
+	if err != nil {
+		t.Fatalf("Failed to parse recipient: %v", err)
+	}
+	fileKey := make([]byte, crypto.KeySize)
+	body, err := recipient.Wrap(fileKey, []byte("context"))
+	if err != nil {
+		t.Fatalf("Failed to wrap: %v", err)
+	}
+	if _, err := identity.Unwrap(recipient.SlotType(), body, []byte("context")); err != nil {
+		t.Errorf("Failed to unwrap: %v", err)
+	}
+	if _, err := identity.Unwrap(recipient.SlotType(), body, []byte("other")); !errors.Is(err, crypto.ErrIdentityMismatch) {
+		t.Errorf("Expected a different context to fail, got: %v", err)
+	}
+}
+
+func TestSSHRecipientRejectsWeakKeys(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	public, _ := sshKeyPair(t, small, "")
+	if _, err := crypto.ParseRecipient(public); err == nil {
+		t.Error("Expected a 1024-bit RSA key to be rejected")
+	}
+}
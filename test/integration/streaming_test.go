@@ -0,0 +1,110 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+func TestStreamingRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	segment := fileops.DefaultSegmentSize
+
+	sizes := []int{0, 1, segment - 1, segment, segment + 1, 3*segment + 17}
+	for _, size := range sizes {
+		testData := make([]byte, size)
+		rand.Read(testData)
+
+		testFile := filepath.Join(tempDir, "plain.dat")
+		encryptedFile := filepath.Join(tempDir, "plain.dat.enc")
+		decryptedFile := filepath.Join(tempDir, "decrypted.dat")
+
+		if err := os.WriteFile(testFile, testData, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+
+		if err := core.EncryptFile(testFile, encryptedFile, password); err != nil {
+			t.Fatalf("size %d: failed to encrypt: %v", size, err)
+		}
+
+		if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+			t.Fatalf("size %d: failed to decrypt: %v", size, err)
+		}
+
+		decryptedData, err := os.ReadFile(decryptedFile)
+		if err != nil {
+			t.Fatalf("Failed to read decrypted file: %v", err)
+		}
+
+		if !bytes.Equal(decryptedData, testData) {
+			t.Errorf("size %d: decrypted data doesn't match original", size)
+		}
+	}
+}
+
+func TestStreamingDetectsTruncation(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+
+	testFile := filepath.Join(tempDir, "plain.dat")
+	encryptedFile := filepath.Join(tempDir, "plain.dat.enc")
+	decryptedFile := filepath.Join(tempDir, "decrypted.dat")
+
+	testData := make([]byte, 3*fileops.DefaultSegmentSize)
+	rand.Read(testData)
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := core.EncryptFile(testFile, encryptedFile, password); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	// Drop the final segment so the file ends on a segment boundary
+	encryptedData, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted file: %v", err)
+	}
+	truncated := encryptedData[:len(encryptedData)-(fileops.DefaultSegmentSize+fileops.AuthTagSize)]
+	if err := os.WriteFile(encryptedFile, truncated, 0644); err != nil {
+		t.Fatalf("Failed to write truncated file: %v", err)
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err == nil {
+		t.Fatal("Truncated file should fail to decrypt")
+	}
+
+	if _, err := os.Stat(decryptedFile); !os.IsNotExist(err) {
+		t.Error("Partial output should be removed after a failed decryption")
+	}
+}
+
+func TestLegacyV1Decryption(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := []byte("Hello from a FileVault v1 file!")
+
+	encryptedFile := filepath.Join(tempDir, "legacy.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "legacy.txt")
+
+	writeV1File(t, encryptedFile, testData, password)
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt v1 file: %v", err)
+	}
+
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+
+	if !bytes.Equal(decryptedData, testData) {
+		t.Errorf("Decrypted data doesn't match original. Expected: %s, Got: %s", testData, decryptedData)
+	}
+}
@@ -0,0 +1,220 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+)
+
+// encryptThreshold encrypts testData so that any threshold of the password
+// holders and recipients decrypt it
+func encryptThreshold(t *testing.T, dir string, testData []byte, threshold int, passwords []string, recipients ...crypto.Recipient) string {
+	t.Helper()
+
+	testFile := filepath.Join(dir, "vault.txt")
+	encryptedFile := testFile + ".enc"
+	if err := os.WriteFile(testFile, testData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	var holders []core.Credentials
+	for _, password := range passwords[1:] {
+		holders = append(holders, core.Password(password))
+	}
+	opts := core.EncryptOptions{
+		KDF:          crypto.PBKDF2Params(crypto.MinIterations),
+		Recipients:   recipients,
+		Threshold:    threshold,
+		ShareHolders: holders,
+	}
+	if err := core.EncryptFileWithOptions(testFile, encryptedFile, core.Password(passwords[0]), opts); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	return encryptedFile
+}
+
+// decryptThreshold decrypts path with creds and compares it to expected
+func decryptThreshold(path string, creds core.Credentials, expected []byte) error {
+	outputFile := path + ".out"
+	defer os.Remove(outputFile)
+	if err := core.DecryptFileWithOptions(path, outputFile, creds, core.DecryptOptions{}); err != nil {
+		return err
+	}
+	decrypted, err := os.ReadFile(outputFile)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(decrypted, expected) {
+		return errors.New("decrypted data doesn't match original")
+	}
+	return nil
+}
+
+func TestThresholdPasswords(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Only two of three officers together may read this.")
+	passwords := []string{"alice-password", "bob-password", "carol-password"}
+	encryptedFile := encryptThreshold(t, tempDir, testData, 2, passwords)
+
+	info, err := core.ReadThreshold(encryptedFile)
+	if err != nil || info.Threshold != 2 || info.Shares != 3 {
+		t.Fatalf("Expected a 2-of-3 file: %v %+v", err, info)
+	}
+	result, err := core.VerifyFile(encryptedFile)
+	if err != nil || !result.IsValid || result.Unlock != "any 2 of 3 shares (password)" {
+		t.Fatalf("Unexpected verification result: %v %+v", err, result)
+	}
+
+	// One password alone is not enough
+	for _, password := range passwords {
+		if err := decryptThreshold(encryptedFile, core.Password(password), testData); !errors.Is(err, core.ErrMoreSharesNeeded) {
+			t.Errorf("Expected ErrMoreSharesNeeded with one password, got %v", err)
+		}
+	}
+
+	// Any two holders together decrypt: one enters a password, the other
+	// unlocks their share first
+	for i, first := range passwords {
+		for j, second := range passwords {
+			if i == j {
+				continue
+			}
+			shares, err := core.UnlockShares(encryptedFile, core.Password(second))
+			if err != nil || len(shares) != 1 {
+				t.Fatalf("Failed to unlock share: %v", err)
+			}
+			creds := core.Credentials{Password: first, Shares: shares}
+			if err := decryptThreshold(encryptedFile, creds, testData); err != nil {
+				t.Errorf("Passwords %d and %d should decrypt: %v", i, j, err)
+			}
+		}
+	}
+
+	if _, err := core.UnlockShares(encryptedFile, core.Password("wrong-password")); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Expected a wrong password to unlock no share, got %v", err)
+	}
+}
+
+func TestThresholdDuplicateHolders(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "vault.txt")
+	if err := os.WriteFile(testFile, []byte("Two distinct people are needed."), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	identity := newIdentity(t)
+
+	// A holder sharing another's password would hold both shares alone
+	cases := map[string]struct {
+		holders    []core.Credentials
+		recipients []crypto.Recipient
+	}{
+		"password":  {holders: []core.Credentials{core.Password("bob-password"), core.Password("alice-password")}},
+		"recipient": {recipients: []crypto.Recipient{identity.Recipient(), identity.Recipient()}},
+	}
+	for name, c := range cases {
+		opts := core.EncryptOptions{
+			KDF:          crypto.PBKDF2Params(crypto.MinIterations),
+			Threshold:    2,
+			ShareHolders: c.holders,
+			Recipients:   c.recipients,
+		}
+		err := core.EncryptFileWithOptions(testFile, testFile+".enc", core.Password("alice-password"), opts)
+		if !errors.Is(err, core.ErrDuplicateHolder) {
+			t.Errorf("%s: expected ErrDuplicateHolder, got %v", name, err)
+		}
+	}
+
+	holders := []core.Credentials{core.Password("alice-password")}
+	if err := core.CheckShareHolder(holders, core.Password("alice-password")); !errors.Is(err, core.ErrDuplicateHolder) {
+		t.Errorf("Expected ErrDuplicateHolder, got %v", err)
+	}
+	if err := core.CheckShareHolder(holders, core.Password("bob-password")); err != nil {
+		t.Errorf("Distinct passwords should be accepted: %v", err)
+	}
+}
+
+func TestThresholdRecipientsAndCards(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Two of: a password, an identity, another identity.")
+	first, second := newIdentity(t), newIdentity(t)
+	encryptedFile := encryptThreshold(t, tempDir, testData, 2, []string{"owner-password"}, first.Recipient(), second.Recipient())
+
+	// Both identities together are enough, without any password
+	creds := core.Credentials{Identities: []crypto.Identity{first, second}}
+	if err := decryptThreshold(encryptedFile, creds, testData); err != nil {
+		t.Fatalf("Two identities should decrypt: %v", err)
+	}
+
+	// A share carried on a card combines with the password
+	shares, err := core.UnlockShares(encryptedFile, core.Credentials{Identities: []crypto.Identity{second}})
+	if err != nil || len(shares) != 1 {
+		t.Fatalf("Failed to unlock share: %v", err)
+	}
+	card, err := crypto.ParseShare(shares[0].String())
+	if err != nil {
+		t.Fatalf("Failed to parse share: %v", err)
+	}
+	creds = core.Credentials{Password: "owner-password", Shares: []*crypto.Share{card}}
+	if err := decryptThreshold(encryptedFile, creds, testData); err != nil {
+		t.Fatalf("Password and card should decrypt: %v", err)
+	}
+
+	// The same card twice is still one share
+	creds = core.Credentials{Shares: []*crypto.Share{card, card}}
+	if err := decryptThreshold(encryptedFile, creds, testData); !errors.Is(err, core.ErrMoreSharesNeeded) {
+		t.Errorf("Expected ErrMoreSharesNeeded with one card, got %v", err)
+	}
+
+	// Cards of another file are ignored
+	otherDir := t.TempDir()
+	otherFile := encryptThreshold(t, otherDir, testData, 2, []string{"owner-password"}, first.Recipient(), second.Recipient())
+	if err := decryptThreshold(otherFile, core.Credentials{Password: "owner-password", Shares: []*crypto.Share{card}}, testData); !errors.Is(err, core.ErrMoreSharesNeeded) {
+		t.Errorf("Expected a card of another file to be ignored, got %v", err)
+	}
+}
+
+func TestThresholdKeySlots(t *testing.T) {
+	tempDir := t.TempDir()
+	testData := []byte("Key slots of threshold files are fixed.")
+	encryptedFile := encryptThreshold(t, tempDir, testData, 2, []string{"alice-password", "bob-password"})
+
+	slots, err := core.ListKeySlots(encryptedFile)
+	if err != nil || len(slots) != 2 {
+		t.Fatalf("Expected two key slots: %v", err)
+	}
+	for i, slot := range slots {
+		if slot.Type != "password" || slot.Share != i+1 || slot.Threshold != 2 {
+			t.Errorf("Unexpected key slot %d: %+v", i, slot)
+		}
+	}
+
+	_, err = core.AddKeySlot(encryptedFile, core.Password("alice-password"), core.Password("mallory-password"))
+	if !errors.Is(err, core.ErrThresholdSlots) {
+		t.Errorf("Expected ErrThresholdSlots, got %v", err)
+	}
+
+	if _, err := core.ReadThreshold(encryptedFile + ".missing"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	plainFile := filepath.Join(tempDir, "plain.txt")
+	os.WriteFile(plainFile, testData, 0644)
+	if err := core.EncryptFileWithOptions(plainFile, plainFile+".enc", core.Password("alice-password"), core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations)}); err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	if _, err := core.ReadThreshold(plainFile + ".enc"); !errors.Is(err, core.ErrNotThreshold) {
+		t.Errorf("Expected ErrNotThreshold, got %v", err)
+	}
+
+	opts := core.EncryptOptions{KDF: crypto.PBKDF2Params(crypto.MinIterations), Threshold: 3}
+	err = core.EncryptFileWithOptions(plainFile, plainFile+".enc", core.Password("alice-password"), opts)
+	if err == nil || !strings.Contains(err.Error(), "threshold") {
+		t.Errorf("Expected a 3-of-1 split to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/core"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
+	fverrors "github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/errors"
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
+)
+
+// writeV1File builds a legacy v1 file by hand: header, single GCM ciphertext, tag
+func writeV1File(t *testing.T, path string, data []byte, password string) {
+	t.Helper()
+
+	salt, _ := crypto.GenerateSalt32()
+	iv, _ := crypto.GenerateIV16()
+
+	header := fileops.NewFileHeader(uint64(len(data)), "legacy.txt", salt, iv)
+	header.Version = fileops.FormatVersionV1
+	header.SetSegmentSize(0)
+
+	cipher, err := crypto.NewAESCipherFromPassword(password, salt)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	encrypted, err := cipher.EncryptWithNonce(data, iv[:crypto.NonceSize])
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	var buf bytes.Buffer
+	header.WriteTo(&buf)
+	buf.Write(encrypted.Ciphertext)
+	buf.Write(encrypted.Tag)
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write v1 file: %v", err)
+	}
+}
+
+func TestUpgradeV1File(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	testData := bytes.Repeat([]byte("Hello from a FileVault v1 file! "), 5000)
+
+	encryptedFile := filepath.Join(tempDir, "legacy.txt.enc")
+	decryptedFile := filepath.Join(tempDir, "legacy.txt")
+	writeV1File(t, encryptedFile, testData, password)
+
+	result, err := core.UpgradeFile(encryptedFile, core.Password(password))
+	if err != nil {
+		t.Fatalf("Failed to upgrade: %v", err)
+	}
+	if !result.Upgraded || result.FromVersion != fileops.FormatVersionV1 || result.ToVersion != fileops.FormatVersion {
+		t.Errorf("Unexpected upgrade result: %+v", result)
+	}
+
+	header := readHeader(t, encryptedFile)
+	if header.Version != fileops.FormatVersion || !header.HasFlag(fileops.FlagEncryptedMetadata) {
+		t.Errorf("Unexpected upgraded header: version %d, flags 0x%x", header.Version, header.Flags())
+	}
+
+	meta, err := core.ReadMetadata(encryptedFile, core.Password(password))
+	if err != nil || meta.FileName != "legacy.txt" {
+		t.Errorf("Expected original name in encrypted metadata, got %+v (%v)", meta, err)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(encryptedFile)
+		if err != nil {
+			t.Fatalf("Failed to stat upgraded file: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected permissions to be kept, got %v", info.Mode().Perm())
+		}
+	}
+
+	if err := core.DecryptFile(encryptedFile, decryptedFile, password); err != nil {
+		t.Fatalf("Failed to decrypt upgraded file: %v", err)
+	}
+	decryptedData, err := os.ReadFile(decryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted file: %v", err)
+	}
+	if !bytes.Equal(decryptedData, testData) {
+		t.Error("Decrypted data doesn't match original")
+	}
+
+	// A current file is left alone
+	result, err = core.UpgradeFile(encryptedFile, core.Password(password))
+	if err != nil || result.Upgraded {
+		t.Errorf("Current file should not be upgraded again: %+v, %v", result, err)
+	}
+}
+
+func TestUpgradeWrongPasswordKeepsOriginal(t *testing.T) {
+	tempDir := t.TempDir()
+	encryptedFile := filepath.Join(tempDir, "legacy.txt.enc")
+	writeV1File(t, encryptedFile, []byte("Hello from a FileVault v1 file!"), "testpassword123")
+
+	original, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read v1 file: %v", err)
+	}
+
+	if _, err := core.UpgradeFile(encryptedFile, core.Password("wrongpassword")); err == nil {
+		t.Fatal("Upgrade with wrong password should fail")
+	}
+
+	after, err := os.ReadFile(encryptedFile)
+	if err != nil {
+		t.Fatalf("Failed to read v1 file: %v", err)
+	}
+	if !bytes.Equal(original, after) {
+		t.Error("Original file was modified by a failed upgrade")
+	}
+
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 1 {
+		t.Errorf("Temporary files were left behind: %d entries", len(entries))
+	}
+}
+
+func TestNewerFormatIsRejected(t *testing.T) {
+	tempDir := t.TempDir()
+	password := "testpassword123"
+	decryptedFile := filepath.Join(tempDir, "decrypted.txt")
+
+	newerVersion := filepath.Join(t.TempDir(), "newer.enc")
+	data, _ := os.ReadFile(encryptTestFile(t, tempDir, []byte("Hello FileVault!"), password))
+	os.WriteFile(newerVersion, data, 0644)
+	rewriteHeader(t, newerVersion, func(h *fileops.FileHeader) {
+		h.Version = fileops.FormatVersion + 1
+		h.UpdateChecksum()
+	})
+
+	err := core.DecryptFile(newerVersion, decryptedFile, password)
+	if !errors.Is(err, fileops.ErrUnsupportedVersion) {
+		t.Fatalf("Expected unsupported version error, got: %v", err)
+	}
+	if fverrors.GetErrorCode(err) != fverrors.ErrUnsupportedVersion {
+		t.Errorf("Expected an unsupported version error code, got %v", fverrors.GetErrorCode(err))
+	}
+
+	unknownFlag := filepath.Join(t.TempDir(), "flags.enc")
+	os.WriteFile(unknownFlag, data, 0644)
+	rewriteHeader(t, unknownFlag, func(h *fileops.FileHeader) {
+		h.SetFlag(1<<31, true)
+	})
+
+	err = core.DecryptFile(unknownFlag, decryptedFile, password)
+	if !errors.Is(err, fileops.ErrUnsupportedFeature) {
+		t.Fatalf("Expected unsupported feature error, got: %v", err)
+	}
+}
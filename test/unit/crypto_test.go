@@ -1,8 +1,11 @@
 package unit
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/crypto"
 )
 
 func TestRandomGeneration(t *testing.T) {
@@ -67,3 +70,65 @@ func TestKeyDerivation(t *testing.T) {
 		t.Error("Iterations should be at least 10000 for security")
 	}
 }
+
+func TestShamirSplitCombine(t *testing.T) {
+	secret := make([]byte, crypto.KeySize)
+	rand.Read(secret)
+
+	shares, err := crypto.SplitSecret(secret, 3, 5)
+	if err != nil {
+		t.Fatalf("Failed to split secret: %v", err)
+	}
+
+	// Every choice of three shares recovers the secret
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				combined, err := crypto.CombineShares([]*crypto.Share{shares[c], shares[a], shares[b]})
+				if err != nil || !bytes.Equal(combined, secret) {
+					t.Fatalf("Shares %d, %d, %d did not recover the secret: %v", a, b, c, err)
+				}
+			}
+		}
+	}
+
+	if _, err := crypto.CombineShares(shares[:2]); err == nil {
+		t.Error("Expected two shares of a 3-of-5 split to be rejected")
+	}
+	if _, err := crypto.CombineShares([]*crypto.Share{shares[0], shares[0], shares[1]}); err == nil {
+		t.Error("Expected a duplicate share to be rejected")
+	}
+
+	for _, invalid := range [][2]int{{1, 3}, {4, 3}, {2, 256}} {
+		if _, err := crypto.SplitSecret(secret, invalid[0], invalid[1]); err == nil {
+			t.Errorf("Expected threshold %d of %d to be rejected", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestShareEncoding(t *testing.T) {
+	secret := make([]byte, crypto.KeySize)
+	rand.Read(secret)
+	shares, err := crypto.SplitSecret(secret, 2, 3)
+	if err != nil {
+		t.Fatalf("Failed to split secret: %v", err)
+	}
+	shares[1].FileID = [crypto.ShareFileIDSize]byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// Grouping and line breaks from a card are ignored
+	encoded := shares[1].String()
+	grouped := encoded[:12] + " " + encoded[12:30] + "\n" + encoded[30:]
+	parsed, err := crypto.ParseShare(grouped)
+	if err != nil {
+		t.Fatalf("Failed to parse share: %v", err)
+	}
+	if parsed.Index != 2 || parsed.Threshold != 2 || parsed.FileID != shares[1].FileID || !bytes.Equal(parsed.Value, shares[1].Value) {
+		t.Fatalf("Share did not round-trip: %+v", parsed)
+	}
+
+	typo := []byte(encoded)
+	typo[20] ^= 1
+	if _, err := crypto.ParseShare(string(typo)); err == nil {
+		t.Error("Expected a typo in a share to be rejected")
+	}
+}
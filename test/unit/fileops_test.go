@@ -2,9 +2,14 @@ package unit
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/vuongdat67/NT140.Q11.ANTT-Group15/internal/fileops"
 )
 
 func TestFileOperations(t *testing.T) {
@@ -78,3 +83,165 @@ func TestBinaryFormat(t *testing.T) {
 		t.Errorf("Version should be 2 bytes, got %d", len(version))
 	}
 }
+
+func TestPadmeSize(t *testing.T) {
+	tests := []struct {
+		size, padded uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{9, 10},
+		{100, 104},
+		{1000, 1024},
+		{1025, 1088},
+		{1 << 20, 1 << 20},
+		{1<<20 + 1, 1<<20 + 1<<15},
+	}
+	for _, tt := range tests {
+		if got := fileops.PadmeSize(tt.size); got != tt.padded {
+			t.Errorf("PadmeSize(%d) = %d, want %d", tt.size, got, tt.padded)
+		}
+	}
+
+	// Padding is idempotent and costs at most 12%
+	for size := uint64(2); size < 100000; size += 37 {
+		padded := fileops.PadmeSize(size)
+		if padded < size || float64(padded-size) > 0.12*float64(size) || fileops.PadmeSize(padded) != padded {
+			t.Fatalf("Bad padding for %d: %d", size, padded)
+		}
+	}
+}
+
+func TestRecoveryFooterLayout(t *testing.T) {
+	for _, tt := range []struct {
+		size    int64
+		percent int
+	}{
+		{1, 1},
+		{100000, 10},
+		{10 << 20, 5},
+		{10 << 20, 100},
+	} {
+		footer, err := fileops.NewRecoveryFooter(tt.size, tt.percent)
+		if err != nil {
+			t.Fatalf("NewRecoveryFooter(%d, %d): %v", tt.size, tt.percent, err)
+		}
+		shards := int(footer.DataShards) + int(footer.ParityShards)
+		if shards > fileops.MaxRecoveryShards || footer.ParityShards == 0 {
+			t.Errorf("Bad shard counts for %d bytes: %d + %d", tt.size, footer.DataShards, footer.ParityShards)
+		}
+		if footer.Redundancy() < float64(tt.percent) {
+			t.Errorf("Expected at least %d%% parity for %d bytes, got %.1f%%", tt.percent, tt.size, footer.Redundancy())
+		}
+
+		data, err := footer.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal footer: %v", err)
+		}
+		parsed, err := fileops.ParseRecoveryFooter(data)
+		if err != nil || *parsed != *footer {
+			t.Errorf("Footer did not round-trip: %+v %v", parsed, err)
+		}
+		data[20] ^= 1
+		if _, err := fileops.ParseRecoveryFooter(data); err == nil {
+			t.Error("A damaged footer must be rejected")
+		}
+	}
+
+	for _, percent := range []int{0, 101} {
+		if _, err := fileops.NewRecoveryFooter(1000, percent); err == nil {
+			t.Errorf("Expected redundancy %d%% to be rejected", percent)
+		}
+	}
+}
+
+// armor encodes data as ASCII armor
+func armor(t *testing.T, data []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := fileops.NewArmorWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write armor: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close armor: %v", err)
+	}
+	return buf.String()
+}
+
+func TestArmor(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	armored := armor(t, data)
+
+	lines := strings.Split(strings.TrimSuffix(armored, "\n"), "\n")
+	if lines[0] != fileops.ArmorBegin || lines[len(lines)-1] != fileops.ArmorEnd {
+		t.Fatalf("Missing BEGIN or END line:\n%s", armored)
+	}
+	for _, line := range lines {
+		if len(line) > fileops.ArmorLineLength {
+			t.Errorf("Line longer than %d characters: %q", fileops.ArmorLineLength, line)
+		}
+	}
+	if !fileops.LooksArmored([]byte(armored)) || fileops.LooksArmored([]byte(fileops.MagicBytes+armored)) {
+		t.Error("LooksArmored misdetects armor")
+	}
+
+	// Quoted text around the armor, CRLF line ends and reflowed lines are
+	// all accepted
+	reflowed := strings.Join(lines[1:len(lines)-2], "")
+	var wrapped []string
+	for len(reflowed) > 50 {
+		wrapped = append(wrapped, reflowed[:50])
+		reflowed = reflowed[50:]
+	}
+	wrapped = append(wrapped, reflowed, "", lines[len(lines)-2])
+	for name, text := range map[string]string{
+		"plain":    armored,
+		"quoted":   "Here is the file:\n\n" + armored + "\nRegards\n",
+		"crlf":     strings.ReplaceAll(armored, "\n", "\r\n"),
+		"reflowed": fileops.ArmorBegin + "\n" + strings.Join(wrapped, "\n") + "\n" + fileops.ArmorEnd + "\n",
+	} {
+		decoded, err := io.ReadAll(fileops.NewArmorReader(strings.NewReader(text)))
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%s: armor did not round-trip: %v", name, err)
+		}
+	}
+
+	empty, err := io.ReadAll(fileops.NewArmorReader(strings.NewReader(armor(t, nil))))
+	if err != nil || len(empty) != 0 {
+		t.Errorf("Empty armor did not round-trip: %v", err)
+	}
+}
+
+func TestArmorDamage(t *testing.T) {
+	data := bytes.Repeat([]byte("FileVault armor "), 20)
+	armored := armor(t, data)
+	lines := strings.Split(armored, "\n")
+
+	// A changed character fails the checksum
+	changed := []byte(lines[1])
+	if changed[10] == 'A' {
+		changed[10] = 'B'
+	} else {
+		changed[10] = 'A'
+	}
+	tampered := strings.Replace(armored, lines[1], string(changed), 1)
+	if _, err := io.ReadAll(fileops.NewArmorReader(strings.NewReader(tampered))); !errors.Is(err, fileops.ErrArmorChecksum) {
+		t.Errorf("Expected ErrArmorChecksum, got %v", err)
+	}
+
+	for name, text := range map[string]string{
+		"no begin":    "just some text\n",
+		"no end":      strings.Join(lines[:3], "\n") + "\n",
+		"no checksum": strings.Replace(armored, lines[len(lines)-3]+"\n", "", 1),
+		"dropped":     strings.Replace(armored, lines[2]+"\n", "", 1),
+		"bad base64":  strings.Replace(armored, lines[1], "!!!!"+lines[1][4:], 1),
+	} {
+		if _, err := io.ReadAll(fileops.NewArmorReader(strings.NewReader(text))); err == nil {
+			t.Errorf("%s: damaged armor was accepted", name)
+		}
+	}
+}
@@ -26,6 +26,17 @@ func TestPasswordValidation(t *testing.T) {
 	}
 }
 
+func TestWeakPasswords(t *testing.T) {
+	// Every share holder's password is checked, not just the first
+	weak := security.WeakPasswords([]string{"Correct-Horse-9-Battery!", "", "abc", "Tr0ub4dor&3-Staple", "12345"})
+	if len(weak) != 2 || weak[0] != 2 || weak[1] != 4 {
+		t.Errorf("Expected passwords 2 and 4 to be weak, got %v", weak)
+	}
+	if weak := security.WeakPasswords([]string{"Correct-Horse-9-Battery!", ""}); len(weak) != 0 {
+		t.Errorf("Expected no weak passwords, got %v", weak)
+	}
+}
+
 func TestInputFileValidation(t *testing.T) {
 	// Test non-existent file
 	err := security.ValidateInputFile("nonexistent.txt")
@@ -39,3 +50,42 @@ func TestInputFileValidation(t *testing.T) {
 		t.Error("Empty path should fail validation")
 	}
 }
+
+func TestArchivePathValidation(t *testing.T) {
+	for _, name := range []string{"project/a b.txt", "project/sub/", "project", "project/v1..2.txt", "project/notes..bak", "project/..hidden"} {
+		if err := security.ValidateArchivePath(name); err != nil {
+			t.Errorf("Archive path %q should pass: %v", name, err)
+		}
+	}
+	for _, name := range []string{"../x", "/abs", "a/../../b", `a\..\b`, "a//b", "", "a/./b", "a/..", "a/b\x00"} {
+		if err := security.ValidateArchivePath(name); err == nil {
+			t.Errorf("Archive path %q should fail validation", name)
+		}
+	}
+
+	// Symlink targets are resolved against the directory of the link
+	if err := security.ValidateSymlink("project/d/l", "sub/file"); err != nil {
+		t.Errorf("Symlink into a subdirectory should pass: %v", err)
+	}
+	if err := security.ValidateSymlink("project/d/l", "../sibling"); err != nil {
+		t.Errorf("Symlink to a sibling should pass: %v", err)
+	}
+	for _, target := range []string{".", "./sub", "v1..2.txt"} {
+		if err := security.ValidateSymlink("project/l", target); err != nil {
+			t.Errorf("Symlink to %q should pass: %v", target, err)
+		}
+	}
+	for _, target := range []string{"/etc/passwd", "../../x", "../..", "", `..\x`} {
+		if err := security.ValidateSymlink("project/l", target); err == nil {
+			t.Errorf("Symlink to %q should fail validation", target)
+		}
+	}
+
+	// The target is checked element by element, not cleaned first: through
+	// a link "project/a" -> ".", "a/../.." climbs out of the archive
+	for _, target := range []string{"../a/../../y", "a/../x", "../a/.."} {
+		if err := security.ValidateSymlink("project/x/b", target); err == nil {
+			t.Errorf("Symlink to %q should fail validation", target)
+		}
+	}
+}